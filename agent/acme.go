@@ -0,0 +1,420 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/context"
+)
+
+var (
+	// acmeRenewBefore is how long before expiry certificates are renewed.
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// acmeCheckRate is how often the leader checks for certificates to
+	// issue or renew.
+	acmeCheckRate = time.Hour
+
+	// acmeLockTTL is the time to live of the certificate renewal lock. The
+	// lock is renewed while it is held.
+	acmeLockTTL = 30 * time.Second
+
+	// acmeLockTimeout is how long to wait for the certificate renewal lock.
+	acmeLockTimeout = time.Minute
+
+	// acmeIssueTimeout is how long issuing a single certificate may take.
+	acmeIssueTimeout = 5 * time.Minute
+)
+
+// CertificateIssuer obtains certificates for domains.
+type CertificateIssuer interface {
+	Issue(ctx context.Context, domain string) (cert string, key string, err error)
+}
+
+// ACMEIssuer obtains certificates from an ACME CA using HTTP-01 challenges.
+// Challenge responses are stored in the kvs, so whichever agent the front
+// end sends the challenge request to can answer it.
+type ACMEIssuer struct {
+	DirectoryURL string
+	Email        string
+	HTTPClient   *http.Client
+
+	store  *kvs.LiveACME
+	logger *logrus.Entry
+}
+
+var _ CertificateIssuer = &ACMEIssuer{}
+
+// NewACMEIssuer builds an ACMEIssuer using the ACME settings in config.
+func NewACMEIssuer(config *Config) *ACMEIssuer {
+	return &ACMEIssuer{
+		DirectoryURL: config.ACMEDirectoryURL,
+		Email:        config.ACMEEmail,
+		HTTPClient:   config.ACMEHTTPClient,
+		store:        kvs.NewLiveACME(config.KVS),
+		logger:       config.GetLogger(),
+	}
+}
+
+// Issue obtains a certificate for domain. It returns the PEM encoded
+// certificate chain and private key.
+func (ai *ACMEIssuer) Issue(ctx context.Context, domain string) (string, string, error) {
+	log := ai.logger.WithField("domain", domain)
+
+	client, err := ai.client(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return "", "", fmt.Errorf("could not create order: %v", err)
+	}
+
+	for _, u := range order.AuthzURLs {
+		if err := ai.authorize(ctx, client, u); err != nil {
+			return "", "", err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return "", "", fmt.Errorf("order was not authorized: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return "", "", fmt.Errorf("could not finalize order: %v", err)
+	}
+
+	certPEM := []byte{}
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	log.Info("issued certificate")
+
+	return string(certPEM), string(keyPEM), nil
+}
+
+// client builds an ACME client for the cluster's account. The account is
+// registered if needed.
+func (ai *ACMEIssuer) client(ctx context.Context) (*acme.Client, error) {
+	if ai.DirectoryURL == "" {
+		return nil, errors.New("acme directory url is not configured")
+	}
+
+	key, err := ai.store.Account()
+	if err != nil {
+		return nil, fmt.Errorf("could not load acme account key: %v", err)
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: ai.DirectoryURL,
+		HTTPClient:   ai.HTTPClient,
+	}
+
+	account := &acme.Account{}
+	if ai.Email != "" {
+		account.Contact = []string{"mailto:" + ai.Email}
+	}
+
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("could not register acme account: %v", err)
+	}
+
+	return client, nil
+}
+
+// authorize completes the HTTP-01 challenge of an authorization.
+func (ai *ACMEIssuer) authorize(ctx context.Context, client *acme.Client, u string) error {
+	authz, err := client.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("could not retrieve authorization: %v", err)
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+
+	if chal == nil {
+		return fmt.Errorf("no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	if err := ai.store.SetChallenge(chal.Token, keyAuth); err != nil {
+		return fmt.Errorf("could not store challenge response: %v", err)
+	}
+
+	defer func() {
+		if err := ai.store.DeleteChallenge(chal.Token); err != nil {
+			ai.logger.WithError(err).Warn("could not remove challenge response")
+		}
+	}()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("could not accept challenge: %v", err)
+	}
+
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization for %s failed: %v", authz.Identifier.Value, err)
+	}
+
+	return nil
+}
+
+// CertificateRenewer obtains certificates for domain services and renews
+// them before they expire. Only one agent renews at a time.
+type CertificateRenewer struct {
+	Issuer         CertificateIssuer
+	ServiceManager ServiceManager
+
+	context context.Context
+	locker  kvs.KLock
+	logger  *logrus.Entry
+	now     func() time.Time
+}
+
+// NewCertificateRenewer builds a CertificateRenewer which uses ACME.
+func NewCertificateRenewer(config *Config) *CertificateRenewer {
+	locker := kvs.NewLock("acme", config.KVS)
+	locker.Owner = config.Name
+
+	return &CertificateRenewer{
+		Issuer:         NewACMEIssuer(config),
+		ServiceManager: config.ServiceManagerFactory(config),
+		context:        config.Context,
+		locker:         locker,
+		logger:         config.GetLogger(),
+		now:            time.Now,
+	}
+}
+
+// Renew issues certificates for domain services which have no current
+// certificate for their domain. Certificates covering exactly the domain
+// which the new certificate replaces are removed. Renewal stops if the
+// renewal lock is lost, and certificates are written fenced by the lock's
+// fencing token, so an agent which lost the lock can't overwrite the work
+// of the next holder.
+func (cr *CertificateRenewer) Renew() error {
+	ctx, cancel := context.WithTimeout(cr.context, acmeLockTimeout)
+	defer cancel()
+
+	token, err := cr.locker.Lock(ctx, acmeLockTTL)
+	if err != nil {
+		return fmt.Errorf("could not lock certificate renewal: %v", err)
+	}
+	defer cr.locker.Unlock()
+
+	lost := cr.locker.Lost()
+
+	cr.logger.WithField("fencing-token", token).Info("checking certificates")
+
+	services, err := cr.ServiceManager.Services()
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range services {
+		domain, ok := svc.ServiceConfig()["domain"].(string)
+		if !ok || svc.ServiceConfig()["matcher"] != "domain" || domain == "" {
+			continue
+		}
+
+		err := cr.renewService(svc, domain, token, lost)
+		if err == kvs.ErrLockLost || err == kvs.ErrStaleToken {
+			return err
+		}
+
+		if err != nil {
+			cr.logger.WithError(err).WithFields(logrus.Fields{
+				"service-name": svc.Name(),
+				"domain":       domain,
+			}).Error("could not renew certificate")
+		}
+	}
+
+	return nil
+}
+
+func (cr *CertificateRenewer) renewService(svc kvs.Service, domain string, token uint64, lost <-chan struct{}) error {
+	replaced := []string{}
+	for _, c := range svc.Certificates() {
+		if !coversName(c, domain) {
+			continue
+		}
+
+		if c.NotAfter.Sub(cr.now()) > acmeRenewBefore {
+			return nil
+		}
+
+		if len(c.Names) == 1 {
+			replaced = append(replaced, c.ID)
+		}
+	}
+
+	log := cr.logger.WithFields(logrus.Fields{
+		"service-name": svc.Name(),
+		"domain":       domain,
+	})
+	log.Info("issuing certificate")
+
+	ctx, cancel := context.WithTimeout(cr.context, acmeIssueTimeout)
+	defer cancel()
+
+	// issuing is abandoned if the renewal lock is lost.
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cert, key, err := cr.Issuer.Issue(ctx, domain)
+	if isClosed(lost) {
+		return kvs.ErrLockLost
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fence, err := cr.locker.FenceOp(token)
+	if err != nil {
+		return err
+	}
+
+	ccr := service.CertificateCreateRequest{Certificate: cert, Key: key}
+	if _, err := cr.ServiceManager.AddFencedCertificate(svc.Name(), ccr, fence); err != nil {
+		return err
+	}
+
+	for _, id := range replaced {
+		if err := cr.ServiceManager.DeleteCertificate(svc.Name(), id); err != nil {
+			log.WithError(err).WithField("certificate-id", id).Warn("could not remove replaced certificate")
+		}
+	}
+
+	return nil
+}
+
+// isClosed returns true if ch is closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func coversName(c kvs.Certificate, name string) bool {
+	for _, n := range c.Names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PollCertificates periodically issues and renews certificates while the
+// agent is the leader. Certificates are checked right away at startup and
+// whenever the agent is elected leader. It does nothing if no ACME directory
+// is configured.
+func (a *Agent) PollCertificates() {
+	if a.Config.ACMEDirectoryURL == "" {
+		return
+	}
+
+	renewer := NewCertificateRenewer(a.Config)
+	changes := a.ClusterMember.Change()
+
+	ticker := time.NewTicker(acmeCheckRate)
+	defer ticker.Stop()
+
+	a.Config.Lock()
+	isLeader := a.Config.ClusterStatus.IsLeader
+	a.Config.Unlock()
+
+	for {
+		if isLeader {
+			if err := renewer.Renew(); err != nil {
+				a.Config.logger.WithError(err).Error("could not renew certificates")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+			a.Config.Lock()
+			isLeader = a.Config.ClusterStatus.IsLeader
+			a.Config.Unlock()
+		case cs := <-changes:
+			isLeader = cs.IsLeader
+		case <-a.Config.Context.Done():
+			return
+		}
+	}
+}
+
+// ACMEChallengeHandler answers ACME HTTP-01 challenges. The front end
+// forwards /.well-known/acme-challenge/ requests for every domain to it.
+func ACMEChallengeHandler(config *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		keyAuth, err := kvs.NewLiveACME(config.KVS).Challenge(token)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
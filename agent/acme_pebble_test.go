@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// TestACMEIssuer_pebble issues a certificate from a local Pebble server.
+// Start Pebble and set PEBBLE_DIRECTORY_URL (e.g. https://localhost:14000/dir)
+// to run it. Pebble validates challenges against PEBBLE_HTTP_ADDR, which
+// defaults to Pebble's default of :5002.
+func TestACMEIssuer_pebble(t *testing.T) {
+	directoryURL := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directoryURL == "" {
+		t.Skip("PEBBLE_DIRECTORY_URL is not set")
+	}
+
+	addr := os.Getenv("PEBBLE_HTTP_ADDR")
+	if addr == "" {
+		addr = ":5002"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{
+		ACMEDirectoryURL: directoryURL,
+		ACMEHTTPClient: &http.Client{
+			// Pebble serves its directory with a throwaway CA.
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+		KVS: kvs.NewMemory(ctx),
+	}
+	config.SetLogger(logrus.WithField("testing", true))
+
+	l, err := net.Listen("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := mux.NewRouter()
+	r.Handle("/.well-known/acme-challenge/{token}", ACMEChallengeHandler(config))
+	go http.Serve(l, r)
+	defer l.Close()
+
+	ctx, cancelIssue := context.WithTimeout(ctx, time.Minute)
+	defer cancelIssue()
+
+	cert, key, err := NewACMEIssuer(config).Issue(ctx, "a.example.com")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	c, err := kvs.ParseCertificate(cert, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com"}, c.Names)
+}
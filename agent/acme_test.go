@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CertificateRenewer", func() {
+
+	var (
+		issuer   *MockCertificateIssuer
+		sm       *MockServiceManager
+		mlock    *kvs.MockKLock
+		renewer  *CertificateRenewer
+		now      = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+		svc      *kvs.HTTPService
+		services []kvs.Service
+		lost     chan struct{}
+		fence    = kvs.TxnOp{Action: kvs.TxnSet, Key: "/dolb/fences/acme", Value: "1"}
+		err      error
+	)
+
+	domainService := func(name, domain string) *kvs.HTTPService {
+		s := kvs.NewHTTPService(name)
+		s.ServiceConfig()["matcher"] = "domain"
+		s.ServiceConfig()["domain"] = domain
+		return s
+	}
+
+	BeforeEach(func() {
+		logrus.SetOutput(ioutil.Discard)
+
+		issuer = &MockCertificateIssuer{}
+		sm = &MockServiceManager{}
+		mlock = &kvs.MockKLock{}
+
+		renewer = &CertificateRenewer{
+			Issuer:         issuer,
+			ServiceManager: sm,
+			context:        context.Background(),
+			locker:         mlock,
+			logger:         logrus.WithField("testing", true),
+			now:            func() time.Time { return now },
+		}
+
+		svc = domainService("service-a", "a.example.com")
+
+		regex := kvs.NewHTTPService("service-b")
+		regex.ServiceConfig()["matcher"] = "url_reg"
+		regex.ServiceConfig()["url_reg"] = ".*"
+
+		services = []kvs.Service{svc, regex, kvs.NewTCPService("service-c")}
+	})
+
+	AfterEach(func() {
+		issuer.AssertExpectations(GinkgoT())
+		sm.AssertExpectations(GinkgoT())
+		mlock.AssertExpectations(GinkgoT())
+	})
+
+	JustBeforeEach(func() {
+		err = renewer.Renew()
+	})
+
+	Context("with the renewal lock", func() {
+
+		BeforeEach(func() {
+			lost = make(chan struct{})
+			mlock.On("Lock", mock.Anything, acmeLockTTL).Return(uint64(1), nil)
+			mlock.On("Lost").Return((<-chan struct{})(lost))
+			mlock.On("Unlock").Return(nil)
+			sm.On("Services").Return(services, nil)
+		})
+
+		Context("with a domain service without a certificate", func() {
+
+			BeforeEach(func() {
+				issuer.On("Issue", mock.Anything, "a.example.com").Return("cert", "key", nil)
+				mlock.On("FenceOp", uint64(1)).Return(fence, nil)
+				ccr := service.CertificateCreateRequest{Certificate: "cert", Key: "key"}
+				sm.On("AddFencedCertificate", "service-a", ccr, fence).Return("1", nil)
+			})
+
+			It("issues a fenced certificate", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with a current certificate", func() {
+
+			BeforeEach(func() {
+				svc.AddCertificate(kvs.Certificate{
+					ID:       "1",
+					Names:    []string{"a.example.com"},
+					NotAfter: now.Add(60 * 24 * time.Hour),
+				})
+			})
+
+			It("doesn't issue a certificate", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with a certificate which expires soon", func() {
+
+			BeforeEach(func() {
+				svc.AddCertificate(kvs.Certificate{
+					ID:       "1",
+					Names:    []string{"a.example.com"},
+					NotAfter: now.Add(10 * 24 * time.Hour),
+				})
+				svc.AddCertificate(kvs.Certificate{
+					ID:       "2",
+					Names:    []string{"a.example.com", "www.example.com"},
+					NotAfter: now.Add(10 * 24 * time.Hour),
+				})
+
+				issuer.On("Issue", mock.Anything, "a.example.com").Return("cert", "key", nil)
+				mlock.On("FenceOp", uint64(1)).Return(fence, nil)
+				ccr := service.CertificateCreateRequest{Certificate: "cert", Key: "key"}
+				sm.On("AddFencedCertificate", "service-a", ccr, fence).Return("3", nil)
+				sm.On("DeleteCertificate", "service-a", "1").Return(nil)
+			})
+
+			It("replaces the certificate for the domain", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when issuing fails", func() {
+
+			BeforeEach(func() {
+				issuer.On("Issue", mock.Anything, "a.example.com").Return("", "", errors.New("boom"))
+			})
+
+			It("doesn't store a certificate", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when the lock is lost while issuing", func() {
+
+		BeforeEach(func() {
+			lost = make(chan struct{})
+			mlock.On("Lock", mock.Anything, acmeLockTTL).Return(uint64(1), nil)
+			mlock.On("Lost").Return((<-chan struct{})(lost))
+			mlock.On("Unlock").Return(kvs.ErrLockLost)
+			sm.On("Services").Return(services, nil)
+
+			issuer.On("Issue", mock.Anything, "a.example.com").Return("", "", context.Canceled).Run(func(mock.Arguments) {
+				close(lost)
+			})
+		})
+
+		It("stops without storing the certificate", func() {
+			Ω(err).To(Equal(kvs.ErrLockLost))
+		})
+	})
+
+	Context("when another agent is renewing", func() {
+
+		BeforeEach(func() {
+			mlock.On("Lock", mock.Anything, acmeLockTTL).Return(uint64(0), context.DeadlineExceeded)
+		})
+
+		It("returns an error", func() {
+			Ω(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("ACMEChallengeHandler", func() {
+
+	var (
+		mem    *kvs.Memory
+		ts     *httptest.Server
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = kvs.NewMemory(ctx)
+
+		config := &Config{KVS: mem}
+		config.SetLogger(logrus.WithField("testing", true))
+		ts = httptest.NewServer(NewAPI(config).Mux)
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		cancel()
+	})
+
+	It("answers known challenges", func() {
+		Ω(kvs.NewLiveACME(mem).SetChallenge("token", "token.thumbprint")).To(Succeed())
+
+		resp, err := http.Get(ts.URL + "/.well-known/acme-challenge/token")
+		Ω(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		body, err := ioutil.ReadAll(resp.Body)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(string(body)).To(Equal("token.thumbprint"))
+	})
+
+	It("returns 404 for unknown challenges", func() {
+		resp, err := http.Get(ts.URL + "/.well-known/acme-challenge/unknown")
+		Ω(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+
+		Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
@@ -83,6 +83,8 @@ func pingServer(config *Config) error {
 
 // PollClusterStatus polls cluster status to see if this node is the leader.
 func (a *Agent) PollClusterStatus() {
+	changes := a.ClusterMember.Change()
+
 	ticker := time.NewTicker(pingRate)
 	for {
 		select {
@@ -91,7 +93,7 @@ func (a *Agent) PollClusterStatus() {
 				a.Config.logger.WithError(err).Error("could not register agent")
 			}
 
-		case cs := <-a.ClusterMember.Change():
+		case cs := <-changes:
 			a.Config.logger.WithFields(log.Fields{
 				"leader":     cs.Leader,
 				"node-count": cs.NodeCount,
@@ -99,9 +101,9 @@ func (a *Agent) PollClusterStatus() {
 			a.Config.Lock()
 			a.Config.ClusterStatus = cs
 
-			node, err := a.Config.KVS.Get(fipKey, nil)
+			fip, err := kvs.NewFipKVS(a.Config.KVS).FloatingIP()
 			if err == nil {
-				a.Config.ClusterStatus.FloatingIP = node.Value
+				a.Config.ClusterStatus.FloatingIP = fip
 			}
 
 			a.Config.Unlock()
@@ -118,6 +120,11 @@ func (a *Agent) PollClusterStatus() {
 					a.Config.logger.WithError(err).Error("could not create haproxy keys")
 				}
 
+				err = hkvs.Migrate()
+				if err != nil {
+					a.Config.logger.WithError(err).Error("could not migrate haproxy keys")
+				}
+
 				handleLeaderElection(a)
 			}
 
@@ -125,9 +132,10 @@ func (a *Agent) PollClusterStatus() {
 	}
 }
 
+// PollFirewall keeps iptables in sync with the firewall ports in the kvs. It
+// syncs once at startup and again whenever the ports change.
 func (a *Agent) PollFirewall() {
 	log := a.Config.logger
-	fw := a.Config.Firewall
 	fkvs := kvs.NewLiveFirewall(a.Config.KVS)
 
 	err := fkvs.Init()
@@ -135,57 +143,66 @@ func (a *Agent) PollFirewall() {
 		log.WithError(err).Error("unable to start firewall poller")
 	}
 
-	ticker := time.NewTicker(time.Second * 5)
+	events, err := fkvs.WatchPorts()
+	if err != nil {
+		log.WithError(err).Error("unable to watch firewall ports")
+		return
+	}
 
 	log.Info("starting firewall poller")
 
-	for {
-		select {
-		case <-ticker.C:
-			state, err := fw.State()
-			if err != nil {
-				log.WithError(err).Error("unable to load firewall state")
-				continue
-			}
+	a.syncFirewall(fkvs)
+	for range events {
+		a.syncFirewall(fkvs)
+	}
+}
 
-			rules, err := state.Rules()
-			if err != nil {
-				log.WithError(err).Error("unable to load firewall rules")
-				continue
-			}
+// syncFirewall opens and closes iptables ports to match the kvs.
+func (a *Agent) syncFirewall(fkvs *kvs.LiveFirewall) {
+	log := a.Config.logger
+	fw := a.Config.Firewall
 
-			m := map[int]*firewall.Rule{}
-			for _, r := range rules {
-				m[r.Destination] = &r
-			}
+	state, err := fw.State()
+	if err != nil {
+		log.WithError(err).Error("unable to load firewall state")
+		return
+	}
 
-			ports, err := fkvs.Ports()
-			if err != nil {
-				log.WithError(err).Error("unable to load ports from kvs")
-				continue
-			}
+	rules, err := state.Rules()
+	if err != nil {
+		log.WithError(err).Error("unable to load firewall rules")
+		return
+	}
 
-			for _, p := range ports {
-				if m[p.Port] == nil {
-					// port rule doesn't exist in iptables
-					if p.Enabled {
-						log.WithField("firewall-port", p.Port).Info("opening firewall port")
-						err = fw.Open(p.Port)
-						if err != nil {
-							log.WithError(err).WithField("firewall-port", p.Port).Error("unable to open port")
-						}
-					}
-				} else {
-					// port rule exists in iptables
-					if !p.Enabled {
-						log.WithField("firewall-port", p.Port).Info("closing firewall port")
-						err = fw.Close(p.Port)
-						if err != nil {
-							log.WithError(err).WithField("firewall-port", p.Port).Error("unable to close port")
-						}
-					}
-				}
+	m := map[int]*firewall.Rule{}
+	for _, r := range rules {
+		m[r.Destination] = &r
+	}
 
+	ports, err := fkvs.Ports()
+	if err != nil {
+		log.WithError(err).Error("unable to load ports from kvs")
+		return
+	}
+
+	for _, p := range ports {
+		if m[p.Port] == nil {
+			// port rule doesn't exist in iptables
+			if p.Enabled {
+				log.WithField("firewall-port", p.Port).Info("opening firewall port")
+				err = fw.Open(p.Port)
+				if err != nil {
+					log.WithError(err).WithField("firewall-port", p.Port).Error("unable to open port")
+				}
+			}
+		} else {
+			// port rule exists in iptables
+			if !p.Enabled {
+				log.WithField("firewall-port", p.Port).Info("closing firewall port")
+				err = fw.Close(p.Port)
+				if err != nil {
+					log.WithError(err).WithField("firewall-port", p.Port).Error("unable to close port")
+				}
 			}
 		}
 	}
@@ -1,7 +1,10 @@
 package agent
 
 import (
+	"expvar"
+	"net/http"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -18,13 +21,22 @@ type Config struct {
 	sync.Mutex
 	ClusterStatus ClusterStatus
 
+	ACMEChallengeAddress  string
+	ACMEDirectoryURL      string
+	ACMEEmail             string
+	ACMEHTTPClient        *http.Client
 	AgentID               string
 	Context               context.Context
 	ClusterName           string
 	ClusterID             string
 	DigitalOceanToken     string
 	DropletID             string
+	Encryption            *kvs.Encrypted
 	Firewall              firewall.Firewall
+	HaproxyCertificateDir string
+	HaproxyConfigFile     string
+	HaproxyReloadCommand  string
+	HaproxyStatsSocket    string
 	KVS                   kvs.KVS
 	Name                  string
 	Region                string
@@ -64,35 +76,91 @@ func NewAPI(config *Config) *API {
 	a.Mux.Handle("/services", service.Handler{Config: config, F: ServiceListHandler}).Methods("GET")
 	a.Mux.Handle("/services/{service}", service.Handler{Config: config, F: ServiceRetrieveHandler}).Methods("GET")
 	a.Mux.Handle("/services/{service}", service.Handler{Config: config, F: ServiceDeleteHandler}).Methods("DELETE")
+	a.Mux.Handle("/services/{service}", service.Handler{Config: config, F: ServiceUpdateHandler}).Methods("PUT", "PATCH")
+	a.Mux.Handle("/services/{service}/stats", service.Handler{Config: config, F: ServiceStatsHandler}).Methods("GET")
 	a.Mux.Handle("/services/{service}/upstreams", service.Handler{Config: config, F: UpstreamCreateHandler}).Methods("PUT")
+	a.Mux.Handle("/services/{service}/upstreams:sync", service.Handler{Config: config, F: UpstreamSyncHandler}).Methods("PUT")
 	a.Mux.Handle("/services/{service}/upstreams/{upstream}", service.Handler{Config: config, F: UpstreamDeleteHandler}).Methods("DELETE")
+	a.Mux.Handle("/services/{service}/upstreams/{upstream}", service.Handler{Config: config, F: UpstreamUpdateHandler}).Methods("PATCH")
+	a.Mux.Handle("/services/{service}/certificates", service.Handler{Config: config, F: CertificateCreateHandler}).Methods("POST")
+	a.Mux.Handle("/services/{service}/certificates", service.Handler{Config: config, F: CertificateListHandler}).Methods("GET")
+	a.Mux.Handle("/services/{service}/certificates/{certificate}", service.Handler{Config: config, F: CertificateDeleteHandler}).Methods("DELETE")
+	a.Mux.Handle("/services/{service}/http-rules", service.Handler{Config: config, F: HTTPRuleCreateHandler}).Methods("POST")
+	a.Mux.Handle("/services/{service}/http-rules", service.Handler{Config: config, F: HTTPRuleListHandler}).Methods("GET")
+	a.Mux.Handle("/services/{service}/http-rules/{rule}", service.Handler{Config: config, F: HTTPRuleUpdateHandler}).Methods("PUT")
+	a.Mux.Handle("/services/{service}/http-rules/{rule}", service.Handler{Config: config, F: HTTPRuleDeleteHandler}).Methods("DELETE")
+	a.Mux.Handle("/snapshot", service.Handler{Config: config, F: SnapshotHandler}).Methods("GET")
+	a.Mux.Handle("/restore", service.Handler{Config: config, F: RestoreHandler}).Methods("POST")
 	a.Mux.Handle("/agent/reload", service.Handler{Config: config, F: AgentReloadHandler}).Methods("POST")
+	a.Mux.Handle("/keyring/refresh", service.Handler{Config: config, F: KeyringRefreshHandler}).Methods("POST")
+	a.Mux.Handle("/keyring/reencrypt", service.Handler{Config: config, F: KeyringReencryptHandler}).Methods("POST")
+	a.Mux.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	a.Mux.Handle("/.well-known/acme-challenge/{token}", ACMEChallengeHandler(config)).Methods("GET")
+	a.Mux.Handle("/haproxy/config", HaproxyConfigHandler(config)).Methods("GET")
 
 	return a
 }
 
 func convertServiceToResponse(s kvs.Service) service.ServiceResponse {
 	sr := service.ServiceResponse{
-		Name:      s.Name(),
-		Port:      s.Port(),
-		Type:      s.Type(),
-		Config:    map[string]interface{}{},
-		Upstreams: []service.UpstreamResponse{},
+		Name:         s.Name(),
+		Port:         s.Port(),
+		Type:         s.Type(),
+		Balance:      s.Balance().Algorithm,
+		Config:       map[string]interface{}{},
+		Upstreams:    []service.UpstreamResponse{},
+		Certificates: []service.CertificateResponse{},
 	}
 
 	for k, v := range s.ServiceConfig() {
 		sr.Config[k] = v
 	}
 
+	if sticky := s.Balance().Sticky; sticky != nil {
+		sr.Sticky = &service.Sticky{Cookie: sticky.Cookie, Mode: sticky.Mode}
+	}
+
+	if hc := s.HealthCheck(); hc != nil {
+		sr.Config["health_check"] = convertHealthCheckToResponse(hc)
+	}
+
+	if l := s.Limits(); l != nil {
+		sr.Config["limits"] = convertLimitsToResponse(l)
+	}
+
+	if rules := s.HTTPRules(); len(rules) > 0 {
+		sr.Config["http_rules"] = convertHTTPRulesToResponse(rules)
+	}
+
 	for _, u := range s.Upstreams() {
-		ur := service.UpstreamResponse{
-			ID:   u.ID,
-			Host: u.Host,
-			Port: u.Port,
-		}
-		sr.Upstreams = append(sr.Upstreams, ur)
+		sr.Upstreams = append(sr.Upstreams, convertUpstreamToResponse(u))
+	}
+
+	for _, c := range s.Certificates() {
+		sr.Certificates = append(sr.Certificates, convertCertificateToResponse(c))
 	}
 
 	return sr
 
 }
+
+func convertUpstreamToResponse(u kvs.Upstream) service.UpstreamResponse {
+	return service.UpstreamResponse{
+		ID:     u.ID,
+		Host:   u.Host,
+		Port:   u.Port,
+		Weight: u.Weight,
+		Backup: u.Backup,
+		State:  u.State,
+	}
+}
+
+func convertCertificateToResponse(c kvs.Certificate) service.CertificateResponse {
+	return service.CertificateResponse{
+		ID:        c.ID,
+		Names:     c.Names,
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		Expired:   c.Expired(time.Now()),
+	}
+}
@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// convertRequestToBalance converts and validates a requested balance for a
+// service of type serviceType. It returns nil if neither an algorithm nor
+// sticky sessions were requested.
+func convertRequestToBalance(algorithm string, sticky *service.Sticky, serviceType string) (*kvs.Balance, error) {
+	if algorithm == "" && sticky == nil {
+		return nil, nil
+	}
+
+	b := &kvs.Balance{Algorithm: algorithm}
+	if sticky != nil {
+		b.Sticky = &kvs.StickySessions{Cookie: sticky.Cookie, Mode: sticky.Mode}
+	}
+
+	b.SetDefaults()
+	if err := b.Validate(serviceType); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// CertificateCreateHandler adds a TLS certificate to a service.
+func CertificateCreateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	var ccr service.CertificateCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&ccr)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	id, err := sm.AddCertificate(svcName, ccr)
+	if err != nil {
+		config.GetLogger().WithError(err).WithField("service-name", svcName).Error("could not add certificate")
+		return service.Response{Body: err, Status: 400}
+	}
+
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		return service.Response{Body: err, Status: 400}
+	}
+
+	for _, cert := range svc.Certificates() {
+		if cert.ID == id {
+			return service.Response{Body: convertCertificateToResponse(cert), Status: http.StatusCreated}
+		}
+	}
+
+	return service.Response{Body: fmt.Errorf("certificate %s was not found", id), Status: 500}
+}
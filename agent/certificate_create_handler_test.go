@@ -0,0 +1,97 @@
+package agent_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CertificateCreateHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		ccr            = service.CertificateCreateRequest{Certificate: "cert", Key: "key"}
+	)
+
+	BeforeEach(func() {
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		b, err := json.Marshal(&ccr)
+		Ω(err).ToNot(HaveOccurred())
+
+		u.Path = "/services/service-a/certificates"
+		resp, err = http.Post(u.String(), "application/json", bytes.NewReader(b))
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with a valid certificate", func() {
+
+		var notAfter = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		BeforeEach(func() {
+			svc := kvs.NewHTTPService("service-a")
+			svc.AddCertificate(kvs.Certificate{ID: "1", Names: []string{"example.com"}, NotAfter: notAfter})
+
+			serviceManager.On("AddCertificate", "service-a", ccr).Return("1", nil)
+			serviceManager.On("Service", "service-a").Return(svc, nil)
+		})
+
+		It("returns a 201", func() {
+			Ω(resp.StatusCode).To(Equal(201))
+		})
+
+		It("reports the certificate", func() {
+			var cr service.CertificateResponse
+			Ω(json.NewDecoder(resp.Body).Decode(&cr)).To(Succeed())
+			Ω(cr.ID).To(Equal("1"))
+			Ω(cr.Names).To(Equal([]string{"example.com"}))
+			Ω(cr.NotAfter).To(Equal(notAfter))
+			Ω(cr.Expired).To(BeFalse())
+		})
+	})
+
+	Context("with an invalid certificate", func() {
+
+		BeforeEach(func() {
+			serviceManager.On("AddCertificate", "service-a", ccr).Return("", errors.New("invalid certificate or key"))
+		})
+
+		It("returns a 400", func() {
+			Ω(resp.StatusCode).To(Equal(400))
+		})
+	})
+})
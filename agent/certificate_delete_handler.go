@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// CertificateDeleteHandler removes a TLS certificate from a service.
+func CertificateDeleteHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+	certID := vars["certificate"]
+
+	sm := config.ServiceManagerFactory(config)
+	err := sm.DeleteCertificate(svcName, certID)
+
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name":   svcName,
+			"certificate-id": certID,
+		}).Error("could not delete certificate")
+		return service.Response{Body: err, Status: 404}
+	}
+
+	return service.Response{Status: 204}
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// CertificateListHandler lists the TLS certificates of a service and when
+// they expire.
+func CertificateListHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	sm := config.ServiceManagerFactory(config)
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		config.GetLogger().WithError(err).WithField("service-name", svcName).Error("could not retrieve service")
+		return service.Response{Body: err, Status: 404}
+	}
+
+	cr := service.CertificatesResponse{
+		Certificates: []service.CertificateResponse{},
+	}
+	for _, cert := range svc.Certificates() {
+		cr.Certificates = append(cr.Certificates, convertCertificateToResponse(cert))
+	}
+
+	return service.Response{Body: cr, Status: http.StatusOK}
+}
@@ -10,12 +10,19 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
 )
 
 var (
 	// checkTTL is the time to live for cluster member keys
 	checkTTL = 10 * time.Second
 
+	// watchBackoff is how long a member waits before watching the cluster
+	// again after its watch ended. It doubles up to watchMaxBackoff while
+	// the watch can't be restarted.
+	watchBackoff    = time.Second
+	watchMaxBackoff = 30 * time.Second
+
 	// ErrClusterNotJoined is returned when this agent has not joined a cluster.
 	ErrClusterNotJoined = errors.New("agent has not joined the cluster")
 
@@ -53,10 +60,13 @@ type ClusterMember struct {
 
 	started       bool
 	modifiedIndex uint64
+	changes       []chan ClusterStatus
+	watchBackoff  time.Duration
 
 	schedule func(*ClusterMember, string, scheduleFn, time.Duration)
 	poll     func(el *ClusterMember) error
 	refresh  func(el *ClusterMember) error
+	watch    func(el *ClusterMember) error
 
 	logger *logrus.Entry
 	mu     sync.Mutex
@@ -70,64 +80,81 @@ func NewClusterMember(name string, config *Config) *ClusterMember {
 		logger: logrus.WithFields(logrus.Fields{
 			"member-name": name,
 		}),
-		name:    name,
-		refresh: refresh,
+		name:         name,
+		refresh:      refresh,
+		watchBackoff: watchBackoff,
 
 		schedule: schedule,
 		poll:     poll,
+		watch:    watch,
 	}
 }
 
-// Change creates a channel that outputs the current cluster leader.
+// Change creates a channel that outputs the cluster status whenever the
+// cluster leader changes. If the leader is already known, the current status
+// is sent first, so listeners which subscribe after Start don't miss it. If
+// the reader falls behind, only the latest status is kept.
 func (cm *ClusterMember) Change() chan ClusterStatus {
-	t := time.NewTicker(time.Millisecond * 250)
 	out := make(chan ClusterStatus, 1)
 
-	leader := cm.Leader
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 
-	go func() {
-		for {
-			select {
-			case <-t.C:
-				cm.mu.Lock()
-				currentLeader := cm.Leader
-				cm.mu.Unlock()
-
-				if leader != currentLeader {
-					cs := ClusterStatus{
-						IsLeader:  cm.isLeader(),
-						Leader:    currentLeader,
-						NodeCount: cm.NodeCount,
-					}
-					leader = currentLeader
-					out <- cs
-				}
-			case <-cm.context.Done():
-				break
-			}
-		}
-	}()
+	cm.changes = append(cm.changes, out)
+	if cm.Leader != "" {
+		out <- cm.status()
+	}
 
 	return out
 }
 
-func (cm *ClusterMember) isLeader() bool {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	return cm.name == cm.Leader
+// status returns the cluster status. It must be called with cm.mu held.
+func (cm *ClusterMember) status() ClusterStatus {
+	return ClusterStatus{
+		IsLeader:  cm.name == cm.Leader,
+		Leader:    cm.Leader,
+		NodeCount: cm.NodeCount,
+	}
+}
+
+// notifyChange sends the cluster status to Change listeners. It must be
+// called with cm.mu held.
+func (cm *ClusterMember) notifyChange() {
+	cs := cm.status()
+
+	for _, ch := range cm.changes {
+		// drop a status the reader hasn't picked up yet.
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- cs
+	}
 }
 
 func (cm *ClusterMember) key() string {
 	return cm.root + cm.name
 }
 
+// isStarted returns true while the cluster membership process runs.
+func (cm *ClusterMember) isStarted() bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.started
+}
+
 // Start starts a cluster membership process.
 func (cm *ClusterMember) Start() error {
+	cm.mu.Lock()
 	if cm.started {
+		cm.mu.Unlock()
 		return ErrClusterJoined
 	}
 
 	cm.started = true
+	cm.mu.Unlock()
 
 	mi, err := cm.cmKVS.RegisterAgent(cm.name)
 	if err != nil {
@@ -136,7 +163,11 @@ func (cm *ClusterMember) Start() error {
 
 	cm.modifiedIndex = mi
 
-	go cm.schedule(cm, "poll", poll, time.Second)
+	err = cm.watch(cm)
+	if err != nil {
+		return &RegisterError{err: err, name: cm.name}
+	}
+
 	go cm.schedule(cm, "refresh", refresh, cm.cmKVS.CheckTTL/2)
 
 	return nil
@@ -144,6 +175,9 @@ func (cm *ClusterMember) Start() error {
 
 // Stop stops a cluster membership process.
 func (cm *ClusterMember) Stop() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	if !cm.started {
 		return ErrClusterNotJoined
 	}
@@ -154,31 +188,92 @@ func (cm *ClusterMember) Stop() error {
 
 type scheduleFn func(*ClusterMember) error
 
+// schedule runs fn every timeout until the cluster membership is stopped.
+// Errors are logged and fn runs again at the next tick.
 func schedule(cm *ClusterMember, name string, fn scheduleFn, timeout time.Duration) {
 	logger := cm.logger.WithField("cluster-action", name)
 
 	t := time.NewTicker(timeout)
-	quit := make(chan struct{})
+	defer t.Stop()
+
+	for range t.C {
+		if !cm.isStarted() {
+			logger.Info("shutting down")
+			return
+		}
+
+		if err := fn(cm); err != nil {
+			logger.WithError(err).Error("could not run scheduled item")
+		}
+	}
+}
+
+// watch watches the cluster members and updates the leader whenever they
+// change.
+func watch(cm *ClusterMember) error {
+	events, err := cm.cmKVS.WatchLeader()
+	if err != nil {
+		return err
+	}
+
+	cm.pollLeader()
+	go cm.followLeader(events)
+
+	return nil
+}
+
+// followLeader updates the leader whenever events fire. If the watch ends
+// while the membership is started, the cluster is watched again with
+// backoff, and the leader is read again because changes may have been
+// missed in the meantime.
+func (cm *ClusterMember) followLeader(events <-chan kvs.Event) {
+	backoff := cm.watchBackoff
 
 	for {
-		if !cm.started {
-			t.Stop()
-			close(quit)
-			break
+		for range events {
+			if !cm.isStarted() {
+				return
+			}
+
+			backoff = cm.watchBackoff
+			cm.pollLeader()
 		}
 
-		select {
-		case <-t.C:
-			err := fn(cm)
-			if err != nil {
-				logger.WithError(err).Error("could not run scheduled item")
-				t.Stop()
-				close(quit)
+		for {
+			if !cm.isStarted() {
+				return
 			}
-		case <-quit:
-			logger.Info("shutting down")
-			return
+
+			cm.logger.WithField("backoff", backoff).Warn("cluster watch ended; watching again")
+
+			select {
+			case <-time.After(backoff):
+			case <-cm.context.Done():
+				return
+			}
+
+			backoff *= 2
+			if backoff > watchMaxBackoff {
+				backoff = watchMaxBackoff
+			}
+
+			var err error
+			events, err = cm.cmKVS.WatchLeader()
+			if err == nil {
+				break
+			}
+
+			cm.logger.WithError(err).Error("could not watch cluster")
 		}
+
+		cm.pollLeader()
+	}
+}
+
+// pollLeader updates the leader and logs failures.
+func (cm *ClusterMember) pollLeader() {
+	if err := cm.poll(cm); err != nil {
+		cm.logger.WithError(err).Error("could not retrieve cluster leader")
 	}
 }
 
@@ -190,14 +285,17 @@ func poll(cm *ClusterMember) error {
 
 	logMsg := cm.logger
 	shouldLog := false
+	leaderChanged := false
 
 	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	if l := leader.Name; cm.Leader != l {
 		logMsg = logMsg.WithField("leader", l)
 		cm.Leader = l
 		shouldLog = true
+		leaderChanged = true
 	}
-	cm.mu.Unlock()
 
 	if nc := leader.NodeCount; cm.NodeCount != nc {
 		logMsg = logMsg.WithField("node-count", nc)
@@ -209,11 +307,22 @@ func poll(cm *ClusterMember) error {
 		logMsg.Info("cluster updated")
 	}
 
+	if leaderChanged {
+		cm.notifyChange()
+	}
+
 	return nil
 }
 
+// refresh renews the agent's cluster membership. If the membership expired
+// or was replaced, the agent registers again.
 func refresh(cm *ClusterMember) error {
 	mi, err := cm.cmKVS.Refresh(cm.name, cm.modifiedIndex)
+	if isLostMembership(err) {
+		cm.logger.WithError(err).Warn("cluster membership lost; registering again")
+		mi, err = cm.cmKVS.RegisterAgent(cm.name)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -222,3 +331,19 @@ func refresh(cm *ClusterMember) error {
 
 	return nil
 }
+
+// isLostMembership returns true if err means the agent's membership key has
+// expired or was changed by someone else.
+func isLostMembership(err error) bool {
+	kverr, ok := err.(*kvs.KVError)
+	if !ok {
+		return false
+	}
+
+	eerr, ok := kverr.Err.(etcdclient.Error)
+	if !ok {
+		return false
+	}
+
+	return eerr.Code == etcdclient.ErrorCodeKeyNotFound || eerr.Code == etcdclient.ErrorCodeTestFailed
+}
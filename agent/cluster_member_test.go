@@ -6,6 +6,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
 	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
@@ -40,26 +41,129 @@ var _ = Describe("ClusterMember", func() {
 			},
 			poll:    poll,
 			refresh: refresh,
+			watch: func(*ClusterMember) error {
+				return nil
+			},
 		}
 	})
 
 	Describe("change", func() {
 		It("emits the change", func() {
-			newCtx, fn := context.WithCancel(cm.context)
-			cm.context = newCtx
+			csChan := cm.Change()
+
+			opts := &kvs.GetOptions{Recursive: true}
+			node := &kvs.Node{
+				Nodes: kvs.Nodes{
+					{ModifiedIndex: 5, CreatedIndex: 1, Value: cm.name},
+				},
+			}
+			mockKVS.On("Get", "/agent/leader", opts).Return(node, nil)
+
+			err = poll(cm)
+			Ω(err).ToNot(HaveOccurred())
+
+			var cs ClusterStatus
+			Eventually(csChan).Should(Receive(&cs))
+			Ω(cs.Leader).To(Equal(cm.name))
+			Ω(cs.IsLeader).To(BeTrue())
+		})
+
+		It("emits the current status to new listeners", func() {
+			cm.Leader = cm.name
+			cm.NodeCount = 2
+
+			var cs ClusterStatus
+			Ω(cm.Change()).To(Receive(&cs))
+			Ω(cs).To(Equal(ClusterStatus{Leader: cm.name, IsLeader: true, NodeCount: 2}))
+		})
 
+		It("doesn't emit when the leader is unchanged", func() {
+			cm.Leader = cm.name
 			csChan := cm.Change()
+			Ω(csChan).To(Receive())
 
-			go func() {
-				cm.mu.Lock()
-				defer cm.mu.Unlock()
-				cm.Leader = cm.name
-			}()
-			cs := <-csChan
+			opts := &kvs.GetOptions{Recursive: true}
+			node := &kvs.Node{
+				Nodes: kvs.Nodes{
+					{ModifiedIndex: 5, CreatedIndex: 1, Value: cm.name},
+				},
+			}
+			mockKVS.On("Get", "/agent/leader", opts).Return(node, nil)
+
+			err = poll(cm)
+			Ω(err).ToNot(HaveOccurred())
+			Consistently(csChan).ShouldNot(Receive())
+		})
+	})
+
+	Describe("watch", func() {
+		It("follows leader changes in the kvs", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mem := kvs.NewMemory(ctx)
+			cm.cmKVS = kvs.NewCluster(mem, time.Minute)
+			cm.started = true
+
+			_, err = cm.cmKVS.RegisterAgent("other")
+			Ω(err).ToNot(HaveOccurred())
+
+			err = watch(cm)
+			Ω(err).ToNot(HaveOccurred())
+			defer cm.Stop()
+
+			Ω(cm.Leader).To(Equal("other"))
+
+			csChan := cm.Change()
+
+			var cs ClusterStatus
+			Ω(csChan).To(Receive(&cs))
+			Ω(cs.IsLeader).To(BeFalse())
+
+			_, err = cm.cmKVS.RegisterAgent(cm.name)
+			Ω(err).ToNot(HaveOccurred())
+			err = mem.Delete("/agent/leader/other")
+			Ω(err).ToNot(HaveOccurred())
+
+			Eventually(csChan).Should(Receive(&cs))
 			Ω(cs.Leader).To(Equal(cm.name))
 			Ω(cs.IsLeader).To(BeTrue())
+		})
+
+		It("watches again when the watch ends", func() {
+			cm.watchBackoff = time.Millisecond
+			cm.started = true
+
+			first := make(chan kvs.Event)
+			second := make(chan kvs.Event)
+			mockKVS.On("Watch", "/agent/leader", true).Return((<-chan kvs.Event)(first), nil).Once()
+			mockKVS.On("Watch", "/agent/leader", true).Return((<-chan kvs.Event)(second), nil).Once()
+
+			opts := &kvs.GetOptions{Recursive: true}
+			node := &kvs.Node{
+				Nodes: kvs.Nodes{
+					{ModifiedIndex: 5, CreatedIndex: 1, Value: cm.name},
+				},
+			}
+			mockKVS.On("Get", "/agent/leader", opts).Return(node, nil)
+
+			err = watch(cm)
+			Ω(err).ToNot(HaveOccurred())
+
+			close(first)
 
-			fn()
+			// the new watch is followed once the second channel is read.
+			Eventually(func() bool {
+				select {
+				case second <- kvs.Event{}:
+					return true
+				default:
+					return false
+				}
+			}).Should(BeTrue())
+
+			Ω(cm.Stop()).To(Succeed())
+			close(second)
 		})
 	})
 
@@ -126,22 +230,50 @@ var _ = Describe("ClusterMember", func() {
 
 			Ω(cm.modifiedIndex).To(Equal(uint64(99)))
 		})
+
+		It("registers again if the membership was lost", func() {
+			cm.started = true
+			cm.modifiedIndex = 5
+
+			opts := &kvs.SetOptions{TTL: 5 * time.Second, PrevIndex: 5}
+			theErr := &kvs.KVError{Key: "/agent/leader/test", Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			mockKVS.On("Set", "/agent/leader/test", "test", opts).Return(nil, theErr)
+
+			registerOpts := &kvs.SetOptions{TTL: 5 * time.Second}
+			node := &kvs.Node{ModifiedIndex: 100}
+			mockKVS.On("Set", "/agent/leader/test", "test", registerOpts).Return(node, nil)
+
+			Ω(refresh(cm)).To(Succeed())
+			Ω(cm.modifiedIndex).To(Equal(uint64(100)))
+		})
+
+		It("returns other errors", func() {
+			cm.started = true
+
+			opts := &kvs.SetOptions{TTL: 5 * time.Second}
+			mockKVS.On("Set", "/agent/leader/test", "test", opts).Return(nil, errors.New("timeout"))
+
+			Ω(refresh(cm)).ToNot(Succeed())
+		})
 	})
 
 	Describe("schedule", func() {
-		It("schedules operations", func() {
+		It("keeps running operations after an error", func() {
 			cm.started = true
 
-			ran := false
+			runs := 0
 
-			fn := func(*ClusterMember) error {
-				ran = true
+			fn := func(cm *ClusterMember) error {
+				runs++
+				if runs == 3 {
+					cm.started = false
+				}
 				return errors.New("bye bye")
 			}
 
-			schedule(cm, "testing", fn, 100*time.Millisecond)
+			schedule(cm, "testing", fn, 10*time.Millisecond)
 
-			Ω(ran).To(BeTrue())
+			Ω(runs).To(Equal(3))
 		})
 	})
 })
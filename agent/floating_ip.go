@@ -17,6 +17,13 @@ import (
 var (
 	fipKey        = "/agent/floating_ip"
 	fipDropletKey = "/agent/floating_ip_droplet"
+
+	// fipLockTTL is the time to live of the floating ip lock. The lock is
+	// renewed while it is held.
+	fipLockTTL = 30 * time.Second
+
+	// fipLockTimeout is how long to wait for the floating ip lock.
+	fipLockTimeout = 2 * time.Minute
 )
 
 // FloatingIPManager manages DigitalOcean floating ips for the agent.
@@ -30,7 +37,7 @@ type EtcdFloatingIPManager struct {
 	dropletID  string
 	godoClient *godo.Client
 	fipKVS     *kvs.FipKVS
-	locker     Locker
+	locker     kvs.KLock
 	name       string
 	logger     *logrus.Entry
 
@@ -46,12 +53,8 @@ func NewFloatingIPManager(config *Config) (*EtcdFloatingIPManager, error) {
 		return nil, errors.New("requires DigitalOceanToken")
 	}
 
-	locker := &etcdLocker{
-		context: config.Context,
-		key:     "/agent/floating_ip",
-		who:     config.Name,
-		kv:      config.KVS,
-	}
+	locker := kvs.NewLock("floating_ip", config.KVS)
+	locker.Owner = config.Name
 
 	return &EtcdFloatingIPManager{
 		context:    config.Context,
@@ -116,9 +119,18 @@ func (fim *EtcdFloatingIPManager) Reserve() (string, error) {
 			"current-id": fip.Droplet.ID,
 			"wanted-id":  id,
 		}).Info("moving floating ip")
-		fim.locker.Lock()
+		ctx, cancel := context.WithTimeout(fim.context, fipLockTimeout)
+		defer cancel()
+
+		token, err := fim.locker.Lock(ctx, fipLockTTL)
+		if err != nil {
+			fim.logger.WithError(err).Error("could not lock floating ip")
+			return "", err
+		}
 		defer fim.locker.Unlock()
 
+		fim.logger.WithField("fencing-token", token).Info("locked floating ip")
+
 		action, _, err := fim.godoClient.FloatingIPActions.Assign(ip, id)
 		if err != nil {
 			logrus.WithError(err).Error("could not retrieve DigitalOcean floating ip to current droplet")
@@ -155,12 +167,7 @@ func (fim *EtcdFloatingIPManager) Reserve() (string, error) {
 
 func existingIP(fim *EtcdFloatingIPManager) (string, error) {
 	fim.logger.Info("checking for existing floating ip")
-	node, err := fim.fipKVS.Get(fipKey, nil)
-	if err != nil {
-		return "", err
-	}
-
-	return node.Value, nil
+	return fim.fipKVS.FloatingIP()
 }
 
 func assignNewIP(fim *EtcdFloatingIPManager) (string, error) {
@@ -178,7 +185,7 @@ func assignNewIP(fim *EtcdFloatingIPManager) (string, error) {
 		return "", err
 	}
 
-	_, err = fim.fipKVS.Set(fipKey, fip.IP, nil)
+	err = fim.fipKVS.SetFloatingIP(fip.IP)
 	if err != nil {
 		return "", err
 	}
@@ -21,6 +21,7 @@ var _ = Describe("FloatingIp", func() {
 		godoFIP       *mocks.FloatingIPsService
 		godoFIPAction *mocks.FloatingIPActionsService
 		mkvs          *kvs.MockKVS
+		mlock         *kvs.MockKLock
 		godoClient    *godo.Client
 		efm           *EtcdFloatingIPManager
 	)
@@ -29,6 +30,7 @@ var _ = Describe("FloatingIp", func() {
 		godoFIP = &mocks.FloatingIPsService{}
 		godoFIPAction = &mocks.FloatingIPActionsService{}
 		mkvs = &kvs.MockKVS{}
+		mlock = &kvs.MockKLock{}
 
 		godoClient = &godo.Client{
 			FloatingIPs:       godoFIP,
@@ -40,6 +42,7 @@ var _ = Describe("FloatingIp", func() {
 		godoFIP.AssertExpectations(GinkgoT())
 		godoFIPAction.AssertExpectations(GinkgoT())
 		mkvs.AssertExpectations(GinkgoT())
+		mlock.AssertExpectations(GinkgoT())
 	})
 
 	JustBeforeEach(func() {
@@ -48,7 +51,7 @@ var _ = Describe("FloatingIp", func() {
 			dropletID:  "12345",
 			godoClient: godoClient,
 			fipKVS:     kvs.NewFipKVS(mkvs),
-			locker:     &memLocker{},
+			locker:     mlock,
 			logger:     logrus.WithField("test", "test"),
 			assignNewIP: func(*EtcdFloatingIPManager) (string, error) {
 				return "192.168.1.2", nil
@@ -131,6 +134,8 @@ var _ = Describe("FloatingIp", func() {
 					ID:     1,
 					Status: "completed",
 				}
+				mlock.On("Lock", mock.Anything, fipLockTTL).Return(uint64(3), nil).Once()
+				mlock.On("Unlock").Return(nil).Once()
 				godoFIPAction.On("Assign", "192.168.1.2", 12345).Return(a1, nil, nil)
 
 				godoFIPAction.On("Get", "192.168.1.2", 1).Return(a1, nil, nil).Once()
@@ -140,6 +145,20 @@ var _ = Describe("FloatingIp", func() {
 				Ω(err).ToNot(HaveOccurred())
 				Ω(ip).To(Equal("192.168.1.2"))
 			})
+
+			It("doesn't move the ip without the lock", func() {
+				fip := &godo.FloatingIP{
+					Droplet: &godo.Droplet{
+						ID: 12346,
+					},
+				}
+				godoFIP.On("Get", "192.168.1.2").Return(fip, nil, nil)
+
+				mlock.On("Lock", mock.Anything, fipLockTTL).Return(uint64(0), context.DeadlineExceeded).Once()
+
+				_, err := efm.Reserve()
+				Ω(err).To(Equal(context.DeadlineExceeded))
+			})
 		})
 	})
 
@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/haproxy"
+	"github.com/bryanl/dolb/kvs"
+)
+
+var (
+	// haproxySettleDelay is how long the config poller waits for a burst
+	// of kvs changes to finish before rendering.
+	haproxySettleDelay = time.Second
+
+	// haproxyRetryDelay is how long the config poller waits before retrying
+	// a failed sync.
+	haproxyRetryDelay = 10 * time.Second
+)
+
+// haproxyOptions are the options the agent renders haproxy's configuration
+// with.
+func haproxyOptions(config *Config) haproxy.Options {
+	return haproxy.Options{
+		StatsSocket:          config.HaproxyStatsSocket,
+		CertificateDir:       config.HaproxyCertificateDir,
+		ACMEChallengeAddress: config.ACMEChallengeAddress,
+	}
+}
+
+// renderHaproxyConfig renders haproxy's configuration from the services in
+// the kvs.
+func renderHaproxyConfig(config *Config) ([]byte, error) {
+	services, err := config.ServiceManagerFactory(config).Services()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := haproxy.Render(&buf, services, haproxyOptions(config)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// HaproxyConfigHandler returns the haproxy configuration rendered from the
+// current services.
+func HaproxyConfigHandler(config *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := renderHaproxyConfig(config)
+		if err != nil {
+			config.GetLogger().WithError(err).Error("could not render haproxy config")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(cfg)
+	})
+}
+
+// PollHaproxyConfig keeps haproxy's configuration in sync with the kvs. It
+// writes the configuration once at startup and again whenever the haproxy
+// keys change. Upstream changes are applied through haproxy's runtime API
+// when possible, and haproxy is reloaded for anything else. A failed sync is
+// retried until it succeeds. It does nothing if no configuration file is
+// configured.
+func (a *Agent) PollHaproxyConfig() {
+	log := a.Config.GetLogger()
+
+	if a.Config.HaproxyConfigFile == "" {
+		log.Info("haproxy config file not configured; not writing haproxy config")
+		return
+	}
+
+	h := kvs.NewLiveHaproxy(a.Config.KVS, a.Config.IDGen, log)
+	events, err := h.Watch(h.RootKey, true)
+	if err != nil {
+		log.WithError(err).Error("unable to watch haproxy keys")
+		return
+	}
+
+	log.Info("starting haproxy config poller")
+
+	var applied *haproxyState
+	for {
+		state, err := a.syncHaproxyConfig(h, applied)
+		var retry <-chan time.Time
+		if err != nil {
+			log.WithError(err).Error("unable to sync haproxy config")
+			retry = time.After(haproxyRetryDelay)
+		} else {
+			applied = state
+		}
+
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-retry:
+		}
+
+		if !settleEvents(events, haproxySettleDelay) {
+			return
+		}
+	}
+}
+
+// settleEvents drains events until none arrive for delay. It returns false
+// if events is closed.
+func settleEvents(events <-chan kvs.Event, delay time.Duration) bool {
+	settle := time.After(delay)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return false
+			}
+		case <-settle:
+			return true
+		}
+	}
+}
+
+// haproxyState is the configuration haproxy was last successfully updated
+// with.
+type haproxyState struct {
+	services []kvs.Service
+	config   []byte
+	certs    map[string][]byte
+}
+
+// sameAs returns true if other has the same config and certificates.
+func (s *haproxyState) sameAs(other *haproxyState) bool {
+	return other != nil && bytes.Equal(s.config, other.config) && s.sameCerts(other)
+}
+
+// sameCerts returns true if other has the same certificates.
+func (s *haproxyState) sameCerts(other *haproxyState) bool {
+	if other == nil || len(s.certs) != len(other.certs) {
+		return false
+	}
+
+	for name, cert := range s.certs {
+		if oc, ok := other.certs[name]; !ok || !bytes.Equal(cert, oc) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// syncHaproxyConfig writes haproxy's configuration and certificates, and
+// updates haproxy if they differ from applied, the state haproxy was last
+// successfully updated with. It returns the state haproxy is running with.
+// If haproxy couldn't be updated, it returns an error and the caller should
+// keep applied.
+func (a *Agent) syncHaproxyConfig(h *kvs.LiveHaproxy, applied *haproxyState) (*haproxyState, error) {
+	log := a.Config.GetLogger().WithField("haproxy-config", a.Config.HaproxyConfigFile)
+
+	services, err := h.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &haproxyState{services: services}
+
+	if dir := a.Config.HaproxyCertificateDir; dir != "" {
+		state.certs, err = certificateBundles(h, services)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := haproxy.WriteCertificates(dir, state.certs); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := haproxy.Render(&buf, services, haproxyOptions(a.Config)); err != nil {
+		return nil, err
+	}
+	state.config = buf.Bytes()
+
+	if _, err := haproxy.WriteFile(a.Config.HaproxyConfigFile, state.config, 0644); err != nil {
+		return nil, err
+	}
+
+	if state.sameAs(applied) {
+		return applied, nil
+	}
+
+	log.Info("wrote haproxy config")
+	if state.sameCerts(applied) && a.updateHaproxyRuntime(applied.services, services, log) {
+		return state, nil
+	}
+
+	if err := reloadHaproxy(a.Config.HaproxyReloadCommand, log); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// updateHaproxyRuntime applies the changes from previous to current through
+// haproxy's runtime API. It returns false if haproxy has to be reloaded
+// instead.
+func (a *Agent) updateHaproxyRuntime(previous, current []kvs.Service, log *logrus.Entry) bool {
+	socket := a.Config.HaproxyStatsSocket
+	if previous == nil || socket == "" {
+		return false
+	}
+
+	commands, ok, err := haproxy.RuntimeChanges(previous, current, haproxyOptions(a.Config))
+	if err != nil {
+		log.WithError(err).Error("unable to compare haproxy configs")
+		return false
+	}
+
+	if !ok {
+		log.Info("haproxy config changed structurally")
+		return false
+	}
+
+	if err := haproxy.NewRuntime(socket).Apply(commands); err != nil {
+		log.WithError(err).Warn("unable to update haproxy through the runtime api")
+		return false
+	}
+
+	log.WithField("commands", len(commands)).Info("updated haproxy through the runtime api")
+	return true
+}
+
+// certificateBundles returns a PEM bundle of each certificate's chain and
+// key, named after the service and certificate.
+func certificateBundles(h *kvs.LiveHaproxy, services []kvs.Service) (map[string][]byte, error) {
+	bundles := map[string][]byte{}
+	for _, s := range services {
+		for _, c := range s.Certificates() {
+			key, err := h.CertificateKey(s.Name(), c.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			chain := c.PEM
+			if !strings.HasSuffix(chain, "\n") {
+				chain += "\n"
+			}
+
+			bundles[s.Name()+"-"+c.ID] = []byte(chain + key)
+		}
+	}
+
+	return bundles, nil
+}
+
+// reloadHaproxy runs the reload command with the shell.
+func reloadHaproxy(command string, log *logrus.Entry) error {
+	if command == "" {
+		log.Warn("haproxy reload command not configured; not reloading haproxy")
+		return nil
+	}
+
+	out, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to reload haproxy: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	log.Info("reloaded haproxy")
+	return nil
+}
@@ -0,0 +1,87 @@
+package agent_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HaproxyConfigHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+	)
+
+	BeforeEach(func() {
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ACMEChallengeAddress: "127.0.0.1:8889",
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/haproxy/config"
+		resp, err = http.Get(u.String())
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with services", func() {
+
+		BeforeEach(func() {
+			svc := kvs.NewTCPService("service-a")
+			upstream := kvs.NewUpstream("10.0.0.1", 5432)
+			upstream.ID = "1"
+			svc.AddUpstream(upstream)
+
+			serviceManager.On("Services").Return([]kvs.Service{svc}, nil)
+		})
+
+		It("returns the rendered config", func() {
+			Ω(resp.StatusCode).To(Equal(200))
+			Ω(resp.Header.Get("Content-Type")).To(Equal("text/plain"))
+
+			b, err := ioutil.ReadAll(resp.Body)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(string(b)).To(ContainSubstring("backend service-a\n    mode tcp"))
+			Ω(string(b)).To(ContainSubstring("server 1 10.0.0.1:5432 weight 1"))
+		})
+	})
+
+	Context("when services can't be loaded", func() {
+
+		BeforeEach(func() {
+			serviceManager.On("Services").Return(nil, errors.New("kvs unavailable"))
+		})
+
+		It("returns a 500", func() {
+			Ω(resp.StatusCode).To(Equal(500))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("syncHaproxyConfig", func() {
+
+	var (
+		dir    string
+		reload string
+		h      *kvs.LiveHaproxy
+		a      *Agent
+		cancel context.CancelFunc
+	)
+
+	reloads := func() int {
+		b, err := ioutil.ReadFile(reload)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		Ω(err).ToNot(HaveOccurred())
+		return len(b)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "haproxy-sync")
+		Ω(err).ToNot(HaveOccurred())
+		reload = filepath.Join(dir, "reloads")
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem := kvs.NewMemory(ctx)
+
+		config := &Config{
+			KVS:                  mem,
+			HaproxyConfigFile:    filepath.Join(dir, "haproxy.cfg"),
+			HaproxyReloadCommand: "echo -n . >> " + reload,
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		a = &Agent{Config: config}
+
+		h = kvs.NewLiveHaproxy(mem, func() string { return "1" }, config.GetLogger())
+		Ω(h.Init()).To(Succeed())
+		Ω(h.TCP("service-a", 5432)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+		os.RemoveAll(dir)
+	})
+
+	It("reloads haproxy only when the config changes", func() {
+		applied, err := a.syncHaproxyConfig(h, nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(reloads()).To(Equal(1))
+
+		applied, err = a.syncHaproxyConfig(h, applied)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(reloads()).To(Equal(1))
+
+		_, err = h.Upstream("service-a", kvs.NewUpstream("10.0.0.1", 5432))
+		Ω(err).ToNot(HaveOccurred())
+		_, err = a.syncHaproxyConfig(h, applied)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(reloads()).To(Equal(2))
+	})
+
+	It("retries a failed reload although the file was written", func() {
+		a.Config.HaproxyReloadCommand = "false"
+		_, err := a.syncHaproxyConfig(h, nil)
+		Ω(err).To(HaveOccurred())
+
+		_, err = os.Stat(a.Config.HaproxyConfigFile)
+		Ω(err).ToNot(HaveOccurred())
+
+		a.Config.HaproxyReloadCommand = "echo -n . >> " + reload
+		_, err = a.syncHaproxyConfig(h, nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(reloads()).To(Equal(1))
+	})
+})
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// convertRequestToHealthCheck converts and validates a requested health
+// check. Settings which weren't supplied get defaults. It returns nil if no
+// health check was requested.
+func convertRequestToHealthCheck(req *service.HealthCheck) (*kvs.HealthCheck, error) {
+	if req == nil {
+		return nil, nil
+	}
+
+	hc := &kvs.HealthCheck{
+		Type:           req.Type,
+		Path:           req.Path,
+		ExpectedStatus: req.ExpectedStatus,
+		Rise:           req.Rise,
+		Fall:           req.Fall,
+	}
+
+	if req.Interval != "" {
+		interval, err := time.ParseDuration(req.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check interval %q", req.Interval)
+		}
+		hc.Interval = interval
+	}
+
+	hc.SetDefaults()
+	if err := hc.Validate(); err != nil {
+		return nil, err
+	}
+
+	return hc, nil
+}
+
+func convertHealthCheckToResponse(hc *kvs.HealthCheck) service.HealthCheck {
+	return service.HealthCheck{
+		Type:           hc.Type,
+		Path:           hc.Path,
+		ExpectedStatus: hc.ExpectedStatus,
+		Interval:       hc.Interval.String(),
+		Rise:           hc.Rise,
+		Fall:           hc.Fall,
+	}
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleCreateHandler appends an http rule to the rules of a service.
+func HTTPRuleCreateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	var hr service.HTTPRule
+	err := json.NewDecoder(r.Body).Decode(&hr)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	id, err := sm.AddHTTPRule(svcName, hr)
+	if err != nil {
+		config.GetLogger().WithError(err).WithField("service-name", svcName).Error("could not add http rule")
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	return httpRuleResponse(sm, svcName, id, http.StatusCreated)
+}
+
+// httpRuleResponse responds with the http rule of a service which has id.
+func httpRuleResponse(sm ServiceManager, svcName, id string, status int) service.Response {
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		return service.Response{Body: err, Status: 400}
+	}
+
+	for _, rule := range svc.HTTPRules() {
+		if rule.ID == id {
+			return service.Response{Body: convertHTTPRuleToResponse(rule), Status: status}
+		}
+	}
+
+	return service.Response{Body: fmt.Errorf("http rule %s was not found", id), Status: 500}
+}
@@ -0,0 +1,90 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPRuleCreateHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		rule           = service.HTTPRule{Action: "del-header", Direction: "response", Header: "Server"}
+	)
+
+	BeforeEach(func() {
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/services/service-a/http-rules"
+		body := `{"action":"del-header","direction":"response","header":"Server"}`
+		resp, err = http.Post(u.String(), "application/json", strings.NewReader(body))
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with an existing service", func() {
+
+		BeforeEach(func() {
+			svc := kvs.NewHTTPService("service-a")
+			svc.AddHTTPRule(kvs.HTTPRule{ID: "1", Action: "del-header", Direction: "response", Header: "Server"})
+
+			serviceManager.On("AddHTTPRule", "service-a", rule).Return("1", nil)
+			serviceManager.On("Service", "service-a").Return(svc, nil)
+		})
+
+		It("returns a 201", func() {
+			Ω(resp.StatusCode).To(Equal(201))
+		})
+
+		It("reports the rule", func() {
+			var hr service.HTTPRule
+			Ω(json.NewDecoder(resp.Body).Decode(&hr)).To(Succeed())
+			Ω(hr).To(Equal(service.HTTPRule{ID: "1", Action: "del-header", Direction: "response", Header: "Server"}))
+		})
+	})
+
+	Context("with a missing service", func() {
+
+		BeforeEach(func() {
+			notFound := &kvs.KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			serviceManager.On("AddHTTPRule", "service-a", rule).Return("", notFound)
+		})
+
+		It("returns a 404", func() {
+			Ω(resp.StatusCode).To(Equal(404))
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleDeleteHandler removes an http rule from a service.
+func HTTPRuleDeleteHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+	ruleID := vars["rule"]
+
+	sm := config.ServiceManagerFactory(config)
+	err := sm.DeleteHTTPRule(svcName, ruleID)
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name": svcName,
+			"rule-id":      ruleID,
+		}).Error("could not delete http rule")
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	return service.Response{Status: 204}
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleListHandler lists the http rules of a service in the order they
+// are applied.
+func HTTPRuleListHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	sm := config.ServiceManagerFactory(config)
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		config.GetLogger().WithError(err).WithField("service-name", svcName).Error("could not retrieve service")
+		return service.Response{Body: err, Status: 404}
+	}
+
+	hrr := service.HTTPRulesResponse{Rules: convertHTTPRulesToResponse(svc.HTTPRules())}
+
+	return service.Response{Body: hrr, Status: http.StatusOK}
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleUpdateHandler replaces an http rule of a service. The rule keeps
+// its id and position.
+func HTTPRuleUpdateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+	ruleID := vars["rule"]
+
+	var hr service.HTTPRule
+	err := json.NewDecoder(r.Body).Decode(&hr)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	err = sm.UpdateHTTPRule(svcName, ruleID, hr)
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name": svcName,
+			"rule-id":      ruleID,
+		}).Error("could not update http rule")
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	return httpRuleResponse(sm, svcName, ruleID, http.StatusOK)
+}
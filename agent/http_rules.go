@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// convertRequestToHTTPRule converts and validates a requested http rule.
+// The id of the request is ignored.
+func convertRequestToHTTPRule(req service.HTTPRule) (kvs.HTTPRule, error) {
+	r := kvs.HTTPRule{
+		Action:            req.Action,
+		Direction:         req.Direction,
+		Header:            req.Header,
+		Value:             req.Value,
+		Host:              req.Host,
+		Code:              req.Code,
+		MaxAge:            req.MaxAge,
+		IncludeSubdomains: req.IncludeSubdomains,
+	}
+
+	r.SetDefaults()
+	if err := r.Validate(); err != nil {
+		return kvs.HTTPRule{}, err
+	}
+
+	return r, nil
+}
+
+func convertHTTPRuleToResponse(r kvs.HTTPRule) service.HTTPRule {
+	return service.HTTPRule{
+		ID:                r.ID,
+		Action:            r.Action,
+		Direction:         r.Direction,
+		Header:            r.Header,
+		Value:             r.Value,
+		Host:              r.Host,
+		Code:              r.Code,
+		MaxAge:            r.MaxAge,
+		IncludeSubdomains: r.IncludeSubdomains,
+	}
+}
+
+func convertHTTPRulesToResponse(rules []kvs.HTTPRule) []service.HTTPRule {
+	converted := []service.HTTPRule{}
+	for _, r := range rules {
+		converted = append(converted, convertHTTPRuleToResponse(r))
+	}
+
+	return converted
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/server"
+	"github.com/bryanl/dolb/service"
+)
+
+var (
+	// keyringRefreshRate is how often agents fetch the cluster keyring, so
+	// they pick up rotations they weren't told about.
+	keyringRefreshRate = 10 * time.Minute
+)
+
+// FetchKeyring retrieves the cluster keyring from the server.
+func FetchKeyring(config *Config) (*kvs.Keyring, error) {
+	u, err := url.Parse(config.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = service.KeyringPath
+
+	kr := server.KeyringRequest{
+		AgentID:   config.AgentID,
+		ClusterID: config.ClusterID,
+	}
+
+	b, err := json.Marshal(&kr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(u.String(), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch keyring: server returned status %d", resp.StatusCode)
+	}
+
+	var keyring kvs.Keyring
+	if err := json.NewDecoder(resp.Body).Decode(&keyring); err != nil {
+		return nil, fmt.Errorf("could not decode keyring: %v", err)
+	}
+
+	if err := keyring.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &keyring, nil
+}
+
+// RefreshKeyring fetches the cluster keyring and encrypts with it from now
+// on. It returns true if the keyring changed.
+func RefreshKeyring(config *Config) (bool, error) {
+	if config.Encryption == nil {
+		return false, fmt.Errorf("kvs encryption is not configured")
+	}
+
+	keyring, err := FetchKeyring(config)
+	if err != nil {
+		return false, err
+	}
+
+	if reflect.DeepEqual(keyring, config.Encryption.Keyring()) {
+		return false, nil
+	}
+
+	if err := config.Encryption.SetKeyring(keyring); err != nil {
+		return false, err
+	}
+
+	config.GetLogger().WithField("keyring-primary", keyring.Primary).Info("refreshed kvs keyring")
+	return true, nil
+}
+
+// PollKeyring refreshes the cluster keyring periodically.
+func (a *Agent) PollKeyring() {
+	if a.Config.Encryption == nil {
+		return
+	}
+
+	ticker := time.NewTicker(keyringRefreshRate)
+	for range ticker.C {
+		if _, err := RefreshKeyring(a.Config); err != nil {
+			a.Config.GetLogger().WithError(err).Error("could not refresh kvs keyring")
+		}
+	}
+}
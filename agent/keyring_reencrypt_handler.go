@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/service"
+)
+
+// KeyringReencryptHandler encrypts the values which aren't encrypted with
+// the primary key of the keyring. Run it once every agent has refreshed its
+// keyring.
+func KeyringReencryptHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	if config.Encryption == nil {
+		return service.Response{Body: "kvs encryption is not configured", Status: 500}
+	}
+
+	count, err := config.Encryption.Reencrypt()
+	if err != nil {
+		config.GetLogger().WithError(err).Error("could not reencrypt kvs values")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	return service.Response{Body: service.KeyringReencryptResponse{Reencrypted: count}, Status: http.StatusOK}
+}
@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/service"
+)
+
+// KeyringRefreshHandler fetches the cluster keyring from the server. The
+// server calls it on every agent after rotating the keyring.
+func KeyringRefreshHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	changed, err := RefreshKeyring(config)
+	if err != nil {
+		config.GetLogger().WithError(err).Error("could not refresh kvs keyring")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	krr := service.KeyringRefreshResponse{
+		Primary: config.Encryption.Keyring().Primary,
+		Changed: changed,
+	}
+
+	return service.Response{Body: krr, Status: http.StatusOK}
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/server"
+	"github.com/bryanl/dolb/service"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestFetchKeyring(t *testing.T) {
+	keyring, err := kvs.NewKeyring()
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, service.KeyringPath, r.URL.Path)
+
+		var kr server.KeyringRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&kr))
+		assert.Equal(t, "agent-1", kr.AgentID)
+		assert.Equal(t, "cluster-1", kr.ClusterID)
+
+		json.NewEncoder(w).Encode(keyring)
+	}))
+	defer ts.Close()
+
+	config := &Config{AgentID: "agent-1", ClusterID: "cluster-1", ServerURL: ts.URL}
+
+	got, err := FetchKeyring(config)
+	assert.NoError(t, err)
+	assert.Equal(t, keyring, got)
+}
+
+func TestFetchKeyring_denied(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	_, err := FetchKeyring(&Config{ServerURL: ts.URL})
+	assert.Error(t, err)
+}
+
+func TestRefreshKeyring(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keyring, err := kvs.NewKeyring()
+	assert.NoError(t, err)
+
+	enc, err := kvs.NewEncrypted(ctx, kvs.NewMemory(ctx), keyring, "/secrets")
+	assert.NoError(t, err)
+
+	rotated := &kvs.Keyring{Primary: keyring.Primary, Keys: map[string][]byte{}}
+	for id, key := range keyring.Keys {
+		rotated.Keys[id] = key
+	}
+	_, err = rotated.Rotate()
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rotated)
+	}))
+	defer ts.Close()
+
+	config := &Config{ServerURL: ts.URL, Encryption: enc}
+	config.SetLogger(logrus.WithField("testing", true))
+
+	changed, err := RefreshKeyring(config)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, rotated.Primary, enc.Keyring().Primary)
+
+	changed, err = RefreshKeyring(config)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// convertRequestToLimits converts and validates requested limits for a
+// service of type serviceType. It returns nil if no limits were requested.
+func convertRequestToLimits(req *service.Limits, serviceType string) (*kvs.Limits, error) {
+	if req == nil {
+		return nil, nil
+	}
+
+	l := &kvs.Limits{
+		RequestRate:          req.RequestRate,
+		ConnectionsPerSource: req.ConnectionsPerSource,
+		UpstreamMaxConn:      req.UpstreamMaxConn,
+		Action:               req.Action,
+	}
+
+	l.SetDefaults()
+	if err := l.Validate(serviceType); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func convertLimitsToResponse(l *kvs.Limits) service.Limits {
+	return service.Limits{
+		RequestRate:          l.RequestRate,
+		ConnectionsPerSource: l.ConnectionsPerSource,
+		UpstreamMaxConn:      l.UpstreamMaxConn,
+		Action:               l.Action,
+	}
+}
@@ -0,0 +1,36 @@
+package agent
+
+import "github.com/stretchr/testify/mock"
+
+import "golang.org/x/net/context"
+
+type MockCertificateIssuer struct {
+	mock.Mock
+}
+
+func (_m *MockCertificateIssuer) Issue(ctx context.Context, domain string) (string, string, error) {
+	ret := _m.Called(ctx, domain)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, domain)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context, string) string); ok {
+		r1 = rf(ctx, domain)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string) error); ok {
+		r2 = rf(ctx, domain)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
@@ -1,32 +0,0 @@
-package agent
-
-import "github.com/stretchr/testify/mock"
-
-type MockLocker struct {
-	mock.Mock
-}
-
-func (_m *MockLocker) Lock() error {
-	ret := _m.Called()
-
-	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
-	} else {
-		r0 = ret.Error(0)
-	}
-
-	return r0
-}
-func (_m *MockLocker) Unlock() error {
-	ret := _m.Called()
-
-	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
-	} else {
-		r0 = ret.Error(0)
-	}
-
-	return r0
-}
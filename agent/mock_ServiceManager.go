@@ -9,6 +9,25 @@ type MockServiceManager struct {
 	mock.Mock
 }
 
+func (_m *MockServiceManager) AddCertificate(svc string, ccr service.CertificateCreateRequest) (string, error) {
+	ret := _m.Called(svc, ccr)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, service.CertificateCreateRequest) string); ok {
+		r0 = rf(svc, ccr)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, service.CertificateCreateRequest) error); ok {
+		r1 = rf(svc, ccr)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
 func (_m *MockServiceManager) AddUpstream(svc string, ucr UpstreamCreateRequest) error {
 	ret := _m.Called(svc, ucr)
 
@@ -21,6 +40,56 @@ func (_m *MockServiceManager) AddUpstream(svc string, ucr UpstreamCreateRequest)
 
 	return r0
 }
+func (_m *MockServiceManager) AddFencedCertificate(svc string, ccr service.CertificateCreateRequest, fence kvs.TxnOp) (string, error) {
+	ret := _m.Called(svc, ccr, fence)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, service.CertificateCreateRequest, kvs.TxnOp) string); ok {
+		r0 = rf(svc, ccr, fence)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, service.CertificateCreateRequest, kvs.TxnOp) error); ok {
+		r1 = rf(svc, ccr, fence)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockServiceManager) AddHTTPRule(svc string, r service.HTTPRule) (string, error) {
+	ret := _m.Called(svc, r)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, service.HTTPRule) string); ok {
+		r0 = rf(svc, r)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, service.HTTPRule) error); ok {
+		r1 = rf(svc, r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockServiceManager) DeleteCertificate(svc string, certificateID string) error {
+	ret := _m.Called(svc, certificateID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(svc, certificateID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockServiceManager) DeleteService(svcName string) error {
 	ret := _m.Called(svcName)
 
@@ -45,6 +114,42 @@ func (_m *MockServiceManager) DeleteUpstream(svc string, upstreamID string) erro
 
 	return r0
 }
+func (_m *MockServiceManager) DeleteHTTPRule(svc string, ruleID string) error {
+	ret := _m.Called(svc, ruleID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(svc, ruleID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockServiceManager) UpdateHTTPRule(svc string, ruleID string, r service.HTTPRule) error {
+	ret := _m.Called(svc, ruleID, r)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, service.HTTPRule) error); ok {
+		r0 = rf(svc, ruleID, r)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockServiceManager) UpdateUpstream(svc string, upstreamID string, uur UpstreamUpdateRequest) error {
+	ret := _m.Called(svc, upstreamID, uur)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, UpstreamUpdateRequest) error); ok {
+		r0 = rf(svc, upstreamID, uur)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockServiceManager) Create(_a0 service.ServiceCreateRequest) error {
 	ret := _m.Called(_a0)
 
@@ -57,6 +162,18 @@ func (_m *MockServiceManager) Create(_a0 service.ServiceCreateRequest) error {
 
 	return r0
 }
+func (_m *MockServiceManager) Update(svcName string, sur ServiceUpdateRequest) error {
+	ret := _m.Called(svcName, sur)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, ServiceUpdateRequest) error); ok {
+		r0 = rf(svcName, sur)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockServiceManager) Services() ([]kvs.Service, error) {
 	ret := _m.Called()
 
@@ -97,3 +214,24 @@ func (_m *MockServiceManager) Service(name string) (kvs.Service, error) {
 
 	return r0, r1
 }
+func (_m *MockServiceManager) SyncUpstreams(svc string, usr UpstreamSyncRequest) (*kvs.UpstreamSync, error) {
+	ret := _m.Called(svc, usr)
+
+	var r0 *kvs.UpstreamSync
+	if rf, ok := ret.Get(0).(func(string, UpstreamSyncRequest) *kvs.UpstreamSync); ok {
+		r0 = rf(svc, usr)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*kvs.UpstreamSync)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, UpstreamSyncRequest) error); ok {
+		r1 = rf(svc, usr)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// RestoreHandler replaces the cluster's services and firewall ports with
+// the contents of a snapshot, and migrates them to the latest schema
+// version. Values are restored as they are in the snapshot, so encrypted
+// values stay encrypted. Only requests signed by the server are accepted.
+func RestoreHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not read snapshot: %v", err), Status: 400}
+	}
+
+	if err := verifyServerRequest(config, r, body); err != nil {
+		config.GetLogger().WithError(err).Warn("rejected restore request")
+		return service.Response{Body: err, Status: 401}
+	}
+
+	var s kvs.Snapshot
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	if err := s.Validate(); err != nil {
+		return service.Response{Body: err, Status: 422}
+	}
+
+	h := kvs.NewLiveHaproxy(rawKVS(config), config.IDGen, config.GetLogger())
+	err = h.RestoreSnapshot(&s)
+	if err != nil {
+		config.GetLogger().WithError(err).Error("could not restore snapshot")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	return service.Response{Status: http.StatusNoContent}
+}
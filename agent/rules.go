@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+func convertRequestToRules(reqs []service.Rule) []kvs.Rule {
+	rules := []kvs.Rule{}
+	for _, r := range reqs {
+		rules = append(rules, kvs.Rule{
+			Host:        r.Host,
+			PathPrefix:  r.PathPrefix,
+			Header:      r.Header,
+			HeaderValue: r.HeaderValue,
+			HeaderRegex: r.HeaderRegex,
+			Method:      r.Method,
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// maxSignatureAge is how far the time a request was signed at may be from
+// the agent's clock.
+const maxSignatureAge = 5 * time.Minute
+
+// verifyServerRequest checks that r was signed by the server with the
+// cluster keyring. body is the request body, which has already been read.
+func verifyServerRequest(config *Config, r *http.Request, body []byte) error {
+	if config.Encryption == nil {
+		return errors.New("agent has no cluster keyring")
+	}
+
+	signedAt := r.Header.Get(service.SignatureTimeHeader)
+	unix, err := strconv.ParseInt(signedAt, 10, 64)
+	if err != nil {
+		return errors.New("request is not signed")
+	}
+
+	age := time.Since(time.Unix(unix, 0))
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return errors.New("request signature has expired")
+	}
+
+	msg := service.SignatureMessage(r.Method, r.URL.Path, signedAt, body)
+	if !config.Encryption.Keyring().Verify(msg, r.Header.Get(service.SignatureHeader)) {
+		return errors.New("request signature is invalid")
+	}
+
+	return nil
+}
+
+// rawKVS returns the kvs without decrypting values, so snapshots keep
+// certificate keys encrypted with the cluster keyring.
+func rawKVS(config *Config) kvs.KVS {
+	if config.Encryption == nil {
+		return config.KVS
+	}
+
+	return config.Encryption.Backend()
+}
@@ -28,10 +28,12 @@ func ServiceCreateHandler(c interface{}, r *http.Request) service.Response {
 	}
 
 	resp := service.ServiceCreateResponse{
-		Name:   ereq.Name,
-		Port:   ereq.Port,
-		Domain: ereq.Domain,
-		Regex:  ereq.Regex,
+		Name:     ereq.Name,
+		Port:     ereq.Port,
+		Protocol: ereq.Protocol,
+		Domain:   ereq.Domain,
+		Regex:    ereq.Regex,
+		Rules:    ereq.Rules,
 	}
 
 	return service.Response{Body: resp, Status: http.StatusCreated}
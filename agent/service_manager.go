@@ -10,10 +10,19 @@ import (
 )
 
 type ServiceManager interface {
+	AddCertificate(svc string, ccr service.CertificateCreateRequest) (string, error)
+	AddFencedCertificate(svc string, ccr service.CertificateCreateRequest, fence kvs.TxnOp) (string, error)
+	AddHTTPRule(svc string, r service.HTTPRule) (string, error)
 	AddUpstream(svc string, ucr UpstreamCreateRequest) error
+	DeleteCertificate(svc, certificateID string) error
+	DeleteHTTPRule(svc, ruleID string) error
 	DeleteService(svcName string) error
 	DeleteUpstream(svc, upstreamID string) error
+	UpdateHTTPRule(svc, ruleID string, r service.HTTPRule) error
+	UpdateUpstream(svc, upstreamID string, uur UpstreamUpdateRequest) error
+	SyncUpstreams(svc string, usr UpstreamSyncRequest) (*kvs.UpstreamSync, error)
 	Create(service.ServiceCreateRequest) error
+	Update(svcName string, sur ServiceUpdateRequest) error
 	Services() ([]kvs.Service, error)
 	Service(name string) (kvs.Service, error)
 }
@@ -37,66 +46,279 @@ func NewEtcdServiceManager(c *Config) ServiceManager {
 }
 
 func (esm *EtcdServiceManager) Create(er service.ServiceCreateRequest) error {
-	log := esm.Log
-
 	if er.Name == "" {
 		return errors.New("invalid service name")
 	}
 
-	if er.Domain != "" && er.Regex != "" {
-		return errors.New("only supply a domain or a URL regex, not both")
+	opts, err := convertRequestToServiceOptions(er)
+	if err != nil {
+		return err
 	}
 
-	if er.Domain == "" && er.Regex == "" {
-		return errors.New("supply a domain or a regex to create a service")
+	switch er.Protocol {
+	case "", "http":
+	case "tcp":
+		return esm.createTCP(er, opts)
+	default:
+		return fmt.Errorf("unknown service protocol %q", er.Protocol)
 	}
 
-	if er.Domain != "" {
-		log.WithFields(logrus.Fields{
-			"domain":       er.Domain,
-			"service-name": er.Name,
-			"port":         er.Port,
-		}).Info("creating domain service")
-		err := esm.Haproxy.Domain(er.Name, er.Domain, er.Port)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"domain":       er.Domain,
-				"service-name": er.Name,
-				"port":         er.Port,
-			}).WithError(err).Error("could not create domain service")
-			return err
-		}
-	} else if er.Regex != "" {
-		log.WithFields(logrus.Fields{
-			"regex":        er.Regex,
-			"service-name": er.Name,
-			"port":         er.Port,
-		}).Info("creating regex service")
-		err := esm.Haproxy.URLReg(er.Name, er.Regex, er.Port)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"regex":        er.Regex,
-				"service-name": er.Name,
-				"port":         er.Port,
-			}).Info("creating regex service")
+	matchers := 0
+	for _, supplied := range []bool{er.Domain != "", er.Regex != "", len(er.Rules) > 0} {
+		if supplied {
+			matchers++
 		}
+	}
+
+	if matchers > 1 {
+		return errors.New("only supply one of a domain, a URL regex or rules")
+	}
+
+	if matchers == 0 {
+		return errors.New("supply a domain, a URL regex or rules to create a service")
+	}
+
+	spec := kvs.ServiceSpec{
+		Name:    er.Name,
+		Port:    er.Port,
+		Options: opts,
+		Ops:     []kvs.TxnOp{kvs.EnablePortOp(er.Port)},
+	}
+
+	switch {
+	case er.Domain != "":
+		spec.Type, spec.Matcher = "domain", er.Domain
+	case er.Regex != "":
+		spec.Type, spec.Matcher = "url_reg", er.Regex
+	default:
+		spec.Type, spec.Rules = "rules", convertRequestToRules(er.Rules)
+	}
+
+	return esm.saveService(spec)
+}
+
+// createTCP creates a TCP service. TCP services are matched by port only.
+func (esm *EtcdServiceManager) createTCP(er service.ServiceCreateRequest, opts kvs.ServiceOptions) error {
+	if er.Domain != "" || er.Regex != "" || len(er.Rules) > 0 {
+		return errors.New("tcp services are matched by port; don't supply a domain, a regex or rules")
+	}
+
+	if er.Port < 1 || er.Port > 65535 {
+		return fmt.Errorf("invalid port %d", er.Port)
+	}
+
+	return esm.saveService(kvs.ServiceSpec{
+		Name:    er.Name,
+		Type:    "tcp",
+		Port:    er.Port,
+		Options: opts,
+		Ops:     []kvs.TxnOp{kvs.EnablePortOp(er.Port)},
+	})
+}
+
+// saveService stores a service with its options and firewall port in a
+// single transaction.
+func (esm *EtcdServiceManager) saveService(spec kvs.ServiceSpec) error {
+	log := esm.Log.WithFields(logrus.Fields{
+		"service-name": spec.Name,
+		"service-type": spec.Type,
+		"port":         spec.Port,
+	})
+
+	log.Info("saving service")
+	if err := esm.Haproxy.SaveService(spec); err != nil {
+		log.WithError(err).Error("could not save service")
 		return err
-	} else {
-		return errors.New("not sure what type of service to create")
 	}
 
-	// TODO clean me up
-	log.WithField("port", er.Port).Info("opening firewall port")
-	err := esm.Firewall.EnablePort(er.Port)
+	return nil
+}
+
+// Update changes a service in place. Its upstreams and certificates are
+// kept. The service, its options and its port reservation are written in a
+// single transaction. If the port changes, the firewall port is moved in the
+// same transaction unless another service still listens on the old port.
+func (esm *EtcdServiceManager) Update(name string, sur ServiceUpdateRequest) error {
+	current, err := esm.Haproxy.Service(name)
 	if err != nil {
-		log.WithError(err).WithField("port", er.Port).Error("unable to open firewall port")
 		return err
 	}
-	log.WithField("port", er.Port).Info("opened firewall port")
+
+	port := current.Port()
+	if sur.Port != nil {
+		port = *sur.Port
+	}
+
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+
+	opts, err := convertUpdateRequestToServiceOptions(current, sur)
+	if err != nil {
+		return err
+	}
+
+	matchers := 0
+	for _, supplied := range []bool{sur.Domain != nil, sur.Regex != nil, sur.Rules != nil} {
+		if supplied {
+			matchers++
+		}
+	}
+
+	if matchers > 1 {
+		return errors.New("only supply one of a domain, a URL regex or rules")
+	}
+
+	spec := kvs.ServiceSpec{Name: name, Port: port, Options: opts}
+	if current.Type() == "tcp" {
+		if matchers > 0 {
+			return errors.New("tcp services are matched by port; don't supply a domain, a regex or rules")
+		}
+		spec.Type = "tcp"
+	} else if err := setMatcher(&spec, current, sur); err != nil {
+		return err
+	}
+
+	if port != current.Port() {
+		ops, err := esm.movePortOps(name, current.Port(), port)
+		if err != nil {
+			return err
+		}
+		spec.Ops = ops
+	}
+
+	return esm.saveService(spec)
+}
+
+// setMatcher sets the requested matcher of an HTTP service in spec, or its
+// current matcher if none was requested.
+func setMatcher(spec *kvs.ServiceSpec, current kvs.Service, sur ServiceUpdateRequest) error {
+	switch {
+	case sur.Domain != nil:
+		spec.Type, spec.Matcher = "domain", *sur.Domain
+		return nil
+	case sur.Regex != nil:
+		spec.Type, spec.Matcher = "url_reg", *sur.Regex
+		return nil
+	case sur.Rules != nil:
+		spec.Type, spec.Rules = "rules", convertRequestToRules(sur.Rules)
+		return nil
+	}
+
+	sc := current.ServiceConfig()
+	switch sc["matcher"] {
+	case "domain":
+		domain, _ := sc["domain"].(string)
+		spec.Type, spec.Matcher = "domain", domain
+	case "url_reg":
+		regex, _ := sc["url_reg"].(string)
+		spec.Type, spec.Matcher = "url_reg", regex
+	case "rules":
+		rules, _ := sc["rules"].([]kvs.Rule)
+		spec.Type, spec.Rules = "rules", rules
+	default:
+		return fmt.Errorf("service %s has an unknown matcher %v", current.Name(), sc["matcher"])
+	}
 
 	return nil
 }
 
+// movePortOps builds the operations which open port to in the firewall and
+// close port from, unless a service other than name still uses it. The
+// https port stays open while any service has certificates.
+func (esm *EtcdServiceManager) movePortOps(name string, from, to int) ([]kvs.TxnOp, error) {
+	log := esm.Log.WithFields(logrus.Fields{
+		"old-port": from,
+		"port":     to,
+	})
+
+	services, err := esm.Haproxy.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range services {
+		inUse := s.Name() != name && s.Port() == from
+		if from == kvs.HTTPSPort && len(s.Certificates()) > 0 {
+			inUse = true
+		}
+
+		if inUse {
+			log.WithField("service-name", s.Name()).Info("old port still in use")
+			return []kvs.TxnOp{kvs.EnablePortOp(to)}, nil
+		}
+	}
+
+	log.Info("moving firewall port")
+	return []kvs.TxnOp{kvs.DisablePortOp(from), kvs.EnablePortOp(to)}, nil
+}
+
+func convertRequestToServiceOptions(er service.ServiceCreateRequest) (kvs.ServiceOptions, error) {
+	var opts kvs.ServiceOptions
+
+	hc, err := convertRequestToHealthCheck(er.HealthCheck)
+	if err != nil {
+		return opts, err
+	}
+	opts.HealthCheck = hc
+
+	serviceType := "http"
+	if er.Protocol == "tcp" {
+		serviceType = "tcp"
+	}
+
+	balance, err := convertRequestToBalance(er.Balance, er.Sticky, serviceType)
+	if err != nil {
+		return opts, err
+	}
+	opts.Balance = balance
+
+	limits, err := convertRequestToLimits(er.Limits, serviceType)
+	if err != nil {
+		return opts, err
+	}
+	opts.Limits = limits
+
+	return opts, nil
+}
+
+// convertUpdateRequestToServiceOptions converts the optional settings of a
+// service update. Sticky sessions and the balance algorithm can be changed
+// separately; the one which isn't supplied is kept.
+func convertUpdateRequestToServiceOptions(current kvs.Service, sur ServiceUpdateRequest) (kvs.ServiceOptions, error) {
+	var opts kvs.ServiceOptions
+
+	hc, err := convertRequestToHealthCheck(sur.HealthCheck)
+	if err != nil {
+		return opts, err
+	}
+	opts.HealthCheck = hc
+
+	limits, err := convertRequestToLimits(sur.Limits, current.Type())
+	if err != nil {
+		return opts, err
+	}
+	opts.Limits = limits
+
+	if sur.Balance == nil && sur.Sticky == nil {
+		return opts, nil
+	}
+
+	balance := current.Balance()
+	algorithm := balance.Algorithm
+	if sur.Balance != nil {
+		algorithm = *sur.Balance
+	}
+
+	sticky := sur.Sticky
+	if sticky == nil && balance.Sticky != nil {
+		sticky = &service.Sticky{Cookie: balance.Sticky.Cookie, Mode: balance.Sticky.Mode}
+	}
+
+	opts.Balance, err = convertRequestToBalance(algorithm, sticky, current.Type())
+	return opts, err
+}
+
 func (esm *EtcdServiceManager) Services() ([]kvs.Service, error) {
 	esm.Log.Info("retrieving services")
 	return esm.Haproxy.Services()
@@ -107,15 +329,74 @@ func (esm *EtcdServiceManager) Service(name string) (kvs.Service, error) {
 	return esm.Haproxy.Service(name)
 }
 
+func convertRequestToUpstream(ucr UpstreamCreateRequest) kvs.Upstream {
+	u := kvs.NewUpstream(ucr.Host, ucr.Port)
+	if ucr.Weight != nil {
+		u.Weight = *ucr.Weight
+	}
+	u.Backup = ucr.Backup
+	if ucr.State != "" {
+		u.State = ucr.State
+	}
+
+	return u
+}
+
 func (esm *EtcdServiceManager) AddUpstream(svc string, ucr UpstreamCreateRequest) error {
-	addr := fmt.Sprintf("%s:%d", ucr.Host, ucr.Port)
+	u := convertRequestToUpstream(ucr)
 
 	esm.Log.WithFields(logrus.Fields{
 		"server": svc,
 		"host":   ucr.Host,
 		"port":   ucr.Port,
+		"weight": u.Weight,
+		"backup": u.Backup,
+		"state":  u.State,
 	}).Info("adding upstream to server")
-	return esm.Haproxy.Upstream(svc, addr)
+	_, err := esm.Haproxy.Upstream(svc, u)
+	return err
+}
+
+// UpdateUpstream changes the weight, backup flag or state of an upstream.
+func (esm *EtcdServiceManager) UpdateUpstream(svc, id string, uur UpstreamUpdateRequest) error {
+	esm.Log.WithFields(logrus.Fields{
+		"upstream-id":  id,
+		"service-name": svc,
+	}).Info("updating upstream")
+	return esm.Haproxy.UpdateUpstream(svc, id, kvs.UpstreamUpdate{
+		Weight: uur.Weight,
+		Backup: uur.Backup,
+		State:  uur.State,
+	})
+}
+
+// SyncUpstreams replaces the upstreams of a service with the requested
+// upstreams.
+func (esm *EtcdServiceManager) SyncUpstreams(svc string, usr UpstreamSyncRequest) (*kvs.UpstreamSync, error) {
+	upstreams := []kvs.Upstream{}
+	for _, ucr := range usr.Upstreams {
+		upstreams = append(upstreams, convertRequestToUpstream(ucr))
+	}
+
+	log := esm.Log.WithFields(logrus.Fields{
+		"service-name":   svc,
+		"upstream-count": len(upstreams),
+	})
+
+	log.Info("syncing upstreams")
+	result, err := esm.Haproxy.SyncUpstreams(svc, upstreams)
+	if err != nil {
+		log.WithError(err).Error("could not sync upstreams")
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		"added":   len(result.Added),
+		"updated": len(result.Updated),
+		"removed": len(result.Removed),
+	}).Info("synced upstreams")
+
+	return result, nil
 }
 
 func (esm *EtcdServiceManager) DeleteUpstream(svc, id string) error {
@@ -132,3 +413,64 @@ func (esm *EtcdServiceManager) DeleteService(svc string) error {
 	}).Info("removing service")
 	return esm.Haproxy.DeleteService(svc)
 }
+
+// AddCertificate adds a certificate to a service. Services with certificates
+// are also served over TLS on kvs.HTTPSPort, which is opened along with
+// the certificate.
+func (esm *EtcdServiceManager) AddCertificate(svc string, ccr service.CertificateCreateRequest) (string, error) {
+	esm.Log.WithField("service-name", svc).Info("adding certificate to service")
+	return esm.Haproxy.AddCertificate(svc, ccr.Certificate, ccr.Key)
+}
+
+// AddFencedCertificate adds a certificate like AddCertificate. The
+// certificate is only written if fence, built by a lock's FenceOp, holds.
+func (esm *EtcdServiceManager) AddFencedCertificate(svc string, ccr service.CertificateCreateRequest, fence kvs.TxnOp) (string, error) {
+	esm.Log.WithField("service-name", svc).Info("adding fenced certificate to service")
+	return esm.Haproxy.AddCertificateWith(svc, ccr.Certificate, ccr.Key, []kvs.TxnOp{fence})
+}
+
+func (esm *EtcdServiceManager) DeleteCertificate(svc, id string) error {
+	esm.Log.WithFields(logrus.Fields{
+		"certificate-id": id,
+		"service-name":   svc,
+	}).Info("removing certificate from service")
+	return esm.Haproxy.DeleteCertificate(svc, id)
+}
+
+// AddHTTPRule validates an http rule and appends it to the rules of a
+// service. It returns the id of the rule.
+func (esm *EtcdServiceManager) AddHTTPRule(svc string, r service.HTTPRule) (string, error) {
+	rule, err := convertRequestToHTTPRule(r)
+	if err != nil {
+		return "", err
+	}
+
+	esm.Log.WithFields(logrus.Fields{
+		"service-name": svc,
+		"rule-action":  rule.Action,
+	}).Info("adding http rule to service")
+	return esm.Haproxy.AddHTTPRule(svc, rule)
+}
+
+// UpdateHTTPRule validates an http rule and replaces the rule with id.
+func (esm *EtcdServiceManager) UpdateHTTPRule(svc, id string, r service.HTTPRule) error {
+	rule, err := convertRequestToHTTPRule(r)
+	if err != nil {
+		return err
+	}
+
+	esm.Log.WithFields(logrus.Fields{
+		"rule-id":      id,
+		"service-name": svc,
+		"rule-action":  rule.Action,
+	}).Info("updating http rule")
+	return esm.Haproxy.UpdateHTTPRule(svc, id, rule)
+}
+
+func (esm *EtcdServiceManager) DeleteHTTPRule(svc, id string) error {
+	esm.Log.WithFields(logrus.Fields{
+		"rule-id":      id,
+		"service-name": svc,
+	}).Info("removing http rule from service")
+	return esm.Haproxy.DeleteHTTPRule(svc, id)
+}
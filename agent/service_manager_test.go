@@ -2,6 +2,7 @@ package agent_test
 
 import (
 	"io/ioutil"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	. "github.com/bryanl/dolb/agent"
@@ -56,8 +57,13 @@ var _ = Describe("EtcdServiceManager", func() {
 					Domain: "example.com",
 					Port:   80,
 				}
-				haproxy.On("Domain", "service-a", "example.com", 80).Return(nil)
-				firewall.On("EnablePort", 80).Return(nil)
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    80,
+					Ops:     []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
 			})
 
 			It("doesn't return an error", func() {
@@ -74,8 +80,13 @@ var _ = Describe("EtcdServiceManager", func() {
 					Regex: ".*",
 					Port:  80,
 				}
-				haproxy.On("URLReg", "service-a", ".*", 80).Return(nil)
-				firewall.On("EnablePort", 80).Return(nil)
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "url_reg",
+					Matcher: ".*",
+					Port:    80,
+					Ops:     []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
 			})
 
 			It("returns an error", func() {
@@ -84,6 +95,50 @@ var _ = Describe("EtcdServiceManager", func() {
 
 		})
 
+		Context("rules with valid inputs", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name: "service-a",
+					Port: 80,
+					Rules: []service.Rule{
+						{Host: "*.example.com", PathPrefix: "/api"},
+						{Header: "X-Tenant", HeaderValue: "acme", Method: "GET"},
+					},
+				}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name: "service-a",
+					Type: "rules",
+					Rules: []kvs.Rule{
+						{Host: "*.example.com", PathPrefix: "/api"},
+						{Header: "X-Tenant", HeaderValue: "acme", Method: "GET"},
+					},
+					Port: 80,
+					Ops:  []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with both a domain and rules", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:   "service-a",
+					Domain: "example.com",
+					Port:   80,
+					Rules:  []service.Rule{{PathPrefix: "/api"}},
+				}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
 		Context("with both domain and regex specific", func() {
 			BeforeEach(func() {
 				scr = service.ServiceCreateRequest{
@@ -107,6 +162,348 @@ var _ = Describe("EtcdServiceManager", func() {
 				Ω(err).To(HaveOccurred())
 			})
 		})
+
+		Context("tcp with valid inputs", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "tcp",
+					Port:     5432,
+				}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name: "service-a",
+					Type: "tcp",
+					Port: 5432,
+					Ops:  []kvs.TxnOp{kvs.EnablePortOp(5432)},
+				}).Return(nil)
+			})
+
+			It("opens the firewall port in the same transaction", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("tcp with a domain", func() {
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "tcp",
+					Domain:   "example.com",
+					Port:     5432,
+				}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("tcp without a port", func() {
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "tcp",
+				}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with a health check", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:   "service-a",
+					Domain: "example.com",
+					Port:   80,
+					HealthCheck: &service.HealthCheck{
+						Type:     "http",
+						Path:     "/health",
+						Interval: "5s",
+					},
+				}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    80,
+					Options: kvs.ServiceOptions{
+						HealthCheck: &kvs.HealthCheck{
+							Type:           "http",
+							Path:           "/health",
+							ExpectedStatus: 200,
+							Interval:       5 * time.Second,
+							Rise:           2,
+							Fall:           3,
+						},
+					},
+					Ops: []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
+			})
+
+			It("stores the health check with defaults", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with an invalid health check", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:        "service-a",
+					Domain:      "example.com",
+					Port:        80,
+					HealthCheck: &service.HealthCheck{Type: "http", Interval: "often"},
+				}
+			})
+
+			It("returns an error without creating the service", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with sticky sessions", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:    "service-a",
+					Domain:  "example.com",
+					Port:    80,
+					Balance: "leastconn",
+					Sticky:  &service.Sticky{Cookie: "SRV"},
+				}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    80,
+					Options: kvs.ServiceOptions{
+						Balance: &kvs.Balance{
+							Algorithm: "leastconn",
+							Sticky:    &kvs.StickySessions{Cookie: "SRV", Mode: "insert"},
+						},
+					},
+					Ops: []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
+			})
+
+			It("stores the balance with defaults", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("tcp with sticky sessions", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "tcp",
+					Port:     5432,
+					Sticky:   &service.Sticky{},
+				}
+			})
+
+			It("returns an error without creating the service", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with limits", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:   "service-a",
+					Domain: "example.com",
+					Port:   80,
+					Limits: &service.Limits{RequestRate: 20, UpstreamMaxConn: 100},
+				}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    80,
+					Options: kvs.ServiceOptions{
+						Limits: &kvs.Limits{
+							RequestRate:     20,
+							UpstreamMaxConn: 100,
+							Action:          "deny",
+						},
+					},
+					Ops: []kvs.TxnOp{kvs.EnablePortOp(80)},
+				}).Return(nil)
+			})
+
+			It("stores the limits with defaults", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("tcp with a request rate limit", func() {
+
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "tcp",
+					Port:     5432,
+					Limits:   &service.Limits{RequestRate: 20},
+				}
+			})
+
+			It("returns an error without creating the service", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with an unknown protocol", func() {
+			BeforeEach(func() {
+				scr = service.ServiceCreateRequest{
+					Name:     "service-a",
+					Protocol: "udp",
+					Port:     53,
+				}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Update", func() {
+
+		var (
+			sur     ServiceUpdateRequest
+			current *kvs.MockService
+			port    = 8080
+		)
+
+		BeforeEach(func() {
+			current = &kvs.MockService{}
+			current.On("Name").Return("service-a")
+			current.On("Port").Return(80)
+			current.On("Type").Return("http")
+			current.On("ServiceConfig").Return(kvs.ServiceConfig{"matcher": "domain", "domain": "example.com"})
+			current.On("Balance").Return(kvs.Balance{
+				Algorithm: kvs.BalanceRoundRobin,
+				Sticky:    &kvs.StickySessions{Cookie: "SRV", Mode: kvs.CookieInsert},
+			})
+			haproxy.On("Service", "service-a").Return(current, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = serviceManager.Update("service-a", sur)
+		})
+
+		Context("with a new port", func() {
+
+			BeforeEach(func() {
+				sur = ServiceUpdateRequest{Port: &port}
+				haproxy.On("Services").Return([]kvs.Service{current}, nil)
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    8080,
+					Ops:     []kvs.TxnOp{kvs.DisablePortOp(80), kvs.EnablePortOp(8080)},
+				}).Return(nil)
+			})
+
+			It("moves the firewall port in the same transaction", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with a new port when the old port is shared", func() {
+
+			BeforeEach(func() {
+				other := &kvs.MockService{}
+				other.On("Name").Return("service-b")
+				other.On("Port").Return(80)
+
+				sur = ServiceUpdateRequest{Port: &port}
+				haproxy.On("Services").Return([]kvs.Service{current, other}, nil)
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    8080,
+					Ops:     []kvs.TxnOp{kvs.EnablePortOp(8080)},
+				}).Return(nil)
+			})
+
+			It("only opens the new firewall port", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with a new matcher", func() {
+
+			BeforeEach(func() {
+				regex := "^/api"
+				sur = ServiceUpdateRequest{Regex: &regex}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "url_reg",
+					Matcher: "^/api",
+					Port:    80,
+				}).Return(nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with a new balance algorithm", func() {
+
+			BeforeEach(func() {
+				leastconn := kvs.BalanceLeastConn
+				sur = ServiceUpdateRequest{Balance: &leastconn}
+				haproxy.On("SaveService", kvs.ServiceSpec{
+					Name:    "service-a",
+					Type:    "domain",
+					Matcher: "example.com",
+					Port:    80,
+					Options: kvs.ServiceOptions{
+						Balance: &kvs.Balance{
+							Algorithm: kvs.BalanceLeastConn,
+							Sticky:    &kvs.StickySessions{Cookie: "SRV", Mode: kvs.CookieInsert},
+						},
+					},
+				}).Return(nil)
+			})
+
+			It("keeps sticky sessions", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with more than one matcher", func() {
+
+			BeforeEach(func() {
+				domain, regex := "example.org", ".*"
+				sur = ServiceUpdateRequest{Domain: &domain, Regex: &regex}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with an invalid port", func() {
+
+			BeforeEach(func() {
+				invalid := 70000
+				sur = ServiceUpdateRequest{Port: &invalid}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+		})
 	})
 
 	Describe("Services", func() {
@@ -185,7 +582,55 @@ var _ = Describe("EtcdServiceManager", func() {
 			BeforeEach(func() {
 				svcName = "service-b"
 				ucr = UpstreamCreateRequest{Host: "hosta", Port: 80}
-				haproxy.On("Upstream", svcName, "hosta:80").Return(nil)
+				haproxy.On("Upstream", svcName, kvs.NewUpstream("hosta", 80)).Return("1", nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+		})
+
+		Context("with upstream attributes", func() {
+
+			BeforeEach(func() {
+				svcName = "service-b"
+				weight := 0
+				ucr = UpstreamCreateRequest{Host: "hosta", Port: 80, Weight: &weight, Backup: true, State: "maint"}
+
+				u := kvs.NewUpstream("hosta", 80)
+				u.Weight = 0
+				u.Backup = true
+				u.State = kvs.UpstreamMaint
+				haproxy.On("Upstream", svcName, u).Return("1", nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+		})
+	})
+
+	Describe("UpdateUpstream", func() {
+		var (
+			svcName string
+			id      string
+			uur     UpstreamUpdateRequest
+		)
+
+		JustBeforeEach(func() {
+			err = serviceManager.UpdateUpstream(svcName, id, uur)
+		})
+
+		Context("with a successful haproxy call", func() {
+
+			BeforeEach(func() {
+				svcName = "service-b"
+				id = "12345"
+				weight := 20
+				uur = UpstreamUpdateRequest{Weight: &weight}
+				haproxy.On("UpdateUpstream", svcName, id, kvs.UpstreamUpdate{Weight: &weight}).Return(nil)
 			})
 
 			It("doesn't return an error", func() {
@@ -195,6 +640,42 @@ var _ = Describe("EtcdServiceManager", func() {
 		})
 	})
 
+	Describe("SyncUpstreams", func() {
+
+		var (
+			result *kvs.UpstreamSync
+		)
+
+		JustBeforeEach(func() {
+			weight := 5
+			result, err = serviceManager.SyncUpstreams("service-b", UpstreamSyncRequest{
+				Upstreams: []UpstreamCreateRequest{
+					{Host: "hosta", Port: 80, Weight: &weight},
+					{Host: "hostb", Port: 80},
+				},
+			})
+		})
+
+		Context("with a successful haproxy call", func() {
+
+			BeforeEach(func() {
+				heavy := kvs.NewUpstream("hosta", 80)
+				heavy.Weight = 5
+
+				upstreams := []kvs.Upstream{heavy, kvs.NewUpstream("hostb", 80)}
+				haproxy.On("SyncUpstreams", "service-b", upstreams).Return(&kvs.UpstreamSync{Added: upstreams}, nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+			It("returns the changes", func() {
+				Ω(result.Added).To(HaveLen(2))
+			})
+		})
+	})
+
 	Describe("DeleteUpstream", func() {
 		var (
 			svcName string
@@ -220,6 +701,89 @@ var _ = Describe("EtcdServiceManager", func() {
 		})
 	})
 
+	Describe("AddCertificate", func() {
+		var (
+			ccr = service.CertificateCreateRequest{Certificate: "cert", Key: "key"}
+			id  string
+		)
+
+		JustBeforeEach(func() {
+			id, err = serviceManager.AddCertificate("service-a", ccr)
+		})
+
+		Context("with a successful haproxy call", func() {
+
+			BeforeEach(func() {
+				haproxy.On("AddCertificate", "service-a", "cert", "key").Return("1", nil)
+			})
+
+			It("leaves opening the https port to the certificate transaction", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("1"))
+				firewall.AssertNotCalled(GinkgoT(), "EnablePort", 443)
+			})
+
+		})
+	})
+
+	Describe("AddHTTPRule", func() {
+		var (
+			rule service.HTTPRule
+			id   string
+		)
+
+		JustBeforeEach(func() {
+			id, err = serviceManager.AddHTTPRule("service-a", rule)
+		})
+
+		Context("with a valid rule", func() {
+
+			BeforeEach(func() {
+				rule = service.HTTPRule{Action: "redirect-https"}
+				haproxy.On("AddHTTPRule", "service-a", kvs.HTTPRule{Action: "redirect-https", Code: 301}).Return("1", nil)
+			})
+
+			It("stores the rule with defaults", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("1"))
+				haproxy.AssertExpectations(GinkgoT())
+			})
+
+		})
+
+		Context("with an invalid rule", func() {
+
+			BeforeEach(func() {
+				rule = service.HTTPRule{Action: "set-header", Header: "X-Request-ID"}
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(HaveOccurred())
+			})
+
+		})
+	})
+
+	Describe("UpdateHTTPRule", func() {
+
+		JustBeforeEach(func() {
+			err = serviceManager.UpdateHTTPRule("service-a", "1", service.HTTPRule{Action: "hsts", IncludeSubdomains: true})
+		})
+
+		Context("with a successful haproxy call", func() {
+
+			BeforeEach(func() {
+				rule := kvs.HTTPRule{Action: "hsts", MaxAge: 31536000, IncludeSubdomains: true}
+				haproxy.On("UpdateHTTPRule", "service-a", "1", rule).Return(nil)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+		})
+	})
+
 	Describe("DeleteService", func() {
 		var (
 			svcName string
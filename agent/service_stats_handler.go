@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/haproxy"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// ServiceStatsHandler returns haproxy's traffic and health statistics of a
+// service and its upstreams.
+func ServiceStatsHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	if config.HaproxyStatsSocket == "" {
+		return service.Response{Body: "haproxy stats socket not configured", Status: http.StatusServiceUnavailable}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		config.GetLogger().WithError(err).WithField("service-name", svcName).Error("could not retrieve service")
+		return service.Response{Body: err, Status: 404}
+	}
+
+	stats, err := haproxy.NewRuntime(config.HaproxyStatsSocket).Stats()
+	if err != nil {
+		config.GetLogger().WithError(err).Error("could not retrieve haproxy stats")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	servers := map[string]haproxy.Stat{}
+	ssr := service.ServiceStatsResponse{
+		Name:      svc.Name(),
+		Upstreams: []service.UpstreamStatsResponse{},
+	}
+	for _, st := range stats {
+		if st.Proxy != svc.Name() {
+			continue
+		}
+
+		switch st.Type {
+		case haproxy.StatBackend:
+			ssr.Stats = convertStatToResponse(st)
+		case haproxy.StatServer:
+			servers[st.Server] = st
+		}
+	}
+
+	for _, u := range svc.Upstreams() {
+		// upstreams haproxy doesn't know about yet have no stats.
+		ssr.Upstreams = append(ssr.Upstreams, service.UpstreamStatsResponse{
+			ID:    u.ID,
+			Host:  u.Host,
+			Port:  u.Port,
+			Stats: convertStatToResponse(servers[u.ID]),
+		})
+	}
+
+	return service.Response{Body: ssr, Status: http.StatusOK}
+}
+
+func convertStatToResponse(st haproxy.Stat) service.Stats {
+	return service.Stats{
+		Status:        st.Status,
+		CheckStatus:   st.CheckStatus,
+		Queue:         st.Queue,
+		Sessions:      st.Sessions,
+		TotalSessions: st.TotalSessions,
+		BytesIn:       st.BytesIn,
+		BytesOut:      st.BytesOut,
+		Responses2xx:  st.Responses2xx,
+		Responses4xx:  st.Responses4xx,
+		Responses5xx:  st.Responses5xx,
+	}
+}
@@ -0,0 +1,138 @@
+package agent_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var statsCSV = `# pxname,svname,qcur,scur,stot,bin,bout,status,type,check_status,hrsp_2xx,hrsp_4xx,hrsp_5xx,
+http-80,FRONTEND,,3,120,40960,819200,OPEN,0,,100,12,6,
+service-a,1,0,2,80,30000,600000,UP,2,L7OK,75,4,0,
+service-a,2,4,1,40,10960,219200,DOWN,2,L7STS,25,8,6,
+service-a,BACKEND,4,3,120,40960,819200,UP,1,,100,12,6,
+`
+
+var _ = Describe("ServiceStatsHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		dir            string
+		listener       net.Listener
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "agent")
+		Ω(err).ToNot(HaveOccurred())
+
+		socket := filepath.Join(dir, "haproxy.sock")
+		listener, err = net.Listen("unix", socket)
+		Ω(err).ToNot(HaveOccurred())
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte(statsCSV))
+				conn.Close()
+			}
+		}()
+
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			HaproxyStatsSocket: socket,
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		listener.Close()
+		os.RemoveAll(dir)
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/services/service-a/stats"
+		resp, err = http.Get(u.String())
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with an existing service", func() {
+
+		BeforeEach(func() {
+			svc := kvs.NewHTTPService("service-a")
+			for _, id := range []string{"1", "2", "3"} {
+				upstream := kvs.NewUpstream("host-"+id, 80)
+				upstream.ID = id
+				svc.AddUpstream(upstream)
+			}
+
+			serviceManager.On("Service", "service-a").Return(svc, nil)
+		})
+
+		It("returns a 200", func() {
+			Ω(resp.StatusCode).To(Equal(200))
+		})
+
+		It("reports the service and upstream stats", func() {
+			var ssr service.ServiceStatsResponse
+			Ω(json.NewDecoder(resp.Body).Decode(&ssr)).To(Succeed())
+
+			Ω(ssr.Name).To(Equal("service-a"))
+			Ω(ssr.Sessions).To(Equal(int64(3)))
+			Ω(ssr.Responses5xx).To(Equal(int64(6)))
+			Ω(ssr.Upstreams).To(HaveLen(3))
+
+			down := ssr.Upstreams[1]
+			Ω(down.ID).To(Equal("2"))
+			Ω(down.Host).To(Equal("host-2"))
+			Ω(down.Status).To(Equal("DOWN"))
+			Ω(down.CheckStatus).To(Equal("L7STS"))
+			Ω(down.Queue).To(Equal(int64(4)))
+			Ω(down.Responses4xx).To(Equal(int64(8)))
+
+			Ω(ssr.Upstreams[2].Status).To(BeEmpty())
+		})
+	})
+
+	Context("without a stats socket", func() {
+
+		BeforeEach(func() {
+			config.HaproxyStatsSocket = ""
+		})
+
+		It("returns a 503", func() {
+			Ω(resp.StatusCode).To(Equal(503))
+		})
+	})
+})
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// ServiceUpdateRequest is a request to change a service without recreating
+// it. Fields which aren't supplied are left alone. At most one of Domain,
+// Regex and Rules can be supplied, and only for http services.
+type ServiceUpdateRequest struct {
+	Port        *int                 `json:"port,omitempty"`
+	Domain      *string              `json:"domain,omitempty"`
+	Regex       *string              `json:"url_regex,omitempty"`
+	Rules       []service.Rule       `json:"rules,omitempty"`
+	HealthCheck *service.HealthCheck `json:"health_check,omitempty"`
+	Balance     *string              `json:"balance,omitempty"`
+	Sticky      *service.Sticky      `json:"sticky,omitempty"`
+	Limits      *service.Limits      `json:"limits,omitempty"`
+}
+
+func ServiceUpdateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	var sur ServiceUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&sur)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	err = sm.Update(svcName, sur)
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name": svcName,
+		}).Error("could not update service")
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		return service.Response{Body: err, Status: 400}
+	}
+
+	sr := convertServiceToResponse(svc)
+
+	return service.Response{Body: sr, Status: http.StatusOK}
+}
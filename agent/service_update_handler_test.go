@@ -0,0 +1,122 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceUpdateHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		method         string
+		port           = 8080
+		sur            = ServiceUpdateRequest{Port: &port}
+	)
+
+	BeforeEach(func() {
+		method = "PATCH"
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/services/service-a"
+		req, err := http.NewRequest(method, u.String(), strings.NewReader(`{"port":8080}`))
+		Ω(err).ToNot(HaveOccurred())
+
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with an existing service", func() {
+
+		BeforeEach(func() {
+			upstream := kvs.NewUpstream("host-a", 80)
+			upstream.ID = "1"
+
+			svc := kvs.NewHTTPService("service-a")
+			svc.AddUpstream(upstream)
+
+			serviceManager.On("Update", "service-a", sur).Return(nil)
+			serviceManager.On("Service", "service-a").Return(svc, nil)
+		})
+
+		It("returns a 200", func() {
+			Ω(resp.StatusCode).To(Equal(200))
+		})
+
+		It("reports the service with its upstreams", func() {
+			var sr service.ServiceResponse
+			Ω(json.NewDecoder(resp.Body).Decode(&sr)).To(Succeed())
+			Ω(sr.Name).To(Equal("service-a"))
+			Ω(sr.Upstreams).To(HaveLen(1))
+		})
+
+		Context("with a PUT", func() {
+
+			BeforeEach(func() {
+				method = "PUT"
+			})
+
+			It("returns a 200", func() {
+				Ω(resp.StatusCode).To(Equal(200))
+			})
+		})
+	})
+
+	Context("with a missing service", func() {
+
+		BeforeEach(func() {
+			notFound := &kvs.KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			serviceManager.On("Update", "service-a", sur).Return(notFound)
+		})
+
+		It("returns a 404", func() {
+			Ω(resp.StatusCode).To(Equal(404))
+		})
+	})
+
+	Context("with a port conflict", func() {
+
+		BeforeEach(func() {
+			serviceManager.On("Update", "service-a", sur).Return(errors.New("port 8080 is already in use"))
+		})
+
+		It("returns a 400", func() {
+			Ω(resp.StatusCode).To(Equal(400))
+		})
+	})
+})
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// SnapshotHandler exports the cluster's services and firewall ports.
+// Encrypted values are exported as ciphertext. Only requests signed by the
+// server are accepted.
+func SnapshotHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	if err := verifyServerRequest(config, r, nil); err != nil {
+		config.GetLogger().WithError(err).Warn("rejected snapshot request")
+		return service.Response{Body: err, Status: 401}
+	}
+
+	s, err := kvs.TakeSnapshot(rawKVS(config))
+	if err != nil {
+		config.GetLogger().WithError(err).Error("could not take snapshot")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	return service.Response{Body: s, Status: http.StatusOK}
+}
@@ -0,0 +1,202 @@
+package agent_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshots", func() {
+
+	var (
+		api     *API
+		config  *Config
+		ts      *httptest.Server
+		u       *url.URL
+		resp    *http.Response
+		err     error
+		mem     *kvs.Memory
+		enc     *kvs.Encrypted
+		keyring *kvs.Keyring
+		cancel  context.CancelFunc
+	)
+
+	sign := func(req *http.Request, body []byte) {
+		signedAt := strconv.FormatInt(time.Now().Unix(), 10)
+		msg := service.SignatureMessage(req.Method, req.URL.Path, signedAt, body)
+		req.Header.Set(service.SignatureTimeHeader, signedAt)
+		req.Header.Set(service.SignatureHeader, keyring.Sign(msg))
+	}
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = kvs.NewMemory(ctx)
+
+		keyring, err = kvs.NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+
+		enc, err = kvs.NewEncrypted(ctx, mem, keyring, "/haproxy-discover/services/*/certificates/*/key")
+		Ω(err).ToNot(HaveOccurred())
+
+		config = &Config{KVS: enc, Encryption: enc}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		cancel()
+	})
+
+	Describe("SnapshotHandler", func() {
+
+		var (
+			signed bool
+		)
+
+		BeforeEach(func() {
+			signed = true
+
+			_, err = enc.Set("/haproxy-discover/services/app/port", "80", nil)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = enc.Set("/haproxy-discover/services/app/certificates/1/key", "secret", nil)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			u.Path = "/snapshot"
+			req, err := http.NewRequest("GET", u.String(), nil)
+			Ω(err).ToNot(HaveOccurred())
+			if signed {
+				sign(req, nil)
+			}
+
+			resp, err = http.DefaultClient.Do(req)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		It("returns the snapshot", func() {
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var s kvs.Snapshot
+			Ω(json.NewDecoder(resp.Body).Decode(&s)).To(Succeed())
+			Ω(s.Version).To(Equal(kvs.SnapshotVersion))
+			Ω(s.Entries).To(ContainElement(kvs.SnapshotEntry{Key: "/haproxy-discover/services/app/port", Value: "80"}))
+		})
+
+		It("keeps encrypted values encrypted", func() {
+			var s kvs.Snapshot
+			Ω(json.NewDecoder(resp.Body).Decode(&s)).To(Succeed())
+
+			found := false
+			for _, e := range s.Entries {
+				if e.Key == "/haproxy-discover/services/app/certificates/1/key" {
+					found = true
+					Ω(e.Value).ToNot(ContainSubstring("secret"))
+					Ω(strings.HasPrefix(e.Value, "dolb:enc:v1:")).To(BeTrue())
+				}
+			}
+			Ω(found).To(BeTrue())
+		})
+
+		Context("with an unsigned request", func() {
+
+			BeforeEach(func() {
+				signed = false
+			})
+
+			It("returns a 401", func() {
+				Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe("RestoreHandler", func() {
+
+		var (
+			snapshot *kvs.Snapshot
+			signed   bool
+		)
+
+		BeforeEach(func() {
+			signed = true
+		})
+
+		JustBeforeEach(func() {
+			b, err := json.Marshal(snapshot)
+			Ω(err).ToNot(HaveOccurred())
+
+			u.Path = "/restore"
+			req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+			Ω(err).ToNot(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			if signed {
+				sign(req, b)
+			}
+
+			resp, err = http.DefaultClient.Do(req)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		Context("with a valid snapshot", func() {
+
+			BeforeEach(func() {
+				snapshot = &kvs.Snapshot{
+					Version: kvs.SnapshotVersion,
+					Entries: []kvs.SnapshotEntry{{Key: "/firewall/ports/80", Value: "enabled"}},
+				}
+			})
+
+			It("restores it", func() {
+				Ω(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+				node, err := mem.Get("/firewall/ports/80", nil)
+				Ω(err).ToNot(HaveOccurred())
+				Ω(node.Value).To(Equal("enabled"))
+			})
+
+			Context("with an unsigned request", func() {
+
+				BeforeEach(func() {
+					signed = false
+				})
+
+				It("returns a 401 and doesn't restore it", func() {
+					Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+					_, err := mem.Get("/firewall/ports/80", nil)
+					Ω(kvs.IsKeyNotFound(err)).To(BeTrue())
+				})
+			})
+		})
+
+		Context("with an unsupported version", func() {
+
+			BeforeEach(func() {
+				snapshot = &kvs.Snapshot{Version: 0}
+			})
+
+			It("returns a 422", func() {
+				Ω(resp.StatusCode).To(Equal(422))
+			})
+		})
+	})
+})
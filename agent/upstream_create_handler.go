@@ -9,9 +9,14 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// UpstreamCreateRequest is a request to add an upstream to a service. Weight
+// defaults to 1 and State defaults to "active".
 type UpstreamCreateRequest struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight *int   `json:"weight,omitempty"`
+	Backup bool   `json:"backup"`
+	State  string `json:"state,omitempty"`
 }
 
 func UpstreamCreateHandler(c interface{}, r *http.Request) service.Response {
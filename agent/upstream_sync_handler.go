@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// UpstreamSyncRequest is the complete list of upstreams of a service.
+// Upstreams which aren't listed are removed, and upstreams listed more than
+// once by address are only added once.
+type UpstreamSyncRequest struct {
+	Upstreams []UpstreamCreateRequest `json:"upstreams"`
+}
+
+func UpstreamSyncHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+
+	var usr UpstreamSyncRequest
+	err := json.NewDecoder(r.Body).Decode(&usr)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	result, err := sm.SyncUpstreams(svcName, usr)
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name": svcName,
+		}).Error("could not sync upstreams")
+
+		// the service or its upstreams changed during the sync.
+		if _, ok := err.(*kvs.TxnError); ok {
+			return service.Response{Body: err, Status: 409}
+		}
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	usResp := service.UpstreamSyncResponse{
+		Added:   convertUpstreamsToResponse(result.Added),
+		Updated: convertUpstreamsToResponse(result.Updated),
+		Removed: convertUpstreamsToResponse(result.Removed),
+	}
+
+	return service.Response{Body: usResp, Status: http.StatusOK}
+}
+
+func convertUpstreamsToResponse(upstreams []kvs.Upstream) []service.UpstreamResponse {
+	ur := []service.UpstreamResponse{}
+	for _, u := range upstreams {
+		ur = append(ur, convertUpstreamToResponse(u))
+	}
+
+	return ur
+}
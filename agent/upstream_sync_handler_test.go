@@ -0,0 +1,122 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpstreamSyncHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		usr            = UpstreamSyncRequest{
+			Upstreams: []UpstreamCreateRequest{
+				{Host: "host-a", Port: 80},
+				{Host: "host-c", Port: 80},
+			},
+		}
+	)
+
+	BeforeEach(func() {
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/services/service-a/upstreams:sync"
+		body := `{"upstreams":[{"host":"host-a","port":80},{"host":"host-c","port":80}]}`
+		req, err := http.NewRequest("PUT", u.String(), strings.NewReader(body))
+		Ω(err).ToNot(HaveOccurred())
+
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with an existing service", func() {
+
+		BeforeEach(func() {
+			added := kvs.NewUpstream("host-c", 80)
+			added.ID = "3"
+			removed := kvs.NewUpstream("host-b", 80)
+			removed.ID = "2"
+
+			serviceManager.On("SyncUpstreams", "service-a", usr).Return(&kvs.UpstreamSync{
+				Added:   []kvs.Upstream{added},
+				Updated: []kvs.Upstream{},
+				Removed: []kvs.Upstream{removed},
+			}, nil)
+		})
+
+		It("returns a 200", func() {
+			Ω(resp.StatusCode).To(Equal(200))
+		})
+
+		It("reports the added and removed upstreams", func() {
+			var usResp service.UpstreamSyncResponse
+			Ω(json.NewDecoder(resp.Body).Decode(&usResp)).To(Succeed())
+			Ω(usResp.Added).To(Equal([]service.UpstreamResponse{
+				{ID: "3", Host: "host-c", Port: 80, Weight: 1, State: "active"},
+			}))
+			Ω(usResp.Updated).To(BeEmpty())
+			Ω(usResp.Removed).To(Equal([]service.UpstreamResponse{
+				{ID: "2", Host: "host-b", Port: 80, Weight: 1, State: "active"},
+			}))
+		})
+	})
+
+	Context("with a missing service", func() {
+
+		BeforeEach(func() {
+			notFound := &kvs.KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			serviceManager.On("SyncUpstreams", "service-a", usr).Return(nil, notFound)
+		})
+
+		It("returns a 404", func() {
+			Ω(resp.StatusCode).To(Equal(404))
+		})
+	})
+
+	Context("with a concurrent change", func() {
+
+		BeforeEach(func() {
+			conflict := &kvs.TxnError{Op: 2, Key: "/haproxy-discover/services/service-a/upstreams/2", Err: errors.New("compare failed")}
+			serviceManager.On("SyncUpstreams", "service-a", usr).Return(nil, conflict)
+		})
+
+		It("returns a 409", func() {
+			Ω(resp.StatusCode).To(Equal(409))
+		})
+	})
+})
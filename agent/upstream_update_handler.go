@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// UpstreamUpdateRequest is a request to change an upstream without removing
+// it. Fields which aren't supplied are left alone. State is "active",
+// "drain" or "maint".
+type UpstreamUpdateRequest struct {
+	Weight *int    `json:"weight,omitempty"`
+	Backup *bool   `json:"backup,omitempty"`
+	State  *string `json:"state,omitempty"`
+}
+
+func UpstreamUpdateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	svcName := vars["service"]
+	uName := vars["upstream"]
+
+	var uur UpstreamUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&uur)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	sm := config.ServiceManagerFactory(config)
+	err = sm.UpdateUpstream(svcName, uName, uur)
+	if err != nil {
+		config.GetLogger().WithError(err).WithFields(logrus.Fields{
+			"service-name": svcName,
+			"upstream-id":  uName,
+		}).Error("could not update upstream")
+
+		if kvs.IsKeyNotFound(err) {
+			return service.Response{Body: err, Status: 404}
+		}
+		return service.Response{Body: err, Status: 400}
+	}
+
+	svc, err := sm.Service(svcName)
+	if err != nil {
+		return service.Response{Body: err, Status: 400}
+	}
+
+	sr := convertServiceToResponse(svc)
+
+	return service.Response{Body: sr, Status: http.StatusOK}
+}
@@ -0,0 +1,99 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/agent"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpstreamUpdateHandler", func() {
+
+	var (
+		api            *API
+		config         *Config
+		ts             *httptest.Server
+		u              *url.URL
+		resp           *http.Response
+		err            error
+		serviceManager *MockServiceManager
+		drain          = kvs.UpstreamDrain
+		uur            = UpstreamUpdateRequest{State: &drain}
+	)
+
+	BeforeEach(func() {
+		serviceManager = &MockServiceManager{}
+		config = &Config{
+			ServiceManagerFactory: func(*Config) ServiceManager {
+				return serviceManager
+			},
+		}
+		config.SetLogger(logrus.WithField("testing", true))
+		api = NewAPI(config)
+		ts = httptest.NewServer(api.Mux)
+		u, err = url.Parse(ts.URL)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	JustBeforeEach(func() {
+		u.Path = "/services/service-a/upstreams/1"
+		req, err := http.NewRequest("PATCH", u.String(), strings.NewReader(`{"state":"drain"}`))
+		Ω(err).ToNot(HaveOccurred())
+
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	Context("with an existing upstream", func() {
+
+		BeforeEach(func() {
+			upstream := kvs.NewUpstream("host-a", 80)
+			upstream.ID = "1"
+			upstream.State = kvs.UpstreamDrain
+
+			svc := kvs.NewHTTPService("service-a")
+			svc.AddUpstream(upstream)
+
+			serviceManager.On("UpdateUpstream", "service-a", "1", uur).Return(nil)
+			serviceManager.On("Service", "service-a").Return(svc, nil)
+		})
+
+		It("returns a 200", func() {
+			Ω(resp.StatusCode).To(Equal(200))
+		})
+
+		It("reports the upstream", func() {
+			var sr service.ServiceResponse
+			Ω(json.NewDecoder(resp.Body).Decode(&sr)).To(Succeed())
+			Ω(sr.Upstreams).To(Equal([]service.UpstreamResponse{
+				{ID: "1", Host: "host-a", Port: 80, Weight: 1, State: "drain"},
+			}))
+		})
+	})
+
+	Context("with a missing upstream", func() {
+
+		BeforeEach(func() {
+			notFound := &kvs.KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			serviceManager.On("UpdateUpstream", "service-a", "1", uur).Return(notFound)
+		})
+
+		It("returns a 404", func() {
+			Ω(resp.StatusCode).To(Equal(404))
+		})
+	})
+})
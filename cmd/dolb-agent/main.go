@@ -1,7 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -16,6 +22,23 @@ import (
 	"github.com/tylerb/graceful"
 )
 
+const (
+	// kvsAttempts is how many times idempotent kvs operations are tried.
+	kvsAttempts = 3
+	// kvsBackoff is the base wait between kvs attempts.
+	kvsBackoff = 200 * time.Millisecond
+	// kvsBreakerThreshold is how many consecutive kvs failures open the
+	// circuit breaker.
+	kvsBreakerThreshold = 5
+	// kvsBreakerCooldown is how long the circuit breaker stays open.
+	kvsBreakerCooldown = 10 * time.Second
+	// keyringBackoff is the first wait between attempts to fetch the kvs
+	// keyring. It doubles up to keyringMaxBackoff.
+	keyringBackoff = time.Second
+	// keyringMaxBackoff is the longest wait between keyring fetches.
+	keyringMaxBackoff = time.Minute
+)
+
 var (
 	addr          = envflag.String("ADDR", ":8889", "listen address")
 	agentID       = envflag.String("AGENT_ID", "", "agent id")
@@ -24,9 +47,21 @@ var (
 	clusterID     = envflag.String("CLUSTER_ID", "", "cluster id")
 	clusterName   = envflag.String("CLUSTER_NAME", "", "cluster name")
 	etcdEndpoints = envflag.String("ETCDENDPOINTS", "", "comma separted list of ectd endpoints")
+	consulAddr    = envflag.String("CONSUL_ADDR", "", "consul agent address")
+	kvsBackend    = envflag.String("KVS_BACKEND", "etcd", "kvs backend (etcd, etcdv3, consul or memory)")
+	kvsRoot       = envflag.String("KVS_ROOT", "", "prefix for all kvs keys")
+	kvsKeyring    = envflag.String("KVS_KEYRING_FILE", "", "kvs keyring file; fetched from the server if empty")
+	kvsEncrypted  = envflag.String("KVS_ENCRYPTED_PREFIXES", "/dolb/secrets,/haproxy-discover/services/*/certificates/*/key", "comma separated list of kvs prefixes to encrypt")
 	dropletID     = envflag.String("DROPLET_ID", "", "current droplet id")
 	doToken       = envflag.String("DIGITALOCEAN_ACCESS_TOKEN", "", "DigitalOcean access token")
 	serverURL     = envflag.String("SERVER_URL", "", "DOLB Server URL")
+	acmeDirectory = envflag.String("ACME_DIRECTORY_URL", "", "ACME directory URL; certificates aren't issued if empty")
+	acmeEmail     = envflag.String("ACME_EMAIL", "", "ACME account contact email")
+	acmeCAFile    = envflag.String("ACME_CA_FILE", "", "extra CA certificate trusted for the ACME directory")
+	haproxyConfig = envflag.String("HAPROXY_CONFIG_FILE", "", "haproxy config file; not written if empty")
+	haproxyCerts  = envflag.String("HAPROXY_CERTIFICATE_DIR", "/etc/haproxy/certs", "directory for haproxy certificate bundles")
+	haproxyReload = envflag.String("HAPROXY_RELOAD_COMMAND", "", "shell command which reloads haproxy")
+	haproxySocket = envflag.String("HAPROXY_STATS_SOCKET", "/var/run/haproxy.sock", "haproxy runtime API socket")
 )
 
 func main() {
@@ -62,15 +97,22 @@ func main() {
 
 	// FIXME is this too much config?
 	config := &agent.Config{
-		AgentID:           *agentID,
-		DigitalOceanToken: *doToken,
-		ClusterID:         *clusterID,
-		ClusterName:       *clusterName,
-		Context:           context.Background(),
-		DropletID:         *dropletID,
-		Region:            *agentRegion,
-		Name:              *agentName,
-		ServerURL:         *serverURL,
+		ACMEChallengeAddress:  acmeChallengeAddress(*addr),
+		ACMEDirectoryURL:      *acmeDirectory,
+		ACMEEmail:             *acmeEmail,
+		AgentID:               *agentID,
+		DigitalOceanToken:     *doToken,
+		ClusterID:             *clusterID,
+		ClusterName:           *clusterName,
+		Context:               context.Background(),
+		DropletID:             *dropletID,
+		HaproxyCertificateDir: *haproxyCerts,
+		HaproxyConfigFile:     *haproxyConfig,
+		HaproxyReloadCommand:  *haproxyReload,
+		HaproxyStatsSocket:    *haproxySocket,
+		Region:                *agentRegion,
+		Name:                  *agentName,
+		ServerURL:             *serverURL,
 		ServiceManagerFactory: func(c *agent.Config) agent.ServiceManager {
 			return agent.NewEtcdServiceManager(c)
 		},
@@ -79,15 +121,42 @@ func main() {
 	logger := log.WithField("agent-name", *agentName)
 	config.SetLogger(logger)
 
+	if *acmeCAFile != "" {
+		client, err := acmeHTTPClient(*acmeCAFile)
+		if err != nil {
+			log.WithError(err).Fatal("could not load ACME_CA_FILE")
+		}
+		config.ACMEHTTPClient = client
+	}
+
 	ic := firewall.NewIptablesCommand()
 	config.Firewall = firewall.NewIptablesFirewall(ic, logger)
 
-	kapi, err := kvs.NewKeysAPI(*etcdEndpoints, nil)
+	kv, err := initKVS(config)
+	if err != nil {
+		log.WithError(err).Fatal("could not initialize kvs")
+	}
+
+	kv = kvs.NewMetrics(
+		kvs.NewBreaker(kvs.NewRetry(kv, kvsAttempts, kvsBackoff), kvsBreakerThreshold, kvsBreakerCooldown),
+		expvar.NewMap("kvs"))
+
+	if *kvsRoot != "" {
+		kv = kvs.NewNamespace(kv, *kvsRoot)
+	}
+
+	keyring, err := loadKeyring(config)
+	if err != nil {
+		log.WithError(err).Fatal("could not load kvs keyring")
+	}
+
+	enc, err := kvs.NewEncrypted(config.Context, kv, keyring, strings.Split(*kvsEncrypted, ",")...)
 	if err != nil {
-		log.WithError(err).Fatal("could not create keys api client")
+		log.WithError(err).Fatal("could not initialize kvs encryption")
 	}
 
-	config.KVS = kvs.NewEtcd(config.Context, kapi)
+	config.Encryption = enc
+	config.KVS = enc
 
 	cm := agent.NewClusterMember(*agentName, config)
 	err = cm.Start()
@@ -102,6 +171,9 @@ func main() {
 
 	go a.PollClusterStatus()
 	go a.PollFirewall()
+	go a.PollCertificates()
+	go a.PollHaproxyConfig()
+	go a.PollKeyring()
 
 	api := agent.NewAPI(config)
 
@@ -126,6 +198,101 @@ func runServer(api *agent.API, errChan chan error) {
 	errChan <- httpServer.ListenAndServe()
 }
 
+// acmeChallengeAddress is the address haproxy reaches the agent's listen
+// address at.
+func acmeChallengeAddress(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return ""
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// acmeHTTPClient builds a http client which also trusts the CA in caFile.
+// Test CAs like Pebble serve their directory with their own CA.
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	pemCerts, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
+
+// loadKeyring loads the kvs keyring from KVS_KEYRING_FILE, or fetches it
+// from the server. Fetches are retried until the server answers, since the
+// agent can't do anything without the keyring.
+func loadKeyring(config *agent.Config) (*kvs.Keyring, error) {
+	if *kvsKeyring != "" {
+		return kvs.LoadKeyring(*kvsKeyring)
+	}
+
+	backoff := keyringBackoff
+	for {
+		keyring, err := agent.FetchKeyring(config)
+		if err == nil {
+			return keyring, nil
+		}
+
+		log.WithError(err).WithField("retry-in", backoff).Warn("could not fetch kvs keyring")
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > keyringMaxBackoff {
+			backoff = keyringMaxBackoff
+		}
+	}
+}
+
+func initKVS(config *agent.Config) (kvs.KVS, error) {
+	switch *kvsBackend {
+	case "etcd":
+		kapi, err := kvs.NewKeysAPI(*etcdEndpoints, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewEtcd(config.Context, kapi, kvs.EtcdTxnRoot(*kvsRoot)), nil
+	case "etcdv3":
+		client, err := kvs.NewEtcdV3Client(*etcdEndpoints, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewEtcdV3(config.Context, client), nil
+	case "consul":
+		client, err := kvs.NewConsulClient(*consulAddr, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewConsul(config.Context, client.KV(), client.Session()), nil
+	case "memory":
+		log.Warn("using in-memory kvs; state will not be shared with other agents")
+		return kvs.NewMemory(config.Context), nil
+	default:
+		return nil, fmt.Errorf("unknown kvs backend %q", *kvsBackend)
+	}
+}
+
 func generateInstanceID() string {
 	strlen := 10
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -21,6 +23,18 @@ const (
 	baseDomain = "lb.doitapp.io"
 )
 
+const (
+	// kvsAttempts is how many times idempotent kvs operations are tried.
+	kvsAttempts = 3
+	// kvsBackoff is the base wait between kvs attempts.
+	kvsBackoff = 200 * time.Millisecond
+	// kvsBreakerThreshold is how many consecutive kvs failures open the
+	// circuit breaker.
+	kvsBreakerThreshold = 5
+	// kvsBreakerCooldown is how long the circuit breaker stays open.
+	kvsBreakerCooldown = 10 * time.Second
+)
+
 var (
 	addr              = envflag.String("ADDR", ":8888", "listen address")
 	dsn               = envflag.String("DB_URL", "", "URL for database")
@@ -32,6 +46,11 @@ var (
 	etcdCAPemFile     = envflag.String("ETCD_CA_PEM", "", "etcd ca pem")
 	etcdClientKeyFile = envflag.String("ETCD_CLIENT_KEY", "", "etcd ca key")
 	etcdClientPemFile = envflag.String("ETCD_CLIENT_PEM", "", "etcd ca pem")
+	consulAddr        = envflag.String("CONSUL_ADDR", "", "consul agent address")
+	kvsBackend        = envflag.String("KVS_BACKEND", "etcd", "kvs backend (etcd, etcdv3 or consul)")
+	kvsRoot           = envflag.String("KVS_ROOT", "", "prefix for all kvs keys")
+	kvsKeyring        = envflag.String("KVS_KEYRING_FILE", "", "keyring file used to encrypt cluster keyrings")
+	kvsPlaintext      = envflag.Bool("KVS_PLAINTEXT_KEYRINGS", false, "store cluster keyrings unencrypted if KVS_KEYRING_FILE is empty")
 )
 
 func main() {
@@ -71,6 +90,30 @@ func main() {
 		log.WithError(err).Fatal("could not initialize kvs")
 	}
 
+	kv = kvs.NewMetrics(
+		kvs.NewBreaker(kvs.NewRetry(kv, kvsAttempts, kvsBackoff), kvsBreakerThreshold, kvsBreakerCooldown),
+		expvar.NewMap("kvs"))
+
+	if *kvsRoot != "" {
+		kv = kvs.NewNamespace(kv, *kvsRoot)
+	}
+
+	if *kvsKeyring != "" {
+		keyring, err := kvs.LoadKeyring(*kvsKeyring)
+		if err != nil {
+			log.WithError(err).Fatal("could not load kvs keyring")
+		}
+
+		kv, err = kvs.NewEncrypted(c.Context, kv, keyring, "/dolb/keyrings")
+		if err != nil {
+			log.WithError(err).Fatal("could not initialize kvs encryption")
+		}
+	} else if *kvsPlaintext {
+		log.Warn("KVS_KEYRING_FILE is not set; cluster keyrings are stored unencrypted")
+	} else {
+		log.Fatal("KVS_KEYRING_FILE environment variable is required; set KVS_PLAINTEXT_KEYRINGS=true to store cluster keyrings unencrypted")
+	}
+
 	c.KVS = kv
 
 	lbStatus := server.NewLBStatus(c)
@@ -92,6 +135,7 @@ func main() {
 	rootMux.Handle("/api/{_dummy:.*}", serverAPI.Mux)
 	rootMux.Handle("/api2/", newLBS.Mux)
 	rootMux.Handle("/api2/{_dummy:.*}", newLBS.Mux)
+	rootMux.Handle("/debug/vars", expvar.Handler())
 	rootMux.Handle("/", dolbSite.Mux)
 	rootMux.Handle("/{_dummy:.*}", dolbSite.Mux)
 
@@ -124,12 +168,32 @@ func initKVS(c *server.Config) (kvs.KVS, error) {
 		Certificate: *etcdClientPemFile,
 		Key:         *etcdClientKeyFile,
 	}
-	kapi, err := kvs.NewKeysAPI(*etcdEndpoints, tlsConfig)
-	if err != nil {
-		return nil, err
-	}
 
-	return kvs.NewEtcd(c.Context, kapi), nil
+	switch *kvsBackend {
+	case "etcd":
+		kapi, err := kvs.NewKeysAPI(*etcdEndpoints, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewEtcd(c.Context, kapi, kvs.EtcdTxnRoot(*kvsRoot)), nil
+	case "etcdv3":
+		client, err := kvs.NewEtcdV3Client(*etcdEndpoints, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewEtcdV3(c.Context, client), nil
+	case "consul":
+		client, err := kvs.NewConsulClient(*consulAddr, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return kvs.NewConsul(c.Context, client.KV(), client.Session()), nil
+	default:
+		return nil, fmt.Errorf("unknown kvs backend %q", *kvsBackend)
+	}
 }
 
 func initEntityManager() (entity.Manager, error) {
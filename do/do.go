@@ -38,6 +38,7 @@ func GodoClientFactory(token string) *godo.Client {
 type DigitalOcean interface {
 	CreateAgent(*DropletCreateRequest) (*Agent, error)
 	DeleteAgent(id int) error
+	GetAgent(id int) (*Agent, error)
 
 	CreateDNS(name, ipAddress string) (*DNSEntry, error)
 	DeleteDNS(id int) error
@@ -123,7 +124,12 @@ func (ldo *LiveDigitalOcean) CreateAgent(dcr *DropletCreateRequest) (*Agent, err
 		return nil, err
 	}
 
-	droplet, _, err = ldo.Client.Droplets.Get(droplet.ID)
+	return ldo.GetAgent(droplet.ID)
+}
+
+// GetAgent retrieves an agent's droplet.
+func (ldo *LiveDigitalOcean) GetAgent(id int) (*Agent, error) {
+	droplet, _, err := ldo.Client.Droplets.Get(id)
 	if err != nil {
 		return nil, err
 	}
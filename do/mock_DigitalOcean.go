@@ -39,6 +39,27 @@ func (_m *MockDigitalOcean) DeleteAgent(id int) error {
 
 	return r0
 }
+func (_m *MockDigitalOcean) GetAgent(id int) (*Agent, error) {
+	ret := _m.Called(id)
+
+	var r0 *Agent
+	if rf, ok := ret.Get(0).(func(int) *Agent); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*Agent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
 func (_m *MockDigitalOcean) CreateDNS(name string, ipAddress string) (*DNSEntry, error) {
 	ret := _m.Called(name, ipAddress)
 
@@ -0,0 +1,436 @@
+// Package haproxy renders and manages the haproxy configuration of a load
+// balancer.
+package haproxy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bryanl/dolb/kvs"
+)
+
+// HTTPSPort is the port HTTP services with certificates are served over TLS
+// on.
+const HTTPSPort = kvs.HTTPSPort
+
+// acmeChallengePath is the path prefix of ACME HTTP-01 challenges.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// Options configure a rendered configuration.
+type Options struct {
+	// StatsSocket is the path of the runtime API socket. There is no socket
+	// if it is empty.
+	StatsSocket string
+	// CertificateDir is the directory containing a PEM bundle for each
+	// certificate. HTTPS isn't served if it is empty.
+	CertificateDir string
+	// ACMEChallengeAddress is the host:port ACME HTTP-01 challenges are
+	// forwarded to. Challenges aren't forwarded if it is empty.
+	ACMEChallengeAddress string
+}
+
+type frontend struct {
+	Name           string
+	Port           int
+	Mode           string
+	TLS            bool
+	ACLs           []string
+	UseBackends    []string
+	DefaultBackend string
+}
+
+type backend struct {
+	Name    string
+	Mode    string
+	Options []string
+	Servers []string
+}
+
+type config struct {
+	Options   Options
+	ACME      bool
+	Frontends []*frontend
+	Backends  []*backend
+}
+
+var configTemplate = template.Must(template.New("haproxy.cfg").Parse(`# generated by dolb; changes will be overwritten
+global
+    maxconn 4096
+{{- if .Options.StatsSocket}}
+    stats socket {{.Options.StatsSocket}} mode 600 level admin
+{{- end}}
+
+defaults
+    timeout connect 5s
+    timeout client 50s
+    timeout server 50s
+{{range .Frontends}}
+frontend {{.Name}}
+    mode {{.Mode}}
+{{- if .TLS}}
+    bind *:{{.Port}} ssl crt {{$.Options.CertificateDir}}
+{{- else}}
+    bind *:{{.Port}}
+{{- end}}
+{{- if eq .Mode "http"}}
+    option forwardfor
+{{- end}}
+{{- range .ACLs}}
+    acl {{.}}
+{{- end}}
+{{- range .UseBackends}}
+    use_backend {{.}}
+{{- end}}
+{{- if .DefaultBackend}}
+    default_backend {{.DefaultBackend}}
+{{- end}}
+{{end}}
+{{- if .ACME}}
+backend acme
+    mode http
+    server agent {{.Options.ACMEChallengeAddress}}
+{{end}}
+{{- range .Backends}}
+backend {{.Name}}
+    mode {{.Mode}}
+{{- range .Options}}
+    {{.}}
+{{- end}}
+{{- range .Servers}}
+    server {{.}}
+{{- end}}
+{{end}}`))
+
+// Render writes the haproxy configuration for services to w. Each port gets
+// a frontend, which dispatches to a backend per service. HTTP services share
+// their port's frontend and are matched in name order. HTTP services with
+// certificates are also served on HTTPSPort.
+func Render(w io.Writer, services []kvs.Service, opts Options) error {
+	c, err := buildConfig(services, opts, true)
+	if err != nil {
+		return err
+	}
+
+	return configTemplate.Execute(w, c)
+}
+
+// buildConfig builds the configuration for services. Backends don't have
+// servers unless withServers is true.
+func buildConfig(services []kvs.Service, opts Options, withServers bool) (*config, error) {
+	c := &config{Options: opts, ACME: opts.ACMEChallengeAddress != ""}
+
+	sorted := make([]kvs.Service, len(services))
+	copy(sorted, services)
+	sort.Sort(byName(sorted))
+
+	frontends := map[int]*frontend{}
+	tcpPorts := map[int]bool{}
+	for _, s := range sorted {
+		if s.Type() == "tcp" {
+			tcpPorts[s.Port()] = true
+			frontends[s.Port()] = &frontend{
+				Name:           fmt.Sprintf("tcp-%d", s.Port()),
+				Port:           s.Port(),
+				Mode:           "tcp",
+				DefaultBackend: s.Name(),
+			}
+		}
+	}
+
+	httpFrontend := func(port int) *frontend {
+		f, ok := frontends[port]
+		if !ok {
+			f = &frontend{Name: fmt.Sprintf("http-%d", port), Port: port, Mode: "http"}
+			if c.ACME {
+				f.ACLs = append(f.ACLs, "acme-challenge path_beg "+acmeChallengePath)
+				f.UseBackends = append(f.UseBackends, "acme if acme-challenge")
+			}
+			frontends[port] = f
+		}
+		return f
+	}
+
+	for _, s := range sorted {
+		if s.Type() == "tcp" {
+			c.Backends = append(c.Backends, renderBackend(s, withServers))
+			continue
+		}
+
+		if tcpPorts[s.Port()] {
+			return nil, fmt.Errorf("http service %s uses port %d of a tcp service", s.Name(), s.Port())
+		}
+
+		acls, conditions, err := matcherACLs(s)
+		if err != nil {
+			return nil, err
+		}
+
+		ports := []int{s.Port()}
+		// the HTTPS frontend can't be added if a tcp service has its port.
+		if len(s.Certificates()) > 0 && opts.CertificateDir != "" && s.Port() != HTTPSPort && !tcpPorts[HTTPSPort] {
+			ports = append(ports, HTTPSPort)
+		}
+
+		for _, port := range ports {
+			f := httpFrontend(port)
+			if port == HTTPSPort && len(s.Certificates()) > 0 && opts.CertificateDir != "" {
+				f.TLS = true
+			}
+
+			f.ACLs = append(f.ACLs, acls...)
+			for _, cond := range conditions {
+				f.UseBackends = append(f.UseBackends, fmt.Sprintf("%s if %s", s.Name(), cond))
+			}
+		}
+
+		c.Backends = append(c.Backends, renderBackend(s, withServers))
+	}
+
+	ports := []int{}
+	for port := range frontends {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	for _, port := range ports {
+		c.Frontends = append(c.Frontends, frontends[port])
+	}
+
+	return c, nil
+}
+
+// matcherACLs returns the ACLs which match requests for an HTTP service, and
+// the conditions which route requests to the service. A request is routed
+// if it meets any of the conditions.
+func matcherACLs(s kvs.Service) ([]string, []string, error) {
+	sc := s.ServiceConfig()
+	name := s.Name()
+
+	switch sc["matcher"] {
+	case "domain":
+		domain, _ := sc["domain"].(string)
+		acl := name + "-domain"
+		return []string{acl + " hdr(host),field(1,:) -i " + escape(domain)}, []string{acl}, nil
+	case "url_reg":
+		reg, _ := sc["url_reg"].(string)
+		acl := name + "-url_reg"
+		return []string{acl + " url_reg " + escape(reg)}, []string{acl}, nil
+	case "rules":
+		rules, _ := sc["rules"].([]kvs.Rule)
+		acls := []string{}
+		conditions := []string{}
+		for i, r := range rules {
+			ruleACLs := ruleACLs(fmt.Sprintf("%s-rule%d", name, i), r)
+
+			names := []string{}
+			for _, acl := range ruleACLs {
+				names = append(names, strings.SplitN(acl, " ", 2)[0])
+			}
+
+			acls = append(acls, ruleACLs...)
+			conditions = append(conditions, strings.Join(names, " "))
+		}
+		return acls, conditions, nil
+	default:
+		return nil, nil, fmt.Errorf("service %s has an unknown matcher %v", name, sc["matcher"])
+	}
+}
+
+// ruleACLs returns an ACL for each condition of a rule.
+func ruleACLs(prefix string, r kvs.Rule) []string {
+	acls := []string{}
+
+	switch {
+	case strings.HasPrefix(r.Host, "*."):
+		acls = append(acls, prefix+"-host hdr(host),field(1,:) -i -m end "+escape(r.Host[1:]))
+	case r.Host != "":
+		acls = append(acls, prefix+"-host hdr(host),field(1,:) -i "+escape(r.Host))
+	}
+
+	if r.PathPrefix != "" {
+		acls = append(acls, prefix+"-path path_beg "+escape(r.PathPrefix))
+	}
+
+	switch {
+	case r.Header != "" && r.HeaderValue != "":
+		acls = append(acls, fmt.Sprintf("%s-header hdr(%s) -m str %s", prefix, r.Header, escape(r.HeaderValue)))
+	case r.Header != "" && r.HeaderRegex != "":
+		acls = append(acls, fmt.Sprintf("%s-header hdr(%s) -m reg %s", prefix, r.Header, escape(r.HeaderRegex)))
+	case r.Header != "":
+		acls = append(acls, fmt.Sprintf("%s-header hdr_cnt(%s) gt 0", prefix, r.Header))
+	}
+
+	if r.Method != "" {
+		acls = append(acls, prefix+"-method method "+r.Method)
+	}
+
+	return acls
+}
+
+// renderBackend renders the backend of a service. It only has servers if
+// withServers is true.
+func renderBackend(s kvs.Service, withServers bool) *backend {
+	mode := "http"
+	if s.Type() == "tcp" {
+		mode = "tcp"
+	}
+
+	b := &backend{Name: s.Name(), Mode: mode}
+
+	balance := s.Balance()
+	b.Options = append(b.Options, "balance "+balance.Algorithm)
+
+	if sticky := balance.Sticky; sticky != nil {
+		cookie := fmt.Sprintf("cookie %s %s", sticky.Cookie, sticky.Mode)
+		if sticky.Mode == kvs.CookieInsert {
+			cookie += " indirect nocache"
+		}
+		b.Options = append(b.Options, cookie)
+	}
+
+	hc := s.HealthCheck()
+	if hc != nil && hc.Type == kvs.HealthCheckHTTP {
+		b.Options = append(b.Options,
+			"option httpchk GET "+escape(hc.Path),
+			fmt.Sprintf("http-check expect status %d", hc.ExpectedStatus))
+	}
+
+	if l := s.Limits(); l != nil {
+		b.Options = append(b.Options, limitOptions(mode, l)...)
+	}
+
+	for _, r := range s.HTTPRules() {
+		b.Options = append(b.Options, httpRuleOptions(r)...)
+	}
+
+	if !withServers {
+		return b
+	}
+
+	for _, u := range s.Upstreams() {
+		b.Servers = append(b.Servers, u.ID+" "+serverOptions(s, u))
+	}
+
+	return b
+}
+
+// limitOptions renders the backend options which enforce limits. Sources
+// are tracked in a stick table of the backend.
+func limitOptions(mode string, l *kvs.Limits) []string {
+	options := []string{}
+
+	if l.RequestRate > 0 || l.ConnectionsPerSource > 0 {
+		store := "conn_cur"
+		if mode == "http" {
+			store += ",http_req_rate(1s)"
+		}
+
+		track := "http-request track-sc0 src"
+		if mode == "tcp" {
+			track = "tcp-request content track-sc0 src"
+		}
+
+		options = append(options, "stick-table type ip size 100k expire 30s store "+store, track)
+	}
+
+	conditions := []string{}
+	if l.RequestRate > 0 {
+		conditions = append(conditions, fmt.Sprintf("{ sc_http_req_rate(0) gt %d }", l.RequestRate))
+	}
+	if l.ConnectionsPerSource > 0 {
+		conditions = append(conditions, fmt.Sprintf("{ sc_conn_cur(0) gt %d }", l.ConnectionsPerSource))
+	}
+
+	for _, cond := range conditions {
+		switch {
+		case mode == "tcp":
+			options = append(options, "tcp-request content reject if "+cond)
+		case l.Action == kvs.LimitTarpit:
+			options = append(options, "http-request tarpit deny_status 429 if "+cond)
+		default:
+			options = append(options, "http-request deny deny_status 429 if "+cond)
+		}
+	}
+
+	if l.UpstreamMaxConn > 0 {
+		options = append(options, fmt.Sprintf("default-server maxconn %d", l.UpstreamMaxConn))
+	}
+
+	return options
+}
+
+// httpRuleOptions renders the backend options of an HTTP rule. Redirects
+// and HSTS only apply to the scheme they make sense for.
+func httpRuleOptions(r kvs.HTTPRule) []string {
+	switch r.Action {
+	case kvs.HTTPRuleSetHeader, kvs.HTTPRuleAddHeader:
+		return []string{fmt.Sprintf("http-%s %s %s %s", r.Direction, r.Action, r.Header, escape(r.Value))}
+	case kvs.HTTPRuleDelHeader:
+		return []string{fmt.Sprintf("http-%s %s %s", r.Direction, r.Action, r.Header)}
+	case kvs.HTTPRuleRedirectHTTPS:
+		return []string{fmt.Sprintf("http-request redirect scheme https code %d unless { ssl_fc }", r.Code)}
+	case kvs.HTTPRuleRedirectHost:
+		host := "{ hdr(host),field(1,:) -i " + r.Host + " }"
+		return []string{
+			fmt.Sprintf("http-request redirect prefix https://%s code %d if { ssl_fc } !%s", r.Host, r.Code, host),
+			fmt.Sprintf("http-request redirect prefix http://%s code %d if !{ ssl_fc } !%s", r.Host, r.Code, host),
+		}
+	case kvs.HTTPRuleHSTS:
+		value := fmt.Sprintf("max-age=%d", r.MaxAge)
+		if r.IncludeSubdomains {
+			value += "; includeSubDomains"
+		}
+		return []string{"http-response set-header Strict-Transport-Security " + escape(value) + " if { ssl_fc }"}
+	default:
+		return nil
+	}
+}
+
+// serverOptions renders the address and options of an upstream's server.
+func serverOptions(s kvs.Service, u kvs.Upstream) string {
+	server := fmt.Sprintf("%s weight %d", u.Address(), serverWeight(u))
+
+	if u.Backup {
+		server += " backup"
+	}
+
+	if s.Balance().Sticky != nil {
+		server += " cookie " + u.ID
+	}
+
+	if hc := s.HealthCheck(); hc != nil {
+		server += fmt.Sprintf(" check inter %d rise %d fall %d",
+			hc.Interval.Nanoseconds()/1e6, hc.Rise, hc.Fall)
+	}
+
+	if u.State == kvs.UpstreamMaint {
+		server += " disabled"
+	}
+
+	return server
+}
+
+// serverWeight is the weight of an upstream's server. Drained upstreams
+// keep their sessions but don't get new ones.
+func serverWeight(u kvs.Upstream) int {
+	if u.State == kvs.UpstreamDrain {
+		return 0
+	}
+
+	return u.Weight
+}
+
+// escape escapes the characters haproxy treats specially in arguments.
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, " ", `\ `, "#", `\#`, `"`, `\"`, `'`, `\'`)
+	return r.Replace(s)
+}
+
+type byName []kvs.Service
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
@@ -0,0 +1,173 @@
+package haproxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRender(t *testing.T) {
+	opts := Options{
+		StatsSocket:          "/var/run/haproxy.sock",
+		CertificateDir:       "/etc/haproxy/certs",
+		ACMEChallengeAddress: "127.0.0.1:8889",
+	}
+
+	examples := []struct {
+		name     string
+		services func(t *testing.T, h *kvs.LiveHaproxy)
+		opts     Options
+	}{
+		{name: "empty", services: func(*testing.T, *kvs.LiveHaproxy) {}},
+		{name: "services", services: createServices, opts: opts},
+	}
+
+	for _, ex := range examples {
+		ctx, cancel := context.WithCancel(context.Background())
+		h := newHaproxy(ctx)
+		ex.services(t, h)
+
+		services, err := h.Services()
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		assert.NoError(t, Render(&buf, services, ex.opts))
+
+		golden := filepath.Join("testdata", ex.name+".cfg")
+		if *update {
+			assert.NoError(t, ioutil.WriteFile(golden, buf.Bytes(), 0644))
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), buf.String(), ex.name)
+
+		cancel()
+	}
+}
+
+func newHaproxy(ctx context.Context) *kvs.LiveHaproxy {
+	id := 0
+	idGen := func() string {
+		id++
+		return fmt.Sprintf("u%d", id)
+	}
+
+	h := kvs.NewLiveHaproxy(kvs.NewMemory(ctx), idGen, app.DefaultLogger())
+	h.Init()
+	return h
+}
+
+func createServices(t *testing.T, h *kvs.LiveHaproxy) {
+	assert.NoError(t, h.Domain("app", "example.com", 80))
+	assert.NoError(t, h.SetBalance("app", kvs.Balance{
+		Algorithm: kvs.BalanceRoundRobin,
+		Sticky:    &kvs.StickySessions{Cookie: "SERVERID", Mode: kvs.CookieInsert},
+	}))
+	assert.NoError(t, h.SetHealthCheck("app", &kvs.HealthCheck{
+		Type: kvs.HealthCheckHTTP, Path: "/health", ExpectedStatus: 200, Interval: 5 * time.Second, Rise: 2, Fall: 3,
+	}))
+
+	primary := kvs.NewUpstream("10.0.0.1", 8080)
+	primary.Weight = 50
+	_, err := h.Upstream("app", primary)
+	assert.NoError(t, err)
+
+	drained := kvs.NewUpstream("10.0.0.2", 8080)
+	drained.State = kvs.UpstreamDrain
+	_, err = h.Upstream("app", drained)
+	assert.NoError(t, err)
+
+	backup := kvs.NewUpstream("10.0.0.3", 8080)
+	backup.Backup = true
+	_, err = h.Upstream("app", backup)
+	assert.NoError(t, err)
+
+	cert, key := testCertificate(t, "example.com")
+	_, err = h.AddCertificate("app", cert, key)
+	assert.NoError(t, err)
+
+	for _, r := range []kvs.HTTPRule{
+		{Action: kvs.HTTPRuleRedirectHTTPS, Code: 301},
+		{Action: kvs.HTTPRuleRedirectHost, Host: "www.example.com", Code: 308},
+		{Action: kvs.HTTPRuleSetHeader, Direction: kvs.HTTPRuleRequest, Header: "X-Forwarded-Proto", Value: "https"},
+		{Action: kvs.HTTPRuleAddHeader, Direction: kvs.HTTPRuleRequest, Header: "X-Request-ID", Value: "%[uuid]"},
+		{Action: kvs.HTTPRuleDelHeader, Direction: kvs.HTTPRuleResponse, Header: "Server"},
+		{Action: kvs.HTTPRuleHSTS, MaxAge: 31536000, IncludeSubdomains: true},
+	} {
+		_, err = h.AddHTTPRule("app", r)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, h.URLReg("legacy", "^/legacy/ .*", 80))
+	assert.NoError(t, h.SetBalance("legacy", kvs.Balance{Algorithm: kvs.BalanceLeastConn}))
+	maint := kvs.NewUpstream("10.0.1.1", 80)
+	maint.State = kvs.UpstreamMaint
+	_, err = h.Upstream("legacy", maint)
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.Rules("api", []kvs.Rule{
+		{Host: "*.example.com", PathPrefix: "/api"},
+		{Header: "X-Version", HeaderRegex: "^v2", Method: "POST"},
+		{Header: "X-Debug"},
+	}, 8080))
+	assert.NoError(t, h.SetLimits("api", &kvs.Limits{
+		RequestRate: 20, ConnectionsPerSource: 10, Action: kvs.LimitTarpit,
+	}))
+	_, err = h.Upstream("api", kvs.NewUpstream("10.0.2.1", 9000))
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.TCP("db", 5432))
+	assert.NoError(t, h.SetHealthCheck("db", &kvs.HealthCheck{
+		Type: kvs.HealthCheckTCP, Interval: 2 * time.Second, Rise: 1, Fall: 2,
+	}))
+	assert.NoError(t, h.SetLimits("db", &kvs.Limits{
+		ConnectionsPerSource: 5, UpstreamMaxConn: 100, Action: kvs.LimitDeny,
+	}))
+	_, err = h.Upstream("db", kvs.NewUpstream("10.0.3.1", 5432))
+	assert.NoError(t, err)
+}
+
+// testCertificate creates a self signed certificate for name.
+func testCertificate(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return string(certPEM), string(keyPEM)
+}
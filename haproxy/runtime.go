@@ -0,0 +1,210 @@
+package haproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/bryanl/dolb/kvs"
+)
+
+// runtimeTimeout is how long a runtime API command may take.
+const runtimeTimeout = 5 * time.Second
+
+// runtimeSuccesses are the responses of runtime API commands which succeed
+// with a message. Other commands succeed silently.
+var runtimeSuccesses = []string{
+	"New server registered.",
+	"Server deleted.",
+}
+
+// Runtime is a client of haproxy's runtime API, served on its stats socket.
+type Runtime struct {
+	Socket  string
+	Timeout time.Duration
+}
+
+// NewRuntime creates a runtime API client for the unix socket at socket.
+func NewRuntime(socket string) *Runtime {
+	return &Runtime{Socket: socket, Timeout: runtimeTimeout}
+}
+
+// Execute runs a runtime API command and returns its response.
+func (r *Runtime) Execute(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", r.Socket, r.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(r.Timeout)); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+
+	// haproxy closes the connection after answering a single command.
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// Apply runs commands in order. It stops at the first command haproxy
+// rejects.
+func (r *Runtime) Apply(commands []string) error {
+	for _, command := range commands {
+		out, err := r.Execute(command)
+		if err != nil {
+			return err
+		}
+
+		if !runtimeSucceeded(out) {
+			return fmt.Errorf("haproxy rejected %q: %s", command, out)
+		}
+	}
+
+	return nil
+}
+
+func runtimeSucceeded(out string) bool {
+	if out == "" {
+		return true
+	}
+
+	for _, s := range runtimeSuccesses {
+		if out == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RuntimeChanges returns the runtime API commands which change haproxy's
+// servers from those of previous to those of current. It returns false if
+// the services changed in a way which needs haproxy to be reloaded, e.g. a
+// service or matcher was added, or an upstream's address changed.
+func RuntimeChanges(previous, current []kvs.Service, opts Options) ([]string, bool, error) {
+	before, err := skeleton(previous, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	after, err := skeleton(current, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if before != after {
+		return nil, false, nil
+	}
+
+	previousServices := map[string]kvs.Service{}
+	for _, s := range previous {
+		previousServices[s.Name()] = s
+	}
+
+	commands := []string{}
+	for _, s := range current {
+		old, ok := previousServices[s.Name()]
+		if !ok {
+			return nil, false, nil
+		}
+
+		serviceCommands, ok := serverChanges(old, s)
+		if !ok {
+			return nil, false, nil
+		}
+
+		commands = append(commands, serviceCommands...)
+	}
+
+	return commands, true, nil
+}
+
+// skeleton renders the configuration of services without servers.
+func skeleton(services []kvs.Service, opts Options) (string, error) {
+	c, err := buildConfig(services, opts, false)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := configTemplate.Execute(&buf, c); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// serverChanges returns the commands which change the servers of previous
+// to those of current. They are the same service, and their backends only
+// differ by servers.
+func serverChanges(previous, current kvs.Service) ([]string, bool) {
+	before := map[string]kvs.Upstream{}
+	for _, u := range previous.Upstreams() {
+		before[u.ID] = u
+	}
+
+	commands := []string{}
+	for _, u := range current.Upstreams() {
+		server := current.Name() + "/" + u.ID
+
+		old, ok := before[u.ID]
+		delete(before, u.ID)
+
+		if !ok {
+			// new servers start in maintenance.
+			commands = append(commands, fmt.Sprintf("add server %s %s", server, serverOptions(current, u)))
+			if current.HealthCheck() != nil {
+				commands = append(commands, "enable health "+server)
+			}
+			if u.State != kvs.UpstreamMaint {
+				commands = append(commands, fmt.Sprintf("set server %s state ready", server))
+			}
+			continue
+		}
+
+		if old.Address() != u.Address() || old.Backup != u.Backup {
+			return nil, false
+		}
+
+		if serverWeight(old) != serverWeight(u) {
+			commands = append(commands, fmt.Sprintf("set weight %s %d", server, serverWeight(u)))
+		}
+
+		if (old.State == kvs.UpstreamMaint) != (u.State == kvs.UpstreamMaint) {
+			state := "ready"
+			if u.State == kvs.UpstreamMaint {
+				state = "maint"
+			}
+			commands = append(commands, fmt.Sprintf("set server %s state %s", server, state))
+		}
+	}
+
+	removed := []string{}
+	for id := range before {
+		removed = append(removed, id)
+	}
+	sort.Strings(removed)
+
+	for _, id := range removed {
+		server := current.Name() + "/" + id
+		// servers can only be deleted once they are in maintenance and
+		// their sessions are gone.
+		commands = append(commands,
+			fmt.Sprintf("set server %s state maint", server),
+			"shutdown sessions server "+server,
+			"del server "+server)
+	}
+
+	return commands, true
+}
@@ -0,0 +1,156 @@
+package haproxy
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSocket is a runtime API server. It answers each command with the
+// response in responses, or an empty response.
+type fakeSocket struct {
+	sync.Mutex
+	listener  net.Listener
+	responses map[string]string
+	commands  []string
+}
+
+func newFakeSocket(t *testing.T, path string, responses map[string]string) *fakeSocket {
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+
+	fs := &fakeSocket{listener: l, responses: responses}
+	go fs.serve()
+	return fs
+}
+
+func (fs *fakeSocket) serve() {
+	for {
+		conn, err := fs.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		command, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil {
+			command = command[:len(command)-1]
+
+			fs.Lock()
+			fs.commands = append(fs.commands, command)
+			response := fs.responses[command]
+			fs.Unlock()
+
+			conn.Write([]byte(response + "\n\n"))
+		}
+		conn.Close()
+	}
+}
+
+func (fs *fakeSocket) received() []string {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.commands
+}
+
+func TestRuntime_Apply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "haproxy.sock")
+	fs := newFakeSocket(t, socket, map[string]string{
+		"add server app/u1 10.0.0.1:80 weight 1": "New server registered.",
+		"set weight app/u2 10":                   "No such server.",
+	})
+	defer fs.listener.Close()
+
+	r := NewRuntime(socket)
+
+	err = r.Apply([]string{"add server app/u1 10.0.0.1:80 weight 1", "set server app/u1 state ready"})
+	assert.NoError(t, err)
+
+	err = r.Apply([]string{"set weight app/u2 10", "set server app/u2 state ready"})
+	assert.EqualError(t, err, `haproxy rejected "set weight app/u2 10": No such server.`)
+
+	assert.Equal(t, []string{
+		"add server app/u1 10.0.0.1:80 weight 1",
+		"set server app/u1 state ready",
+		"set weight app/u2 10",
+	}, fs.received())
+}
+
+func TestRuntime_Execute_noSocket(t *testing.T) {
+	r := NewRuntime("/nonexistent/haproxy.sock")
+	r.Timeout = time.Second
+
+	_, err := r.Execute("show info")
+	assert.Error(t, err)
+}
+
+func TestRuntimeChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newHaproxy(ctx)
+	assert.NoError(t, h.Domain("app", "example.com", 80))
+	assert.NoError(t, h.SetHealthCheck("app", &kvs.HealthCheck{
+		Type: kvs.HealthCheckTCP, Interval: time.Second, Rise: 1, Fall: 1,
+	}))
+	_, err := h.Upstream("app", kvs.NewUpstream("10.0.0.1", 80))
+	assert.NoError(t, err)
+	_, err = h.Upstream("app", kvs.NewUpstream("10.0.0.2", 80))
+	assert.NoError(t, err)
+
+	previous, err := h.Services()
+	assert.NoError(t, err)
+
+	_, err = h.Upstream("app", kvs.NewUpstream("10.0.0.3", 80))
+	assert.NoError(t, err)
+	assert.NoError(t, h.DeleteUpstream("app", "u1"))
+	weight, maint := 20, kvs.UpstreamMaint
+	assert.NoError(t, h.UpdateUpstream("app", "u2", kvs.UpstreamUpdate{Weight: &weight, State: &maint}))
+
+	current, err := h.Services()
+	assert.NoError(t, err)
+
+	commands, ok, err := RuntimeChanges(previous, current, Options{})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"set weight app/u2 20",
+		"set server app/u2 state maint",
+		"add server app/u3 10.0.0.3:80 weight 1 check inter 1000 rise 1 fall 1",
+		"enable health app/u3",
+		"set server app/u3 state ready",
+		"set server app/u1 state maint",
+		"shutdown sessions server app/u1",
+		"del server app/u1",
+	}, commands)
+
+	backup := true
+	assert.NoError(t, h.UpdateUpstream("app", "u3", kvs.UpstreamUpdate{Backup: &backup}))
+	changed, err := h.Services()
+	assert.NoError(t, err)
+
+	_, ok, err = RuntimeChanges(current, changed, Options{})
+	assert.NoError(t, err)
+	assert.False(t, ok, "backup servers can't be changed at runtime")
+
+	assert.NoError(t, h.URLReg("legacy", ".*", 80))
+	changed, err = h.Services()
+	assert.NoError(t, err)
+
+	_, ok, err = RuntimeChanges(current, changed, Options{})
+	assert.NoError(t, err)
+	assert.False(t, ok, "new services need a reload")
+}
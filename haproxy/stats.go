@@ -0,0 +1,130 @@
+package haproxy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Stat types of haproxy's show stat rows.
+const (
+	StatFrontend = "frontend"
+	StatBackend  = "backend"
+	StatServer   = "server"
+)
+
+// statTypes are the stat types by haproxy's type column.
+var statTypes = map[string]string{
+	"0": StatFrontend,
+	"1": StatBackend,
+	"2": StatServer,
+}
+
+// Stat is a row of haproxy's statistics. Proxy is the name of the frontend
+// or backend, and Server is the name of the server, FRONTEND or BACKEND.
+type Stat struct {
+	Proxy         string
+	Server        string
+	Type          string
+	Status        string
+	CheckStatus   string
+	Queue         int64
+	Sessions      int64
+	TotalSessions int64
+	BytesIn       int64
+	BytesOut      int64
+	Responses2xx  int64
+	Responses4xx  int64
+	Responses5xx  int64
+}
+
+// Stats returns haproxy's statistics.
+func (r *Runtime) Stats() ([]Stat, error) {
+	out, err := r.Execute("show stat")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseStats(strings.NewReader(out))
+}
+
+// ParseStats parses the CSV output of haproxy's show stat command.
+func ParseStats(r io.Reader) ([]Stat, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return []Stat{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "# ")] = i
+	}
+
+	for _, name := range []string{"pxname", "svname", "type"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("stats are missing column %q", name)
+		}
+	}
+
+	stats := []Stat{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := statRow{columns: columns, record: record}
+		stats = append(stats, Stat{
+			Proxy:         row.value("pxname"),
+			Server:        row.value("svname"),
+			Type:          statTypes[row.value("type")],
+			Status:        row.value("status"),
+			CheckStatus:   row.value("check_status"),
+			Queue:         row.int("qcur"),
+			Sessions:      row.int("scur"),
+			TotalSessions: row.int("stot"),
+			BytesIn:       row.int("bin"),
+			BytesOut:      row.int("bout"),
+			Responses2xx:  row.int("hrsp_2xx"),
+			Responses4xx:  row.int("hrsp_4xx"),
+			Responses5xx:  row.int("hrsp_5xx"),
+		})
+	}
+}
+
+// statRow is a row of stats with its columns.
+type statRow struct {
+	columns map[string]int
+	record  []string
+}
+
+func (sr statRow) value(name string) string {
+	i, ok := sr.columns[name]
+	if !ok || i >= len(sr.record) {
+		return ""
+	}
+
+	return sr.record[i]
+}
+
+// int returns the value of a counter. Counters which don't apply to the row,
+// e.g. response codes of tcp backends, are empty and count as 0.
+func (sr statRow) int(name string) int64 {
+	i, err := strconv.ParseInt(sr.value(name), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return i
+}
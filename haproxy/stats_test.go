@@ -0,0 +1,71 @@
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStats(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "stats.csv"))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	stats, err := ParseStats(f)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 5)
+
+	assert.Equal(t, Stat{
+		Proxy:         "app",
+		Server:        "u2",
+		Type:          StatServer,
+		Status:        "DOWN",
+		CheckStatus:   "L7STS",
+		Queue:         4,
+		Sessions:      1,
+		TotalSessions: 40,
+		BytesIn:       10960,
+		BytesOut:      219200,
+		Responses2xx:  25,
+		Responses4xx:  8,
+		Responses5xx:  6,
+	}, stats[2])
+
+	assert.Equal(t, StatFrontend, stats[0].Type)
+	assert.Equal(t, StatBackend, stats[3].Type)
+
+	db := stats[4]
+	assert.Equal(t, "no check", db.Status)
+	assert.Equal(t, int64(0), db.Responses2xx)
+}
+
+func TestParseStats_empty(t *testing.T) {
+	stats, err := ParseStats(strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+
+	_, err = ParseStats(strings.NewReader("# foo,bar\n1,2\n"))
+	assert.Error(t, err)
+}
+
+func TestRuntime_Stats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	csv, err := ioutil.ReadFile(filepath.Join("testdata", "stats.csv"))
+	assert.NoError(t, err)
+
+	socket := filepath.Join(dir, "haproxy.sock")
+	fs := newFakeSocket(t, socket, map[string]string{"show stat": string(csv)})
+	defer fs.listener.Close()
+
+	stats, err := NewRuntime(socket).Stats()
+	assert.NoError(t, err)
+	assert.Len(t, stats, 5)
+	assert.Equal(t, []string{"show stat"}, fs.received())
+}
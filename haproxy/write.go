@@ -0,0 +1,91 @@
+package haproxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFile atomically replaces the file at path with data. It returns false
+// without writing if the file already contains data.
+func WriteFile(path string, data []byte, perm os.FileMode) (bool, error) {
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return false, err
+	}
+
+	// the rename replaces path, so only clean up if it isn't reached.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// WriteCertificates makes the PEM bundles in dir match bundles, which maps
+// file names without the .pem extension to a certificate chain followed by
+// its key. It returns true if any bundle was written or removed.
+func WriteCertificates(dir string, bundles map[string][]byte) (bool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+
+	changed := false
+	for name, bundle := range bundles {
+		written, err := WriteFile(filepath.Join(dir, name+".pem"), bundle, 0600)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || written
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, fi := range files {
+		name := strings.TrimSuffix(fi.Name(), ".pem")
+		if fi.IsDir() || name == fi.Name() {
+			continue
+		}
+
+		if _, ok := bundles[name]; ok {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
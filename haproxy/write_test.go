@@ -0,0 +1,65 @@
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "haproxy.cfg")
+
+	changed, err := WriteFile(path, []byte("global\n"), 0644)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = WriteFile(path, []byte("global\n"), 0644)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = WriteFile(path, []byte("defaults\n"), 0644)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "defaults\n", string(b))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files are cleaned up")
+}
+
+func TestWriteCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certs := filepath.Join(dir, "certs")
+
+	changed, err := WriteCertificates(certs, map[string][]byte{"app-1": []byte("a"), "app-2": []byte("b")})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = WriteCertificates(certs, map[string][]byte{"app-1": []byte("a"), "app-2": []byte("b")})
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = WriteCertificates(certs, map[string][]byte{"app-2": []byte("b")})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	files, err := ioutil.ReadDir(certs)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "app-2.pem", files[0].Name())
+		assert.Equal(t, os.FileMode(0600), files[0].Mode().Perm())
+	}
+}
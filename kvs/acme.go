@@ -0,0 +1,115 @@
+package kvs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+const (
+	// acmeChallengesKey is the directory containing HTTP-01 challenge
+	// responses.
+	acmeChallengesKey = "/haproxy-discover/acme/challenges"
+	// acmeAccountKey contains the ACME account key. It is below the default
+	// encrypted prefix.
+	acmeAccountKey = "/dolb/secrets/acme/account_key"
+	// acmeChallengeTTL is how long challenge responses are kept if they
+	// aren't removed.
+	acmeChallengeTTL = 10 * time.Minute
+)
+
+// LiveACME stores the ACME account key and HTTP-01 challenge responses. The
+// responses are stored in the kvs so every agent can answer a challenge.
+type LiveACME struct {
+	KVS
+	ChallengesKey string
+	AccountKey    string
+}
+
+// NewLiveACME builds a LiveACME instance.
+func NewLiveACME(backend KVS) *LiveACME {
+	return &LiveACME{
+		KVS:           backend,
+		ChallengesKey: acmeChallengesKey,
+		AccountKey:    acmeAccountKey,
+	}
+}
+
+// SetChallenge stores the key authorization for a challenge token.
+func (a *LiveACME) SetChallenge(token, keyAuth string) error {
+	_, err := a.Set(a.challengeKey(token), keyAuth, &SetOptions{TTL: acmeChallengeTTL})
+	return err
+}
+
+// Challenge returns the key authorization for a challenge token.
+func (a *LiveACME) Challenge(token string) (string, error) {
+	node, err := a.Get(a.challengeKey(token), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return node.Value, nil
+}
+
+// DeleteChallenge removes the key authorization for a challenge token.
+func (a *LiveACME) DeleteChallenge(token string) error {
+	return a.Delete(a.challengeKey(token))
+}
+
+// Account returns the ACME account key, creating it if it doesn't exist.
+func (a *LiveACME) Account() (crypto.Signer, error) {
+	node, err := a.Get(a.AccountKey, nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+		_, err = a.Set(a.AccountKey, string(keyPEM), &SetOptions{IfNotExist: true})
+		if _, ok := err.(*NodeExistError); ok {
+			// created concurrently.
+			return a.Account()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(node.Value))
+	if block == nil {
+		return nil, errors.New("acme account key is not PEM encoded")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid acme account key: %v", err)
+	}
+
+	return key, nil
+}
+
+func (a *LiveACME) challengeKey(token string) string {
+	return a.ChallengesKey + "/" + token
+}
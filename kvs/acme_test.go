@@ -0,0 +1,66 @@
+package kvs_test
+
+import (
+	"crypto/ecdsa"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ACME", func() {
+
+	var (
+		mem    *Memory
+		store  *LiveACME
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+		store = NewLiveACME(mem)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("stores challenge responses", func() {
+		Ω(store.SetChallenge("token", "token.thumbprint")).To(Succeed())
+
+		keyAuth, err := store.Challenge("token")
+		Ω(err).ToNot(HaveOccurred())
+		Ω(keyAuth).To(Equal("token.thumbprint"))
+
+		node, err := mem.Get("/haproxy-discover/acme/challenges/token", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Expiration).ToNot(BeNil())
+
+		Ω(store.DeleteChallenge("token")).To(Succeed())
+		_, err = store.Challenge("token")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("creates the account key once", func() {
+		key, err := store.Account()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(key).To(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+
+		again, err := store.Account()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(again).To(Equal(key))
+	})
+
+	It("rejects invalid account keys", func() {
+		_, err := mem.Set("/dolb/secrets/acme/account_key", "invalid", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = store.Account()
+		Ω(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,183 @@
+package kvs
+
+import (
+	"fmt"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// Balancing algorithms.
+const (
+	// BalanceRoundRobin uses each upstream in turn, according to its weight.
+	BalanceRoundRobin = "roundrobin"
+	// BalanceLeastConn uses the upstream with the fewest connections.
+	BalanceLeastConn = "leastconn"
+	// BalanceSource hashes the client's address.
+	BalanceSource = "source"
+	// BalanceURI hashes the request's URI. It is only used by HTTP services.
+	BalanceURI = "uri"
+)
+
+// Sticky session cookie modes. They are haproxy's cookie modes.
+const (
+	// CookieInsert adds a cookie naming the upstream to responses.
+	CookieInsert = "insert"
+	// CookieRewrite replaces the value of a cookie set by the upstream.
+	CookieRewrite = "rewrite"
+	// CookiePrefix prefixes the value of a cookie set by the upstream.
+	CookiePrefix = "prefix"
+)
+
+const (
+	defaultCookieName = "SERVERID"
+	defaultCookieMode = CookieInsert
+)
+
+// Balance configures how a service spreads traffic across its upstreams.
+// If Sticky is set, HTTP clients keep using the same upstream.
+type Balance struct {
+	Algorithm string
+	Sticky    *StickySessions
+}
+
+// StickySessions configures cookie based session persistence.
+type StickySessions struct {
+	Cookie string
+	Mode   string
+}
+
+// SetDefaults fills in the settings which weren't supplied.
+func (b *Balance) SetDefaults() {
+	if b.Algorithm == "" {
+		b.Algorithm = BalanceRoundRobin
+	}
+
+	if b.Sticky != nil {
+		if b.Sticky.Cookie == "" {
+			b.Sticky.Cookie = defaultCookieName
+		}
+
+		if b.Sticky.Mode == "" {
+			b.Sticky.Mode = defaultCookieMode
+		}
+	}
+}
+
+// Validate makes sure a balance can be used by a service of type
+// serviceType.
+func (b *Balance) Validate(serviceType string) error {
+	switch b.Algorithm {
+	case BalanceRoundRobin, BalanceLeastConn, BalanceSource:
+	case BalanceURI:
+		if serviceType == "tcp" {
+			return fmt.Errorf("tcp services can't balance by uri")
+		}
+	default:
+		return fmt.Errorf("unknown balance algorithm %q", b.Algorithm)
+	}
+
+	if b.Sticky == nil {
+		return nil
+	}
+
+	if serviceType == "tcp" {
+		return fmt.Errorf("tcp services can't have sticky sessions")
+	}
+
+	if b.Sticky.Cookie == "" || strings.ContainsAny(b.Sticky.Cookie, " \t\r\n;,=\"") {
+		return fmt.Errorf("invalid sticky session cookie %q", b.Sticky.Cookie)
+	}
+
+	switch b.Sticky.Mode {
+	case CookieInsert, CookieRewrite, CookiePrefix:
+	default:
+		return fmt.Errorf("unknown sticky session cookie mode %q", b.Sticky.Mode)
+	}
+
+	return nil
+}
+
+// encodeBalance encodes a balance like haproxy's configuration, e.g.
+// "roundrobin cookie SERVERID insert".
+func encodeBalance(b Balance) string {
+	fields := []string{b.Algorithm}
+	if b.Sticky != nil {
+		fields = append(fields, "cookie", b.Sticky.Cookie, b.Sticky.Mode)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// parseBalance decodes a balance encoded by encodeBalance.
+func parseBalance(value string) (Balance, error) {
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 1:
+		return Balance{Algorithm: fields[0]}, nil
+	case len(fields) == 4 && fields[1] == "cookie":
+		return Balance{
+			Algorithm: fields[0],
+			Sticky:    &StickySessions{Cookie: fields[2], Mode: fields[3]},
+		}, nil
+	default:
+		return Balance{}, fmt.Errorf("invalid balance %q", value)
+	}
+}
+
+// SetBalance replaces the balance of a service.
+func (h *LiveHaproxy) SetBalance(app string, b Balance) error {
+	isTCP, err := h.isTCPService(app)
+	if err != nil {
+		return err
+	}
+
+	serviceType := "http"
+	dir := h.serviceKey(app, "")
+	if isTCP {
+		serviceType = "tcp"
+		dir = h.tcpServiceKey(app, "")
+	}
+
+	balanceOps, err := balanceOps(dir, serviceType, b)
+	if err != nil {
+		return err
+	}
+
+	portNode, err := h.Get(dir+"/port", nil)
+	if err != nil {
+		return err
+	}
+
+	ops := []TxnOp{
+		// the service must not be deleted while the balance is changed.
+		{Action: TxnCheck, Key: dir + "/port", PrevIndex: portNode.ModifiedIndex},
+	}
+
+	return h.Txn(append(ops, balanceOps...))
+}
+
+// balanceOps builds the operations which replace the balance in the service
+// directory dir.
+func balanceOps(dir, serviceType string, b Balance) ([]TxnOp, error) {
+	if err := b.Validate(serviceType); err != nil {
+		return nil, err
+	}
+
+	return []TxnOp{{Action: TxnSet, Key: dir + "/balance", Value: encodeBalance(b)}}, nil
+}
+
+// findBalance reads the balance in the service directory dir. Services
+// without a balance use round robin.
+func (h *LiveHaproxy) findBalance(dir string) (Balance, error) {
+	node, err := h.Get(dir+"/balance", nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return Balance{Algorithm: BalanceRoundRobin}, nil
+	}
+
+	if err != nil {
+		return Balance{}, err
+	}
+
+	return parseBalance(node.Value)
+}
@@ -0,0 +1,44 @@
+package kvs_test
+
+import (
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Balance", func() {
+
+	It("defaults to round robin", func() {
+		b := &Balance{}
+		b.SetDefaults()
+
+		Ω(b).To(Equal(&Balance{Algorithm: "roundrobin"}))
+		Ω(b.Validate("tcp")).To(Succeed())
+	})
+
+	It("defaults sticky sessions to an inserted SERVERID cookie", func() {
+		b := &Balance{Algorithm: "leastconn", Sticky: &StickySessions{}}
+		b.SetDefaults()
+
+		Ω(b.Sticky).To(Equal(&StickySessions{Cookie: "SERVERID", Mode: "insert"}))
+		Ω(b.Validate("http")).To(Succeed())
+	})
+
+	It("rejects invalid balances", func() {
+		invalid := []struct {
+			serviceType string
+			balance     Balance
+		}{
+			{"http", Balance{Algorithm: "random"}},
+			{"tcp", Balance{Algorithm: "uri"}},
+			{"tcp", Balance{Algorithm: "source", Sticky: &StickySessions{Cookie: "SRV", Mode: "insert"}}},
+			{"http", Balance{Algorithm: "source", Sticky: &StickySessions{Cookie: "S RV", Mode: "insert"}}},
+			{"http", Balance{Algorithm: "source", Sticky: &StickySessions{Cookie: "SRV", Mode: "append"}}},
+		}
+
+		for _, i := range invalid {
+			Ω(i.balance.Validate(i.serviceType)).ToNot(Succeed(), "%s %#v", i.serviceType, i.balance)
+		}
+	})
+})
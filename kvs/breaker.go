@@ -0,0 +1,181 @@
+package kvs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned when a Breaker isn't letting operations
+	// through to its backend.
+	ErrCircuitOpen = errors.New("kvs circuit breaker is open")
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Breaker is a KVS circuit breaker. After Threshold consecutive operations
+// fail with a transient error, operations fail fast with ErrCircuitOpen.
+// Once Cooldown has passed, a single operation is let through to probe the
+// backend. If it succeeds the breaker closes, otherwise it opens again.
+type Breaker struct {
+	backend KVS
+
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var _ KVS = &Breaker{}
+
+// NewBreaker builds a Breaker instance.
+func NewBreaker(backend KVS, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		backend:   backend,
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// State returns the state of the breaker.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state()
+}
+
+// Delete deletes a key.
+func (b *Breaker) Delete(key string) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := b.backend.Delete(key)
+	b.record(err)
+	return err
+}
+
+// Get retrieves a key.
+func (b *Breaker) Get(key string, options *GetOptions) (*Node, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	node, err := b.backend.Get(key, options)
+	b.record(err)
+	return node, err
+}
+
+// Mkdir creates a directory.
+func (b *Breaker) Mkdir(dir string) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := b.backend.Mkdir(dir)
+	b.record(err)
+	return err
+}
+
+// Rmdir removes a directory.
+func (b *Breaker) Rmdir(dir string) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := b.backend.Rmdir(dir)
+	b.record(err)
+	return err
+}
+
+// Set sets a key's value.
+func (b *Breaker) Set(key, value string, options *SetOptions) (*Node, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	node, err := b.backend.Set(key, value, options)
+	b.record(err)
+	return node, err
+}
+
+// Txn applies a transaction.
+func (b *Breaker) Txn(ops []TxnOp) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := b.backend.Txn(ops)
+	b.record(err)
+	return err
+}
+
+// Watch watches a key for changes.
+func (b *Breaker) Watch(key string, recursive bool) (<-chan Event, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	events, err := b.backend.Watch(key, recursive)
+	b.record(err)
+	return events, err
+}
+
+// state returns the state of the breaker. It must be called with b.mu held.
+func (b *Breaker) state() string {
+	switch {
+	case b.failures < b.Threshold:
+		return BreakerClosed
+	case time.Since(b.openedAt) < b.Cooldown:
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+// allow returns ErrCircuitOpen if an operation can't be sent to the backend.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case BreakerClosed:
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return ErrCircuitOpen
+	}
+}
+
+// record updates the breaker with the result of an operation.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !isTransient(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+	}
+}
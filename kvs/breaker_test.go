@@ -0,0 +1,81 @@
+package kvs_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Breaker", func() {
+
+	var (
+		kvs     *MockKVS
+		breaker *Breaker
+		err     error
+		timeout = errors.New("timeout")
+	)
+
+	BeforeEach(func() {
+		kvs = &MockKVS{}
+		breaker = NewBreaker(kvs, 2, 50*time.Millisecond)
+	})
+
+	AfterEach(func() {
+		kvs.AssertExpectations(GinkgoT())
+	})
+
+	It("opens after consecutive transient failures", func() {
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, timeout).Twice()
+
+		_, err = breaker.Get("/foo", nil)
+		Ω(err).To(Equal(timeout))
+		Ω(breaker.State()).To(Equal(BreakerClosed))
+
+		_, err = breaker.Get("/foo", nil)
+		Ω(err).To(Equal(timeout))
+		Ω(breaker.State()).To(Equal(BreakerOpen))
+
+		_, err = breaker.Get("/foo", nil)
+		Ω(err).To(Equal(ErrCircuitOpen))
+	})
+
+	It("isn't opened by errors reported by the kvs", func() {
+		notFound := &KVError{Key: "/foo", Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, notFound).Times(3)
+
+		for i := 0; i < 3; i++ {
+			_, err = breaker.Get("/foo", nil)
+			Ω(err).To(Equal(notFound))
+		}
+
+		Ω(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("closes after a successful probe", func() {
+		kvs.On("Delete", "/foo").Return(timeout).Twice()
+		kvs.On("Delete", "/foo").Return(nil).Once()
+
+		Ω(breaker.Delete("/foo")).To(Equal(timeout))
+		Ω(breaker.Delete("/foo")).To(Equal(timeout))
+
+		Eventually(breaker.State).Should(Equal(BreakerHalfOpen))
+		Ω(breaker.Delete("/foo")).To(Succeed())
+		Ω(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("opens again after a failed probe", func() {
+		kvs.On("Delete", "/foo").Return(timeout).Times(3)
+
+		Ω(breaker.Delete("/foo")).To(Equal(timeout))
+		Ω(breaker.Delete("/foo")).To(Equal(timeout))
+
+		Eventually(breaker.State).Should(Equal(BreakerHalfOpen))
+		Ω(breaker.Delete("/foo")).To(Equal(timeout))
+		Ω(breaker.State()).To(Equal(BreakerOpen))
+	})
+})
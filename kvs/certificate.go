@@ -0,0 +1,73 @@
+package kvs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HTTPSPort is the port HTTP services with certificates are served over TLS
+// on. Adding a certificate reserves it for the service.
+const HTTPSPort = 443
+
+// Certificate is a TLS certificate a service terminates HTTPS with. A
+// service can have several certificates; the listener picks one using SNI.
+type Certificate struct {
+	ID        string
+	PEM       string
+	Names     []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Expired returns true if the certificate is no longer valid at t.
+func (c *Certificate) Expired(t time.Time) bool {
+	return t.After(c.NotAfter)
+}
+
+// ParseCertificate validates a PEM encoded certificate chain and the private
+// key of its leaf certificate.
+func ParseCertificate(certPEM, keyPEM string) (*Certificate, error) {
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return nil, fmt.Errorf("invalid certificate or key: %v", err)
+	}
+
+	c, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Expired(time.Now()) {
+		return nil, fmt.Errorf("certificate expired at %s", c.NotAfter.Format(time.RFC3339))
+	}
+
+	return c, nil
+}
+
+// parseCertificatePEM reads the leaf of a PEM encoded certificate chain.
+func parseCertificatePEM(certPEM string) (*Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+
+	return &Certificate{
+		PEM:       certPEM,
+		Names:     names,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+	}, nil
+}
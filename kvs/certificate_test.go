@@ -0,0 +1,76 @@
+package kvs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testCertificate creates a self-signed certificate for name.
+func testCertificate(name string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ToNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Ω(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Ω(err).ToNot(HaveOccurred())
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return string(certPEM), string(keyPEM)
+}
+
+var _ = Describe("Certificate", func() {
+
+	It("parses a certificate and its key", func() {
+		notAfter := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		certPEM, keyPEM := testCertificate("example.com", notAfter)
+
+		c, err := ParseCertificate(certPEM, keyPEM)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(c.Names).To(Equal([]string{"example.com"}))
+		Ω(c.NotAfter).To(BeTemporally("==", notAfter))
+		Ω(c.PEM).To(Equal(certPEM))
+	})
+
+	It("rejects keys which don't match the certificate", func() {
+		certPEM, _ := testCertificate("example.com", time.Now().Add(time.Hour))
+		_, keyPEM := testCertificate("example.com", time.Now().Add(time.Hour))
+
+		_, err := ParseCertificate(certPEM, keyPEM)
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("rejects expired certificates", func() {
+		certPEM, keyPEM := testCertificate("example.com", time.Now().Add(-time.Hour))
+
+		_, err := ParseCertificate(certPEM, keyPEM)
+		Ω(err).To(MatchError(ContainSubstring("expired")))
+	})
+
+	It("rejects invalid input", func() {
+		_, err := ParseCertificate("invalid", "invalid")
+		Ω(err).To(HaveOccurred())
+	})
+})
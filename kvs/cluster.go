@@ -71,6 +71,11 @@ func (ckvs *Cluster) Leader() (*Leader, error) {
 	}, nil
 }
 
+// WatchLeader watches the cluster members for changes.
+func (ckvs *Cluster) WatchLeader() (<-chan Event, error) {
+	return ckvs.Watch(ckvs.LeaderKey, true)
+}
+
 // Refresh refreshes a key with a new TTL.
 func (ckvs *Cluster) Refresh(name string, lastIndex uint64) (uint64, error) {
 	opts := &SetOptions{
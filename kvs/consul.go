@@ -0,0 +1,661 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+)
+
+var (
+	// consulMinSessionTTL is the shortest session TTL consul accepts.
+	consulMinSessionTTL = 10 * time.Second
+
+	// consulWatchWaitTime is how long a watch blocks waiting for changes.
+	consulWatchWaitTime = 30 * time.Second
+)
+
+// ConsulKV is the subset of the consul KV API used by Consul. It is
+// satisfied by *api.KV.
+type ConsulKV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
+}
+
+// ConsulSession is the subset of the consul session API used by Consul. It
+// is satisfied by *api.Session.
+type ConsulSession interface {
+	Create(se *api.SessionEntry, q *api.WriteOptions) (string, *api.WriteMeta, error)
+	Renew(id string, q *api.WriteOptions) (*api.SessionEntry, *api.WriteMeta, error)
+}
+
+// Consul is a kvs based on the consul KV store. Like EtcdV3, directories are
+// modeled as key prefixes with an optional marker key ending in a slash.
+// Keys with a TTL are locked by a session which deletes them when it
+// expires. Consul expires sessions lazily, so keys may outlive their TTL by
+// up to twice its length, and TTLs shorter than ten seconds are rounded up.
+type Consul struct {
+	ctx      context.Context
+	kv       ConsulKV
+	sessions ConsulSession
+}
+
+var _ KVS = &Consul{}
+
+// NewConsul builds a Consul instance.
+func NewConsul(ctx context.Context, kv ConsulKV, sessions ConsulSession) *Consul {
+	return &Consul{
+		ctx:      ctx,
+		kv:       kv,
+		sessions: sessions,
+	}
+}
+
+// Mkdir makes a directory in the kvs.
+func (c *Consul) Mkdir(dir string) error {
+	dir = normalizeKey(dir)
+	if dir == "/" {
+		return &MkdirError{Dir: dir, Err: newEtcdError(etcdclient.ErrorCodeRootROnly, dir, 0)}
+	}
+
+	children, meta, err := c.kv.List(consulPrefix(dir), c.queryOptions())
+	if err != nil {
+		return &MkdirError{Dir: dir, Err: err}
+	}
+
+	if len(children) > 0 {
+		return &MkdirError{Dir: dir, Err: newEtcdError(etcdclient.ErrorCodeNotFile, dir, meta.LastIndex)}
+	}
+
+	// like etcd v2, an existing file is replaced by the directory.
+	ops := api.KVTxnOps{
+		{Verb: api.KVCheckNotExists, Key: consulPrefix(dir)},
+		{Verb: api.KVDelete, Key: consulKey(dir)},
+		{Verb: api.KVSet, Key: consulPrefix(dir)},
+	}
+
+	ok, _, meta, err := c.kv.Txn(ops, c.queryOptions())
+	if err != nil {
+		return &MkdirError{Dir: dir, Err: err}
+	}
+
+	if !ok {
+		return &MkdirError{Dir: dir, Err: newEtcdError(etcdclient.ErrorCodeNotFile, dir, txnIndex(meta))}
+	}
+
+	return nil
+}
+
+// Set creates or updates a key in the kvs.
+func (c *Consul) Set(key, value string, options *SetOptions) (*Node, error) {
+	if options == nil {
+		options = &SetOptions{}
+	}
+
+	key = normalizeKey(key)
+	if key == "/" {
+		return nil, &KVError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeRootROnly, key, 0)}
+	}
+
+	children, meta, err := c.kv.List(consulPrefix(key), c.queryOptions())
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	// a key can't replace a directory.
+	if len(children) > 0 {
+		return nil, &KVError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeNotFile, key, meta.LastIndex)}
+	}
+
+	existing, _, err := c.kv.Get(consulKey(key), c.queryOptions())
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	if options.IfNotExist && existing != nil {
+		return nil, &NodeExistError{Key: key}
+	}
+
+	ops := api.KVTxnOps{}
+
+	// or be created below a file.
+	for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: consulKey(dir)})
+	}
+
+	if options.IfNotExist {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: consulKey(key)})
+	}
+
+	if options.PrevIndex > 0 {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCheckIndex, Key: consulKey(key), Index: options.PrevIndex})
+	}
+
+	var session string
+	if existing != nil {
+		session = existing.Session
+	}
+
+	var expiration *time.Time
+	switch {
+	case options.TTL > 0:
+		session, err = c.session(session, options.TTL)
+		if err != nil {
+			return nil, &KVError{Key: key, Err: err}
+		}
+
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVLock, Key: consulKey(key), Value: []byte(value), Session: session})
+
+		exp := time.Now().Add(options.TTL)
+		expiration = &exp
+	case session != "":
+		// the key no longer has a TTL, so release it from its session.
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVUnlock, Key: consulKey(key), Value: []byte(value), Session: session})
+	default:
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: consulKey(key), Value: []byte(value)})
+	}
+
+	ops = append(ops, &api.KVTxnOp{Verb: api.KVGet, Key: consulKey(key)})
+
+	ok, resp, meta, err := c.kv.Txn(ops, c.queryOptions())
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	if !ok {
+		return nil, c.setError(key, options, txnIndex(meta))
+	}
+
+	pair := resp.Results[len(resp.Results)-1]
+	n := convertKVPair(pair)
+	n.Expiration = expiration
+
+	return n, nil
+}
+
+// setError explains why a set transaction failed.
+func (c *Consul) setError(key string, options *SetOptions, index uint64) error {
+	existing, _, err := c.kv.Get(consulKey(key), c.queryOptions())
+	if err != nil {
+		return &KVError{Key: key, Err: err}
+	}
+
+	var code int
+	switch {
+	case options.IfNotExist && existing != nil:
+		return &NodeExistError{Key: key}
+	case options.PrevIndex > 0 && existing == nil:
+		code = etcdclient.ErrorCodeKeyNotFound
+	case options.PrevIndex > 0 && existing.ModifyIndex != options.PrevIndex:
+		code = etcdclient.ErrorCodeTestFailed
+	default:
+		code = etcdclient.ErrorCodeNotDir
+	}
+
+	return &KVError{Key: key, Err: newEtcdError(code, key, index)}
+}
+
+// session renews the session id. If it can't be renewed, a new session with
+// the given TTL is created.
+func (c *Consul) session(id string, ttl time.Duration) (string, error) {
+	if id != "" {
+		entry, _, err := c.sessions.Renew(id, c.writeOptions())
+		if err == nil && entry != nil {
+			return id, nil
+		}
+	}
+
+	if ttl < consulMinSessionTTL {
+		ttl = consulMinSessionTTL
+	}
+
+	id, _, err := c.sessions.Create(&api.SessionEntry{
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: time.Millisecond,
+		TTL:       ttl.String(),
+	}, c.writeOptions())
+
+	return id, err
+}
+
+// Get retrieves a key from the kvs.
+func (c *Consul) Get(key string, options *GetOptions) (*Node, error) {
+	if options == nil {
+		options = &GetOptions{}
+	}
+
+	key = normalizeKey(key)
+
+	if key != "/" {
+		pair, _, err := c.kv.Get(consulKey(key), c.queryOptions())
+		if err != nil {
+			return nil, &KVError{Key: key, Err: err}
+		}
+
+		if pair != nil {
+			return convertKVPair(pair), nil
+		}
+	}
+
+	pairs, meta, err := c.kv.List(consulPrefix(key), c.queryOptions())
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	if len(pairs) == 0 {
+		return nil, &KVError{
+			Key: key,
+			Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, meta.LastIndex),
+		}
+	}
+
+	flat := Nodes{}
+	for _, pair := range pairs {
+		flat = append(flat, convertKVPair(pair))
+	}
+
+	n := buildDir(key, flat)
+	if !options.Recursive {
+		for _, child := range n.Nodes {
+			child.Nodes = Nodes{}
+		}
+	}
+
+	return n, nil
+}
+
+// Rmdir removes a directory from the kvs.
+func (c *Consul) Rmdir(dir string) error {
+	dir = normalizeKey(dir)
+	if dir == "/" {
+		return &KVDeleteError{Key: dir, Err: newEtcdError(etcdclient.ErrorCodeRootROnly, dir, 0)}
+	}
+
+	children, meta, err := c.kv.List(consulPrefix(dir), c.queryOptions())
+	if err != nil {
+		return &KVDeleteError{Key: dir, Err: err}
+	}
+
+	existing, _, err := c.kv.Get(consulKey(dir), c.queryOptions())
+	if err != nil {
+		return &KVDeleteError{Key: dir, Err: err}
+	}
+
+	if len(children) == 0 && existing == nil {
+		return &KVDeleteError{
+			Key: dir,
+			Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, dir, meta.LastIndex),
+		}
+	}
+
+	ops := api.KVTxnOps{
+		{Verb: api.KVDelete, Key: consulKey(dir)},
+		{Verb: api.KVDeleteTree, Key: consulPrefix(dir)},
+	}
+
+	ok, resp, _, err := c.kv.Txn(ops, c.queryOptions())
+	if err != nil {
+		return &KVDeleteError{Key: dir, Err: err}
+	}
+
+	if !ok {
+		return &KVDeleteError{Key: dir, Err: txnError(resp)}
+	}
+
+	return nil
+}
+
+// Delete deletes a key from the kvs.
+func (c *Consul) Delete(key string) error {
+	key = normalizeKey(key)
+
+	children, meta, err := c.kv.List(consulPrefix(key), c.queryOptions())
+	if err != nil {
+		return &KVDeleteError{Key: key, Err: err}
+	}
+
+	if len(children) > 0 {
+		return &KVDeleteError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeNotFile, key, meta.LastIndex)}
+	}
+
+	// the get fails the transaction if the key doesn't exist.
+	ops := api.KVTxnOps{
+		{Verb: api.KVGet, Key: consulKey(key)},
+		{Verb: api.KVDelete, Key: consulKey(key)},
+	}
+
+	ok, _, meta, err := c.kv.Txn(ops, c.queryOptions())
+	if err != nil {
+		return &KVDeleteError{Key: key, Err: err}
+	}
+
+	if !ok {
+		return &KVDeleteError{
+			Key: key,
+			Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, txnIndex(meta)),
+		}
+	}
+
+	return nil
+}
+
+// Txn applies ops atomically in a single consul transaction. Consul limits
+// the number of operations in a transaction, and checks that keys aren't
+// directories are made before the transaction is submitted.
+func (c *Consul) Txn(ops []TxnOp) error {
+	if err := validateTxn(ops); err != nil {
+		return err
+	}
+
+	txn := api.KVTxnOps{}
+	guards := []consulGuard{}
+
+	add := func(i, code int, op *api.KVTxnOp) {
+		txn = append(txn, op)
+		guards = append(guards, consulGuard{op: i, code: code})
+	}
+
+	for i, op := range ops {
+		key := normalizeKey(op.Key)
+
+		if err := c.txnPrecheck(key, op); err != nil {
+			return &TxnError{Op: i, Key: key, Err: err}
+		}
+
+		switch {
+		case op.IfNotExist:
+			add(i, etcdclient.ErrorCodeNodeExist, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: consulKey(key)})
+		case op.PrevIndex > 0:
+			add(i, etcdclient.ErrorCodeTestFailed, &api.KVTxnOp{Verb: api.KVCheckIndex, Key: consulKey(key), Index: op.PrevIndex})
+		case op.Action == TxnCheck || op.Action == TxnDelete:
+			add(i, etcdclient.ErrorCodeKeyNotFound, &api.KVTxnOp{Verb: api.KVGet, Key: consulKey(key)})
+		}
+
+		switch op.Action {
+		case TxnSet:
+			for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+				add(i, etcdclient.ErrorCodeNotDir, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: consulKey(dir)})
+			}
+			add(i, 0, &api.KVTxnOp{Verb: api.KVSet, Key: consulKey(key), Value: []byte(op.Value)})
+		case TxnDelete:
+			add(i, 0, &api.KVTxnOp{Verb: api.KVDelete, Key: consulKey(key)})
+		case TxnRmdir:
+			add(i, 0, &api.KVTxnOp{Verb: api.KVDelete, Key: consulKey(key)})
+			add(i, 0, &api.KVTxnOp{Verb: api.KVDeleteTree, Key: consulPrefix(key)})
+		}
+	}
+
+	ok, resp, meta, err := c.kv.Txn(txn, c.queryOptions())
+	if err != nil {
+		return &TxnError{Op: -1, Err: err}
+	}
+
+	if ok {
+		return nil
+	}
+
+	if resp == nil || len(resp.Errors) == 0 || resp.Errors[0].OpIndex >= len(guards) {
+		return &TxnError{Op: -1, Err: txnError(resp)}
+	}
+
+	g := guards[resp.Errors[0].OpIndex]
+	key := normalizeKey(ops[g.op].Key)
+	if g.code == 0 {
+		return &TxnError{Op: g.op, Key: key, Err: txnError(resp)}
+	}
+
+	code := g.code
+	if code == etcdclient.ErrorCodeTestFailed {
+		existing, _, err := c.kv.Get(consulKey(key), c.queryOptions())
+		if err == nil && existing == nil {
+			code = etcdclient.ErrorCodeKeyNotFound
+		}
+	}
+
+	return &TxnError{Op: g.op, Key: key, Err: newEtcdError(code, key, txnIndex(meta))}
+}
+
+// consulGuard maps an operation in a consul transaction back to the kvs
+// transaction operation it came from and the error code reported when it
+// fails.
+type consulGuard struct {
+	op   int
+	code int
+}
+
+// txnPrecheck checks the parts of a transaction operation consul can't
+// check atomically.
+func (c *Consul) txnPrecheck(key string, op TxnOp) error {
+	if op.Action == TxnCheck {
+		return nil
+	}
+
+	if key == "/" {
+		return newEtcdError(etcdclient.ErrorCodeRootROnly, key, 0)
+	}
+
+	children, meta, err := c.kv.List(consulPrefix(key), c.queryOptions())
+	if err != nil {
+		return err
+	}
+
+	switch op.Action {
+	case TxnSet, TxnDelete:
+		if len(children) > 0 {
+			return newEtcdError(etcdclient.ErrorCodeNotFile, key, meta.LastIndex)
+		}
+	case TxnRmdir:
+		if op.IfNotExist || len(children) > 0 {
+			return nil
+		}
+
+		existing, _, err := c.kv.Get(consulKey(key), c.queryOptions())
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			return newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, meta.LastIndex)
+		}
+	}
+
+	return nil
+}
+
+// Watch watches a key for changes. If recursive is true, changes to keys
+// below key are reported as well. Changes are found by diffing the results
+// of blocking queries, so rapid changes to a key may be coalesced into one
+// event, and expired keys are reported as deletes. The returned channel is
+// closed when the kvs context is done.
+func (c *Consul) Watch(key string, recursive bool) (<-chan Event, error) {
+	key = normalizeKey(key)
+
+	matches := func(k string) bool {
+		return k == key || (recursive && isBelowKey(k, key))
+	}
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+
+		var index uint64
+		var prev map[string]*Node
+
+		for {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			q := c.queryOptions()
+			q.WaitIndex = index
+			q.WaitTime = consulWatchWaitTime
+
+			pairs, meta, err := c.kv.List(consulKey(key), q)
+			if err != nil {
+				select {
+				case <-time.After(watchRetryTimeout):
+				case <-c.ctx.Done():
+					return
+				}
+				continue
+			}
+
+			current := map[string]*Node{}
+			for _, pair := range pairs {
+				n := convertKVPair(pair)
+				if matches(n.Key) {
+					current[n.Key] = n
+				}
+			}
+
+			if prev != nil {
+				for _, e := range diffConsulNodes(prev, current, meta.LastIndex) {
+					select {
+					case out <- e:
+					case <-c.ctx.Done():
+						return
+					}
+				}
+			}
+
+			prev = current
+
+			// consul indexes can go backwards after a snapshot restore.
+			index = meta.LastIndex
+			if index < q.WaitIndex {
+				index = 0
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (c *Consul) queryOptions() *api.QueryOptions {
+	q := &api.QueryOptions{}
+	return q.WithContext(c.ctx)
+}
+
+func (c *Consul) writeOptions() *api.WriteOptions {
+	w := &api.WriteOptions{}
+	return w.WithContext(c.ctx)
+}
+
+// NewConsulClient creates a consul client.
+func NewConsulClient(address string, tc *TLSConfig) (*api.Client, error) {
+	if address == "" {
+		return nil, errors.New("missing CONSUL_ADDR environment variable")
+	}
+
+	config := api.DefaultConfig()
+	config.Address = address
+
+	if tc != nil {
+		config.Scheme = "https"
+		config.TLSConfig = api.TLSConfig{
+			CAFile:   tc.RootPEM,
+			CertFile: tc.Certificate,
+			KeyFile:  tc.Key,
+		}
+	}
+
+	return api.NewClient(config)
+}
+
+// diffConsulNodes creates the events that turn prev into current.
+func diffConsulNodes(prev, current map[string]*Node, index uint64) []Event {
+	events := []Event{}
+
+	for k, n := range current {
+		if p, ok := prev[k]; !ok || p.ModifiedIndex != n.ModifiedIndex {
+			events = append(events, Event{Action: "set", Node: n, PrevNode: p})
+		}
+	}
+
+	for k, p := range prev {
+		if _, ok := current[k]; !ok {
+			events = append(events, Event{
+				Action: "delete",
+				Node: &Node{
+					CreatedIndex:  p.CreatedIndex,
+					Dir:           p.Dir,
+					Key:           p.Key,
+					ModifiedIndex: index,
+					Nodes:         Nodes{},
+				},
+				PrevNode: p,
+			})
+		}
+	}
+
+	sort.Sort(eventsByKey(events))
+
+	return events
+}
+
+// consulKey converts a kvs key to a consul key. Consul keys have no leading
+// slash.
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// consulPrefix is the consul prefix of all keys below key.
+func consulPrefix(key string) string {
+	return consulKey(childPrefix(key))
+}
+
+// convertKVPair converts a consul key pair to a Node. Directory markers are
+// converted to directories.
+func convertKVPair(pair *api.KVPair) *Node {
+	n := &Node{
+		CreatedIndex:  pair.CreateIndex,
+		Key:           "/" + pair.Key,
+		ModifiedIndex: pair.ModifyIndex,
+		Nodes:         Nodes{},
+		Value:         string(pair.Value),
+	}
+
+	if strings.HasSuffix(n.Key, "/") && n.Key != "/" {
+		n.Key = strings.TrimSuffix(n.Key, "/")
+		n.Dir = true
+	}
+
+	return n
+}
+
+// txnIndex is the index reported for a transaction.
+func txnIndex(meta *api.QueryMeta) uint64 {
+	if meta != nil {
+		return meta.LastIndex
+	}
+
+	return 0
+}
+
+// txnError converts the errors of a failed transaction to an error.
+func txnError(resp *api.KVTxnResponse) error {
+	if resp == nil || len(resp.Errors) == 0 {
+		return errors.New("transaction failed")
+	}
+
+	msgs := []string{}
+	for _, e := range resp.Errors {
+		msgs = append(msgs, fmt.Sprintf("op %d: %s", e.OpIndex, e.What))
+	}
+
+	return fmt.Errorf("transaction failed: %s", strings.Join(msgs, ", "))
+}
+
+type eventsByKey []Event
+
+func (s eventsByKey) Len() int           { return len(s) }
+func (s eventsByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s eventsByKey) Less(i, j int) bool { return s[i].Node.Key < s[j].Node.Key }
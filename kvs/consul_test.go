@@ -0,0 +1,418 @@
+package kvs_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeConsulSession struct {
+	ttl      time.Duration
+	deadline time.Time
+}
+
+// fakeConsul is an in-process stand in for the consul KV and session APIs.
+// Time only moves when advance is called.
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	pairs    map[string]*api.KVPair
+	sessions map[string]*fakeConsulSession
+	now      time.Time
+	created  []*api.SessionEntry
+}
+
+var _ ConsulKV = &fakeConsul{}
+var _ ConsulSession = &fakeConsul{}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		pairs:    map[string]*api.KVPair{},
+		sessions: map[string]*fakeConsulSession{},
+		now:      time.Now(),
+	}
+}
+
+// advance moves the clock forward and invalidates expired sessions.
+func (f *fakeConsul) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	for id, s := range f.sessions {
+		if s.deadline.After(f.now) {
+			continue
+		}
+
+		delete(f.sessions, id)
+		f.index++
+		for k, p := range f.pairs {
+			if p.Session == id {
+				delete(f.pairs, k)
+			}
+		}
+	}
+}
+
+// wait blocks like a consul blocking query. It holds f.mu when it returns.
+func (f *fakeConsul) wait(q *api.QueryOptions) {
+	f.mu.Lock()
+	if q == nil || q.WaitIndex == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for f.index <= q.WaitIndex && time.Now().Before(deadline) {
+		f.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		f.mu.Lock()
+	}
+}
+
+func (f *fakeConsul) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	f.wait(q)
+	defer f.mu.Unlock()
+
+	meta := &api.QueryMeta{LastIndex: f.index}
+	p, ok := f.pairs[key]
+	if !ok {
+		return nil, meta, nil
+	}
+
+	c := *p
+	return &c, meta, nil
+}
+
+func (f *fakeConsul) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	f.wait(q)
+	defer f.mu.Unlock()
+
+	pairs := api.KVPairs{}
+	for k, p := range f.pairs {
+		if strings.HasPrefix(k, prefix) {
+			c := *p
+			pairs = append(pairs, &c)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	return pairs, &api.QueryMeta{LastIndex: f.index}, nil
+}
+
+func (f *fakeConsul) Txn(ops api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := f.index + 1
+	pairs := map[string]*api.KVPair{}
+	for k, p := range f.pairs {
+		pairs[k] = p
+	}
+
+	resp := &api.KVTxnResponse{}
+	fail := func(i int, what string) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+		resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: what})
+		return false, resp, &api.QueryMeta{LastIndex: f.index}, nil
+	}
+
+	write := func(op *api.KVTxnOp, session string) {
+		p := &api.KVPair{Key: op.Key, Value: op.Value, CreateIndex: index, ModifyIndex: index, Session: session}
+		if existing, ok := pairs[op.Key]; ok {
+			p.CreateIndex = existing.CreateIndex
+		}
+		pairs[op.Key] = p
+	}
+
+	for i, op := range ops {
+		existing, exists := pairs[op.Key]
+
+		switch op.Verb {
+		case api.KVCheckNotExists:
+			if exists {
+				return fail(i, "key exists")
+			}
+		case api.KVCheckIndex:
+			if !exists || existing.ModifyIndex != op.Index {
+				return fail(i, "index mismatch")
+			}
+		case api.KVGet:
+			if !exists {
+				return fail(i, "key doesn't exist")
+			}
+			c := *existing
+			resp.Results = append(resp.Results, &c)
+		case api.KVSet:
+			write(op, "")
+		case api.KVLock:
+			if _, ok := f.sessions[op.Session]; !ok {
+				return fail(i, "invalid session")
+			}
+			if exists && existing.Session != "" && existing.Session != op.Session {
+				return fail(i, "locked by another session")
+			}
+			write(op, op.Session)
+		case api.KVUnlock:
+			if !exists || existing.Session != op.Session {
+				return fail(i, "not locked by session")
+			}
+			write(op, "")
+		case api.KVDelete:
+			delete(pairs, op.Key)
+		case api.KVDeleteTree:
+			for k := range pairs {
+				if strings.HasPrefix(k, op.Key) {
+					delete(pairs, k)
+				}
+			}
+		default:
+			return fail(i, fmt.Sprintf("unsupported verb %q", op.Verb))
+		}
+	}
+
+	f.pairs = pairs
+	f.index = index
+
+	return true, resp, &api.QueryMeta{LastIndex: f.index}, nil
+}
+
+func (f *fakeConsul) Create(se *api.SessionEntry, q *api.WriteOptions) (string, *api.WriteMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ttl, err := time.ParseDuration(se.TTL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	f.created = append(f.created, se)
+	id := fmt.Sprintf("session-%d", len(f.created))
+	f.sessions[id] = &fakeConsulSession{ttl: ttl, deadline: f.now.Add(ttl)}
+
+	return id, &api.WriteMeta{}, nil
+}
+
+func (f *fakeConsul) Renew(id string, q *api.WriteOptions) (*api.SessionEntry, *api.WriteMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.sessions[id]
+	if !ok {
+		return nil, &api.WriteMeta{}, nil
+	}
+
+	s.deadline = f.now.Add(s.ttl)
+
+	return &api.SessionEntry{ID: id, TTL: s.ttl.String()}, &api.WriteMeta{}, nil
+}
+
+var _ = Describe("Consul", func() {
+
+	var (
+		fake      *fakeConsul
+		consulKVS *Consul
+		node      *Node
+		err       error
+		cancel    context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeConsul()
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		consulKVS = NewConsul(ctx, fake, fake)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("creates and updates keys", func() {
+		node, err = consulKVS.Set("/foo/bar", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Key).To(Equal("/foo/bar"))
+		created := node.CreatedIndex
+
+		node, err = consulKVS.Set("/foo/bar", "qux", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.CreatedIndex).To(Equal(created))
+		Ω(node.ModifiedIndex).To(BeNumerically(">", created))
+
+		node, err = consulKVS.Get("/foo/bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("qux"))
+		Ω(fake.pairs).To(HaveKey("foo/bar"))
+	})
+
+	It("supports IfNotExist", func() {
+		_, err = consulKVS.Set("/foo", "bar", &SetOptions{IfNotExist: true})
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = consulKVS.Set("/foo", "bar", &SetOptions{IfNotExist: true})
+		Ω(err).To(BeAssignableToTypeOf(&NodeExistError{}))
+	})
+
+	It("compares and swaps with PrevIndex", func() {
+		node, err = consulKVS.Set("/foo", "bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = consulKVS.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex + 100})
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeTestFailed))
+
+		_, err = consulKVS.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex})
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = consulKVS.Set("/missing", "baz", &SetOptions{PrevIndex: node.ModifiedIndex})
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("expires keys with a TTL using sessions", func() {
+		node, err = consulKVS.Set("/foo", "bar", &SetOptions{TTL: time.Second})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Expiration).ToNot(BeNil())
+
+		Ω(fake.created).To(HaveLen(1))
+		Ω(fake.created[0].TTL).To(Equal("10s"))
+		Ω(fake.created[0].Behavior).To(Equal(api.SessionBehaviorDelete))
+
+		// refreshing the key renews its session.
+		fake.advance(9 * time.Second)
+		_, err = consulKVS.Set("/foo", "bar", &SetOptions{TTL: time.Second})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(fake.created).To(HaveLen(1))
+
+		fake.advance(9 * time.Second)
+		_, err = consulKVS.Get("/foo", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		fake.advance(2 * time.Second)
+		_, err = consulKVS.Get("/foo", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("releases a key from its session when the TTL is removed", func() {
+		_, err = consulKVS.Set("/foo", "bar", &SetOptions{TTL: time.Second})
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = consulKVS.Set("/foo", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		fake.advance(time.Minute)
+
+		node, err = consulKVS.Get("/foo", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("baz"))
+	})
+
+	It("models directories as prefixes", func() {
+		Ω(consulKVS.Mkdir("/a")).To(Succeed())
+		Ω(fake.pairs).To(HaveKey("a/"))
+
+		_, err = consulKVS.Set("/a/b", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = consulKVS.Set("/a/c/d", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		node, err = consulKVS.Get("/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Dir).To(BeTrue())
+		Ω(node.Nodes).To(HaveLen(2))
+		Ω(node.Nodes[0].Key).To(Equal("/a/b"))
+		Ω(node.Nodes[1].Key).To(Equal("/a/c"))
+		Ω(node.Nodes[1].Dir).To(BeTrue())
+		Ω(node.Nodes[1].Nodes).To(BeEmpty())
+
+		node, err = consulKVS.Get("/a", &GetOptions{Recursive: true})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Nodes[1].Nodes).To(HaveLen(1))
+		Ω(node.Nodes[1].Nodes[0].Value).To(Equal("2"))
+
+		err = consulKVS.Mkdir("/a")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		_, err = consulKVS.Set("/a", "1", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		_, err = consulKVS.Set("/a/b/c", "1", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotDir))
+
+		err = consulKVS.Delete("/a")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		Ω(consulKVS.Rmdir("/a")).To(Succeed())
+		Ω(fake.pairs).To(BeEmpty())
+
+		err = consulKVS.Rmdir("/a")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("deletes keys", func() {
+		_, err = consulKVS.Set("/foo", "bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(consulKVS.Delete("/foo")).To(Succeed())
+
+		err = consulKVS.Delete("/foo")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("watches keys", func() {
+		events, err := consulKVS.Watch("/foo", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		// give the watch time to read the initial state.
+		time.Sleep(50 * time.Millisecond)
+
+		_, err = consulKVS.Set("/foobar", "ignored", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = consulKVS.Set("/foo/bar", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		var e Event
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Action).To(Equal("set"))
+		Ω(e.Node.Key).To(Equal("/foo/bar"))
+		Ω(e.Node.Value).To(Equal("baz"))
+
+		Ω(consulKVS.Delete("/foo/bar")).To(Succeed())
+
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Action).To(Equal("delete"))
+		Ω(e.PrevNode.Value).To(Equal("baz"))
+
+		cancel()
+		Eventually(events).Should(BeClosed())
+	})
+
+	txnSpecs(func() KVS { return consulKVS })
+
+	It("elects a cluster leader", func() {
+		cluster := NewCluster(consulKVS, 5*time.Second)
+
+		_, err = cluster.RegisterAgent("agent-1")
+		Ω(err).ToNot(HaveOccurred())
+		index, err := cluster.RegisterAgent("agent-2")
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = cluster.Refresh("agent-2", index)
+		Ω(err).ToNot(HaveOccurred())
+
+		leader, err := cluster.Leader()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(leader.Name).To(Equal("agent-1"))
+		Ω(leader.NodeCount).To(Equal(2))
+	})
+})
@@ -0,0 +1,443 @@
+package kvs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+const (
+	// encryptedValuePrefix marks values written by Encrypted. It is followed
+	// by the key id, the wrapped data key and the ciphertext, separated by
+	// colons.
+	encryptedValuePrefix = "dolb:enc:v1:"
+)
+
+// Encrypted is a KVS which encrypts the values of keys below Prefixes. Each
+// value is encrypted with its own random data key, and the data key is
+// encrypted with the primary key of a keyring. Values are bound to their
+// key, so an encrypted value can't be copied to another key.
+//
+// A "*" segment in a prefix matches any single key segment, so
+// "/services/*/secret" covers the secret of every service.
+//
+// Values below Prefixes which aren't encrypted are returned as they are, so
+// existing values can be encrypted later with Reencrypt.
+type Encrypted struct {
+	backend  KVS
+	ctx      context.Context
+	Prefixes []string
+
+	mu      sync.RWMutex
+	keyring *Keyring
+}
+
+var _ KVS = &Encrypted{}
+
+// NewEncrypted builds an Encrypted instance. Watches stop when ctx is done.
+func NewEncrypted(ctx context.Context, backend KVS, keyring *Keyring, prefixes ...string) (*Encrypted, error) {
+	e := &Encrypted{backend: backend, ctx: ctx}
+	for _, prefix := range prefixes {
+		e.Prefixes = append(e.Prefixes, normalizeKey(prefix))
+	}
+
+	if err := e.SetKeyring(keyring); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// SetKeyring replaces the keyring. Use it to rotate keys while running; the
+// new keyring must still contain the keys existing values were encrypted
+// with until Reencrypt has run.
+func (e *Encrypted) SetKeyring(keyring *Keyring) error {
+	if err := keyring.Validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.keyring = keyring
+	return nil
+}
+
+// Backend returns the kvs values are stored in. Values read from it aren't
+// decrypted.
+func (e *Encrypted) Backend() KVS {
+	return e.backend
+}
+
+// Keyring returns the keyring values are encrypted with.
+func (e *Encrypted) Keyring() *Keyring {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.keyring
+}
+
+// Delete deletes a key.
+func (e *Encrypted) Delete(key string) error {
+	return e.backend.Delete(key)
+}
+
+// Get retrieves a key and decrypts the values below it.
+func (e *Encrypted) Get(key string, options *GetOptions) (*Node, error) {
+	node, err := e.backend.Get(key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decryptNode(node)
+}
+
+// Mkdir creates a directory.
+func (e *Encrypted) Mkdir(dir string) error {
+	return e.backend.Mkdir(dir)
+}
+
+// Rmdir removes a directory.
+func (e *Encrypted) Rmdir(dir string) error {
+	return e.backend.Rmdir(dir)
+}
+
+// Set encrypts a value if needed and sets it.
+func (e *Encrypted) Set(key, value string, options *SetOptions) (*Node, error) {
+	stored, err := e.encryptValue(key, value)
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	node, err := e.backend.Set(key, stored, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decryptNode(node)
+}
+
+// Txn encrypts the values of set operations if needed and applies a
+// transaction.
+func (e *Encrypted) Txn(ops []TxnOp) error {
+	encrypted := make([]TxnOp, len(ops))
+	for i, op := range ops {
+		if op.Action == TxnSet {
+			stored, err := e.encryptValue(op.Key, op.Value)
+			if err != nil {
+				return &TxnError{Op: i, Key: op.Key, Err: err}
+			}
+			op.Value = stored
+		}
+		encrypted[i] = op
+	}
+
+	return e.backend.Txn(encrypted)
+}
+
+// Watch watches a key for changes. Values in events are decrypted. If a
+// value can't be decrypted, the event has an empty value. The returned
+// channel is closed when the context is done.
+func (e *Encrypted) Watch(key string, recursive bool) (<-chan Event, error) {
+	in, err := e.backend.Watch(key, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+
+		for ev := range in {
+			ev.Node = e.decryptEventNode(ev.Node)
+			ev.PrevNode = e.decryptEventNode(ev.PrevNode)
+
+			select {
+			case out <- ev:
+			case <-e.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Reencrypt encrypts the values below Prefixes which aren't encrypted with
+// the primary key. Run it after rotating keys so old keys can be removed
+// from the keyring. It returns the number of values it encrypted.
+func (e *Encrypted) Reencrypt() (int, error) {
+	count := 0
+
+	for _, prefix := range e.Prefixes {
+		node, err := e.backend.Get(literalPrefix(prefix), &GetOptions{Recursive: true})
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		for _, leaf := range leafNodes(node) {
+			if !matchesPrefix(leaf.Key, prefix) || e.usesPrimaryKey(leaf.Value) {
+				continue
+			}
+
+			value, err := e.decryptValue(leaf.Key, leaf.Value)
+			if err != nil {
+				return count, &KVError{Key: leaf.Key, Err: err}
+			}
+
+			stored, err := e.encryptValue(leaf.Key, value)
+			if err != nil {
+				return count, &KVError{Key: leaf.Key, Err: err}
+			}
+
+			err = e.backend.Txn([]TxnOp{
+				{Action: TxnCheck, Key: leaf.Key, PrevIndex: leaf.ModifiedIndex},
+				{Action: TxnSet, Key: leaf.Key, Value: stored},
+			})
+			if terr, ok := err.(*TxnError); ok && terr.Op == 0 {
+				// the value changed, so it was encrypted by its writer.
+				continue
+			}
+
+			if err != nil {
+				return count, err
+			}
+
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// isEncryptedKey returns true if values of key are encrypted.
+func (e *Encrypted) isEncryptedKey(key string) bool {
+	key = normalizeKey(key)
+	for _, prefix := range e.Prefixes {
+		if matchesPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesPrefix returns true if key is prefix or below it. A "*" segment in
+// prefix matches any segment.
+func matchesPrefix(key, prefix string) bool {
+	keySegs, prefixSegs := keyParts(key), keyParts(prefix)
+	if len(keySegs) < len(prefixSegs) {
+		return false
+	}
+
+	for i, seg := range prefixSegs {
+		if seg != "*" && seg != keySegs[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// literalPrefix returns the part of prefix before its first "*" segment.
+func literalPrefix(prefix string) string {
+	literal := []string{}
+	for _, seg := range keyParts(prefix) {
+		if seg == "*" {
+			break
+		}
+		literal = append(literal, seg)
+	}
+
+	return normalizeKey(strings.Join(literal, "/"))
+}
+
+func (e *Encrypted) usesPrimaryKey(value string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return strings.HasPrefix(value, encryptedValuePrefix+e.keyring.Primary+":")
+}
+
+func (e *Encrypted) decryptNode(n *Node) (*Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	c := *n
+	if !n.Dir {
+		value, err := e.decryptValue(n.Key, n.Value)
+		if err != nil {
+			return nil, &KVError{Key: n.Key, Err: err}
+		}
+		c.Value = value
+	}
+
+	if n.Nodes != nil {
+		c.Nodes = make(Nodes, len(n.Nodes))
+		for i, child := range n.Nodes {
+			dc, err := e.decryptNode(child)
+			if err != nil {
+				return nil, err
+			}
+			c.Nodes[i] = dc
+		}
+	}
+
+	return &c, nil
+}
+
+func (e *Encrypted) decryptEventNode(n *Node) *Node {
+	dn, err := e.decryptNode(n)
+	if err != nil {
+		c := *n
+		c.Value = ""
+		return &c
+	}
+
+	return dn
+}
+
+// encryptValue encrypts a value if key is below Prefixes.
+func (e *Encrypted) encryptValue(key, value string) (string, error) {
+	if !e.isEncryptedKey(key) {
+		return value, nil
+	}
+
+	e.mu.RLock()
+	id := e.keyring.Primary
+	kek := e.keyring.Keys[id]
+	e.mu.RUnlock()
+
+	dek := make([]byte, keyringKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+
+	aad := []byte(normalizeKey(key))
+
+	wrapped, err := seal(kek, dek, aad)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dek, []byte(value), aad)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedValuePrefix + id + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptValue decrypts a value if it is encrypted.
+func (e *Encrypted) decryptValue(key, value string) (string, error) {
+	if !e.isEncryptedKey(key) || !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	e.mu.RLock()
+	kek, ok := e.keyring.Keys[parts[0]]
+	e.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("value was encrypted with unknown key %q", parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	aad := []byte(normalizeKey(key))
+
+	dek, err := open(kek, wrapped, aad)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dek, ciphertext, aad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// seal encrypts plaintext with AES-GCM. The nonce is prepended to the
+// ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts a ciphertext created by seal.
+func open(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// leafNodes flattens the values below a directory.
+func leafNodes(n *Node) Nodes {
+	if !n.Dir {
+		return Nodes{n}
+	}
+
+	leaves := Nodes{}
+	for _, child := range n.Nodes {
+		leaves = append(leaves, leafNodes(child)...)
+	}
+
+	return leaves
+}
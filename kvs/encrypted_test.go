@@ -0,0 +1,335 @@
+package kvs_test
+
+import (
+	. "github.com/bryanl/dolb/kvs"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted", func() {
+
+	var (
+		mem     *Memory
+		enc     *Encrypted
+		keyring *Keyring
+		node    *Node
+		err     error
+		ctx     context.Context
+		cancel  context.CancelFunc
+	)
+
+	raw := func(key string) string {
+		n, err := mem.Get(key, nil)
+		Ω(err).ToNot(HaveOccurred())
+		return n.Value
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+
+		keyring, err = NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+
+		enc, err = NewEncrypted(ctx, mem, keyring, "/secrets/")
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("requires a valid keyring", func() {
+		_, err = NewEncrypted(ctx, mem, &Keyring{Primary: "missing"}, "/secrets")
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("encrypts values below the prefixes", func() {
+		node, err = enc.Set("/secrets/a", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("password"))
+
+		Ω(raw("/secrets/a")).ToNot(ContainSubstring("password"))
+		Ω(raw("/secrets/a")).To(HavePrefix("dolb:enc:v1:" + keyring.Primary + ":"))
+
+		node, err = enc.Get("/secrets/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("password"))
+	})
+
+	It("leaves other values alone", func() {
+		_, err = enc.Set("/public/a", "value", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = enc.Set("/secretsx", "value", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(raw("/public/a")).To(Equal("value"))
+		Ω(raw("/secretsx")).To(Equal("value"))
+	})
+
+	It("matches prefixes with wildcard segments", func() {
+		enc, err = NewEncrypted(ctx, mem, keyring, "/services/*/secret")
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = enc.Set("/services/a/secret/key", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = enc.Set("/services/a/port", "80", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(raw("/services/a/secret/key")).To(HavePrefix("dolb:enc:v1:"))
+		Ω(raw("/services/a/port")).To(Equal("80"))
+
+		_, err = mem.Set("/services/b/secret", "plaintext", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		count, err := enc.Reencrypt()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(count).To(Equal(1))
+		Ω(raw("/services/b/secret")).To(HavePrefix("dolb:enc:v1:"))
+		Ω(raw("/services/a/port")).To(Equal("80"))
+	})
+
+	It("reencrypts nested values stored in etcd", func() {
+		etcd := NewEtcd(ctx, &memoryKeysAPI{mem: mem})
+		enc, err = NewEncrypted(ctx, etcd, keyring, "/services/*/certificates/*/key")
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = mem.Set("/services/a/certificates/1/key", "plaintext", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = mem.Set("/services/a/certificates/1/certificate", "certificate", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		count, err := enc.Reencrypt()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(count).To(Equal(1))
+		Ω(raw("/services/a/certificates/1/key")).To(HavePrefix("dolb:enc:v1:"))
+		Ω(raw("/services/a/certificates/1/certificate")).To(Equal("certificate"))
+	})
+
+	It("returns unencrypted values below the prefixes", func() {
+		_, err = mem.Set("/secrets/a", "plaintext", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		node, err = enc.Get("/secrets/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("plaintext"))
+	})
+
+	It("decrypts directories recursively", func() {
+		_, err = enc.Set("/secrets/svc/a", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = enc.Set("/secrets/svc/b/c", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		node, err = enc.Get("/secrets", &GetOptions{Recursive: true})
+		Ω(err).ToNot(HaveOccurred())
+
+		values := map[string]string{}
+		var walk func(n *Node)
+		walk = func(n *Node) {
+			if !n.Dir {
+				values[n.Key] = n.Value
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		}
+		walk(node)
+
+		Ω(values).To(Equal(map[string]string{
+			"/secrets/svc/a":   "1",
+			"/secrets/svc/b/c": "2",
+		}))
+	})
+
+	It("binds values to their key", func() {
+		_, err = enc.Set("/secrets/a", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = mem.Set("/secrets/b", raw("/secrets/a"), nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = enc.Get("/secrets/b", nil)
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("fails for values encrypted with unknown keys", func() {
+		_, err = enc.Set("/secrets/a", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		other, err := NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(enc.SetKeyring(other)).To(Succeed())
+
+		_, err = enc.Get("/secrets/a", nil)
+		Ω(err).To(MatchError(ContainSubstring("unknown key")))
+	})
+
+	It("encrypts values set in transactions", func() {
+		Ω(enc.Txn([]TxnOp{
+			{Action: TxnSet, Key: "/secrets/a", Value: "password"},
+			{Action: TxnSet, Key: "/public/a", Value: "value"},
+		})).To(Succeed())
+
+		Ω(raw("/secrets/a")).To(HavePrefix("dolb:enc:v1:"))
+		Ω(raw("/public/a")).To(Equal("value"))
+
+		node, err = enc.Get("/secrets/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("password"))
+	})
+
+	It("decrypts watched values", func() {
+		ch, err := enc.Watch("/secrets", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = enc.Set("/secrets/a", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		var ev Event
+		Eventually(ch).Should(Receive(&ev))
+		Ω(ev.Node.Value).To(Equal("password"))
+	})
+
+	It("stops watching when the context is done", func() {
+		ch, err := enc.Watch("/secrets", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		// nothing receives the event, so it's pending when the context is done.
+		_, err = enc.Set("/secrets/a", "password", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		cancel()
+		Eventually(ch).Should(BeClosed())
+	})
+
+	Context("with a rotated keyring", func() {
+
+		var oldKey string
+
+		BeforeEach(func() {
+			_, err = enc.Set("/secrets/a", "1", nil)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = mem.Set("/secrets/b", "2", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			oldKey = keyring.Primary
+			_, err = keyring.Rotate()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(enc.SetKeyring(keyring)).To(Succeed())
+		})
+
+		It("decrypts values encrypted with old keys", func() {
+			node, err = enc.Get("/secrets/a", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("1"))
+		})
+
+		It("reencrypts values with the primary key", func() {
+			count, err := enc.Reencrypt()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(count).To(Equal(2))
+
+			for _, key := range []string{"/secrets/a", "/secrets/b"} {
+				Ω(raw(key)).To(HavePrefix("dolb:enc:v1:" + keyring.Primary + ":"))
+			}
+
+			delete(keyring.Keys, oldKey)
+			Ω(enc.SetKeyring(keyring)).To(Succeed())
+
+			node, err = enc.Get("/secrets/a", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("1"))
+
+			count, err = enc.Reencrypt()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(count).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("Keyring", func() {
+
+	var (
+		mem    *Memory
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("keeps old keys when rotating", func() {
+		kr, err := NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+		old := kr.Primary
+
+		id, err := kr.Rotate()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(kr.Primary).To(Equal(id))
+		Ω(kr.Keys).To(HaveKey(old))
+		Ω(kr.Validate()).To(Succeed())
+	})
+
+	It("verifies signatures made with any of its keys", func() {
+		kr, err := NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+
+		sig := kr.Sign([]byte("message"))
+		Ω(kr.Verify([]byte("message"), sig)).To(BeTrue())
+		Ω(kr.Verify([]byte("other"), sig)).To(BeFalse())
+		Ω(kr.Verify([]byte("message"), "")).To(BeFalse())
+
+		_, err = kr.Rotate()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(kr.Verify([]byte("message"), sig)).To(BeTrue())
+
+		other, err := NewKeyring()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(other.Verify([]byte("message"), sig)).To(BeFalse())
+	})
+
+	It("rejects keys of the wrong size", func() {
+		kr := &Keyring{Primary: "a", Keys: map[string][]byte{"a": []byte("short")}}
+		Ω(kr.Validate()).ToNot(Succeed())
+	})
+
+	It("creates a cluster keyring once", func() {
+		kr, err := ClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).ToNot(HaveOccurred())
+
+		again, err := ClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).ToNot(HaveOccurred())
+		Ω(again).To(Equal(kr))
+	})
+
+	It("rotates the cluster keyring", func() {
+		kr, err := ClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).ToNot(HaveOccurred())
+
+		rotated, err := RotateClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).ToNot(HaveOccurred())
+		Ω(rotated.Primary).ToNot(Equal(kr.Primary))
+		Ω(rotated.Keys).To(HaveKeyWithValue(kr.Primary, kr.Keys[kr.Primary]))
+
+		again, err := ClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).ToNot(HaveOccurred())
+		Ω(again).To(Equal(rotated))
+	})
+
+	It("rejects invalid cluster keyrings", func() {
+		_, err := mem.Set("/keyrings/cluster", "invalid", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = ClusterKeyring(mem, "/keyrings/cluster")
+		Ω(err).To(HaveOccurred())
+	})
+})
@@ -31,3 +31,17 @@ type KVDeleteError struct {
 func (kde *KVDeleteError) Error() string {
 	return fmt.Sprintf("could not delete %q: %v", kde.Key, kde.Err)
 }
+
+// TxnError is returned when a transaction is not applied because one of
+// its operations failed. Op is the index of the failed operation, or -1 if
+// the transaction as a whole failed. The etcd v2 kvs also returns it when
+// an operation was skipped after the rest of the transaction was applied.
+type TxnError struct {
+	Op  int
+	Key string
+	Err error
+}
+
+func (te *TxnError) Error() string {
+	return fmt.Sprintf("transaction failed at operation %d on %q: %v", te.Op, te.Key, te.Err)
+}
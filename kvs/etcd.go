@@ -3,11 +3,13 @@ package kvs
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -15,6 +17,16 @@ import (
 	"golang.org/x/net/context"
 )
 
+var (
+	// watchRetryTimeout is how long a watch waits before trying again
+	// after an error.
+	watchRetryTimeout = time.Second
+
+	// etcdTxnKey is the key etcd v2 transactions are journaled in. It is
+	// below the root set with EtcdTxnRoot.
+	etcdTxnKey = "/dolb/txn"
+)
+
 type NodeExistError struct {
 	Key string
 }
@@ -25,17 +37,36 @@ func (e *NodeExistError) Error() string {
 
 // EtcdKVS is a kvs based on etcd.
 type Etcd struct {
-	ctx   context.Context
-	ksapi etcdclient.KeysAPI
+	ctx    context.Context
+	ksapi  etcdclient.KeysAPI
+	txnKey string
 }
 
 var _ KVS = &Etcd{}
 
 // NewEtcdKVS builds a EtcdKVS instance.
-func NewEtcd(ctx context.Context, ksapi etcdclient.KeysAPI) *Etcd {
-	return &Etcd{
-		ctx:   ctx,
-		ksapi: ksapi,
+func NewEtcd(ctx context.Context, ksapi etcdclient.KeysAPI, options ...func(*Etcd)) *Etcd {
+	ekvs := &Etcd{
+		ctx:    ctx,
+		ksapi:  ksapi,
+		txnKey: etcdTxnKey,
+	}
+
+	for _, option := range options {
+		option(ekvs)
+	}
+
+	return ekvs
+}
+
+// EtcdTxnRoot moves the transaction journal below root. Every write is
+// serialized by the journal, so processes which write under different roots
+// shouldn't share one. root should be the root of the Namespace the kvs is
+// used with: a Namespace keeps every key below its root, so writers which
+// use other roots never touch the same keys.
+func EtcdTxnRoot(root string) func(*Etcd) {
+	return func(ekvs *Etcd) {
+		ekvs.txnKey = normalizeKey(root + etcdTxnKey)
 	}
 }
 
@@ -56,38 +87,38 @@ func (ekvs *Etcd) Mkdir(dir string) error {
 	return nil
 }
 
-// Set creates or updates a key in the kvs.
+// Set creates or updates a key in the kvs. It is written through the
+// transaction journal, so a write can't slip between the checks and the
+// operations of a transaction.
 func (ekvs *Etcd) Set(key, value string, options *SetOptions) (*Node, error) {
 	if options == nil {
 		options = &SetOptions{}
 	}
 
-	opts := &etcdclient.SetOptions{
-		TTL:       options.TTL,
-		PrevIndex: options.PrevIndex,
+	op := TxnOp{
+		Action:     TxnSet,
+		Key:        key,
+		Value:      value,
+		PrevIndex:  options.PrevIndex,
+		IfNotExist: options.IfNotExist,
 	}
 
-	if options.IfNotExist {
-		opts.PrevExist = etcdclient.PrevNoExist
-	}
-
-	resp, err := ekvs.ksapi.Set(ekvs.ctx, key, value, opts)
+	nodes, err := ekvs.txn([]TxnOp{op}, options.TTL)
 	if err != nil {
-		if eerr, ok := err.(etcdclient.Error); ok && eerr.Code == etcdclient.ErrorCodeNodeExist {
-			return nil, &NodeExistError{
-				Key: key,
-			}
-		} else {
-			return nil, &KVError{
-				Key: key,
-				Err: err,
-			}
+		cause := txnErrorCause(err)
+		if isEtcdError(cause, etcdclient.ErrorCodeNodeExist) {
+			return nil, &NodeExistError{Key: key}
 		}
+
+		return nil, &KVError{Key: key, Err: cause}
 	}
 
-	n := ekvs.convertNode(resp.Node)
+	if nodes[0] != nil {
+		return nodes[0], nil
+	}
 
-	return n, nil
+	// another writer claimed the journal and applied the write.
+	return ekvs.Get(key, nil)
 }
 
 // Get retrieves a key from the kvs.
@@ -107,18 +138,14 @@ func (ekvs *Etcd) Get(key string, options *GetOptions) (*Node, error) {
 			Err: err,
 		}
 	}
-	n := ekvs.convertNode(resp.Node)
-
-	for _, en := range resp.Node.Nodes {
-		n.Nodes = append(n.Nodes, ekvs.convertNode(en))
-	}
 
-	return n, nil
+	return ekvs.convertNode(resp.Node), nil
 }
 
-// convertNode converts an etcd client Node to a Node.
+// convertNode converts an etcd client Node and the nodes below it to a Node.
+// etcd only returns nested nodes for recursive gets.
 func (ekvs *Etcd) convertNode(in *etcdclient.Node) *Node {
-	return &Node{
+	n := &Node{
 		CreatedIndex:  in.CreatedIndex,
 		Dir:           in.Dir,
 		Expiration:    in.Expiration,
@@ -127,47 +154,537 @@ func (ekvs *Etcd) convertNode(in *etcdclient.Node) *Node {
 		Nodes:         Nodes{},
 		Value:         in.Value,
 	}
-}
 
-// Rmdir removes a directory from the kvs.
-func (ekvs *Etcd) Rmdir(dir string) error {
-	opts := &etcdclient.DeleteOptions{
-		Dir:       true,
-		Recursive: true,
+	for _, child := range in.Nodes {
+		n.Nodes = append(n.Nodes, ekvs.convertNode(child))
 	}
 
-	_, err := ekvs.ksapi.Delete(ekvs.ctx, dir, opts)
-	if err != nil {
+	return n
+}
+
+// Rmdir removes a directory from the kvs. It is written through the
+// transaction journal.
+func (ekvs *Etcd) Rmdir(dir string) error {
+	if _, err := ekvs.txn([]TxnOp{{Action: TxnRmdir, Key: dir}}, 0); err != nil {
 		return &KVDeleteError{
 			Key: dir,
-			Err: err,
+			Err: txnErrorCause(err),
 		}
 	}
 
 	return nil
 }
 
-// Delete deletes a key from the kvs.
+// Delete deletes a key from the kvs. It is written through the transaction
+// journal.
 func (ekvs *Etcd) Delete(key string) error {
-	opts := &etcdclient.DeleteOptions{}
-
-	_, err := ekvs.ksapi.Delete(ekvs.ctx, key, opts)
-	if err != nil {
+	if _, err := ekvs.txn([]TxnOp{{Action: TxnDelete, Key: key}}, 0); err != nil {
 		return &KVDeleteError{
 			Key: key,
-			Err: err,
+			Err: txnErrorCause(err),
 		}
 	}
 
 	return nil
 }
 
+// txnErrorCause returns the error which made a transaction fail.
+func txnErrorCause(err error) error {
+	if te, ok := err.(*TxnError); ok {
+		return te.Err
+	}
+
+	return err
+}
+
+// journalOp is a journaled write operation. Prev is the modified index the
+// key had when the transaction was checked, and Absent is set if the key
+// didn't exist then. Journals are applied by comparing every key against
+// that state, so an operation is never applied twice or over a later
+// write. Journals written before Prev and Absent existed are applied
+// unconditionally. TTL is the TTL of a set key. Op is the index of the
+// transaction operation the journaled operation belongs to; it is only
+// known to the writer which checked the transaction.
+type journalOp struct {
+	TxnOp
+	Prev   uint64        `json:",omitempty"`
+	Absent bool          `json:",omitempty"`
+	TTL    time.Duration `json:",omitempty"`
+	Op     int           `json:"-"`
+}
+
+// Txn applies ops as a transaction. etcd v2 has no multi-key transactions,
+// so transactions are serialized by compare-and-swapping a journal key: the
+// operations are checked, written to the journal and then applied. If a
+// writer dies part way through, the next writer claims the journal by
+// compare-and-swapping it and finishes applying it. Transactions are atomic
+// with respect to each other, but readers may briefly see a partially
+// applied transaction.
+//
+// Every write, including plain and TTL writes, goes through the journal,
+// since a write between the checks and the operations of a transaction
+// would leave it partially applied. The journal is kept per root (see
+// EtcdTxnRoot), so only writers sharing a root wait for each other. A
+// journaled write costs a read of the journal, a read of each key and its
+// parents, a write of the journal, a read of the journal before each
+// operation and a write to clear it.
+//
+// If a key is changed outside of the journal anyway, for example because
+// it expired, the operations on it are skipped, the rest of the transaction
+// is applied and a TxnError for the first skipped operation is returned.
+func (ekvs *Etcd) Txn(ops []TxnOp) error {
+	_, err := ekvs.txn(ops, 0)
+	return err
+}
+
+// txn applies ops as a transaction. ttl is the TTL of the keys ops set, or 0
+// if they don't expire. It returns the node written by each journaled set
+// operation this writer applied.
+func (ekvs *Etcd) txn(ops []TxnOp, ttl time.Duration) ([]*Node, error) {
+	if err := validateTxn(ops); err != nil {
+		return nil, err
+	}
+
+	for {
+		journal, err := ekvs.txnJournal()
+		if err != nil {
+			return nil, &TxnError{Op: -1, Err: err}
+		}
+
+		if journal.Value != "" {
+			// a transaction was interrupted or is still being applied.
+			// only the writer whose claim succeeds finishes it.
+			claimed, err := ekvs.claimTxn(journal)
+			if isEtcdError(err, etcdclient.ErrorCodeTestFailed, etcdclient.ErrorCodeKeyNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return nil, &TxnError{Op: -1, Err: err}
+			}
+
+			if err := ekvs.replayTxn(claimed); err != nil {
+				return nil, &TxnError{Op: -1, Err: err}
+			}
+			continue
+		}
+
+		jops, err := ekvs.checkTxn(ops, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := json.Marshal(jops)
+		if err != nil {
+			return nil, &TxnError{Op: -1, Err: err}
+		}
+
+		opts := &etcdclient.SetOptions{PrevIndex: journal.ModifiedIndex}
+		if journal.ModifiedIndex == 0 {
+			opts = &etcdclient.SetOptions{PrevExist: etcdclient.PrevNoExist}
+		}
+
+		resp, err := ekvs.ksapi.Set(ekvs.ctx, ekvs.txnKey, string(data), opts)
+		if isEtcdError(err, etcdclient.ErrorCodeTestFailed, etcdclient.ErrorCodeNodeExist) {
+			// another transaction got there first.
+			continue
+		}
+
+		if err != nil {
+			return nil, &TxnError{Op: -1, Err: err}
+		}
+
+		return ekvs.finishTxn(ekvs.convertNode(resp.Node), jops)
+	}
+}
+
+// txnJournal retrieves the transaction journal. A missing journal is
+// returned as an empty node.
+func (ekvs *Etcd) txnJournal() (*Node, error) {
+	resp, err := ekvs.ksapi.Get(ekvs.ctx, ekvs.txnKey, nil)
+	if isEtcdError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return &Node{Key: ekvs.txnKey}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ekvs.convertNode(resp.Node), nil
+}
+
+// claimTxn claims an interrupted journal by rewriting it. The claim fails if
+// the journal was cleared or claimed since it was read, so a journal which
+// was already finished is never replayed.
+func (ekvs *Etcd) claimTxn(journal *Node) (*Node, error) {
+	opts := &etcdclient.SetOptions{PrevIndex: journal.ModifiedIndex}
+	resp, err := ekvs.ksapi.Set(ekvs.ctx, ekvs.txnKey, journal.Value, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return ekvs.convertNode(resp.Node), nil
+}
+
+// checkTxn makes sure every operation in a transaction can be applied and
+// returns the operations to journal. Directories are removed key by key so
+// every removal can be compared. Set keys expire after ttl, unless it is 0.
+func (ekvs *Etcd) checkTxn(ops []TxnOp, ttl time.Duration) ([]journalOp, error) {
+	jops := []journalOp{}
+	removed := []string{}
+
+	for i, op := range ops {
+		key := normalizeKey(op.Key)
+
+		var node *Node
+		var index uint64
+
+		opts := &etcdclient.GetOptions{Recursive: op.Action == TxnRmdir}
+		resp, err := ekvs.ksapi.Get(ekvs.ctx, key, opts)
+		switch {
+		case isEtcdError(err, etcdclient.ErrorCodeKeyNotFound):
+		case err != nil:
+			return nil, &TxnError{Op: i, Key: key, Err: err}
+		default:
+			node = ekvs.convertNode(resp.Node)
+			index = resp.Index
+		}
+
+		code := 0
+		switch {
+		case op.IfNotExist && node != nil:
+			code = etcdclient.ErrorCodeNodeExist
+		case op.IfNotExist:
+		case node == nil && (op.Action != TxnSet || op.PrevIndex > 0):
+			code = etcdclient.ErrorCodeKeyNotFound
+		case op.PrevIndex > 0 && node.ModifiedIndex != op.PrevIndex:
+			code = etcdclient.ErrorCodeTestFailed
+		case node != nil && node.Dir && (op.Action == TxnSet || op.Action == TxnDelete):
+			code = etcdclient.ErrorCodeNotFile
+		}
+
+		if code == 0 && op.Action == TxnSet {
+			code, err = ekvs.checkParents(key)
+			if err != nil {
+				return nil, &TxnError{Op: i, Key: key, Err: err}
+			}
+		}
+
+		if code != 0 {
+			return nil, &TxnError{Op: i, Key: key, Err: newEtcdError(code, key, index)}
+		}
+
+		if isRemoved(key, removed) {
+			// an earlier operation removes the key.
+			node = nil
+		}
+
+		op.Key = key
+		jop := journalOp{TxnOp: op, Op: i}
+		if op.Action == TxnSet {
+			jop.TTL = ttl
+		}
+
+		switch {
+		case op.Action == TxnCheck:
+		case op.Action == TxnRmdir && node != nil:
+			for _, removal := range removalOps(node) {
+				removal.Op = i
+				jops = append(jops, removal)
+			}
+		case node != nil:
+			jop.Prev = node.ModifiedIndex
+			jops = append(jops, jop)
+		default:
+			jop.Absent = true
+			jops = append(jops, jop)
+		}
+
+		if op.Action == TxnDelete || op.Action == TxnRmdir {
+			removed = append(removed, key)
+		}
+	}
+
+	return jops, nil
+}
+
+// isRemoved returns true if key or one of its parents is in removed.
+func isRemoved(key string, removed []string) bool {
+	for _, r := range removed {
+		if key == r || isBelowKey(key, r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removalOps returns the operations which remove a node: its files are
+// deleted, then its directories from the bottom up.
+func removalOps(n *Node) []journalOp {
+	if !n.Dir {
+		return []journalOp{{TxnOp: TxnOp{Action: TxnDelete, Key: n.Key}, Prev: n.ModifiedIndex}}
+	}
+
+	jops := []journalOp{}
+	for _, child := range n.Nodes {
+		jops = append(jops, removalOps(child)...)
+	}
+
+	return append(jops, journalOp{TxnOp: TxnOp{Action: TxnRmdir, Key: n.Key}, Prev: n.ModifiedIndex})
+}
+
+// checkParents makes sure none of the parents of key are files.
+func (ekvs *Etcd) checkParents(key string) (int, error) {
+	for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+		resp, err := ekvs.ksapi.Get(ekvs.ctx, dir, nil)
+		switch {
+		case isEtcdError(err, etcdclient.ErrorCodeKeyNotFound):
+			continue
+		case err != nil:
+			return 0, err
+		case !resp.Node.Dir:
+			return etcdclient.ErrorCodeNotDir, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// finishTxn applies the operations of the journal this writer wrote and
+// clears it. An operation whose key no longer is in the state it was checked
+// against is only expected if another writer claimed the journal and
+// applied the operation; then that writer finishes the journal. Otherwise
+// the key was changed outside of the journal, so the operation is skipped
+// and reported as a TxnError once the rest of the journal is applied. It
+// returns the node written by each set operation this writer applied.
+func (ekvs *Etcd) finishTxn(journal *Node, jops []journalOp) ([]*Node, error) {
+	var skipped error
+
+	nodes := make([]*Node, len(jops))
+	for i, op := range jops {
+		claimed, err := ekvs.isTxnClaimed(journal)
+		if err != nil {
+			return nil, &TxnError{Op: -1, Err: err}
+		}
+
+		if claimed {
+			return nodes, skipped
+		}
+
+		nodes[i], err = ekvs.applyJournalOp(op)
+		if isJournalConflict(err) {
+			claimed, cerr := ekvs.isTxnClaimed(journal)
+			if cerr != nil {
+				return nil, &TxnError{Op: -1, Err: cerr}
+			}
+
+			if claimed {
+				return nodes, skipped
+			}
+
+			if skipped == nil {
+				skipped = &TxnError{Op: op.Op, Key: op.Key, Err: err}
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, &TxnError{Op: -1, Err: err}
+		}
+	}
+
+	if err := ekvs.clearTxn(journal); err != nil {
+		return nil, &TxnError{Op: -1, Err: err}
+	}
+
+	return nodes, skipped
+}
+
+// replayTxn applies the operations in a journal which was claimed from
+// another writer and clears it. That writer may have applied some of them
+// already, so operations whose keys no longer are in the state they were
+// checked against are skipped. It stops early if yet another writer claims
+// the journal, since that writer finishes it.
+func (ekvs *Etcd) replayTxn(journal *Node) error {
+	var jops []journalOp
+	if err := json.Unmarshal([]byte(journal.Value), &jops); err != nil {
+		return err
+	}
+
+	for _, op := range jops {
+		claimed, err := ekvs.isTxnClaimed(journal)
+		if err != nil {
+			return err
+		}
+
+		if claimed {
+			return nil
+		}
+
+		if _, err := ekvs.applyJournalOp(op); err != nil && !isJournalConflict(err) {
+			return err
+		}
+	}
+
+	return ekvs.clearTxn(journal)
+}
+
+// isTxnClaimed returns true if the journal was claimed or cleared since
+// journal was written.
+func (ekvs *Etcd) isTxnClaimed(journal *Node) (bool, error) {
+	current, err := ekvs.txnJournal()
+	if err != nil {
+		return false, err
+	}
+
+	return current.ModifiedIndex != journal.ModifiedIndex, nil
+}
+
+// clearTxn clears the journal, unless another writer claimed it.
+func (ekvs *Etcd) clearTxn(journal *Node) error {
+	_, err := ekvs.ksapi.Set(ekvs.ctx, ekvs.txnKey, "", &etcdclient.SetOptions{PrevIndex: journal.ModifiedIndex})
+	if err != nil && !isEtcdError(err, etcdclient.ErrorCodeTestFailed) {
+		return err
+	}
+
+	return nil
+}
+
+// isJournalConflict returns true if err means a journaled operation's key
+// wasn't in the state the transaction was checked against.
+func isJournalConflict(err error) bool {
+	return isEtcdError(err, etcdclient.ErrorCodeKeyNotFound, etcdclient.ErrorCodeTestFailed,
+		etcdclient.ErrorCodeNodeExist, etcdclient.ErrorCodeDirNotEmpty)
+}
+
+// applyJournalOp applies a journaled operation if its key is still in the
+// state the transaction was checked against. It returns the node a set
+// operation wrote.
+func (ekvs *Etcd) applyJournalOp(op journalOp) (*Node, error) {
+	var resp *etcdclient.Response
+	var err error
+
+	switch {
+	case op.Action == TxnSet && op.Absent:
+		opts := &etcdclient.SetOptions{PrevExist: etcdclient.PrevNoExist, TTL: op.TTL}
+		resp, err = ekvs.ksapi.Set(ekvs.ctx, op.Key, op.Value, opts)
+	case op.Action == TxnSet:
+		opts := &etcdclient.SetOptions{PrevIndex: op.Prev, TTL: op.TTL}
+		resp, err = ekvs.ksapi.Set(ekvs.ctx, op.Key, op.Value, opts)
+	case op.Absent:
+		// there is nothing to delete.
+	case op.Action == TxnDelete:
+		_, err = ekvs.ksapi.Delete(ekvs.ctx, op.Key, &etcdclient.DeleteOptions{PrevIndex: op.Prev})
+	case op.Action == TxnRmdir:
+		// etcd can't compare directories, so only empty directories are
+		// removed; their contents are deleted by earlier operations.
+		opts := &etcdclient.DeleteOptions{Dir: true, Recursive: op.Prev == 0}
+		_, err = ekvs.ksapi.Delete(ekvs.ctx, op.Key, opts)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp == nil {
+		return nil, nil
+	}
+
+	return ekvs.convertNode(resp.Node), nil
+}
+
+// Watch watches a key for changes. If recursive is true, changes to keys
+// below key are reported as well. The returned channel is closed when the
+// kvs context is done.
+func (ekvs *Etcd) Watch(key string, recursive bool) (<-chan Event, error) {
+	opts := &etcdclient.WatcherOptions{
+		Recursive: recursive,
+	}
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+
+		w := ekvs.ksapi.Watcher(key, opts)
+		for {
+			resp, err := w.Next(ekvs.ctx)
+			if err != nil {
+				if ekvs.ctx.Err() != nil {
+					return
+				}
+
+				if eerr, ok := err.(etcdclient.Error); ok && eerr.Code == etcdclient.ErrorCodeEventIndexCleared {
+					// the watcher fell too far behind. start over from the
+					// current index.
+					w = ekvs.ksapi.Watcher(key, opts)
+				}
+
+				time.Sleep(watchRetryTimeout)
+				continue
+			}
+
+			e := Event{
+				Action: resp.Action,
+				Node:   ekvs.convertNode(resp.Node),
+			}
+
+			if resp.PrevNode != nil {
+				e.PrevNode = ekvs.convertNode(resp.PrevNode)
+			}
+
+			select {
+			case out <- e:
+			case <-ekvs.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
 type TLSConfig struct {
 	RootPEM     string
 	Certificate string
 	Key         string
 }
 
+// ClientConfig loads the certificates and builds a client tls.Config.
+func (tc *TLSConfig) ClientConfig() (*tls.Config, error) {
+	caCert, err := ioutil.ReadFile(tc.RootPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	ok := roots.AppendCertsFromPEM(caCert)
+	if !ok {
+		return nil, errors.New("failed to parse root certificate")
+	}
+
+	cert, err := ioutil.ReadFile(tc.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(tc.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{keyPair},
+	}, nil
+}
+
 // NewKeysAPI creates an etcd KeysAPI instance.
 func NewKeysAPI(etcdEndpoints string, tc *TLSConfig) (etcdclient.KeysAPI, error) {
 	if etcdEndpoints == "" {
@@ -184,35 +701,11 @@ func NewKeysAPI(etcdEndpoints string, tc *TLSConfig) (etcdclient.KeysAPI, error)
 	}
 
 	if tc != nil {
-		caCert, err := ioutil.ReadFile(tc.RootPEM)
+		tlsConfig, err := tc.ClientConfig()
 		if err != nil {
 			return nil, err
 		}
-
-		roots := x509.NewCertPool()
-		ok := roots.AppendCertsFromPEM(caCert)
-		if !ok {
-			return nil, errors.New("failed to parse root certificate")
-		}
-
-		cert, err := ioutil.ReadFile(tc.Certificate)
-		if err != nil {
-			return nil, err
-		}
-
-		key, err := ioutil.ReadFile(tc.Key)
-		if err != nil {
-			return nil, err
-		}
-
-		keyPair, err := tls.X509KeyPair(cert, key)
-		if err != nil {
-			return nil, err
-		}
-		transport.TLSClientConfig = &tls.Config{
-			RootCAs:      roots,
-			Certificates: []tls.Certificate{keyPair},
-		}
+		transport.TLSClientConfig = tlsConfig
 	}
 
 	etcdConfig := etcdclient.Config{
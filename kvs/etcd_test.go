@@ -2,6 +2,8 @@ package kvs_test
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	. "github.com/bryanl/dolb/kvs"
 	"github.com/bryanl/dolb/mocks"
@@ -12,6 +14,19 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type fakeWatcher struct {
+	responses chan *client.Response
+}
+
+func (fw *fakeWatcher) Next(ctx context.Context) (*client.Response, error) {
+	select {
+	case resp := <-fw.responses:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 var _ = Describe("Etcd", func() {
 
 	var (
@@ -21,8 +36,31 @@ var _ = Describe("Etcd", func() {
 		err     error
 		failErr = errors.New("generic fail")
 		node    *Node
+
+		notFound = client.Error{Code: client.ErrorCodeKeyNotFound}
+		journal  = &client.Response{Node: &client.Node{Key: "/dolb/txn", ModifiedIndex: 10}}
 	)
 
+	BeforeEach(func() {
+		kaMock = &mocks.KeysAPI{}
+		etcdKVS = NewEtcd(ctx, kaMock)
+	})
+
+	// expectJournal expects value to be written to the transaction journal,
+	// which is then read reads times while it is applied.
+	expectJournal := func(value string, reads int) {
+		cOpts := &client.SetOptions{PrevExist: client.PrevNoExist}
+		kaMock.On("Set", ctx, "/dolb/txn", value, cOpts).Return(journal, nil).Once()
+		kaMock.On("Get", ctx, "/dolb/txn", (*client.GetOptions)(nil)).Return(journal, nil).Times(reads)
+	}
+
+	// expectJournalCleared expects the journal to be cleared once it is
+	// applied.
+	expectJournalCleared := func() {
+		cOpts := &client.SetOptions{PrevIndex: 10}
+		kaMock.On("Set", ctx, "/dolb/txn", "", cOpts).Return(journal, nil).Once()
+	}
+
 	Describe("Mkdir", func() {
 
 		var opts = &client.SetOptions{Dir: true}
@@ -62,18 +100,27 @@ var _ = Describe("Etcd", func() {
 			opts *SetOptions
 		)
 
+		BeforeEach(func() {
+			opts = nil
+			kaMock.On("Get", ctx, "/dolb/txn", (*client.GetOptions)(nil)).Return(nil, notFound).Once()
+			kaMock.On("Get", ctx, "/foo", &client.GetOptions{}).Return(nil, notFound).Once()
+		})
+
 		JustBeforeEach(func() {
 			node, err = etcdKVS.Set("/foo", "bar", opts)
 		})
 
 		Context("with success", func() {
 			BeforeEach(func() {
-				cOpts := &client.SetOptions{}
+				expectJournal(`[{"Action":"set","Key":"/foo","Value":"bar","PrevIndex":0,"IfNotExist":false,"Absent":true}]`, 1)
+
+				cOpts := &client.SetOptions{PrevExist: client.PrevNoExist}
 				resp := &client.Response{
 					Node: &client.Node{Value: "bar"},
 				}
 
 				kaMock.On("Set", ctx, "/foo", "bar", cOpts).Return(resp, nil).Once()
+				expectJournalCleared()
 			})
 
 			It("returns node with value", func() {
@@ -85,16 +132,54 @@ var _ = Describe("Etcd", func() {
 			})
 		})
 
+		Context("with a TTL", func() {
+			BeforeEach(func() {
+				opts = &SetOptions{TTL: time.Minute}
+				expectJournal(`[{"Action":"set","Key":"/foo","Value":"bar","PrevIndex":0,"IfNotExist":false,"Absent":true,"TTL":60000000000}]`, 1)
+
+				cOpts := &client.SetOptions{PrevExist: client.PrevNoExist, TTL: time.Minute}
+				resp := &client.Response{
+					Node: &client.Node{Value: "bar"},
+				}
+
+				kaMock.On("Set", ctx, "/foo", "bar", cOpts).Return(resp, nil).Once()
+				expectJournalCleared()
+			})
+
+			It("journals the TTL", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(node.Value).To(Equal("bar"))
+			})
+		})
+
 		Context("with failure", func() {
 			BeforeEach(func() {
-				cOpts := &client.SetOptions{}
+				expectJournal(`[{"Action":"set","Key":"/foo","Value":"bar","PrevIndex":0,"IfNotExist":false,"Absent":true}]`, 1)
+
+				cOpts := &client.SetOptions{PrevExist: client.PrevNoExist}
 				kaMock.On("Set", ctx, "/foo", "bar", cOpts).Return(nil, failErr).Once()
 			})
+
 			It("returns an error", func() {
 				Ω(err).To(HaveOccurred())
 			})
 		})
 
+		Context("when the key was written outside of the journal", func() {
+			BeforeEach(func() {
+				expectJournal(`[{"Action":"set","Key":"/foo","Value":"bar","PrevIndex":0,"IfNotExist":false,"Absent":true}]`, 2)
+
+				cOpts := &client.SetOptions{PrevExist: client.PrevNoExist}
+				exists := client.Error{Code: client.ErrorCodeNodeExist}
+				kaMock.On("Set", ctx, "/foo", "bar", cOpts).Return(nil, exists).Once()
+				expectJournalCleared()
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(BeAssignableToTypeOf(&NodeExistError{}))
+				kaMock.AssertExpectations(GinkgoT())
+			})
+		})
 	})
 
 	Describe("Get", func() {
@@ -144,8 +229,16 @@ var _ = Describe("Etcd", func() {
 				cOpts := &client.GetOptions{Recursive: true}
 				resp := &client.Response{
 					Node: &client.Node{
+						Key: "/foo",
+						Dir: true,
 						Nodes: client.Nodes{
-							&client.Node{},
+							&client.Node{
+								Key: "/foo/bar",
+								Dir: true,
+								Nodes: client.Nodes{
+									&client.Node{Key: "/foo/bar/baz", Value: "qux"},
+								},
+							},
 						},
 					},
 				}
@@ -155,31 +248,61 @@ var _ = Describe("Etcd", func() {
 			It("doesn't return an error", func() {
 				Ω(err).ToNot(HaveOccurred())
 			})
+
+			It("converts nested nodes", func() {
+				Ω(node.Nodes).To(HaveLen(1))
+				Ω(node.Nodes[0].Nodes).To(HaveLen(1))
+				Ω(node.Nodes[0].Nodes[0].Key).To(Equal("/foo/bar/baz"))
+				Ω(node.Nodes[0].Nodes[0].Value).To(Equal("qux"))
+			})
 		})
 
 	})
 
 	Describe("Rmdir", func() {
 
+		BeforeEach(func() {
+			resp := &client.Response{
+				Node: &client.Node{
+					Key:           "/foo",
+					Dir:           true,
+					ModifiedIndex: 3,
+					Nodes: client.Nodes{
+						{Key: "/foo/bar", Value: "1", ModifiedIndex: 2},
+					},
+				},
+			}
+
+			kaMock.On("Get", ctx, "/dolb/txn", (*client.GetOptions)(nil)).Return(nil, notFound).Once()
+			kaMock.On("Get", ctx, "/foo", &client.GetOptions{Recursive: true}).Return(resp, nil).Once()
+		})
+
 		JustBeforeEach(func() {
 			err = etcdKVS.Rmdir("/foo")
 		})
 
 		Context("with success", func() {
 			BeforeEach(func() {
-				cOpts := &client.DeleteOptions{Dir: true, Recursive: true}
-				kaMock.On("Delete", ctx, "/foo", cOpts).Return(nil, nil).Once()
+				expectJournal(`[{"Action":"delete","Key":"/foo/bar","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":2},`+
+					`{"Action":"rmdir","Key":"/foo","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":3}]`, 2)
+
+				kaMock.On("Delete", ctx, "/foo/bar", &client.DeleteOptions{PrevIndex: 2}).Return(&client.Response{}, nil).Once()
+				kaMock.On("Delete", ctx, "/foo", &client.DeleteOptions{Dir: true}).Return(&client.Response{}, nil).Once()
+				expectJournalCleared()
 			})
 
 			It("doesn't return an error", func() {
 				Ω(err).ToNot(HaveOccurred())
+				kaMock.AssertExpectations(GinkgoT())
 			})
 		})
 
 		Context("with failure", func() {
 			BeforeEach(func() {
-				cOpts := &client.DeleteOptions{Dir: true, Recursive: true}
-				kaMock.On("Delete", ctx, "/foo", cOpts).Return(nil, failErr).Once()
+				expectJournal(`[{"Action":"delete","Key":"/foo/bar","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":2},`+
+					`{"Action":"rmdir","Key":"/foo","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":3}]`, 1)
+
+				kaMock.On("Delete", ctx, "/foo/bar", &client.DeleteOptions{PrevIndex: 2}).Return(nil, failErr).Once()
 			})
 
 			It("returns an error", func() {
@@ -190,25 +313,38 @@ var _ = Describe("Etcd", func() {
 
 	Describe("Delete", func() {
 
+		BeforeEach(func() {
+			resp := &client.Response{
+				Node: &client.Node{Key: "/foo", Value: "bar", ModifiedIndex: 2},
+			}
+
+			kaMock.On("Get", ctx, "/dolb/txn", (*client.GetOptions)(nil)).Return(nil, notFound).Once()
+			kaMock.On("Get", ctx, "/foo", &client.GetOptions{}).Return(resp, nil).Once()
+		})
+
 		JustBeforeEach(func() {
 			err = etcdKVS.Delete("/foo")
 		})
 
 		Context("with success", func() {
 			BeforeEach(func() {
-				cOpts := &client.DeleteOptions{}
-				kaMock.On("Delete", ctx, "/foo", cOpts).Return(nil, nil).Once()
+				expectJournal(`[{"Action":"delete","Key":"/foo","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":2}]`, 1)
+
+				kaMock.On("Delete", ctx, "/foo", &client.DeleteOptions{PrevIndex: 2}).Return(&client.Response{}, nil).Once()
+				expectJournalCleared()
 			})
 
 			It("doesn't return an error", func() {
 				Ω(err).ToNot(HaveOccurred())
+				kaMock.AssertExpectations(GinkgoT())
 			})
 		})
 
 		Context("with failure", func() {
 			BeforeEach(func() {
-				cOpts := &client.DeleteOptions{}
-				kaMock.On("Delete", ctx, "/foo", cOpts).Return(nil, failErr).Once()
+				expectJournal(`[{"Action":"delete","Key":"/foo","Value":"","PrevIndex":0,"IfNotExist":false,"Prev":2}]`, 1)
+
+				kaMock.On("Delete", ctx, "/foo", &client.DeleteOptions{PrevIndex: 2}).Return(nil, failErr).Once()
 			})
 
 			It("returns an error", func() {
@@ -216,4 +352,374 @@ var _ = Describe("Etcd", func() {
 			})
 		})
 	})
+
+	Describe("Watch", func() {
+
+		var (
+			events  <-chan Event
+			watcher *fakeWatcher
+			cancel  context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			var watchCtx context.Context
+			watchCtx, cancel = context.WithCancel(ctx)
+			etcdKVS = NewEtcd(watchCtx, kaMock)
+
+			watcher = &fakeWatcher{responses: make(chan *client.Response, 1)}
+			opts := &client.WatcherOptions{Recursive: true}
+			kaMock.On("Watcher", "/foo", opts).Return(watcher).Once()
+		})
+
+		AfterEach(func() {
+			cancel()
+			etcdKVS = NewEtcd(ctx, kaMock)
+		})
+
+		JustBeforeEach(func() {
+			events, err = etcdKVS.Watch("/foo", true)
+		})
+
+		It("converts watch responses to events", func() {
+			Ω(err).ToNot(HaveOccurred())
+
+			watcher.responses <- &client.Response{
+				Action:   "set",
+				Node:     &client.Node{Key: "/foo/bar", Value: "baz"},
+				PrevNode: &client.Node{Key: "/foo/bar", Value: "qux"},
+			}
+
+			var e Event
+			Eventually(events).Should(Receive(&e))
+			Ω(e.Action).To(Equal("set"))
+			Ω(e.Node.Value).To(Equal("baz"))
+			Ω(e.PrevNode.Value).To(Equal("qux"))
+		})
+
+		It("closes the channel when the context is done", func() {
+			cancel()
+			Eventually(events).Should(BeClosed())
+		})
+	})
+})
+
+// memoryKeysAPI is an etcd v2 KeysAPI backed by a Memory kvs. Methods the
+// kvs doesn't use are left unimplemented.
+type memoryKeysAPI struct {
+	client.KeysAPI
+	mem *Memory
+}
+
+func (m *memoryKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	getOpts := &GetOptions{}
+	if opts != nil {
+		getOpts.Recursive = opts.Recursive
+	}
+
+	n, err := m.mem.Get(key, getOpts)
+	if err != nil {
+		return nil, toEtcdError(err)
+	}
+
+	return &client.Response{Node: toClientNode(n), Index: n.ModifiedIndex}, nil
+}
+
+func (m *memoryKeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.SetOptions{}
+	}
+
+	if opts.Dir {
+		if err := m.mem.Mkdir(key); err != nil {
+			return nil, toEtcdError(err)
+		}
+
+		return m.Get(ctx, key, nil)
+	}
+
+	n, err := m.mem.Set(key, value, &SetOptions{
+		TTL:        opts.TTL,
+		PrevIndex:  opts.PrevIndex,
+		IfNotExist: opts.PrevExist == client.PrevNoExist,
+	})
+	if err != nil {
+		return nil, toEtcdError(err)
+	}
+
+	return &client.Response{Action: "set", Node: toClientNode(n), Index: n.ModifiedIndex}, nil
+}
+
+func (m *memoryKeysAPI) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.DeleteOptions{}
+	}
+
+	n, err := m.mem.Get(key, nil)
+	if err != nil {
+		return nil, toEtcdError(err)
+	}
+
+	switch {
+	case opts.PrevIndex > 0 && n.ModifiedIndex != opts.PrevIndex:
+		return nil, client.Error{Code: client.ErrorCodeTestFailed}
+	case n.Dir && !opts.Dir:
+		return nil, client.Error{Code: client.ErrorCodeNotFile}
+	case n.Dir && !opts.Recursive && len(n.Nodes) > 0:
+		return nil, client.Error{Code: client.ErrorCodeDirNotEmpty}
+	case n.Dir:
+		err = m.mem.Rmdir(key)
+	default:
+		err = m.mem.Delete(key)
+	}
+
+	if err != nil {
+		return nil, toEtcdError(err)
+	}
+
+	return &client.Response{Action: "delete", Node: &client.Node{Key: key}}, nil
+}
+
+func toClientNode(n *Node) *client.Node {
+	cn := &client.Node{
+		Key:           n.Key,
+		Dir:           n.Dir,
+		Value:         n.Value,
+		CreatedIndex:  n.CreatedIndex,
+		ModifiedIndex: n.ModifiedIndex,
+	}
+
+	for _, child := range n.Nodes {
+		cn.Nodes = append(cn.Nodes, toClientNode(child))
+	}
+
+	return cn
+}
+
+func toEtcdError(err error) error {
+	if _, ok := err.(*NodeExistError); ok {
+		return client.Error{Code: client.ErrorCodeNodeExist}
+	}
+
+	return client.Error{Code: etcdErrorCode(err)}
+}
+
+var _ = Describe("Etcd transactions", func() {
+
+	var (
+		mem     *Memory
+		etcdKVS *Etcd
+		cancel  context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		mem = NewMemory(ctx)
+		etcdKVS = NewEtcd(ctx, &memoryKeysAPI{mem: mem})
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	txnSpecs(func() KVS { return etcdKVS })
+
+	It("clears the journal", func() {
+		Ω(etcdKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/x", Value: "1"}})).To(Succeed())
+
+		node, err := mem.Get("/dolb/txn", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(BeEmpty())
+	})
+
+	It("finishes an interrupted transaction first", func() {
+		_, err := mem.Set("/dolb/txn", `[{"Action":"set","Key":"/x","Value":"1"},{"Action":"delete","Key":"/gone"}]`, nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(etcdKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/y", Value: "2"}})).To(Succeed())
+
+		node, err := mem.Get("/x", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("1"))
+
+		node, err = mem.Get("/y", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+	})
+
+	It("writes plain keys through the journal", func() {
+		node, err := etcdKVS.Set("/dir/a", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("1"))
+
+		_, err = etcdKVS.Set("/dir/a", "2", &SetOptions{IfNotExist: true})
+		Ω(err).To(BeAssignableToTypeOf(&NodeExistError{}))
+
+		_, err = etcdKVS.Set("/dir/a", "2", &SetOptions{PrevIndex: node.ModifiedIndex + 100})
+		Ω(etcdErrorCode(err)).To(Equal(client.ErrorCodeTestFailed))
+
+		_, err = etcdKVS.Set("/dir/b", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(etcdKVS.Delete("/dir/a")).To(Succeed())
+		Ω(etcdErrorCode(etcdKVS.Delete("/dir/a"))).To(Equal(client.ErrorCodeKeyNotFound))
+
+		Ω(etcdKVS.Rmdir("/dir")).To(Succeed())
+		_, err = mem.Get("/dir", nil)
+		Ω(etcdErrorCode(err)).To(Equal(client.ErrorCodeKeyNotFound))
+
+		journal, err := mem.Get("/dolb/txn", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(journal.Value).To(BeEmpty())
+	})
+
+	It("doesn't replay operations over later writes", func() {
+		old, err := mem.Set("/x", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = mem.Set("/x", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = mem.Set("/svc/port", "80", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		journal := fmt.Sprintf(`[{"Action":"set","Key":"/x","Value":"0","Prev":%d},`+
+			`{"Action":"set","Key":"/svc/port","Value":"81","Absent":true},`+
+			`{"Action":"delete","Key":"/svc/port","Prev":%d},`+
+			`{"Action":"rmdir","Key":"/svc","Prev":%d}]`,
+			old.ModifiedIndex, old.ModifiedIndex, old.ModifiedIndex)
+		_, err = mem.Set("/dolb/txn", journal, nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(etcdKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/y", Value: "2"}})).To(Succeed())
+
+		node, err := mem.Get("/x", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+
+		node, err = mem.Get("/svc/port", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("80"))
+	})
+
+	It("doesn't replay a journal which was finished after it was read", func() {
+		Ω(etcdKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/x", Value: "1"}})).To(Succeed())
+		_, err := mem.Set("/x", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		// the writer saw the journal before it was cleared.
+		stale := &client.Node{Key: "/dolb/txn", Value: `[{"Action":"set","Key":"/x","Value":"1"}]`, ModifiedIndex: 1}
+		staleKVS := NewEtcd(context.Background(), &staleJournalKeysAPI{memoryKeysAPI: &memoryKeysAPI{mem: mem}, stale: stale})
+
+		Ω(staleKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/y", Value: "2"}})).To(Succeed())
+
+		node, err := mem.Get("/x", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+	})
+
+	It("finishes removing a directory which was partially removed", func() {
+		Ω(etcdKVS.Txn([]TxnOp{
+			{Action: TxnSet, Key: "/svc/port", Value: "80"},
+			{Action: TxnSet, Key: "/svc/upstreams/a", Value: "10.0.0.1:80"},
+		})).To(Succeed())
+
+		port, err := mem.Get("/svc/port", nil)
+		Ω(err).ToNot(HaveOccurred())
+		upstream, err := mem.Get("/svc/upstreams/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		// the writer died after deleting the port.
+		Ω(mem.Delete("/svc/port")).To(Succeed())
+		journal := fmt.Sprintf(`[{"Action":"delete","Key":"/svc/port","Prev":%d},`+
+			`{"Action":"delete","Key":"/svc/upstreams/a","Prev":%d},`+
+			`{"Action":"rmdir","Key":"/svc/upstreams","Prev":1},`+
+			`{"Action":"rmdir","Key":"/svc","Prev":1}]`,
+			port.ModifiedIndex, upstream.ModifiedIndex)
+		_, err = mem.Set("/dolb/txn", journal, nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(etcdKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/y", Value: "2"}})).To(Succeed())
+
+		_, err = mem.Get("/svc", nil)
+		Ω(etcdErrorCode(err)).To(Equal(client.ErrorCodeKeyNotFound))
+	})
+
+	It("reports an operation skipped because its key was written outside of the journal", func() {
+		_, err := mem.Set("/x", "0", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		outsideKVS := NewEtcd(context.Background(), &outsideWriteKeysAPI{
+			memoryKeysAPI: &memoryKeysAPI{mem: mem},
+			write: func() {
+				_, err := mem.Set("/x", "outside", nil)
+				Ω(err).ToNot(HaveOccurred())
+			},
+		})
+
+		err = outsideKVS.Txn([]TxnOp{
+			{Action: TxnSet, Key: "/x", Value: "1"},
+			{Action: TxnSet, Key: "/y", Value: "2"},
+		})
+		Ω(err).To(BeAssignableToTypeOf(&TxnError{}))
+		Ω(err.(*TxnError).Op).To(Equal(0))
+		Ω(etcdErrorCode(err)).To(Equal(client.ErrorCodeTestFailed))
+
+		node, err := mem.Get("/x", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("outside"))
+
+		node, err = mem.Get("/y", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+
+		journal, err := mem.Get("/dolb/txn", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(journal.Value).To(BeEmpty())
+	})
+
+	It("keeps the journal below its root", func() {
+		rootKVS := NewEtcd(context.Background(), &memoryKeysAPI{mem: mem}, EtcdTxnRoot("/clusters/a"))
+		Ω(rootKVS.Txn([]TxnOp{{Action: TxnSet, Key: "/clusters/a/x", Value: "1"}})).To(Succeed())
+
+		journal, err := mem.Get("/clusters/a/dolb/txn", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(journal.Value).To(BeEmpty())
+
+		_, err = mem.Get("/dolb/txn", nil)
+		Ω(etcdErrorCode(err)).To(Equal(client.ErrorCodeKeyNotFound))
+	})
 })
+
+// outsideWriteKeysAPI calls write once, after a transaction journal was
+// written and before it is applied.
+type outsideWriteKeysAPI struct {
+	*memoryKeysAPI
+	write func()
+}
+
+func (o *outsideWriteKeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	resp, err := o.memoryKeysAPI.Set(ctx, key, value, opts)
+	if key == "/dolb/txn" && value != "" && o.write != nil {
+		write := o.write
+		o.write = nil
+		write()
+	}
+
+	return resp, err
+}
+
+// staleJournalKeysAPI returns a stale transaction journal the first time it
+// is read.
+type staleJournalKeysAPI struct {
+	*memoryKeysAPI
+	stale *client.Node
+}
+
+func (s *staleJournalKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	if key == "/dolb/txn" && s.stale != nil {
+		stale := s.stale
+		s.stale = nil
+		return &client.Response{Node: stale, Index: stale.ModifiedIndex}, nil
+	}
+
+	return s.memoryKeysAPI.Get(ctx, key, opts)
+}
@@ -0,0 +1,467 @@
+package kvs
+
+import (
+	"errors"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+// EtcdV3 is a kvs based on the etcd v3 API. The v3 keyspace is flat, so
+// directories are modeled as key prefixes, TTLs as leases and indexes as
+// revisions. An explicitly created directory is stored as a marker key
+// ending in a slash.
+type EtcdV3 struct {
+	ctx    context.Context
+	client *clientv3.Client
+}
+
+var _ KVS = &EtcdV3{}
+
+// NewEtcdV3 builds an EtcdV3 instance.
+func NewEtcdV3(ctx context.Context, client *clientv3.Client) *EtcdV3 {
+	return &EtcdV3{
+		ctx:    ctx,
+		client: client,
+	}
+}
+
+// Mkdir makes a directory in the kvs.
+func (e *EtcdV3) Mkdir(dir string) error {
+	dir = normalizeKey(dir)
+	if dir == "/" {
+		return &MkdirError{Dir: dir, Err: newEtcdError(etcdclient.ErrorCodeRootROnly, dir, 0)}
+	}
+
+	marker := childPrefix(dir)
+
+	// like etcd v2, an existing file is replaced by the directory.
+	resp, err := e.client.Txn(e.ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(marker), "=", 0).WithPrefix()).
+		Then(clientv3.OpPut(marker, ""), clientv3.OpDelete(dir)).
+		Commit()
+	if err != nil {
+		return &MkdirError{Dir: dir, Err: err}
+	}
+
+	if !resp.Succeeded {
+		return &MkdirError{Dir: dir, Err: newEtcdError(etcdclient.ErrorCodeNotFile, dir, uint64(resp.Header.Revision))}
+	}
+
+	return nil
+}
+
+// Set creates or updates a key in the kvs.
+func (e *EtcdV3) Set(key, value string, options *SetOptions) (*Node, error) {
+	if options == nil {
+		options = &SetOptions{}
+	}
+
+	key = normalizeKey(key)
+
+	cmps := []clientv3.Cmp{
+		// a key can't replace a directory.
+		clientv3.Compare(clientv3.CreateRevision(childPrefix(key)), "=", 0).WithPrefix(),
+	}
+
+	// or be created below a file.
+	for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(dir), "=", 0))
+	}
+
+	if options.IfNotExist {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	}
+
+	if options.PrevIndex > 0 {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", int64(options.PrevIndex)))
+	}
+
+	putOpts := []clientv3.OpOption{clientv3.WithPrevKV()}
+
+	var expiration *time.Time
+	if options.TTL > 0 {
+		ttl := int64(math.Ceil(options.TTL.Seconds()))
+		lease, err := e.client.Grant(e.ctx, ttl)
+		if err != nil {
+			return nil, &KVError{Key: key, Err: err}
+		}
+
+		putOpts = append(putOpts, clientv3.WithLease(lease.ID))
+
+		exp := time.Now().Add(options.TTL)
+		expiration = &exp
+	}
+
+	resp, err := e.client.Txn(e.ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(key, value, putOpts...)).
+		Else(
+			clientv3.OpGet(key),
+			clientv3.OpGet(childPrefix(key), clientv3.WithPrefix(), clientv3.WithCountOnly()),
+		).
+		Commit()
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	rev := uint64(resp.Header.Revision)
+
+	if !resp.Succeeded {
+		existing := resp.Responses[0].GetResponseRange().Kvs
+		isDir := resp.Responses[1].GetResponseRange().Count > 0
+
+		var code int
+		switch {
+		case isDir:
+			code = etcdclient.ErrorCodeNotFile
+		case options.IfNotExist && len(existing) > 0:
+			return nil, &NodeExistError{Key: key}
+		case options.PrevIndex > 0 && len(existing) == 0:
+			code = etcdclient.ErrorCodeKeyNotFound
+		case options.PrevIndex > 0 && uint64(existing[0].ModRevision) != options.PrevIndex:
+			code = etcdclient.ErrorCodeTestFailed
+		default:
+			code = etcdclient.ErrorCodeNotDir
+		}
+
+		return nil, &KVError{Key: key, Err: newEtcdError(code, key, rev)}
+	}
+
+	n := &Node{
+		CreatedIndex:  rev,
+		Expiration:    expiration,
+		Key:           key,
+		ModifiedIndex: rev,
+		Nodes:         Nodes{},
+		Value:         value,
+	}
+
+	if prev := resp.Responses[0].GetResponsePut().PrevKv; prev != nil {
+		n.CreatedIndex = uint64(prev.CreateRevision)
+	}
+
+	return n, nil
+}
+
+// Get retrieves a key from the kvs. The expiration of keys with a TTL is
+// not reported.
+func (e *EtcdV3) Get(key string, options *GetOptions) (*Node, error) {
+	if options == nil {
+		options = &GetOptions{}
+	}
+
+	key = normalizeKey(key)
+
+	resp, err := e.client.Txn(e.ctx).
+		Then(
+			clientv3.OpGet(key),
+			clientv3.OpGet(childPrefix(key), clientv3.WithPrefix()),
+		).
+		Commit()
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 && key != "/" {
+		return convertKeyValue(kvs[0]), nil
+	}
+
+	kvs := resp.Responses[1].GetResponseRange().Kvs
+	if len(kvs) == 0 {
+		return nil, &KVError{
+			Key: key,
+			Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, uint64(resp.Header.Revision)),
+		}
+	}
+
+	flat := Nodes{}
+	for _, kv := range kvs {
+		flat = append(flat, convertKeyValue(kv))
+	}
+
+	n := buildDir(key, flat)
+	if !options.Recursive {
+		for _, child := range n.Nodes {
+			child.Nodes = Nodes{}
+		}
+	}
+
+	return n, nil
+}
+
+// Rmdir removes a directory from the kvs.
+func (e *EtcdV3) Rmdir(dir string) error {
+	dir = normalizeKey(dir)
+	if dir == "/" {
+		return &KVDeleteError{Key: dir, Err: newEtcdError(etcdclient.ErrorCodeRootROnly, dir, 0)}
+	}
+
+	resp, err := e.client.Txn(e.ctx).
+		Then(
+			clientv3.OpDelete(dir),
+			clientv3.OpDelete(childPrefix(dir), clientv3.WithPrefix()),
+		).
+		Commit()
+	if err != nil {
+		return &KVDeleteError{Key: dir, Err: err}
+	}
+
+	deleted := resp.Responses[0].GetResponseDeleteRange().Deleted +
+		resp.Responses[1].GetResponseDeleteRange().Deleted
+	if deleted == 0 {
+		return &KVDeleteError{
+			Key: dir,
+			Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, dir, uint64(resp.Header.Revision)),
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a key from the kvs.
+func (e *EtcdV3) Delete(key string) error {
+	key = normalizeKey(key)
+
+	resp, err := e.client.Txn(e.ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(childPrefix(key)), "=", 0).WithPrefix()).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return &KVDeleteError{Key: key, Err: err}
+	}
+
+	rev := uint64(resp.Header.Revision)
+
+	if !resp.Succeeded {
+		return &KVDeleteError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeNotFile, key, rev)}
+	}
+
+	if resp.Responses[0].GetResponseDeleteRange().Deleted == 0 {
+		return &KVDeleteError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, rev)}
+	}
+
+	return nil
+}
+
+// Txn applies ops atomically in a single etcd transaction.
+func (e *EtcdV3) Txn(ops []TxnOp) error {
+	if err := validateTxn(ops); err != nil {
+		return err
+	}
+
+	guards := make([][]etcdV3Guard, len(ops))
+	cmps := []clientv3.Cmp{}
+	writes := []clientv3.Op{}
+
+	for i, op := range ops {
+		key := normalizeKey(op.Key)
+		guards[i] = txnGuards(key, op)
+		for _, g := range guards[i] {
+			cmps = append(cmps, g.cmp)
+		}
+
+		switch op.Action {
+		case TxnSet:
+			writes = append(writes, clientv3.OpPut(key, op.Value))
+		case TxnDelete:
+			writes = append(writes, clientv3.OpDelete(key))
+		case TxnRmdir:
+			writes = append(writes,
+				clientv3.OpDelete(key),
+				clientv3.OpDelete(childPrefix(key), clientv3.WithPrefix()),
+			)
+		}
+	}
+
+	for {
+		resp, err := e.client.Txn(e.ctx).If(cmps...).Then(writes...).Commit()
+		if err != nil {
+			return &TxnError{Op: -1, Err: err}
+		}
+
+		if resp.Succeeded {
+			return nil
+		}
+
+		// find the guard that failed. if they all pass now, the keys
+		// changed in between, so try again.
+		for i, gs := range guards {
+			for _, g := range gs {
+				check, err := e.client.Txn(e.ctx).If(g.cmp).Commit()
+				if err != nil {
+					return &TxnError{Op: i, Key: normalizeKey(ops[i].Key), Err: err}
+				}
+
+				if !check.Succeeded {
+					key := normalizeKey(ops[i].Key)
+					return &TxnError{Op: i, Key: key, Err: newEtcdError(g.code, key, uint64(check.Header.Revision))}
+				}
+			}
+		}
+	}
+}
+
+// etcdV3Guard is a comparison in a transaction and the error code reported
+// when it fails.
+type etcdV3Guard struct {
+	cmp  clientv3.Cmp
+	code int
+}
+
+// txnGuards builds the comparisons which must hold for op to be applied.
+func txnGuards(key string, op TxnOp) []etcdV3Guard {
+	guards := []etcdV3Guard{}
+
+	exists := clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+	if op.Action == TxnRmdir {
+		exists = clientv3.Compare(clientv3.CreateRevision(childPrefix(key)), ">", 0).WithPrefix()
+	}
+
+	switch {
+	case op.IfNotExist:
+		guards = append(guards, etcdV3Guard{
+			cmp:  clientv3.Compare(clientv3.CreateRevision(key), "=", 0),
+			code: etcdclient.ErrorCodeNodeExist,
+		})
+	case op.Action != TxnSet || op.PrevIndex > 0:
+		guards = append(guards, etcdV3Guard{cmp: exists, code: etcdclient.ErrorCodeKeyNotFound})
+	}
+
+	if op.PrevIndex > 0 {
+		guards = append(guards, etcdV3Guard{
+			cmp:  clientv3.Compare(clientv3.ModRevision(key), "=", int64(op.PrevIndex)),
+			code: etcdclient.ErrorCodeTestFailed,
+		})
+	}
+
+	if op.Action == TxnSet || op.Action == TxnDelete {
+		// files can't replace or be mistaken for directories.
+		guards = append(guards, etcdV3Guard{
+			cmp:  clientv3.Compare(clientv3.CreateRevision(childPrefix(key)), "=", 0).WithPrefix(),
+			code: etcdclient.ErrorCodeNotFile,
+		})
+	}
+
+	if op.Action == TxnSet {
+		for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+			guards = append(guards, etcdV3Guard{
+				cmp:  clientv3.Compare(clientv3.CreateRevision(dir), "=", 0),
+				code: etcdclient.ErrorCodeNotDir,
+			})
+		}
+	}
+
+	return guards
+}
+
+// Watch watches a key for changes. If recursive is true, changes to keys
+// below key are reported as well. Expired keys are reported as deletes. The
+// returned channel is closed when the kvs context is done.
+func (e *EtcdV3) Watch(key string, recursive bool) (<-chan Event, error) {
+	key = normalizeKey(key)
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+
+		for {
+			wch := e.client.Watch(e.ctx, key, clientv3.WithPrefix(), clientv3.WithPrevKV())
+			for wresp := range wch {
+				if wresp.Err() != nil {
+					// the watch was canceled or compacted. start over.
+					break
+				}
+
+				for _, ev := range wresp.Events {
+					k := string(ev.Kv.Key)
+					if k != key && k != childPrefix(key) && !(recursive && isBelowKey(k, key)) {
+						continue
+					}
+
+					select {
+					case out <- convertEvent(ev):
+					case <-e.ctx.Done():
+						return
+					}
+				}
+			}
+
+			if e.ctx.Err() != nil {
+				return
+			}
+
+			time.Sleep(watchRetryTimeout)
+		}
+	}()
+
+	return out, nil
+}
+
+// NewEtcdV3Client creates an etcd v3 client.
+func NewEtcdV3Client(etcdEndpoints string, tc *TLSConfig) (*clientv3.Client, error) {
+	if etcdEndpoints == "" {
+		return nil, errors.New("missing ETCDENDPOINTS environment variable")
+	}
+
+	config := clientv3.Config{
+		Endpoints:   strings.Split(etcdEndpoints, ","),
+		DialTimeout: 5 * time.Second,
+	}
+
+	if tc != nil {
+		tlsConfig, err := tc.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+	}
+
+	return clientv3.New(config)
+}
+
+// convertKeyValue converts an etcd v3 key value to a Node. Directory markers
+// are converted to directories.
+func convertKeyValue(kv *mvccpb.KeyValue) *Node {
+	n := &Node{
+		CreatedIndex:  uint64(kv.CreateRevision),
+		Key:           string(kv.Key),
+		ModifiedIndex: uint64(kv.ModRevision),
+		Nodes:         Nodes{},
+		Value:         string(kv.Value),
+	}
+
+	if strings.HasSuffix(n.Key, "/") && n.Key != "/" {
+		n.Key = strings.TrimSuffix(n.Key, "/")
+		n.Dir = true
+	}
+
+	return n
+}
+
+func convertEvent(ev *clientv3.Event) Event {
+	e := Event{
+		Action: "set",
+		Node:   convertKeyValue(ev.Kv),
+	}
+
+	if ev.Type == mvccpb.DELETE {
+		e.Action = "delete"
+	}
+
+	if ev.PrevKv != nil {
+		e.PrevNode = convertKeyValue(ev.PrevKv)
+		if ev.Type == mvccpb.DELETE {
+			e.Node.CreatedIndex = e.PrevNode.CreatedIndex
+		}
+	}
+
+	return e
+}
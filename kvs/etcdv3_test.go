@@ -0,0 +1,235 @@
+package kvs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func freeURL() url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ω(err).ToNot(HaveOccurred())
+	defer l.Close()
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
+	Ω(err).ToNot(HaveOccurred())
+
+	return *u
+}
+
+// startEmbeddedEtcd starts a single member etcd server in a temporary
+// directory.
+func startEmbeddedEtcd() (*embed.Etcd, string) {
+	dir, err := ioutil.TempDir("", "dolb-etcd")
+	Ω(err).ToNot(HaveOccurred())
+
+	clientURL := freeURL()
+	peerURL := freeURL()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LCUrls = []url.URL{clientURL}
+	cfg.ACUrls = []url.URL{clientURL}
+	cfg.LPUrls = []url.URL{peerURL}
+	cfg.APUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	Ω(err).ToNot(HaveOccurred())
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		Fail("embedded etcd took too long to start")
+	}
+
+	return e, clientURL.String()
+}
+
+var _ = Describe("EtcdV3", func() {
+
+	var (
+		server  *embed.Etcd
+		client  *clientv3.Client
+		etcdKVS *EtcdV3
+		node    *Node
+		err     error
+		cancel  context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var endpoint string
+		server, endpoint = startEmbeddedEtcd()
+
+		client, err = NewEtcdV3Client(endpoint, nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		etcdKVS = NewEtcdV3(ctx, client)
+	})
+
+	AfterEach(func() {
+		cancel()
+		client.Close()
+		server.Close()
+		os.RemoveAll(server.Config().Dir)
+	})
+
+	It("creates and updates keys", func() {
+		node, err = etcdKVS.Set("/foo/bar", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Key).To(Equal("/foo/bar"))
+		created := node.CreatedIndex
+
+		node, err = etcdKVS.Set("/foo/bar", "qux", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.CreatedIndex).To(Equal(created))
+		Ω(node.ModifiedIndex).To(BeNumerically(">", created))
+
+		node, err = etcdKVS.Get("/foo/bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("qux"))
+	})
+
+	It("supports IfNotExist", func() {
+		_, err = etcdKVS.Set("/foo", "bar", &SetOptions{IfNotExist: true})
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = etcdKVS.Set("/foo", "bar", &SetOptions{IfNotExist: true})
+		Ω(err).To(BeAssignableToTypeOf(&NodeExistError{}))
+	})
+
+	It("compares and swaps with PrevIndex", func() {
+		node, err = etcdKVS.Set("/foo", "bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = etcdKVS.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex + 100})
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeTestFailed))
+
+		_, err = etcdKVS.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex})
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = etcdKVS.Set("/missing", "baz", &SetOptions{PrevIndex: node.ModifiedIndex})
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("expires keys with a TTL", func() {
+		node, err = etcdKVS.Set("/foo", "bar", &SetOptions{TTL: time.Second})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Expiration).ToNot(BeNil())
+
+		Eventually(func() int {
+			_, err := etcdKVS.Get("/foo", nil)
+			return etcdErrorCode(err)
+		}, 5*time.Second, 100*time.Millisecond).Should(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("models directories as prefixes", func() {
+		Ω(etcdKVS.Mkdir("/a")).To(Succeed())
+		_, err = etcdKVS.Set("/a/b", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = etcdKVS.Set("/a/c/d", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		node, err = etcdKVS.Get("/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Dir).To(BeTrue())
+		Ω(node.Nodes).To(HaveLen(2))
+		Ω(node.Nodes[0].Key).To(Equal("/a/b"))
+		Ω(node.Nodes[1].Key).To(Equal("/a/c"))
+		Ω(node.Nodes[1].Dir).To(BeTrue())
+		Ω(node.Nodes[1].Nodes).To(BeEmpty())
+
+		node, err = etcdKVS.Get("/a", &GetOptions{Recursive: true})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Nodes[1].Nodes).To(HaveLen(1))
+		Ω(node.Nodes[1].Nodes[0].Value).To(Equal("2"))
+
+		err = etcdKVS.Mkdir("/a")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		_, err = etcdKVS.Set("/a", "1", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		_, err = etcdKVS.Set("/a/b/c", "1", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotDir))
+
+		err = etcdKVS.Delete("/a")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+
+		Ω(etcdKVS.Rmdir("/a")).To(Succeed())
+
+		_, err = etcdKVS.Get("/a/c/d", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("deletes keys", func() {
+		_, err = etcdKVS.Set("/foo", "bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(etcdKVS.Delete("/foo")).To(Succeed())
+
+		err = etcdKVS.Delete("/foo")
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("watches keys", func() {
+		events, err := etcdKVS.Watch("/foo", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		// give the watch time to be established.
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = etcdKVS.Set("/foobar", "ignored", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = etcdKVS.Set("/foo/bar", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(etcdKVS.Delete("/foo/bar")).To(Succeed())
+
+		var e Event
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Action).To(Equal("set"))
+		Ω(e.Node.Key).To(Equal("/foo/bar"))
+		Ω(e.Node.Value).To(Equal("baz"))
+
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Action).To(Equal("delete"))
+		Ω(e.PrevNode.Value).To(Equal("baz"))
+
+		cancel()
+		Eventually(events).Should(BeClosed())
+	})
+
+	txnSpecs(func() KVS { return etcdKVS })
+
+	It("elects a cluster leader", func() {
+		cluster := NewCluster(etcdKVS, 5*time.Second)
+
+		_, err = cluster.RegisterAgent("agent-1")
+		Ω(err).ToNot(HaveOccurred())
+		index, err := cluster.RegisterAgent("agent-2")
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = cluster.Refresh("agent-2", index)
+		Ω(err).ToNot(HaveOccurred())
+
+		leader, err := cluster.Leader()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(leader.Name).To(Equal("agent-1"))
+		Ω(leader.NodeCount).To(Equal(2))
+	})
+})
@@ -6,11 +6,18 @@ import (
 	"strings"
 )
 
+const (
+	// firewallPortsKey is the directory containing firewall ports. Use a
+	// Namespace to move it under another root.
+	firewallPortsKey = "/firewall/ports"
+)
+
 type Firewall interface {
 	Init() error
 	Ports() ([]FirewallPort, error)
 	EnablePort(port int) error
 	DisablePort(port int) error
+	MovePort(from, to int) error
 }
 
 type LiveFirewall struct {
@@ -31,12 +38,12 @@ type FirewallPort struct {
 }
 
 func (f *LiveFirewall) Init() error {
-	err := f.Mkdir("/firewall/ports")
+	err := f.Mkdir(firewallPortsKey)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Set("/firewall/ports/8889", "enabled", nil)
+	_, err = f.Set(firewallPortsKey+"/8889", "enabled", nil)
 	return err
 }
 
@@ -47,13 +54,13 @@ func (f *LiveFirewall) Ports() ([]FirewallPort, error) {
 		Recursive: true,
 	}
 
-	node, err := f.Get("/firewall/ports", opts)
+	node, err := f.Get(firewallPortsKey, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, n := range node.Nodes {
-		port := strings.TrimPrefix(n.Key, "/firewall/ports/")
+		port := strings.TrimPrefix(n.Key, firewallPortsKey+"/")
 
 		i, err := strconv.Atoi(port)
 		if err != nil {
@@ -70,13 +77,37 @@ func (f *LiveFirewall) Ports() ([]FirewallPort, error) {
 }
 
 func (f *LiveFirewall) EnablePort(port int) error {
-	key := fmt.Sprintf("/firewall/ports/%d", port)
+	key := fmt.Sprintf("%s/%d", firewallPortsKey, port)
 	_, err := f.Set(key, "enabled", nil)
 	return err
 }
 
 func (f *LiveFirewall) DisablePort(port int) error {
-	key := fmt.Sprintf("/firewall/ports/%d", port)
+	key := fmt.Sprintf("%s/%d", firewallPortsKey, port)
 	_, err := f.Set(key, "disabled", nil)
 	return err
 }
+
+// MovePort disables port from and enables port to in a single transaction.
+func (f *LiveFirewall) MovePort(from, to int) error {
+	return f.Txn([]TxnOp{
+		{Action: TxnSet, Key: fmt.Sprintf("%s/%d", firewallPortsKey, from), Value: "disabled"},
+		{Action: TxnSet, Key: fmt.Sprintf("%s/%d", firewallPortsKey, to), Value: "enabled"},
+	})
+}
+
+// EnablePortOp builds the operation which enables a firewall port, so it
+// can be opened in the same transaction as the service listening on it.
+func EnablePortOp(port int) TxnOp {
+	return TxnOp{Action: TxnSet, Key: fmt.Sprintf("%s/%d", firewallPortsKey, port), Value: "enabled"}
+}
+
+// DisablePortOp builds the operation which disables a firewall port.
+func DisablePortOp(port int) TxnOp {
+	return TxnOp{Action: TxnSet, Key: fmt.Sprintf("%s/%d", firewallPortsKey, port), Value: "disabled"}
+}
+
+// WatchPorts watches the firewall ports for changes.
+func (f *LiveFirewall) WatchPorts() (<-chan Event, error) {
+	return f.Watch(firewallPortsKey, true)
+}
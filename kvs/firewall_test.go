@@ -0,0 +1,42 @@
+package kvs_test
+
+import (
+	"golang.org/x/net/context"
+
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Firewall", func() {
+
+	var (
+		cancel   context.CancelFunc
+		firewall *LiveFirewall
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		firewall = NewLiveFirewall(NewMemory(ctx))
+		Ω(firewall.Init()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("moves ports", func() {
+		Ω(firewall.EnablePort(80)).To(Succeed())
+		Ω(firewall.MovePort(80, 8080)).To(Succeed())
+
+		ports, err := firewall.Ports()
+		Ω(err).ToNot(HaveOccurred())
+		Ω(ports).To(ConsistOf(
+			FirewallPort{Port: 80, Enabled: false},
+			FirewallPort{Port: 8080, Enabled: true},
+			FirewallPort{Port: 8889, Enabled: true},
+		))
+	})
+})
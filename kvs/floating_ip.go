@@ -1,13 +1,36 @@
 package kvs
 
+const (
+	// floatingIPKey is the key containing the cluster's floating ip.
+	floatingIPKey = "/agent/floating_ip"
+)
+
 // FipKVS is a floating ip management kvs.
 type FipKVS struct {
 	KVS
+	Key string
 }
 
 // NewFipKVS builds a FipKVS instance.
 func NewFipKVS(backend KVS) *FipKVS {
 	return &FipKVS{
 		KVS: backend,
+		Key: floatingIPKey,
+	}
+}
+
+// FloatingIP returns the cluster's floating ip.
+func (f *FipKVS) FloatingIP() (string, error) {
+	node, err := f.Get(f.Key, nil)
+	if err != nil {
+		return "", err
 	}
+
+	return node.Value, nil
+}
+
+// SetFloatingIP sets the cluster's floating ip.
+func (f *FipKVS) SetFloatingIP(ip string) error {
+	_, err := f.Set(f.Key, ip, nil)
+	return err
 }
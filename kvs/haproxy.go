@@ -2,16 +2,19 @@ package kvs
 
 import (
 	"fmt"
-	"net"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
+	etcdclient "github.com/coreos/etcd/client"
 )
 
 const (
 	svcDomain = "domain"
 	svcURLReg = "url_reg"
+	svcRules  = "rules"
 )
 
 type Service interface {
@@ -20,14 +23,25 @@ type Service interface {
 	Type() string
 	Upstreams() []Upstream
 	ServiceConfig() ServiceConfig
+	Certificates() []Certificate
+	HealthCheck() *HealthCheck
+	Balance() Balance
+	Limits() *Limits
+	HTTPRules() []HTTPRule
 }
 
 type ServiceConfig map[string]interface{}
 
+// Upstream is a node traffic is balanced across. Backup upstreams only
+// receive traffic when all the other upstreams are down. State is the admin
+// state of the upstream.
 type Upstream struct {
-	ID   string
-	Host string
-	Port int
+	ID     string
+	Host   string
+	Port   int
+	Weight int
+	Backup bool
+	State  string
 }
 
 type HTTPService struct {
@@ -35,6 +49,11 @@ type HTTPService struct {
 	port          int
 	serviceConfig ServiceConfig
 	upstreams     []Upstream
+	certificates  []Certificate
+	healthCheck   *HealthCheck
+	balance       Balance
+	limits        *Limits
+	httpRules     []HTTPRule
 }
 
 var _ Service = &HTTPService{}
@@ -44,6 +63,9 @@ func NewHTTPService(n string) *HTTPService {
 		n:             n,
 		serviceConfig: ServiceConfig{},
 		upstreams:     []Upstream{},
+		certificates:  []Certificate{},
+		balance:       Balance{Algorithm: BalanceRoundRobin},
+		httpRules:     []HTTPRule{},
 	}
 }
 
@@ -71,17 +93,165 @@ func (hs *HTTPService) ServiceConfig() ServiceConfig {
 	return hs.serviceConfig
 }
 
+// Certificates returns the certificates the service terminates HTTPS with.
+// If there are none, the service only serves plain HTTP.
+func (hs *HTTPService) Certificates() []Certificate {
+	return hs.certificates
+}
+
+func (hs *HTTPService) AddCertificate(c Certificate) {
+	hs.certificates = append(hs.certificates, c)
+}
+
+// HealthCheck returns how the upstreams are checked. It is nil if they
+// aren't checked.
+func (hs *HTTPService) HealthCheck() *HealthCheck {
+	return hs.healthCheck
+}
+
+func (hs *HTTPService) SetHealthCheck(hc *HealthCheck) {
+	hs.healthCheck = hc
+}
+
+// Balance returns how traffic is spread across the upstreams.
+func (hs *HTTPService) Balance() Balance {
+	return hs.balance
+}
+
+func (hs *HTTPService) SetBalance(b Balance) {
+	hs.balance = b
+}
+
+// Limits returns how clients and upstreams are limited. It is nil if they
+// aren't limited.
+func (hs *HTTPService) Limits() *Limits {
+	return hs.limits
+}
+
+func (hs *HTTPService) SetLimits(l *Limits) {
+	hs.limits = l
+}
+
+// HTTPRules returns the ordered list of rules which rewrite the requests and
+// responses of the service.
+func (hs *HTTPService) HTTPRules() []HTTPRule {
+	return hs.httpRules
+}
+
+func (hs *HTTPService) AddHTTPRule(r HTTPRule) {
+	hs.httpRules = append(hs.httpRules, r)
+}
+
+// TCPService is a service which balances TCP connections. It is matched by
+// port only.
+type TCPService struct {
+	n           string
+	port        int
+	upstreams   []Upstream
+	healthCheck *HealthCheck
+	balance     Balance
+	limits      *Limits
+}
+
+var _ Service = &TCPService{}
+
+func NewTCPService(n string) *TCPService {
+	return &TCPService{
+		n:         n,
+		upstreams: []Upstream{},
+		balance:   Balance{Algorithm: BalanceRoundRobin},
+	}
+}
+
+func (ts *TCPService) Name() string {
+	return ts.n
+}
+
+func (ts *TCPService) Port() int {
+	return ts.port
+}
+
+func (ts *TCPService) Type() string {
+	return "tcp"
+}
+
+func (ts *TCPService) Upstreams() []Upstream {
+	return ts.upstreams
+}
+
+func (ts *TCPService) AddUpstream(u Upstream) {
+	ts.upstreams = append(ts.upstreams, u)
+}
+
+func (ts *TCPService) ServiceConfig() ServiceConfig {
+	return ServiceConfig{}
+}
+
+// Certificates returns no certificates. TCP services pass TLS through to
+// their upstreams.
+func (ts *TCPService) Certificates() []Certificate {
+	return []Certificate{}
+}
+
+// HealthCheck returns how the upstreams are checked. It is nil if they
+// aren't checked.
+func (ts *TCPService) HealthCheck() *HealthCheck {
+	return ts.healthCheck
+}
+
+func (ts *TCPService) SetHealthCheck(hc *HealthCheck) {
+	ts.healthCheck = hc
+}
+
+// Balance returns how connections are spread across the upstreams.
+func (ts *TCPService) Balance() Balance {
+	return ts.balance
+}
+
+func (ts *TCPService) SetBalance(b Balance) {
+	ts.balance = b
+}
+
+// Limits returns how clients and upstreams are limited. It is nil if they
+// aren't limited.
+func (ts *TCPService) Limits() *Limits {
+	return ts.limits
+}
+
+func (ts *TCPService) SetLimits(l *Limits) {
+	ts.limits = l
+}
+
+// HTTPRules returns no rules. TCP services don't look at HTTP traffic.
+func (ts *TCPService) HTTPRules() []HTTPRule {
+	return []HTTPRule{}
+}
+
 type IDGenFN func() string
 
 type Haproxy interface {
+	AddCertificate(svcName, cert, key string) (string, error)
+	AddCertificateWith(svcName, cert, key string, ops []TxnOp) (string, error)
+	AddHTTPRule(svcName string, r HTTPRule) (string, error)
+	DeleteCertificate(svcName, id string) error
+	DeleteHTTPRule(svcName, id string) error
 	DeleteService(name string) error
 	DeleteUpstream(svcName, id string) error
 	Domain(svcName, domain string, port int) error
 	Init() error
+	Rules(svcName string, rules []Rule, port int) error
+	SaveService(spec ServiceSpec) error
 	Service(name string) (Service, error)
 	Services() ([]Service, error)
+	SetBalance(svcName string, b Balance) error
+	SetHealthCheck(svcName string, hc *HealthCheck) error
+	SetLimits(svcName string, l *Limits) error
+	TCP(svcName string, port int) error
 	URLReg(svcName, regex string, port int) error
-	Upstream(svcName, address string) error
+	Upstream(svcName string, u Upstream) (string, error)
+	UpdateHTTPRule(svcName, id string, r HTTPRule) error
+	UpdateUpstream(svcName, id string, uu UpstreamUpdate) error
+	SyncUpstreams(svcName string, upstreams []Upstream) (*UpstreamSync, error)
 }
 
 // HaproxyKVS is a haproxy management kvs.
@@ -104,83 +274,867 @@ func NewLiveHaproxy(backend KVS, idGen IDGenFN, log *logrus.Entry) *LiveHaproxy
 	}
 }
 
-// Init initializes a kvs for haproxy configuration management.
+// Init initializes a kvs for haproxy configuration management. Existing
+// keys are brought up to date with Migrate.
 func (h *LiveHaproxy) Init() error {
-	err := h.Mkdir(h.RootKey + "/services")
+	for _, dir := range []string{"/services", "/tcp-services"} {
+		err := h.Mkdir(h.RootKey + dir)
+		if err != nil && !isKVError(err, etcdclient.ErrorCodeNotFile, etcdclient.ErrorCodeNodeExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Domain creates an endpoint based on a domain name.
+func (h *LiveHaproxy) Domain(app, domain string, port int) error {
+	return h.SaveService(ServiceSpec{Name: app, Type: svcDomain, Matcher: domain, Port: port})
+}
+
+// URLReg creates an endpoint based on a regular expression.
+func (h *LiveHaproxy) URLReg(app, reg string, port int) error {
+	return h.SaveService(ServiceSpec{Name: app, Type: svcURLReg, Matcher: reg, Port: port})
+}
+
+// Rules creates an endpoint based on an ordered list of routing rules. A
+// request is routed to the service if it matches any of the rules.
+func (h *LiveHaproxy) Rules(app string, rules []Rule, port int) error {
+	return h.SaveService(ServiceSpec{Name: app, Type: svcRules, Rules: rules, Port: port})
+}
+
+// TCP creates or updates a TCP service. TCP services are only matched by
+// port. Like the ports of HTTP services, the port is reserved in the same
+// transaction.
+func (h *LiveHaproxy) TCP(app string, port int) error {
+	return h.SaveService(ServiceSpec{Name: app, Type: "tcp", Port: port})
+}
+
+// ServiceOptions are the optional settings of a service. Settings which are
+// nil are left as they are.
+type ServiceOptions struct {
+	HealthCheck *HealthCheck
+	Balance     *Balance
+	Limits      *Limits
+}
+
+// ServiceSpec describes a service saved by SaveService. Type is "domain",
+// "url_reg", "rules" or "tcp". Matcher is the domain or URL regex of an HTTP
+// service, and Rules are the routing rules of a rules service. Ops are
+// applied in the same transaction as the service, e.g. to open its firewall
+// port.
+type ServiceSpec struct {
+	Name    string
+	Type    string
+	Matcher string
+	Rules   []Rule
+	Port    int
+	Options ServiceOptions
+	Ops     []TxnOp
+}
+
+// SaveService creates or updates a service, its options and the operations
+// in spec.Ops in a single transaction.
+func (h *LiveHaproxy) SaveService(spec ServiceSpec) error {
+	var (
+		ops       []TxnOp
+		reserveOp int
+		err       error
+	)
+
+	serviceType := "http"
+	dir := h.serviceKey(spec.Name, "")
+
+	switch spec.Type {
+	case "tcp":
+		serviceType = "tcp"
+		dir = h.tcpServiceKey(spec.Name, "")
+		ops, reserveOp, err = h.tcpServiceOps(spec.Name, spec.Port)
+	case svcDomain, svcURLReg:
+		ops, reserveOp, err = h.httpServiceOps(spec.Type, spec.Name, spec.Matcher, spec.Port)
+	case svcRules:
+		var encoded string
+		encoded, err = validateRules(spec.Rules)
+		if err == nil {
+			ops, reserveOp, err = h.httpServiceOps(svcRules, spec.Name, encoded, spec.Port)
+		}
+	default:
+		return fmt.Errorf("unknown service type %q", spec.Type)
+	}
+
 	if err != nil {
 		return err
 	}
 
-	err = h.Mkdir(h.RootKey + "/tcp-services")
+	optionOps, err := h.serviceOptionOps(dir, serviceType, spec.Options)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	ops = append(ops, optionOps...)
+	ops = append(ops, spec.Ops...)
+
+	return h.applyServiceTxn(ops, spec.Name, spec.Port, reserveOp)
 }
 
-// Domain creates an endpoint based on a domain name.
-func (h *LiveHaproxy) Domain(app, domain string, port int) error {
-	return h.addService("domain", app, domain, port)
+// validateRules validates the routing rules of a rules service and encodes
+// them.
+func validateRules(rules []Rule) (string, error) {
+	if len(rules) == 0 {
+		return "", fmt.Errorf("supply at least one rule")
+	}
+
+	for i, r := range rules {
+		if err := r.Validate(); err != nil {
+			return "", fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+
+	return encodeRules(rules)
 }
 
-// URLReg creates an endpoint based on a regular expression.
-func (h *LiveHaproxy) URLReg(app, reg string, port int) error {
-	return h.addService("url_reg", app, reg, port)
+// serviceOptionOps builds the operations which store the options of the
+// service in the directory dir.
+func (h *LiveHaproxy) serviceOptionOps(dir, serviceType string, opts ServiceOptions) ([]TxnOp, error) {
+	ops := []TxnOp{}
+
+	if opts.HealthCheck != nil {
+		checkOps, err := h.healthCheckOps(dir, opts.HealthCheck)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, checkOps...)
+	}
+
+	if opts.Balance != nil {
+		balanceOps, err := balanceOps(dir, serviceType, *opts.Balance)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, balanceOps...)
+	}
+
+	if opts.Limits != nil {
+		limitsOps, err := h.limitsOps(dir, serviceType, opts.Limits)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, limitsOps...)
+	}
+
+	return ops, nil
+}
+
+// httpServiceOps builds the operations which create or update an HTTP
+// service. The service's port is reserved in the same transaction; the
+// index of the reserving operation is returned too. HTTP services dispatch
+// on their matchers, so they can share a port with each other but not with
+// a TCP service.
+func (h *LiveHaproxy) httpServiceOps(sType, app, opt string, port int) ([]TxnOp, int, error) {
+	ops := []TxnOp{
+		// HTTP and TCP services share names.
+		{Action: TxnCheck, Key: h.tcpServiceKey(app, "/port"), IfNotExist: true},
+	}
+
+	portKey := h.serviceKey(app, "/port")
+	current, err := h.Get(portKey, nil)
+	switch {
+	case err == nil:
+		// the service must not change while it is being updated.
+		ops = append(ops, TxnOp{Action: TxnCheck, Key: portKey, PrevIndex: current.ModifiedIndex})
+
+		currentPort, err := strconv.Atoi(current.Value)
+		if err == nil && currentPort != port {
+			releaseOps, err := h.releaseServicePort(app, currentPort)
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, releaseOps...)
+		}
+
+		matcherOps, err := h.removeMatcher(app, sType)
+		if err != nil {
+			return nil, 0, err
+		}
+		ops = append(ops, matcherOps...)
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		ops = append(ops, TxnOp{Action: TxnCheck, Key: portKey, IfNotExist: true})
+	default:
+		return nil, 0, err
+	}
+
+	reserveOp := len(ops)
+	reserve, err := h.reservePort(app, port, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ops = append(ops,
+		reserve,
+		TxnOp{Action: TxnSet, Key: h.serviceKey(app, "/%s", sType), Value: opt},
+		TxnOp{Action: TxnSet, Key: h.serviceKey(app, "/type"), Value: sType},
+		TxnOp{Action: TxnSet, Key: portKey, Value: strconv.Itoa(port)},
+	)
+
+	return ops, reserveOp, nil
+}
+
+// tcpServiceOps builds the operations which create or update a TCP service
+// and reserve its port, and returns the index of the reserving operation.
+func (h *LiveHaproxy) tcpServiceOps(app string, port int) ([]TxnOp, int, error) {
+	ops := []TxnOp{
+		// HTTP and TCP services share names.
+		{Action: TxnCheck, Key: h.serviceKey(app, "/port"), IfNotExist: true},
+	}
+
+	portKey := h.tcpServiceKey(app, "/port")
+	current, err := h.Get(portKey, nil)
+	switch {
+	case err == nil:
+		// the service must not change while it is being updated.
+		ops = append(ops, TxnOp{Action: TxnCheck, Key: portKey, PrevIndex: current.ModifiedIndex})
+
+		currentPort, err := strconv.Atoi(current.Value)
+		if err == nil && currentPort != port {
+			releaseOps, err := h.releasePort(app, currentPort)
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, releaseOps...)
+		}
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		ops = append(ops, TxnOp{Action: TxnCheck, Key: portKey, IfNotExist: true})
+	default:
+		return nil, 0, err
+	}
+
+	reserveOp := len(ops)
+	reserve, err := h.reservePort(app, port, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ops = append(ops,
+		reserve,
+		TxnOp{Action: TxnSet, Key: portKey, Value: strconv.Itoa(port)},
+	)
+
+	return ops, reserveOp, nil
 }
 
-func (h *LiveHaproxy) addService(sType, app, opt string, port int) error {
-	svcs, err := h.Services()
+// reservePort builds the operation which reserves port for app. If shared
+// is true, app is an HTTP service and can share the port with other HTTP
+// services.
+func (h *LiveHaproxy) reservePort(app string, port int, shared bool) (TxnOp, error) {
+	owner, err := h.Get(h.portKey(port), nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return TxnOp{Action: TxnSet, Key: h.portKey(port), Value: app, IfNotExist: true}, nil
+	case err != nil:
+		return TxnOp{}, err
+	}
+
+	owners := portOwners(owner.Value)
+	if containsString(owners, app) {
+		return TxnOp{Action: TxnCheck, Key: h.portKey(port), PrevIndex: owner.ModifiedIndex}, nil
+	}
+
+	if !shared {
+		return TxnOp{}, fmt.Errorf("port %d is already in use by %s", port, strings.Join(owners, ", "))
+	}
+
+	// ports are only shared by HTTP services, so if one owner is an HTTP
+	// service, they all are.
+	isTCP, err := h.isTCPService(owners[0])
+	if err != nil {
+		return TxnOp{}, err
+	}
+
+	if isTCP {
+		return TxnOp{}, fmt.Errorf("port %d is already in use by tcp service %s", port, owners[0])
+	}
+
+	return TxnOp{
+		Action:    TxnSet,
+		Key:       h.portKey(port),
+		Value:     strings.Join(append(owners, app), " "),
+		PrevIndex: owner.ModifiedIndex,
+	}, nil
+}
+
+// applyServiceTxn applies the transaction which creates or updates a
+// service. The first operation makes sure the service's name isn't used by
+// a service of another type.
+func (h *LiveHaproxy) applyServiceTxn(ops []TxnOp, app string, port, reserveOp int) error {
+	err := h.Txn(ops)
+	if terr, ok := err.(*TxnError); ok {
+		switch terr.Op {
+		case 0:
+			return fmt.Errorf("service %s already exists with another type", app)
+		case reserveOp:
+			return fmt.Errorf("port %d is already in use", port)
+		}
+	}
+
+	return err
+}
+
+// releasePort builds the operations which release a port reserved by app.
+// The port stays reserved for the other services sharing it.
+func (h *LiveHaproxy) releasePort(app string, port int) ([]TxnOp, error) {
+	owner, err := h.Get(h.portKey(port), nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	owners := portOwners(owner.Value)
+	if !containsString(owners, app) {
+		return nil, nil
+	}
+
+	remaining := []string{}
+	for _, o := range owners {
+		if o != app {
+			remaining = append(remaining, o)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return []TxnOp{{Action: TxnDelete, Key: owner.Key, PrevIndex: owner.ModifiedIndex}}, nil
+	}
+
+	return []TxnOp{{
+		Action:    TxnSet,
+		Key:       owner.Key,
+		Value:     strings.Join(remaining, " "),
+		PrevIndex: owner.ModifiedIndex,
+	}}, nil
+}
+
+// releaseServicePort builds the operations which release the port an HTTP
+// service is moving away from. HTTPSPort stays reserved while the service
+// has certificates.
+func (h *LiveHaproxy) releaseServicePort(app string, port int) ([]TxnOp, error) {
+	if port == HTTPSPort {
+		certificates, err := h.findCertificates(app)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(certificates) > 0 {
+			return nil, nil
+		}
+	}
+
+	return h.releasePort(app, port)
+}
+
+// portOwners returns the names of the services which reserved a port. The
+// value of a port key is a space separated list of names.
+func portOwners(value string) []string {
+	return strings.Fields(value)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeMatcher builds the operations which remove a service's matcher if
+// the service type is changing.
+func (h *LiveHaproxy) removeMatcher(app, sType string) ([]TxnOp, error) {
+	currentType, err := h.serviceType(app)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case currentType == sType:
+		return nil, nil
+	}
+
+	matcher, err := h.Get(h.serviceKey(app, "/%s", currentType), nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return []TxnOp{{Action: TxnDelete, Key: matcher.Key, PrevIndex: matcher.ModifiedIndex}}, nil
+}
+
+// DeleteService deletes a service and releases its ports in a single
+// transaction.
+func (h *LiveHaproxy) DeleteService(name string) error {
+	dir, err := h.serviceDir(name)
 	if err != nil {
 		return err
 	}
-	for _, svc := range svcs {
-		if svc.Port() == port {
-			return fmt.Errorf("port %d is already in used by %s", svc.Port(), svc.Name())
+
+	ops := []TxnOp{{Action: TxnRmdir, Key: dir}}
+
+	ports := []int{}
+	if port, err := h.readPort(dir + "/port"); err == nil && port != HTTPSPort {
+		ports = append(ports, port)
+	}
+	ports = append(ports, HTTPSPort)
+
+	for _, port := range ports {
+		releaseOps, err := h.releasePort(name, port)
+		if err != nil {
+			return err
 		}
+		ops = append(ops, releaseOps...)
+	}
+
+	return h.Txn(ops)
+}
+
+// Upstream adds an upstream to a service and returns its id. If the service
+// already has an upstream with the same address, that upstream's id is
+// returned and nothing is added. The address is claimed in the transaction
+// which adds the upstream, so concurrent adds of an address can't both
+// succeed.
+func (h *LiveHaproxy) Upstream(app string, u Upstream) (string, error) {
+	if err := u.Validate(); err != nil {
+		return "", err
 	}
 
-	key := h.serviceKey(app, "/%s", sType)
-	_, err = h.Set(key, opt, nil)
+	dir, err := h.serviceDir(app)
+	if err != nil {
+		return "", err
+	}
+
+	portKey := dir + "/port"
+	portNode, err := h.Get(portKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	upstreamsKey := dir + "/upstreams"
+	addressKey := upstreamAddressKey(dir, u.Address())
+	claim := TxnOp{Action: TxnSet, Key: addressKey, IfNotExist: true}
+
+	indexed, err := h.Get(addressKey, nil)
+	switch {
+	case err == nil:
+		_, err := h.Get(upstreamsKey+"/"+indexed.Value, nil)
+		if err == nil {
+			return indexed.Value, nil
+		}
+
+		if !isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			return "", err
+		}
+
+		// the upstream was removed without its address, e.g. by an agent
+		// from before addresses were claimed.
+		claim = TxnOp{Action: TxnSet, Key: addressKey, PrevIndex: indexed.ModifiedIndex}
+	case !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return "", err
+	}
+
+	// upstreams added before addresses were claimed are only found by
+	// their value.
+	node, err := h.Get(upstreamsKey, nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		node = &Node{}
+	case err != nil:
+		return "", err
+	}
+
+	for _, n := range node.Nodes {
+		existing, err := parseUpstream(strings.TrimPrefix(n.Key, upstreamsKey+"/"), n.Value)
+		if err != nil {
+			return "", err
+		}
+
+		if existing.Address() == u.Address() {
+			return existing.ID, nil
+		}
+	}
+
+	id := h.IDGen()
+	claim.Value = id
+	err = h.Txn([]TxnOp{
+		// the service must not be deleted while the upstream is added.
+		{Action: TxnCheck, Key: portKey, PrevIndex: portNode.ModifiedIndex},
+		claim,
+		{Action: TxnSet, Key: upstreamsKey + "/" + id, Value: encodeUpstream(u), IfNotExist: true},
+	})
+	if terr, ok := err.(*TxnError); ok && terr.Op == 1 {
+		// the address was claimed by a concurrent add.
+		indexed, err := h.Get(addressKey, nil)
+		if err != nil {
+			return "", err
+		}
+
+		return indexed.Value, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// upstreamAddressKey is the key which claims an upstream address for a
+// service. Its value is the id of the upstream with the address.
+func upstreamAddressKey(dir, address string) string {
+	return dir + "/upstream-addresses/" + url.QueryEscape(address)
+}
+
+// UpdateUpstream changes the attributes of an existing upstream. It fails if
+// the upstream was changed while it was being updated.
+func (h *LiveHaproxy) UpdateUpstream(app, id string, uu UpstreamUpdate) error {
+	dir, err := h.serviceDir(app)
 	if err != nil {
 		return err
 	}
 
-	key = h.serviceKey(app, "/type")
-	_, err = h.Set(key, sType, nil)
+	key := fmt.Sprintf("%s/upstreams/%s", dir, id)
+	node, err := h.Get(key, nil)
+	if err != nil {
+		return err
+	}
+
+	u, err := parseUpstream(id, node.Value)
 	if err != nil {
 		return err
 	}
 
-	i := strconv.Itoa(port)
-	key = h.serviceKey(app, "/port")
-	_, err = h.Set(key, i, nil)
+	uu.apply(&u)
+	if err := u.Validate(); err != nil {
+		return err
+	}
 
+	_, err = h.Set(key, encodeUpstream(u), &SetOptions{PrevIndex: node.ModifiedIndex})
 	return err
 }
 
-func (h *LiveHaproxy) DeleteService(name string) error {
-	key := h.serviceKey(name, "")
-	err := h.Rmdir(key)
-	return err
+// SyncUpstreams replaces the upstreams of a service with upstreams in a
+// single transaction. Upstreams are identified by address: existing
+// upstreams keep their ids, and duplicate addresses are dropped. It fails if
+// the service or its upstreams changed while they were being synced.
+func (h *LiveHaproxy) SyncUpstreams(app string, upstreams []Upstream) (*UpstreamSync, error) {
+	desired := []Upstream{}
+	wanted := map[string]bool{}
+	for _, u := range upstreams {
+		if err := u.Validate(); err != nil {
+			return nil, err
+		}
+
+		if wanted[u.Address()] {
+			continue
+		}
+
+		wanted[u.Address()] = true
+		desired = append(desired, u)
+	}
+
+	dir, err := h.serviceDir(app)
+	if err != nil {
+		return nil, err
+	}
+
+	portKey := dir + "/port"
+	portNode, err := h.Get(portKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []TxnOp{
+		// the service must not be deleted while its upstreams are synced.
+		{Action: TxnCheck, Key: portKey, PrevIndex: portNode.ModifiedIndex},
+	}
+
+	result := &UpstreamSync{
+		Added:   []Upstream{},
+		Updated: []Upstream{},
+		Removed: []Upstream{},
+	}
+
+	upstreamsKey := dir + "/upstreams"
+	current := map[string]*Node{}
+	node, err := h.Get(upstreamsKey, nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		node = &Node{}
+	case err != nil:
+		return nil, err
+	}
+
+	for _, n := range node.Nodes {
+		u, err := parseUpstream(strings.TrimPrefix(n.Key, upstreamsKey+"/"), n.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, dup := current[u.Address()]; dup || !wanted[u.Address()] {
+			ops = append(ops, TxnOp{Action: TxnDelete, Key: n.Key, PrevIndex: n.ModifiedIndex})
+			result.Removed = append(result.Removed, u)
+			continue
+		}
+
+		current[u.Address()] = n
+	}
+
+	// ids maps the address keys of the synced upstreams to their ids.
+	ids := map[string]string{}
+
+	for _, u := range desired {
+		n, ok := current[u.Address()]
+		if !ok {
+			u.ID = h.IDGen()
+			ids[upstreamAddressKey(dir, u.Address())] = u.ID
+			ops = append(ops, TxnOp{Action: TxnSet, Key: upstreamsKey + "/" + u.ID, Value: encodeUpstream(u), IfNotExist: true})
+			result.Added = append(result.Added, u)
+			continue
+		}
+
+		u.ID = strings.TrimPrefix(n.Key, upstreamsKey+"/")
+		ids[upstreamAddressKey(dir, u.Address())] = u.ID
+		if encodeUpstream(u) == n.Value {
+			ops = append(ops, TxnOp{Action: TxnCheck, Key: n.Key, PrevIndex: n.ModifiedIndex})
+			continue
+		}
+
+		ops = append(ops, TxnOp{Action: TxnSet, Key: n.Key, Value: encodeUpstream(u), PrevIndex: n.ModifiedIndex})
+		result.Updated = append(result.Updated, u)
+	}
+
+	addressOps, err := h.syncUpstreamAddresses(dir, ids)
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops, addressOps...)
+
+	if err := h.Txn(ops); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-// Upstream sets a new upstream node.
-func (h *LiveHaproxy) Upstream(app, address string) error {
-	key := h.serviceKey(app, "/upstreams/%s", h.IDGen())
-	_, err := h.Set(key, address, nil)
-	return err
+// syncUpstreamAddresses builds the operations which make the claimed
+// addresses of a service match ids, which maps address keys to upstream ids.
+func (h *LiveHaproxy) syncUpstreamAddresses(dir string, ids map[string]string) ([]TxnOp, error) {
+	claimed := map[string]*Node{}
+	node, err := h.Get(dir+"/upstream-addresses", nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		node = &Node{}
+	case err != nil:
+		return nil, err
+	}
+
+	ops := []TxnOp{}
+	for _, n := range node.Nodes {
+		claimed[n.Key] = n
+
+		if _, ok := ids[n.Key]; !ok {
+			ops = append(ops, TxnOp{Action: TxnDelete, Key: n.Key, PrevIndex: n.ModifiedIndex})
+		}
+	}
+
+	keys := []string{}
+	for key := range ids {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		n, ok := claimed[key]
+		switch {
+		case !ok:
+			ops = append(ops, TxnOp{Action: TxnSet, Key: key, Value: ids[key], IfNotExist: true})
+		case n.Value != ids[key]:
+			ops = append(ops, TxnOp{Action: TxnSet, Key: key, Value: ids[key], PrevIndex: n.ModifiedIndex})
+		}
+	}
+
+	return ops, nil
 }
 
+// DeleteUpstream removes an upstream and releases its address.
 func (h *LiveHaproxy) DeleteUpstream(app, id string) error {
-	key := h.serviceKey(app, "/upstreams/%s", id)
-	return h.Delete(key)
+	dir, err := h.serviceDir(app)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/upstreams/%s", dir, id)
+	node, err := h.Get(key, nil)
+	if err != nil {
+		return err
+	}
+
+	ops := []TxnOp{{Action: TxnDelete, Key: key, PrevIndex: node.ModifiedIndex}}
+
+	// upstreams which can't be parsed are removed without their address.
+	if u, err := parseUpstream(id, node.Value); err == nil {
+		addressKey := upstreamAddressKey(dir, u.Address())
+		indexed, err := h.Get(addressKey, nil)
+		switch {
+		case err == nil && indexed.Value == id:
+			ops = append(ops, TxnOp{Action: TxnDelete, Key: addressKey, PrevIndex: indexed.ModifiedIndex})
+		case err != nil && !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+			return err
+		}
+	}
+
+	return h.Txn(ops)
+}
+
+// AddCertificate adds a TLS certificate to an HTTP service. The certificate
+// must match the key. It returns the id of the certificate.
+func (h *LiveHaproxy) AddCertificate(app, cert, key string) (string, error) {
+	return h.AddCertificateWith(app, cert, key, nil)
+}
+
+// AddCertificateWith adds a certificate like AddCertificate and applies ops
+// in the same transaction, e.g. to fence the write with a lock's fencing
+// token.
+func (h *LiveHaproxy) AddCertificateWith(app, cert, key string, ops []TxnOp) (string, error) {
+	if _, err := ParseCertificate(cert, key); err != nil {
+		return "", err
+	}
+
+	isTCP, err := h.isTCPService(app)
+	if err != nil {
+		return "", err
+	}
+
+	if isTCP {
+		return "", fmt.Errorf("service %s is a tcp service; tcp services pass TLS through", app)
+	}
+
+	portKey := h.serviceKey(app, "/port")
+	portNode, err := h.Get(portKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// services with certificates are also served on HTTPSPort, which HTTP
+	// services share. The port is opened in the same transaction.
+	reserve, err := h.reservePort(app, HTTPSPort, true)
+	if err != nil {
+		return "", err
+	}
+
+	id := h.IDGen()
+	err = h.Txn(append([]TxnOp{
+		// the service must not be deleted while the certificate is added.
+		{Action: TxnCheck, Key: portKey, PrevIndex: portNode.ModifiedIndex},
+		reserve,
+		{Action: TxnSet, Key: h.serviceKey(app, "/certificates/%s/certificate", id), Value: cert},
+		{Action: TxnSet, Key: h.serviceKey(app, "/certificates/%s/key", id), Value: key},
+		EnablePortOp(HTTPSPort),
+	}, ops...))
+	if terr, ok := err.(*TxnError); ok && terr.Op == 1 {
+		return "", fmt.Errorf("port %d is already in use", HTTPSPort)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// CertificateKey returns the PEM encoded private key of a certificate.
+func (h *LiveHaproxy) CertificateKey(app, id string) (string, error) {
+	node, err := h.Get(h.serviceKey(app, "/certificates/%s/key", id), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return node.Value, nil
+}
+
+// DeleteCertificate removes a certificate and its key from a service. When
+// the last certificate is removed, HTTPSPort is released unless the service
+// listens on it, and closed if no other service uses it.
+func (h *LiveHaproxy) DeleteCertificate(app, id string) error {
+	ops := []TxnOp{{Action: TxnRmdir, Key: h.serviceKey(app, "/certificates/%s", id)}}
+
+	certificates, err := h.findCertificates(app)
+	if err != nil {
+		return err
+	}
+
+	if len(certificates) == 1 && certificates[0].ID == id {
+		port, err := h.servicePort(app)
+		if err != nil {
+			return err
+		}
+
+		if port != HTTPSPort {
+			releaseOps, err := h.releasePort(app, HTTPSPort)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, releaseOps...)
+
+			if len(releaseOps) == 1 && releaseOps[0].Action == TxnDelete {
+				ops = append(ops, DisablePortOp(HTTPSPort))
+			}
+		}
+	}
+
+	return h.Txn(ops)
+}
+
+// portKey is the key which reserves a port. Its value is the name of the
+// service using the port.
+func (h *LiveHaproxy) portKey(port int) string {
+	return fmt.Sprintf("%s/ports/%d", h.RootKey, port)
 }
 
 func (h *LiveHaproxy) serviceKey(service, format string, a ...interface{}) string {
 	return fmt.Sprintf("%s/services/%s"+format, append([]interface{}{h.RootKey, service}, a...)...)
 }
 
+func (h *LiveHaproxy) tcpServiceKey(service, format string, a ...interface{}) string {
+	return fmt.Sprintf("%s/tcp-services/%s"+format, append([]interface{}{h.RootKey, service}, a...)...)
+}
+
+// isTCPService returns true if name is a TCP service.
+func (h *LiveHaproxy) isTCPService(name string) (bool, error) {
+	_, err := h.Get(h.tcpServiceKey(name, "/port"), nil)
+	switch {
+	case err == nil:
+		return true, nil
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// serviceDir returns the directory containing a service's keys.
+func (h *LiveHaproxy) serviceDir(name string) (string, error) {
+	isTCP, err := h.isTCPService(name)
+	if err != nil {
+		return "", err
+	}
+
+	if isTCP {
+		return h.tcpServiceKey(name, ""), nil
+	}
+
+	return h.serviceKey(name, ""), nil
+}
+
 func (h *LiveHaproxy) Services() ([]Service, error) {
 
 	services := []Service{}
@@ -200,6 +1154,25 @@ func (h *LiveHaproxy) Services() ([]Service, error) {
 		services = append(services, s)
 	}
 
+	tcpNode, err := h.Get(h.RootKey+"/tcp-services", nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return services, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range tcpNode.Nodes {
+		name := strings.TrimPrefix(n.Key, h.RootKey+"/tcp-services/")
+		s, err := h.tcpService(name)
+		if err != nil {
+			return nil, err
+		}
+
+		services = append(services, s)
+	}
+
 	return services, nil
 }
 
@@ -207,6 +1180,16 @@ func (h *LiveHaproxy) Service(name string) (Service, error) {
 	h.log.WithFields(logrus.Fields{
 		"service-name": name,
 	}).Info("retrieving service")
+
+	isTCP, err := h.isTCPService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if isTCP {
+		return h.tcpService(name)
+	}
+
 	s := NewHTTPService(name)
 
 	port, err := h.servicePort(name)
@@ -215,7 +1198,7 @@ func (h *LiveHaproxy) Service(name string) (Service, error) {
 	}
 	s.port = port
 
-	upstreams, err := h.findUpstreams(name)
+	upstreams, err := h.findUpstreams(h.serviceKey(name, "/upstreams"))
 	if err != nil {
 		return nil, err
 	}
@@ -233,6 +1216,42 @@ func (h *LiveHaproxy) Service(name string) (Service, error) {
 		s.AddUpstream(u)
 	}
 
+	certificates, err := h.findCertificates(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range certificates {
+		s.AddCertificate(c)
+	}
+
+	hc, err := h.findHealthCheck(h.serviceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetHealthCheck(hc)
+
+	balance, err := h.findBalance(h.serviceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetBalance(balance)
+
+	limits, err := h.findLimits(h.serviceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetLimits(limits)
+
+	httpRules, err := h.findHTTPRules(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range httpRules {
+		s.AddHTTPRule(r)
+	}
+
 	h.log.WithFields(logrus.Fields{
 		"service": fmt.Sprintf("%#v", s),
 	}).Info("found service")
@@ -241,8 +1260,52 @@ func (h *LiveHaproxy) Service(name string) (Service, error) {
 
 }
 
+// tcpService retrieves a TCP service.
+func (h *LiveHaproxy) tcpService(name string) (Service, error) {
+	s := NewTCPService(name)
+
+	port, err := h.readPort(h.tcpServiceKey(name, "/port"))
+	if err != nil {
+		return nil, err
+	}
+	s.port = port
+
+	upstreams, err := h.findUpstreams(h.tcpServiceKey(name, "/upstreams"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range upstreams {
+		s.AddUpstream(u)
+	}
+
+	hc, err := h.findHealthCheck(h.tcpServiceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetHealthCheck(hc)
+
+	balance, err := h.findBalance(h.tcpServiceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetBalance(balance)
+
+	limits, err := h.findLimits(h.tcpServiceKey(name, ""))
+	if err != nil {
+		return nil, err
+	}
+	s.SetLimits(limits)
+
+	return s, nil
+}
+
 func (h *LiveHaproxy) servicePort(name string) (int, error) {
-	key := h.serviceKey(name, "/port")
+	return h.readPort(h.serviceKey(name, "/port"))
+}
+
+// readPort reads a port from key.
+func (h *LiveHaproxy) readPort(key string) (int, error) {
 	node, err := h.Get(key, nil)
 	if err != nil {
 		return 0, err
@@ -266,10 +1329,10 @@ func (h *LiveHaproxy) serviceType(name string) (string, error) {
 	return node.Value, nil
 }
 
-func (h *LiveHaproxy) findUpstreams(name string) ([]Upstream, error) {
+// findUpstreams reads the upstreams in the directory key.
+func (h *LiveHaproxy) findUpstreams(key string) ([]Upstream, error) {
 	upstreams := []Upstream{}
 
-	key := h.serviceKey(name, "/upstreams")
 	node, err := h.Get(key, nil)
 	if err != nil {
 		h.log.WithFields(logrus.Fields{
@@ -284,23 +1347,52 @@ func (h *LiveHaproxy) findUpstreams(name string) ([]Upstream, error) {
 
 	for _, u := range node.Nodes {
 		uName := strings.TrimPrefix(u.Key, key+"/")
-		host, port, err := net.SplitHostPort(u.Value)
+		upstream, err := parseUpstream(uName, u.Value)
 		if err != nil {
 			return nil, err
 		}
 
-		portInt, err := strconv.Atoi(port)
-		if err != nil {
-			return nil, err
-		}
-
-		upstream := Upstream{ID: uName, Host: host, Port: portInt}
 		upstreams = append(upstreams, upstream)
 	}
 
 	return upstreams, nil
 }
 
+// findCertificates reads the certificates of an HTTP service. Their keys
+// aren't read.
+func (h *LiveHaproxy) findCertificates(name string) ([]Certificate, error) {
+	certificates := []Certificate{}
+
+	key := h.serviceKey(name, "/certificates")
+	node, err := h.Get(key, &GetOptions{Recursive: true})
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return certificates, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range node.Nodes {
+		id := strings.TrimPrefix(n.Key, key+"/")
+		for _, child := range n.Nodes {
+			if child.Key != n.Key+"/certificate" {
+				continue
+			}
+
+			c, err := parseCertificatePEM(child.Value)
+			if err != nil {
+				return nil, fmt.Errorf("certificate %s of service %s: %v", id, name, err)
+			}
+			c.ID = id
+
+			certificates = append(certificates, *c)
+		}
+	}
+
+	return certificates, nil
+}
+
 func (h *LiveHaproxy) serviceConfig(name string) (ServiceConfig, error) {
 	serviceType, err := h.serviceType(name)
 	if err != nil {
@@ -322,6 +1414,13 @@ func (h *LiveHaproxy) serviceConfig(name string) (ServiceConfig, error) {
 	case svcURLReg:
 		sc["matcher"] = svcURLReg
 		sc["url_reg"] = vNode.Value
+	case svcRules:
+		rules, err := parseRules(vNode.Value)
+		if err != nil {
+			return nil, err
+		}
+		sc["matcher"] = svcRules
+		sc["rules"] = rules
 	default:
 		return nil, fmt.Errorf("unknown service type %q", serviceType)
 	}
@@ -0,0 +1,279 @@
+package kvs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// SchemaVersionKey returns the key containing the version of the haproxy
+// key layout.
+func (h *LiveHaproxy) SchemaVersionKey() string {
+	return h.RootKey + "/schema_version"
+}
+
+// Migrator builds a Migrator for the haproxy keys.
+func (h *LiveHaproxy) Migrator() *Migrator {
+	return NewMigrator(h.KVS, h.SchemaVersionKey(), h.migrations(), h.log)
+}
+
+// Migrate brings the haproxy keys up to the latest schema version.
+func (h *LiveHaproxy) Migrate() error {
+	return h.Migrator().Migrate()
+}
+
+// RestoreSnapshot replaces the haproxy and firewall trees with the contents
+// of a snapshot, and migrates them to the latest schema version. Snapshots
+// of a newer schema version are refused.
+func (h *LiveHaproxy) RestoreSnapshot(s *Snapshot) error {
+	m := h.Migrator()
+	for _, e := range s.Entries {
+		if normalizeKey(e.Key) != m.VersionKey {
+			continue
+		}
+
+		version, err := strconv.Atoi(e.Value)
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q in snapshot", e.Value)
+		}
+
+		if version > m.LatestVersion() {
+			return fmt.Errorf("snapshot schema version %d is newer than the latest known version %d", version, m.LatestVersion())
+		}
+	}
+
+	if err := RestoreSnapshot(h.KVS, s); err != nil {
+		return err
+	}
+
+	return m.Migrate()
+}
+
+// migrations are the changes to the haproxy key layout. Append new
+// migrations to the end; never change or remove existing ones.
+func (h *LiveHaproxy) migrations() []Migration {
+	return []Migration{
+		{Version: 1, Description: "reserve the ports of existing services", Plan: h.planReservePorts},
+		{Version: 2, Description: "remove matchers of the wrong service type", Plan: h.planRemoveStaleMatchers},
+		{Version: 3, Description: "encode upstream attributes in upstream values", Plan: h.planEncodeUpstreams},
+		{Version: 4, Description: "share the ports of http services", Plan: h.planSharePorts},
+	}
+}
+
+// planReservePorts reserves the ports of services created before ports were
+// reserved. If services share a port, the first one keeps it.
+func (h *LiveHaproxy) planReservePorts() ([]TxnOp, error) {
+	names, err := h.serviceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []TxnOp{}
+	reserved := map[int]bool{}
+
+	for _, name := range names {
+		port, err := h.servicePort(name)
+		if err != nil {
+			h.log.WithError(err).WithField("service-name", name).Warn("unable to reserve port for service")
+			continue
+		}
+
+		if reserved[port] {
+			h.log.WithField("service-name", name).WithField("port", port).Warn("port is used by another service")
+			continue
+		}
+		reserved[port] = true
+
+		_, err = h.Get(h.portKey(port), nil)
+		switch {
+		case err == nil:
+			continue
+		case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+			ops = append(ops, TxnOp{Action: TxnSet, Key: h.portKey(port), Value: name, IfNotExist: true})
+		default:
+			return nil, err
+		}
+	}
+
+	return ops, nil
+}
+
+// planRemoveStaleMatchers removes the matchers services kept when their type
+// changed.
+func (h *LiveHaproxy) planRemoveStaleMatchers() ([]TxnOp, error) {
+	names, err := h.serviceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []TxnOp{}
+
+	for _, name := range names {
+		sType, err := h.serviceType(name)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, matcher := range []string{svcDomain, svcURLReg} {
+			if matcher == sType {
+				continue
+			}
+
+			node, err := h.Get(h.serviceKey(name, "/%s", matcher), nil)
+			switch {
+			case err == nil:
+				ops = append(ops, TxnOp{Action: TxnDelete, Key: node.Key, PrevIndex: node.ModifiedIndex})
+			case !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+				return nil, err
+			}
+		}
+	}
+
+	return ops, nil
+}
+
+// planEncodeUpstreams rewrites upstream values in the encoding which carries
+// weight, backup and state after the address. Values written before
+// upstreams had attributes are bare addresses and stay as they are; this
+// version marks that readers must understand the attributes.
+func (h *LiveHaproxy) planEncodeUpstreams() ([]TxnOp, error) {
+	ops := []TxnOp{}
+
+	for _, root := range []string{h.RootKey + "/services", h.RootKey + "/tcp-services"} {
+		services, err := h.Get(root, nil)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, svc := range services.Nodes {
+			key := svc.Key + "/upstreams"
+			upstreams, err := h.Get(key, nil)
+			if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, n := range upstreams.Nodes {
+				u, err := parseUpstream(strings.TrimPrefix(n.Key, key+"/"), n.Value)
+				if err != nil {
+					h.log.WithError(err).WithField("upstream", n.Key).Warn("unable to encode upstream")
+					continue
+				}
+
+				if value := encodeUpstream(u); value != n.Value {
+					ops = append(ops, TxnOp{Action: TxnSet, Key: n.Key, Value: value, PrevIndex: n.ModifiedIndex})
+				}
+			}
+		}
+	}
+
+	return ops, nil
+}
+
+// planSharePorts adds the HTTP services sharing a port to the port's owners.
+// Before ports were shared, the first service kept a port. Services with
+// certificates also share HTTPSPort. Ports owned by TCP services are left
+// alone.
+func (h *LiveHaproxy) planSharePorts() ([]TxnOp, error) {
+	names, err := h.serviceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := []int{}
+	users := map[int][]string{}
+	use := func(port int, name string) {
+		if _, ok := users[port]; !ok {
+			ports = append(ports, port)
+		}
+		users[port] = append(users[port], name)
+	}
+
+	for _, name := range names {
+		port, err := h.servicePort(name)
+		if err != nil {
+			continue
+		}
+		use(port, name)
+
+		certificates, err := h.findCertificates(name)
+		if err != nil {
+			h.log.WithError(err).WithField("service-name", name).Warn("unable to read certificates of service")
+			continue
+		}
+
+		if len(certificates) > 0 && port != HTTPSPort {
+			use(HTTPSPort, name)
+		}
+	}
+
+	ops := []TxnOp{}
+	for _, port := range ports {
+		node, err := h.Get(h.portKey(port), nil)
+		switch {
+		case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+			ops = append(ops, TxnOp{Action: TxnSet, Key: h.portKey(port), Value: strings.Join(users[port], " "), IfNotExist: true})
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		owners := portOwners(node.Value)
+		if len(owners) > 0 {
+			isTCP, err := h.isTCPService(owners[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if isTCP {
+				h.log.WithField("port", port).WithField("service-name", owners[0]).Warn("port is used by a tcp service")
+				continue
+			}
+		}
+
+		shared := owners
+		for _, name := range users[port] {
+			if !containsString(shared, name) {
+				shared = append(shared, name)
+			}
+		}
+
+		if len(shared) != len(owners) {
+			ops = append(ops, TxnOp{Action: TxnSet, Key: node.Key, Value: strings.Join(shared, " "), PrevIndex: node.ModifiedIndex})
+		}
+	}
+
+	return ops, nil
+}
+
+// serviceNames returns the names of the services.
+func (h *LiveHaproxy) serviceNames() ([]string, error) {
+	node, err := h.Get(h.RootKey+"/services", nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, n := range node.Nodes {
+		names = append(names, strings.TrimPrefix(n.Key, h.RootKey+"/services/"))
+	}
+
+	return names, nil
+}
@@ -2,11 +2,16 @@ package kvs_test
 
 import (
 	"io/ioutil"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	. "github.com/bryanl/dolb/kvs"
 	"github.com/bryanl/dolb/pkg/app"
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -53,10 +58,28 @@ var _ = Describe("Haproxy", func() {
 				Ω(err).ToNot(HaveOccurred())
 			})
 		})
+
+		Context("with existing directories", func() {
+
+			BeforeEach(func() {
+				existsErr := &MkdirError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeNotFile}}
+				kvs.On("Mkdir", "/haproxy-discover/services").Return(existsErr)
+				kvs.On("Mkdir", "/haproxy-discover/tcp-services").Return(existsErr)
+			})
+
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
 	})
 
 	Describe("Domain", func() {
 
+		var (
+			getOpts  *GetOptions
+			notFound = &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+		)
+
 		JustBeforeEach(func() {
 			err = haproxy.Domain("app", "example.com", 80)
 		})
@@ -64,15 +87,18 @@ var _ = Describe("Haproxy", func() {
 		Context("with valid inputs", func() {
 
 			BeforeEach(func() {
-				var opts *SetOptions
-				node := &Node{}
-				kvs.On("Set", "/haproxy-discover/services/app/domain", "example.com", opts).Return(node, nil)
-				kvs.On("Set", "/haproxy-discover/services/app/type", "domain", opts).Return(node, nil)
-				kvs.On("Set", "/haproxy-discover/services/app/port", "80", opts).Return(node, nil)
-
-				emptyNode := &Node{Nodes: Nodes{}}
-				var getOpts *GetOptions
-				kvs.On("Get", "/haproxy-discover/services", getOpts).Return(emptyNode, nil)
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/tcp-services/app/port", IfNotExist: true},
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/ports/80", Value: "app", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/domain", Value: "example.com"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/type", Value: "domain"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/port", Value: "80"},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
 			It("doesn't return an error", func() {
@@ -82,60 +108,183 @@ var _ = Describe("Haproxy", func() {
 
 		Context("with port conflict", func() {
 			BeforeEach(func() {
-				node := &Node{
-					Nodes: Nodes{
-						{Key: haproxy.RootKey + "/services/service-a"},
-					},
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(nil, notFound)
+
+				owner := &Node{Key: "/haproxy-discover/ports/80", Value: "service-a"}
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(owner, nil)
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-a/port", getOpts).Return(&Node{Value: "80"}, nil)
+			})
+
+			It("returns an error", func() {
+				Ω(err).To(MatchError("port 80 is already in use by tcp service service-a"))
+			})
+
+		})
+
+		Context("with a port used by another http service", func() {
+			BeforeEach(func() {
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(nil, notFound)
+
+				owner := &Node{Key: "/haproxy-discover/ports/80", Value: "service-a", ModifiedIndex: 7}
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(owner, nil)
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-a/port", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/tcp-services/app/port", IfNotExist: true},
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/ports/80", Value: "service-a app", PrevIndex: 7},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/domain", Value: "example.com"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/type", Value: "domain"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/port", Value: "80"},
 				}
-				var getOpts *GetOptions
-				kvs.On("Get", "/haproxy-discover/services", getOpts).Return(node, nil)
+				kvs.On("Txn", ops).Return(nil)
+			})
 
-				upstreamKey := "/haproxy-discover/services/service-a/upstreams"
-				node6 := &Node{
-					Nodes: Nodes{
-						{Key: upstreamKey + "/a", Value: "host-a:80"},
-					},
+			It("shares the port", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+		})
+
+		Context("when the port is reserved concurrently", func() {
+			BeforeEach(func() {
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(nil, notFound)
+
+				txnErr := &TxnError{Op: 2, Err: etcdclient.Error{Code: etcdclient.ErrorCodeNodeExist}}
+				kvs.On("Txn", mock.Anything).Return(txnErr)
+			})
+
+			It("returns a port conflict error", func() {
+				Ω(err).To(MatchError("port 80 is already in use"))
+			})
+		})
+
+		Context("when a tcp service has the same name", func() {
+			BeforeEach(func() {
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(nil, notFound)
+
+				txnErr := &TxnError{Op: 0, Err: etcdclient.Error{Code: etcdclient.ErrorCodeNodeExist}}
+				kvs.On("Txn", mock.Anything).Return(txnErr)
+			})
+
+			It("returns a name conflict error", func() {
+				Ω(err).To(MatchError("service app already exists with another type"))
+			})
+		})
+	})
+
+	Describe("TCP", func() {
+
+		var (
+			getOpts  *GetOptions
+			notFound = &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+		)
+
+		JustBeforeEach(func() {
+			err = haproxy.TCP("app", 5432)
+		})
+
+		Context("with valid inputs", func() {
+
+			BeforeEach(func() {
+				kvs.On("Get", "/haproxy-discover/tcp-services/app/port", getOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/ports/5432", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", IfNotExist: true},
+					{Action: TxnCheck, Key: "/haproxy-discover/tcp-services/app/port", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/ports/5432", Value: "app", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/tcp-services/app/port", Value: "5432"},
 				}
-				kvs.On("Get", upstreamKey, getOpts).Return(node6, nil)
+				kvs.On("Txn", ops).Return(nil)
+			})
 
-				node2 := &Node{Value: "domain"}
-				kvs.On("Get", "/haproxy-discover/services/service-a/type", getOpts).Return(node2, nil)
+			It("doesn't return an error", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
 
-				node4 := &Node{Value: "example.com"}
-				kvs.On("Get", "/haproxy-discover/services/service-a/domain", getOpts).Return(node4, nil)
+		Context("when changing the port", func() {
 
-				node8 := &Node{Value: "80"}
-				kvs.On("Get", "/haproxy-discover/services/service-a/port", getOpts).Return(node8, nil)
+			BeforeEach(func() {
+				portNode := &Node{Key: "/haproxy-discover/tcp-services/app/port", Value: "6379", ModifiedIndex: 5}
+				kvs.On("Get", "/haproxy-discover/tcp-services/app/port", getOpts).Return(portNode, nil)
+
+				owner := &Node{Key: "/haproxy-discover/ports/6379", Value: "app", ModifiedIndex: 4}
+				kvs.On("Get", "/haproxy-discover/ports/6379", getOpts).Return(owner, nil)
+				kvs.On("Get", "/haproxy-discover/ports/5432", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", IfNotExist: true},
+					{Action: TxnCheck, Key: "/haproxy-discover/tcp-services/app/port", PrevIndex: 5},
+					{Action: TxnDelete, Key: "/haproxy-discover/ports/6379", PrevIndex: 4},
+					{Action: TxnSet, Key: "/haproxy-discover/ports/5432", Value: "app", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/tcp-services/app/port", Value: "5432"},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
-			It("returns an error", func() {
-				Ω(err).To(HaveOccurred())
+			It("moves the service in one transaction", func() {
+				Ω(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with port conflict", func() {
+			BeforeEach(func() {
+				kvs.On("Get", "/haproxy-discover/tcp-services/app/port", getOpts).Return(nil, notFound)
+
+				owner := &Node{Key: "/haproxy-discover/ports/5432", Value: "service-a"}
+				kvs.On("Get", "/haproxy-discover/ports/5432", getOpts).Return(owner, nil)
 			})
 
+			It("returns an error", func() {
+				Ω(err).To(MatchError("port 5432 is already in use by service-a"))
+			})
 		})
 	})
 
 	Describe("URLReg", func() {
 
+		var (
+			getOpts *GetOptions
+		)
+
 		JustBeforeEach(func() {
 			err = haproxy.URLReg("app", ".*", 80)
 		})
 
-		Context("with valid inputs", func() {
+		Context("when updating a domain service", func() {
 
 			BeforeEach(func() {
-				var opts *SetOptions
-				node := &Node{}
-				kvs.On("Set", "/haproxy-discover/services/app/url_reg", ".*", opts).Return(node, nil)
-				kvs.On("Set", "/haproxy-discover/services/app/type", "url_reg", opts).Return(node, nil)
-				kvs.On("Set", "/haproxy-discover/services/app/port", "80", opts).Return(node, nil)
-
-				emptyNode := &Node{Nodes: Nodes{}}
-				var getOpts *GetOptions
-				kvs.On("Get", "/haproxy-discover/services", getOpts).Return(emptyNode, nil)
+				portNode := &Node{Key: "/haproxy-discover/services/app/port", Value: "81", ModifiedIndex: 5}
+				kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(portNode, nil)
+
+				owner81 := &Node{Key: "/haproxy-discover/ports/81", Value: "app", ModifiedIndex: 4}
+				kvs.On("Get", "/haproxy-discover/ports/81", getOpts).Return(owner81, nil)
+
+				kvs.On("Get", "/haproxy-discover/services/app/type", getOpts).Return(&Node{Value: "domain"}, nil)
+				matcher := &Node{Key: "/haproxy-discover/services/app/domain", ModifiedIndex: 3}
+				kvs.On("Get", "/haproxy-discover/services/app/domain", getOpts).Return(matcher, nil)
+
+				notFound := &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/tcp-services/app/port", IfNotExist: true},
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", PrevIndex: 5},
+					{Action: TxnDelete, Key: "/haproxy-discover/ports/81", PrevIndex: 4},
+					{Action: TxnDelete, Key: "/haproxy-discover/services/app/domain", PrevIndex: 3},
+					{Action: TxnSet, Key: "/haproxy-discover/ports/80", Value: "app", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/url_reg", Value: ".*"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/type", Value: "url_reg"},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/port", Value: "80"},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
-			It("doesn't return an error", func() {
+			It("replaces the service in one transaction", func() {
 				Ω(err).ToNot(HaveOccurred())
 			})
 
@@ -144,20 +293,102 @@ var _ = Describe("Haproxy", func() {
 
 	Describe("Upstream", func() {
 
+		var (
+			id         string
+			getOpts    *GetOptions
+			notFound   = &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+			addressKey = "/haproxy-discover/services/app/upstream-addresses/node%3A80"
+		)
+
+		BeforeEach(func() {
+			haproxy.IDGen = func() string { return "1" }
+			kvs.On("Get", "/haproxy-discover/tcp-services/app/port", getOpts).Return(nil, notFound)
+			kvs.On("Get", "/haproxy-discover/services/app/port", getOpts).Return(&Node{ModifiedIndex: 4}, nil)
+		})
+
 		JustBeforeEach(func() {
-			err = haproxy.Upstream("app", "node:80")
+			id, err = haproxy.Upstream("app", NewUpstream("node", 80))
 		})
 
 		Context("with valid inputs", func() {
 
 			BeforeEach(func() {
-				var opts *SetOptions
-				node := &Node{}
-				kvs.On("Set", "/haproxy-discover/services/app/upstreams/1", "node:80", opts).Return(node, nil)
+				kvs.On("Get", addressKey, getOpts).Return(nil, notFound)
+
+				node := &Node{
+					Nodes: Nodes{
+						{Key: "/haproxy-discover/services/app/upstreams/0", Value: "other:80"},
+					},
+				}
+				kvs.On("Get", "/haproxy-discover/services/app/upstreams", getOpts).Return(node, nil)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", PrevIndex: 4},
+					{Action: TxnSet, Key: addressKey, Value: "1", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/upstreams/1", Value: "node:80", IfNotExist: true},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
-			It("doesn't return an error", func() {
+			It("returns the id of the new upstream", func() {
 				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("1"))
+			})
+
+		})
+
+		Context("with a claimed address", func() {
+
+			BeforeEach(func() {
+				kvs.On("Get", addressKey, getOpts).Return(&Node{Value: "0"}, nil)
+				kvs.On("Get", "/haproxy-discover/services/app/upstreams/0", getOpts).Return(&Node{Value: "node:80"}, nil)
+			})
+
+			It("returns the existing id", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("0"))
+			})
+
+		})
+
+		Context("with an existing upstream added before addresses were claimed", func() {
+
+			BeforeEach(func() {
+				kvs.On("Get", addressKey, getOpts).Return(nil, notFound)
+
+				node := &Node{
+					Nodes: Nodes{
+						{Key: "/haproxy-discover/services/app/upstreams/0", Value: "node:80"},
+					},
+				}
+				kvs.On("Get", "/haproxy-discover/services/app/upstreams", getOpts).Return(node, nil)
+			})
+
+			It("returns the existing id", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("0"))
+			})
+
+		})
+
+		Context("when the address is claimed concurrently", func() {
+
+			BeforeEach(func() {
+				kvs.On("Get", addressKey, getOpts).Return(nil, notFound).Once()
+				kvs.On("Get", "/haproxy-discover/services/app/upstreams", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnCheck, Key: "/haproxy-discover/services/app/port", PrevIndex: 4},
+					{Action: TxnSet, Key: addressKey, Value: "1", IfNotExist: true},
+					{Action: TxnSet, Key: "/haproxy-discover/services/app/upstreams/1", Value: "node:80", IfNotExist: true},
+				}
+				kvs.On("Txn", ops).Return(&TxnError{Op: 1, Key: addressKey})
+				kvs.On("Get", addressKey, getOpts).Return(&Node{Value: "7"}, nil).Once()
+			})
+
+			It("returns the id of the concurrent add", func() {
+				Ω(err).ToNot(HaveOccurred())
+				Ω(id).To(Equal("7"))
 			})
 
 		})
@@ -222,6 +453,71 @@ var _ = Describe("Haproxy", func() {
 				portBNode := &Node{Value: "81"}
 				kvs.On("Get", "/haproxy-discover/services/service-b/port", opts).Return(portBNode, nil)
 
+				notFound := &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-a/port", opts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-b/port", opts).Return(nil, notFound)
+
+				certOpts := &GetOptions{Recursive: true}
+				kvs.On("Get", "/haproxy-discover/services/service-a/certificates", certOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/services/service-b/certificates", certOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/services/service-a/health_check", certOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-c/health_check", certOpts).Return(nil, notFound)
+
+				hcKey := "/haproxy-discover/services/service-b/health_check"
+				hcNode := &Node{
+					Key: hcKey,
+					Nodes: Nodes{
+						{Key: hcKey + "/type", Value: "http"},
+						{Key: hcKey + "/path", Value: "/health"},
+						{Key: hcKey + "/expected_status", Value: "204"},
+						{Key: hcKey + "/interval", Value: "5s"},
+						{Key: hcKey + "/rise", Value: "2"},
+						{Key: hcKey + "/fall", Value: "3"},
+					},
+				}
+				kvs.On("Get", hcKey, certOpts).Return(hcNode, nil)
+
+				kvs.On("Get", "/haproxy-discover/services/service-a/balance", opts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/services/service-b/balance", opts).Return(&Node{Value: "uri cookie SRV prefix"}, nil)
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-c/balance", opts).Return(&Node{Value: "leastconn"}, nil)
+
+				kvs.On("Get", "/haproxy-discover/services/service-a/limits", certOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/services/service-b/limits", certOpts).Return(nil, notFound)
+
+				limitsKey := "/haproxy-discover/tcp-services/service-c/limits"
+				limitsNode := &Node{
+					Key: limitsKey,
+					Nodes: Nodes{
+						{Key: limitsKey + "/request_rate", Value: "0"},
+						{Key: limitsKey + "/connections_per_source", Value: "5"},
+						{Key: limitsKey + "/upstream_maxconn", Value: "100"},
+						{Key: limitsKey + "/action", Value: "deny"},
+					},
+				}
+				kvs.On("Get", limitsKey, certOpts).Return(limitsNode, nil)
+
+				kvs.On("Get", "/haproxy-discover/services/service-a/http_rules", opts).Return(nil, notFound)
+				httpRulesNode := &Node{Value: `[{"id":"r1","action":"redirect-https","code":301}]`}
+				kvs.On("Get", "/haproxy-discover/services/service-b/http_rules", opts).Return(httpRulesNode, nil)
+
+				tcpNode := &Node{
+					Nodes: Nodes{
+						{Key: haproxy.RootKey + "/tcp-services/service-c"},
+					},
+				}
+				kvs.On("Get", "/haproxy-discover/tcp-services", opts).Return(tcpNode, nil)
+
+				portCNode := &Node{Value: "5432"}
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-c/port", opts).Return(portCNode, nil)
+
+				kc := "/haproxy-discover/tcp-services/service-c/upstreams"
+				node8 := &Node{
+					Nodes: Nodes{
+						{Key: kc + "/f", Value: "db:5432 weight 10 backup drain"},
+					},
+				}
+				kvs.On("Get", kc, opts).Return(node8, nil)
+
 			})
 
 			It("doesn't return an error", func() {
@@ -229,7 +525,7 @@ var _ = Describe("Haproxy", func() {
 			})
 
 			It("return the services", func() {
-				Ω(services).To(HaveLen(2))
+				Ω(services).To(HaveLen(3))
 
 				Ω(services[0].Name()).To(Equal("service-a"))
 				Ω(services[0].Type()).To(Equal("http"))
@@ -243,6 +539,34 @@ var _ = Describe("Haproxy", func() {
 				Ω(services[1].ServiceConfig()["url_reg"]).To(Equal(".*"))
 				Ω(services[1].Upstreams()).To(HaveLen(3))
 				Ω(services[1].Upstreams()[0].ID).To(Equal("c"))
+				Ω(services[1].HealthCheck()).To(Equal(&HealthCheck{
+					Type:           "http",
+					Path:           "/health",
+					ExpectedStatus: 204,
+					Interval:       5 * time.Second,
+					Rise:           2,
+					Fall:           3,
+				}))
+				Ω(services[0].HealthCheck()).To(BeNil())
+				Ω(services[0].Balance()).To(Equal(Balance{Algorithm: BalanceRoundRobin}))
+				Ω(services[1].Balance()).To(Equal(Balance{
+					Algorithm: BalanceURI,
+					Sticky:    &StickySessions{Cookie: "SRV", Mode: CookiePrefix},
+				}))
+				Ω(services[0].HTTPRules()).To(BeEmpty())
+				Ω(services[1].HTTPRules()).To(Equal([]HTTPRule{
+					{ID: "r1", Action: HTTPRuleRedirectHTTPS, Code: 301},
+				}))
+
+				Ω(services[2].Name()).To(Equal("service-c"))
+				Ω(services[2].Type()).To(Equal("tcp"))
+				Ω(services[2].Port()).To(Equal(5432))
+				Ω(services[2].ServiceConfig()).To(BeEmpty())
+				Ω(services[2].Balance()).To(Equal(Balance{Algorithm: BalanceLeastConn}))
+				Ω(services[2].Limits()).To(Equal(&Limits{ConnectionsPerSource: 5, UpstreamMaxConn: 100, Action: LimitDeny}))
+				Ω(services[2].Upstreams()).To(Equal([]Upstream{
+					{ID: "f", Host: "db", Port: 5432, Weight: 10, Backup: true, State: UpstreamDrain},
+				}))
 
 			})
 		})
@@ -257,10 +581,23 @@ var _ = Describe("Haproxy", func() {
 		Context("with valid inputs", func() {
 
 			BeforeEach(func() {
-				kvs.On("Delete", "/haproxy-discover/services/service-a/upstreams/999").Return(nil)
+				var getOpts *GetOptions
+				notFound := &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-a/port", getOpts).Return(nil, notFound)
+
+				node := &Node{Value: "node:80", ModifiedIndex: 5}
+				kvs.On("Get", "/haproxy-discover/services/service-a/upstreams/999", getOpts).Return(node, nil)
+				addressKey := "/haproxy-discover/services/service-a/upstream-addresses/node%3A80"
+				kvs.On("Get", addressKey, getOpts).Return(&Node{Value: "999", ModifiedIndex: 5}, nil)
+
+				ops := []TxnOp{
+					{Action: TxnDelete, Key: "/haproxy-discover/services/service-a/upstreams/999", PrevIndex: 5},
+					{Action: TxnDelete, Key: addressKey, PrevIndex: 5},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
-			It("doesn't not return an error", func() {
+			It("removes the upstream and its address", func() {
 				Ω(err).ToNot(HaveOccurred())
 			})
 		})
@@ -273,7 +610,20 @@ var _ = Describe("Haproxy", func() {
 
 		Context("with a valid service name", func() {
 			BeforeEach(func() {
-				kvs.On("Rmdir", "/haproxy-discover/services/service-a").Return(nil)
+				var getOpts *GetOptions
+				notFound := &KVError{Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+				kvs.On("Get", "/haproxy-discover/tcp-services/service-a/port", getOpts).Return(nil, notFound)
+				kvs.On("Get", "/haproxy-discover/services/service-a/port", getOpts).Return(&Node{Value: "80"}, nil)
+
+				owner := &Node{Key: "/haproxy-discover/ports/80", Value: "service-a", ModifiedIndex: 7}
+				kvs.On("Get", "/haproxy-discover/ports/80", getOpts).Return(owner, nil)
+				kvs.On("Get", "/haproxy-discover/ports/443", getOpts).Return(nil, notFound)
+
+				ops := []TxnOp{
+					{Action: TxnRmdir, Key: "/haproxy-discover/services/service-a"},
+					{Action: TxnDelete, Key: "/haproxy-discover/ports/80", PrevIndex: 7},
+				}
+				kvs.On("Txn", ops).Return(nil)
 			})
 
 			It("doesn't return an error", func() {
@@ -282,4 +632,540 @@ var _ = Describe("Haproxy", func() {
 		})
 	})
 
+	Context("with a memory kvs", func() {
+
+		var (
+			cancel context.CancelFunc
+			mem    *Memory
+		)
+
+		BeforeEach(func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			mem = NewMemory(ctx)
+			i = 0
+			haproxy = NewLiveHaproxy(mem, idGen, log)
+			Ω(haproxy.Init()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("reserves ports", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.Domain("service-b", "b.example.com", 80)).To(Succeed())
+			Ω(haproxy.TCP("service-c", 80)).ToNot(Succeed())
+
+			_, err = mem.Get("/haproxy-discover/tcp-services/service-c", nil)
+			Ω(err).To(HaveOccurred())
+
+			node, err := mem.Get("/haproxy-discover/ports/80", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("service-a service-b"))
+
+			Ω(haproxy.URLReg("service-a", ".*", 81)).To(Succeed())
+
+			node, err = mem.Get("/haproxy-discover/ports/80", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("service-b"))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Port()).To(Equal(81))
+			Ω(svc.ServiceConfig()["matcher"]).To(Equal("url_reg"))
+
+			_, err = mem.Get("/haproxy-discover/services/service-a/domain", nil)
+			Ω(err).To(HaveOccurred())
+
+			Ω(haproxy.DeleteService("service-a")).To(Succeed())
+
+			node, err = mem.Get("/haproxy-discover/ports", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Nodes).To(HaveLen(1))
+			Ω(node.Nodes[0].Value).To(Equal("service-b"))
+		})
+
+		It("keeps upstreams when a service moves", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			_, err := haproxy.Upstream("service-a", NewUpstream("10.0.0.1", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+			_, err = haproxy.Upstream("service-b", NewUpstream("10.0.0.2", 5432))
+			Ω(err).ToNot(HaveOccurred())
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 8080)).To(Succeed())
+			Ω(haproxy.TCP("service-b", 5433)).To(Succeed())
+
+			for _, name := range []string{"service-a", "service-b"} {
+				svc, err := haproxy.Service(name)
+				Ω(err).ToNot(HaveOccurred())
+				Ω(svc.Upstreams()).To(HaveLen(1))
+			}
+
+			node, err := mem.Get("/haproxy-discover/ports", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Nodes).To(HaveLen(2))
+		})
+
+		It("syncs upstreams", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			id, err := haproxy.Upstream("service-a", NewUpstream("10.0.0.1", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			again, err := haproxy.Upstream("service-a", NewUpstream("10.0.0.1", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			Ω(again).To(Equal(id))
+			_, err = haproxy.Upstream("service-a", NewUpstream("10.0.0.2", 8080))
+			Ω(err).ToNot(HaveOccurred())
+
+			// duplicates written before upstreams were deduplicated are removed.
+			_, err = mem.Set("/haproxy-discover/services/service-a/upstreams/legacy", "10.0.0.1:8080", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			heavy := NewUpstream("10.0.0.1", 8080)
+			heavy.Weight = 5
+			desired := []Upstream{heavy, NewUpstream("10.0.0.3", 8080), NewUpstream("10.0.0.3", 8080)}
+
+			addresses := func(upstreams []Upstream) []string {
+				a := []string{}
+				for _, u := range upstreams {
+					a = append(a, u.Address())
+				}
+				return a
+			}
+
+			result, err := haproxy.SyncUpstreams("service-a", desired)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(addresses(result.Added)).To(Equal([]string{"10.0.0.3:8080"}))
+			Ω(addresses(result.Updated)).To(Equal([]string{"10.0.0.1:8080"}))
+			Ω(addresses(result.Removed)).To(ConsistOf("10.0.0.1:8080", "10.0.0.2:8080"))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(addresses(svc.Upstreams())).To(ConsistOf("10.0.0.1:8080", "10.0.0.3:8080"))
+			Ω(svc.Upstreams()).To(ContainElement(WithTransform(func(u Upstream) int { return u.Weight }, Equal(5))))
+
+			result, err = haproxy.SyncUpstreams("service-a", desired)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(result).To(Equal(&UpstreamSync{Added: []Upstream{}, Updated: []Upstream{}, Removed: []Upstream{}}))
+
+			_, err = haproxy.SyncUpstreams("service-b", desired)
+			Ω(IsKeyNotFound(err)).To(BeTrue())
+
+			_, err = haproxy.SyncUpstreams("service-a", []Upstream{{Host: "10.0.0.4"}})
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("claims upstream addresses", func() {
+			claim := func(address string) string {
+				node, err := mem.Get("/haproxy-discover/services/service-a/upstream-addresses/"+url.QueryEscape(address), nil)
+				if IsKeyNotFound(err) {
+					return ""
+				}
+				Ω(err).ToNot(HaveOccurred())
+				return node.Value
+			}
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+
+			id, err := haproxy.Upstream("service-a", NewUpstream("10.0.0.1", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			Ω(claim("10.0.0.1:8080")).To(Equal(id))
+
+			Ω(haproxy.DeleteUpstream("service-a", id)).To(Succeed())
+			Ω(claim("10.0.0.1:8080")).To(Equal(""))
+
+			readded, err := haproxy.Upstream("service-a", NewUpstream("10.0.0.1", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			Ω(readded).ToNot(Equal(id))
+
+			// claims can outlive upstreams removed by older agents.
+			_, err = mem.Set("/haproxy-discover/services/service-a/upstream-addresses/"+url.QueryEscape("10.0.0.2:8080"), "gone", nil)
+			Ω(err).ToNot(HaveOccurred())
+			id, err = haproxy.Upstream("service-a", NewUpstream("10.0.0.2", 8080))
+			Ω(err).ToNot(HaveOccurred())
+			Ω(id).ToNot(Equal("gone"))
+			Ω(claim("10.0.0.2:8080")).To(Equal(id))
+
+			result, err := haproxy.SyncUpstreams("service-a", []Upstream{NewUpstream("10.0.0.2", 8080), NewUpstream("10.0.0.3", 8080)})
+			Ω(err).ToNot(HaveOccurred())
+			Ω(claim("10.0.0.1:8080")).To(Equal(""))
+			Ω(claim("10.0.0.2:8080")).To(Equal(id))
+			Ω(claim("10.0.0.3:8080")).To(Equal(result.Added[0].ID))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Upstreams()).To(HaveLen(2))
+		})
+
+		It("manages rules", func() {
+			rules := []Rule{
+				{Host: "*.example.com", PathPrefix: "/api"},
+				{Header: "X-Version", HeaderRegex: "^v2", Method: "POST"},
+			}
+			Ω(haproxy.Rules("service-a", rules, 80)).To(Succeed())
+			Ω(haproxy.Domain("service-b", "example.com", 80)).To(Succeed())
+			Ω(haproxy.Rules("service-c", []Rule{}, 80)).ToNot(Succeed())
+			Ω(haproxy.Rules("service-c", []Rule{{PathPrefix: "api"}}, 80)).ToNot(Succeed())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Port()).To(Equal(80))
+			Ω(svc.ServiceConfig()["matcher"]).To(Equal("rules"))
+			Ω(svc.ServiceConfig()["rules"]).To(Equal(rules))
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			_, err = mem.Get("/haproxy-discover/services/service-a/rules", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("manages tcp services", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.TCP("service-b", 80)).ToNot(Succeed())
+			Ω(haproxy.TCP("service-a", 5432)).ToNot(Succeed())
+
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+			Ω(haproxy.Domain("service-b", "b.example.com", 81)).ToNot(Succeed())
+			_, err := haproxy.Upstream("service-b", NewUpstream("db", 5432))
+			Ω(err).ToNot(HaveOccurred())
+
+			svc, err := haproxy.Service("service-b")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Type()).To(Equal("tcp"))
+			Ω(svc.Port()).To(Equal(5432))
+			Ω(svc.Upstreams()).To(HaveLen(1))
+
+			services, err := haproxy.Services()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(services).To(HaveLen(2))
+
+			Ω(haproxy.DeleteUpstream("service-b", svc.Upstreams()[0].ID)).To(Succeed())
+			Ω(haproxy.DeleteService("service-b")).To(Succeed())
+
+			_, err = mem.Get("/haproxy-discover/ports/5432", nil)
+			Ω(err).To(HaveOccurred())
+			_, err = mem.Get("/haproxy-discover/tcp-services/service-b", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("manages balance", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+
+			sticky := Balance{Algorithm: BalanceSource, Sticky: &StickySessions{}}
+			sticky.SetDefaults()
+			Ω(haproxy.SetBalance("service-a", sticky)).To(Succeed())
+			Ω(haproxy.SetBalance("service-b", sticky)).ToNot(Succeed())
+			Ω(haproxy.SetBalance("service-b", Balance{Algorithm: BalanceURI})).ToNot(Succeed())
+			Ω(haproxy.SetBalance("service-b", Balance{Algorithm: BalanceLeastConn})).To(Succeed())
+			Ω(haproxy.SetBalance("service-c", Balance{Algorithm: BalanceLeastConn})).ToNot(Succeed())
+
+			node, err := mem.Get("/haproxy-discover/services/service-a/balance", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("source cookie SERVERID insert"))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Balance()).To(Equal(sticky))
+
+			svc, err = haproxy.Service("service-b")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Balance()).To(Equal(Balance{Algorithm: BalanceLeastConn}))
+		})
+
+		It("manages upstream attributes", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+
+			u := NewUpstream("host-a", 8080)
+			u.Weight = 50
+			_, err := haproxy.Upstream("service-a", u)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = haproxy.Upstream("service-a", NewUpstream("host-b", 8080))
+			Ω(err).ToNot(HaveOccurred())
+
+			invalid := NewUpstream("host-c", 8080)
+			invalid.State = "sleeping"
+			_, err = haproxy.Upstream("service-a", invalid)
+			Ω(err).To(HaveOccurred())
+
+			node, err := mem.Get("/haproxy-discover/services/service-a/upstreams", nil)
+			Ω(err).ToNot(HaveOccurred())
+			values := []string{}
+			for _, n := range node.Nodes {
+				values = append(values, n.Value)
+			}
+			Ω(values).To(ConsistOf("host-a:8080 weight 50", "host-b:8080"))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Upstreams()).To(HaveLen(2))
+			id := svc.Upstreams()[0].ID
+
+			backup := true
+			state := UpstreamMaint
+			Ω(haproxy.UpdateUpstream("service-a", id, UpstreamUpdate{Backup: &backup, State: &state})).To(Succeed())
+
+			weight := 300
+			Ω(haproxy.UpdateUpstream("service-a", id, UpstreamUpdate{Weight: &weight})).ToNot(Succeed())
+
+			err = haproxy.UpdateUpstream("service-a", "missing", UpstreamUpdate{State: &state})
+			Ω(IsKeyNotFound(err)).To(BeTrue())
+
+			updated := svc.Upstreams()[0]
+			updated.Backup = true
+			updated.State = UpstreamMaint
+
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Upstreams()).To(ContainElement(updated))
+		})
+
+		It("manages certificates", func() {
+			certPEM, keyPEM := testCertificate("a.example.com", time.Now().Add(time.Hour))
+			otherCert, otherKey := testCertificate("b.example.com", time.Now().Add(2*time.Hour))
+
+			_, err = haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).To(HaveOccurred())
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 443)).To(Succeed())
+
+			_, err = haproxy.AddCertificate("service-a", certPEM, otherKey)
+			Ω(err).To(MatchError(ContainSubstring("invalid certificate or key")))
+
+			id, err := haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = haproxy.AddCertificate("service-a", otherCert, otherKey)
+			Ω(err).ToNot(HaveOccurred())
+
+			node, err := mem.Get("/haproxy-discover/services/service-a/certificates/"+id+"/key", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal(keyPEM))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Certificates()).To(HaveLen(2))
+			Ω(svc.Certificates()[0].ID).To(Equal(id))
+			Ω(svc.Certificates()[0].Names).To(Equal([]string{"a.example.com"}))
+			Ω(svc.Certificates()[1].Names).To(Equal([]string{"b.example.com"}))
+
+			Ω(haproxy.DeleteCertificate("service-a", id)).To(Succeed())
+
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Certificates()).To(HaveLen(1))
+
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+			_, err = haproxy.AddCertificate("service-b", certPEM, keyPEM)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("reserves the https port for services with certificates", func() {
+			certPEM, keyPEM := testCertificate("a.example.com", time.Now().Add(time.Hour))
+			owners := func() string {
+				node, err := mem.Get("/haproxy-discover/ports/443", nil)
+				if IsKeyNotFound(err) {
+					return ""
+				}
+				Ω(err).ToNot(HaveOccurred())
+				return node.Value
+			}
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.Domain("service-b", "b.example.com", 80)).To(Succeed())
+
+			idA, err := haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(owners()).To(Equal("service-a"))
+
+			Ω(haproxy.TCP("service-c", 443)).ToNot(Succeed())
+
+			_, err = haproxy.AddCertificate("service-b", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(owners()).To(Equal("service-a service-b"))
+
+			Ω(haproxy.DeleteCertificate("service-a", idA)).To(Succeed())
+			Ω(owners()).To(Equal("service-a service-b"))
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(haproxy.DeleteCertificate("service-a", svc.Certificates()[0].ID)).To(Succeed())
+			Ω(owners()).To(Equal("service-b"))
+
+			Ω(haproxy.DeleteService("service-b")).To(Succeed())
+			Ω(owners()).To(Equal(""))
+
+			Ω(haproxy.TCP("service-c", 443)).To(Succeed())
+			_, err = haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).To(MatchError(ContainSubstring("port 443 is already in use")))
+		})
+
+		It("opens the https port with the first certificate and closes it with the last", func() {
+			certPEM, keyPEM := testCertificate("a.example.com", time.Now().Add(time.Hour))
+			firewallPort := func() string {
+				node, err := mem.Get("/firewall/ports/443", nil)
+				Ω(err).ToNot(HaveOccurred())
+				return node.Value
+			}
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.Domain("service-b", "b.example.com", 80)).To(Succeed())
+
+			idA, err := haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(firewallPort()).To(Equal("enabled"))
+			idB, err := haproxy.AddCertificate("service-b", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+
+			Ω(haproxy.DeleteCertificate("service-a", idA)).To(Succeed())
+			Ω(firewallPort()).To(Equal("enabled"))
+
+			Ω(haproxy.DeleteCertificate("service-b", idB)).To(Succeed())
+			Ω(firewallPort()).To(Equal("disabled"))
+		})
+
+		It("keeps the https port reserved when a service with certificates moves away from it", func() {
+			certPEM, keyPEM := testCertificate("a.example.com", time.Now().Add(time.Hour))
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 443)).To(Succeed())
+			_, err = haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+
+			node, err := mem.Get("/haproxy-discover/ports/443", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("service-a"))
+		})
+
+		It("manages health checks", func() {
+			hc := &HealthCheck{Type: HealthCheckHTTP}
+			hc.SetDefaults()
+
+			Ω(haproxy.SetHealthCheck("service-a", hc)).ToNot(Succeed())
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.SetHealthCheck("service-a", hc)).To(Succeed())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HealthCheck()).To(Equal(hc))
+
+			tcp := &HealthCheck{}
+			tcp.SetDefaults()
+			Ω(haproxy.SetHealthCheck("service-a", tcp)).To(Succeed())
+
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HealthCheck()).To(Equal(tcp))
+
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+			Ω(haproxy.SetHealthCheck("service-b", tcp)).To(Succeed())
+
+			svc, err = haproxy.Service("service-b")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HealthCheck()).To(Equal(tcp))
+
+			Ω(haproxy.SetHealthCheck("service-a", nil)).To(Succeed())
+
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HealthCheck()).To(BeNil())
+		})
+
+		It("saves a service with its options in one transaction", func() {
+			hc := &HealthCheck{}
+			hc.SetDefaults()
+			limits := &Limits{ConnectionsPerSource: 10}
+			limits.SetDefaults()
+
+			spec := ServiceSpec{
+				Name:    "service-a",
+				Type:    "tcp",
+				Port:    5432,
+				Options: ServiceOptions{HealthCheck: hc, Balance: &Balance{Algorithm: BalanceLeastConn}, Limits: limits},
+				Ops:     []TxnOp{EnablePortOp(5432)},
+			}
+			Ω(haproxy.SaveService(spec)).To(Succeed())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HealthCheck()).To(Equal(hc))
+			Ω(svc.Balance().Algorithm).To(Equal(BalanceLeastConn))
+			Ω(svc.Limits()).To(Equal(limits))
+
+			node, err := mem.Get("/firewall/ports/5432", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("enabled"))
+
+			conflict := spec
+			conflict.Name = "service-b"
+			conflict.Ops = []TxnOp{EnablePortOp(5433)}
+			Ω(haproxy.SaveService(conflict)).ToNot(Succeed())
+
+			_, err = mem.Get("/haproxy-discover/tcp-services/service-b", nil)
+			Ω(err).To(HaveOccurred())
+			_, err = mem.Get("/firewall/ports/5433", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("leaves a service unchanged when its update fails", func() {
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+
+			err := haproxy.SaveService(ServiceSpec{
+				Name:    "service-a",
+				Type:    "url_reg",
+				Matcher: ".*",
+				Port:    5432,
+				Options: ServiceOptions{Balance: &Balance{Algorithm: BalanceLeastConn}},
+				Ops:     []TxnOp{DisablePortOp(80), EnablePortOp(5432)},
+			})
+			Ω(err).To(HaveOccurred())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Port()).To(Equal(80))
+			Ω(svc.ServiceConfig()["matcher"]).To(Equal("domain"))
+			Ω(svc.Balance().Algorithm).To(Equal(BalanceRoundRobin))
+
+			_, err = mem.Get("/firewall/ports/80", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("can be initialized again", func() {
+			Ω(haproxy.Init()).To(Succeed())
+		})
+	})
+
+	Context("with an etcd kvs", func() {
+
+		var cancel context.CancelFunc
+
+		BeforeEach(func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			haproxy = NewLiveHaproxy(NewEtcd(ctx, &memoryKeysAPI{mem: NewMemory(ctx)}), idGen, log)
+			Ω(haproxy.Init()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("finds the certificates of a service", func() {
+			certPEM, keyPEM := testCertificate("a.example.com", time.Now().Add(time.Hour))
+
+			Ω(haproxy.Domain("service-a", "a.example.com", 80)).To(Succeed())
+			id, err := haproxy.AddCertificate("service-a", certPEM, keyPEM)
+			Ω(err).ToNot(HaveOccurred())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Certificates()).To(HaveLen(1))
+			Ω(svc.Certificates()[0].ID).To(Equal(id))
+			Ω(svc.Certificates()[0].Names).To(Equal([]string{"a.example.com"}))
+		})
+	})
 })
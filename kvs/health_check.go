@@ -0,0 +1,204 @@
+package kvs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// Health check types.
+const (
+	// HealthCheckTCP checks that upstreams accept connections.
+	HealthCheckTCP = "tcp"
+	// HealthCheckHTTP checks that upstreams answer a request with the
+	// expected status.
+	HealthCheckHTTP = "http"
+)
+
+// Health check defaults. They are haproxy's defaults.
+const (
+	defaultHealthCheckInterval = 2 * time.Second
+	defaultHealthCheckRise     = 2
+	defaultHealthCheckFall     = 3
+	defaultHealthCheckPath     = "/"
+	defaultHealthCheckStatus   = 200
+)
+
+// HealthCheck configures how the upstreams of a service are checked. An
+// upstream stops receiving traffic after Fall failed checks in a row, and
+// receives traffic again after Rise successful ones.
+type HealthCheck struct {
+	Type           string
+	Path           string
+	ExpectedStatus int
+	Interval       time.Duration
+	Rise           int
+	Fall           int
+}
+
+// SetDefaults fills in the settings which weren't supplied.
+func (hc *HealthCheck) SetDefaults() {
+	if hc.Type == "" {
+		hc.Type = HealthCheckTCP
+	}
+
+	if hc.Type == HealthCheckHTTP {
+		if hc.Path == "" {
+			hc.Path = defaultHealthCheckPath
+		}
+
+		if hc.ExpectedStatus == 0 {
+			hc.ExpectedStatus = defaultHealthCheckStatus
+		}
+	}
+
+	if hc.Interval == 0 {
+		hc.Interval = defaultHealthCheckInterval
+	}
+
+	if hc.Rise == 0 {
+		hc.Rise = defaultHealthCheckRise
+	}
+
+	if hc.Fall == 0 {
+		hc.Fall = defaultHealthCheckFall
+	}
+}
+
+// Validate makes sure a health check can be used.
+func (hc *HealthCheck) Validate() error {
+	switch hc.Type {
+	case HealthCheckTCP:
+		if hc.Path != "" || hc.ExpectedStatus != 0 {
+			return fmt.Errorf("tcp health checks don't have a path or expected status")
+		}
+	case HealthCheckHTTP:
+		if !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("invalid health check path %q", hc.Path)
+		}
+
+		if hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599 {
+			return fmt.Errorf("invalid health check expected status %d", hc.ExpectedStatus)
+		}
+	default:
+		return fmt.Errorf("unknown health check type %q", hc.Type)
+	}
+
+	if hc.Interval < 100*time.Millisecond {
+		return fmt.Errorf("health check interval %s is too short", hc.Interval)
+	}
+
+	if hc.Rise < 1 || hc.Fall < 1 {
+		return fmt.Errorf("health check rise and fall must be at least 1")
+	}
+
+	return nil
+}
+
+// SetHealthCheck replaces the health check of a service. If hc is nil, the
+// service's upstreams aren't checked.
+func (h *LiveHaproxy) SetHealthCheck(app string, hc *HealthCheck) error {
+	dir, err := h.serviceDir(app)
+	if err != nil {
+		return err
+	}
+
+	portNode, err := h.Get(dir+"/port", nil)
+	if err != nil {
+		return err
+	}
+
+	checkOps, err := h.healthCheckOps(dir, hc)
+	if err != nil {
+		return err
+	}
+
+	ops := []TxnOp{
+		// the service must not be deleted while the check is changed.
+		{Action: TxnCheck, Key: dir + "/port", PrevIndex: portNode.ModifiedIndex},
+	}
+
+	return h.Txn(append(ops, checkOps...))
+}
+
+// healthCheckOps builds the operations which replace the health check in the
+// service directory dir. If hc is nil, the existing check is removed.
+func (h *LiveHaproxy) healthCheckOps(dir string, hc *HealthCheck) ([]TxnOp, error) {
+	ops := []TxnOp{}
+
+	key := dir + "/health_check"
+	_, err := h.Get(key, nil)
+	switch {
+	case err == nil:
+		ops = append(ops, TxnOp{Action: TxnRmdir, Key: key})
+	case !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return nil, err
+	}
+
+	if hc == nil {
+		return ops, nil
+	}
+
+	if err := hc.Validate(); err != nil {
+		return nil, err
+	}
+
+	ops = append(ops,
+		TxnOp{Action: TxnSet, Key: key + "/type", Value: hc.Type},
+		TxnOp{Action: TxnSet, Key: key + "/interval", Value: hc.Interval.String()},
+		TxnOp{Action: TxnSet, Key: key + "/rise", Value: strconv.Itoa(hc.Rise)},
+		TxnOp{Action: TxnSet, Key: key + "/fall", Value: strconv.Itoa(hc.Fall)},
+	)
+
+	if hc.Type == HealthCheckHTTP {
+		ops = append(ops,
+			TxnOp{Action: TxnSet, Key: key + "/path", Value: hc.Path},
+			TxnOp{Action: TxnSet, Key: key + "/expected_status", Value: strconv.Itoa(hc.ExpectedStatus)},
+		)
+	}
+
+	return ops, nil
+}
+
+// findHealthCheck reads the health check in the service directory dir. It
+// returns nil if the service has no health check.
+func (h *LiveHaproxy) findHealthCheck(dir string) (*HealthCheck, error) {
+	key := dir + "/health_check"
+	node, err := h.Get(key, &GetOptions{Recursive: true})
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	hc := &HealthCheck{}
+	for _, n := range node.Nodes {
+		field := strings.TrimPrefix(n.Key, key+"/")
+
+		switch field {
+		case "type":
+			hc.Type = n.Value
+		case "path":
+			hc.Path = n.Value
+		case "expected_status":
+			hc.ExpectedStatus, err = strconv.Atoi(n.Value)
+		case "interval":
+			hc.Interval, err = time.ParseDuration(n.Value)
+		case "rise":
+			hc.Rise, err = strconv.Atoi(n.Value)
+		case "fall":
+			hc.Fall, err = strconv.Atoi(n.Value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check %s: %v", field, err)
+		}
+	}
+
+	return hc, nil
+}
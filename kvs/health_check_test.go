@@ -0,0 +1,46 @@
+package kvs_test
+
+import (
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthCheck", func() {
+
+	It("defaults to a tcp check", func() {
+		hc := &HealthCheck{}
+		hc.SetDefaults()
+
+		Ω(hc).To(Equal(&HealthCheck{Type: "tcp", Interval: 2 * time.Second, Rise: 2, Fall: 3}))
+		Ω(hc.Validate()).To(Succeed())
+	})
+
+	It("defaults http checks to the root path and 200", func() {
+		hc := &HealthCheck{Type: "http", Interval: time.Second}
+		hc.SetDefaults()
+
+		Ω(hc.Path).To(Equal("/"))
+		Ω(hc.ExpectedStatus).To(Equal(200))
+		Ω(hc.Interval).To(Equal(time.Second))
+		Ω(hc.Validate()).To(Succeed())
+	})
+
+	It("rejects invalid checks", func() {
+		invalid := []HealthCheck{
+			{Type: "udp", Interval: time.Second, Rise: 1, Fall: 1},
+			{Type: "tcp", Path: "/", Interval: time.Second, Rise: 1, Fall: 1},
+			{Type: "http", Path: "health", ExpectedStatus: 200, Interval: time.Second, Rise: 1, Fall: 1},
+			{Type: "http", Path: "/", ExpectedStatus: 999, Interval: time.Second, Rise: 1, Fall: 1},
+			{Type: "tcp", Interval: time.Millisecond, Rise: 1, Fall: 1},
+			{Type: "tcp", Interval: time.Second, Fall: 1},
+		}
+
+		for _, hc := range invalid {
+			Ω(hc.Validate()).ToNot(Succeed(), "%#v", hc)
+		}
+	})
+})
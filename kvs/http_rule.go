@@ -0,0 +1,263 @@
+package kvs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// HTTP rule actions.
+const (
+	// HTTPRuleSetHeader sets a header, replacing any existing values.
+	HTTPRuleSetHeader = "set-header"
+	// HTTPRuleAddHeader adds a header value.
+	HTTPRuleAddHeader = "add-header"
+	// HTTPRuleDelHeader removes a header.
+	HTTPRuleDelHeader = "del-header"
+	// HTTPRuleRedirectHTTPS redirects plain HTTP requests to HTTPS.
+	HTTPRuleRedirectHTTPS = "redirect-https"
+	// HTTPRuleRedirectHost redirects requests for any other host to Host,
+	// keeping the scheme and path.
+	HTTPRuleRedirectHost = "redirect-host"
+	// HTTPRuleHSTS sets the Strict-Transport-Security header of HTTPS
+	// responses.
+	HTTPRuleHSTS = "hsts"
+)
+
+// Header rule directions.
+const (
+	HTTPRuleRequest  = "request"
+	HTTPRuleResponse = "response"
+)
+
+// HTTP rule defaults.
+const (
+	defaultRedirectCode = 301
+	defaultHSTSMaxAge   = 31536000
+)
+
+// HTTPRule rewrites the requests or responses of an HTTP service. Header
+// rules change a header in Direction; Value is a haproxy log-format string,
+// so it can contain sample fetches like "%[ssl_fc]". Redirect rules answer
+// with Code. HSTS rules tell browsers to only use HTTPS for MaxAge seconds.
+type HTTPRule struct {
+	ID                string `json:"id"`
+	Action            string `json:"action"`
+	Direction         string `json:"direction,omitempty"`
+	Header            string `json:"header,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Host              string `json:"host,omitempty"`
+	Code              int    `json:"code,omitempty"`
+	MaxAge            int    `json:"max_age,omitempty"`
+	IncludeSubdomains bool   `json:"include_subdomains,omitempty"`
+}
+
+// SetDefaults fills in the settings which weren't supplied.
+func (r *HTTPRule) SetDefaults() {
+	switch r.Action {
+	case HTTPRuleSetHeader, HTTPRuleAddHeader, HTTPRuleDelHeader:
+		if r.Direction == "" {
+			r.Direction = HTTPRuleRequest
+		}
+	case HTTPRuleRedirectHTTPS, HTTPRuleRedirectHost:
+		if r.Code == 0 {
+			r.Code = defaultRedirectCode
+		}
+	case HTTPRuleHSTS:
+		if r.MaxAge == 0 {
+			r.MaxAge = defaultHSTSMaxAge
+		}
+	}
+}
+
+// Validate makes sure an HTTP rule can be used.
+func (r *HTTPRule) Validate() error {
+	switch r.Action {
+	case HTTPRuleSetHeader, HTTPRuleAddHeader, HTTPRuleDelHeader:
+		if r.Direction != HTTPRuleRequest && r.Direction != HTTPRuleResponse {
+			return fmt.Errorf("invalid http rule direction %q", r.Direction)
+		}
+
+		if !headerPattern.MatchString(r.Header) {
+			return fmt.Errorf("invalid http rule header %q", r.Header)
+		}
+
+		switch {
+		case r.Action == HTTPRuleDelHeader && r.Value != "":
+			return fmt.Errorf("%s rules don't have a value", r.Action)
+		case r.Action != HTTPRuleDelHeader && r.Value == "":
+			return fmt.Errorf("%s rules need a value", r.Action)
+		case strings.ContainsAny(r.Value, "\r\n"):
+			return fmt.Errorf("invalid http rule value %q", r.Value)
+		}
+	case HTTPRuleRedirectHTTPS:
+		return validateRedirectCode(r.Code)
+	case HTTPRuleRedirectHost:
+		if !hostPattern.MatchString(r.Host) || strings.HasPrefix(r.Host, "*.") {
+			return fmt.Errorf("invalid http rule redirect host %q", r.Host)
+		}
+
+		return validateRedirectCode(r.Code)
+	case HTTPRuleHSTS:
+		if r.MaxAge < 1 {
+			return fmt.Errorf("hsts max age must be at least 1 second")
+		}
+	default:
+		return fmt.Errorf("unknown http rule action %q", r.Action)
+	}
+
+	return nil
+}
+
+func validateRedirectCode(code int) error {
+	switch code {
+	case 301, 302, 303, 307, 308:
+		return nil
+	default:
+		return fmt.Errorf("invalid redirect code %d", code)
+	}
+}
+
+// encodeHTTPRules encodes the HTTP rules of a service as a JSON list.
+func encodeHTTPRules(rules []HTTPRule) (string, error) {
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// parseHTTPRules decodes HTTP rules encoded by encodeHTTPRules.
+func parseHTTPRules(value string) ([]HTTPRule, error) {
+	rules := []HTTPRule{}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("invalid http rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+// AddHTTPRule appends an HTTP rule to the rules of an HTTP service. It
+// returns the id of the rule.
+func (h *LiveHaproxy) AddHTTPRule(app string, r HTTPRule) (string, error) {
+	r.ID = h.IDGen()
+
+	err := h.changeHTTPRules(app, func(rules []HTTPRule) ([]HTTPRule, error) {
+		return append(rules, r), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return r.ID, nil
+}
+
+// UpdateHTTPRule replaces an HTTP rule. The rule keeps its id and position.
+func (h *LiveHaproxy) UpdateHTTPRule(app, id string, r HTTPRule) error {
+	r.ID = id
+
+	return h.changeHTTPRules(app, func(rules []HTTPRule) ([]HTTPRule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				rules[i] = r
+				return rules, nil
+			}
+		}
+
+		return nil, httpRuleNotFound(app, id)
+	})
+}
+
+// DeleteHTTPRule removes an HTTP rule.
+func (h *LiveHaproxy) DeleteHTTPRule(app, id string) error {
+	return h.changeHTTPRules(app, func(rules []HTTPRule) ([]HTTPRule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+
+		return nil, httpRuleNotFound(app, id)
+	})
+}
+
+// changeHTTPRules applies change to the HTTP rules of a service and stores
+// them. It fails if the service or its rules changed in the meantime.
+func (h *LiveHaproxy) changeHTTPRules(app string, change func([]HTTPRule) ([]HTTPRule, error)) error {
+	isTCP, err := h.isTCPService(app)
+	if err != nil {
+		return err
+	}
+
+	if isTCP {
+		return fmt.Errorf("service %s is a tcp service; tcp services don't have http rules", app)
+	}
+
+	portKey := h.serviceKey(app, "/port")
+	portNode, err := h.Get(portKey, nil)
+	if err != nil {
+		return err
+	}
+
+	ops := []TxnOp{
+		// the service must not be deleted while its rules are changed.
+		{Action: TxnCheck, Key: portKey, PrevIndex: portNode.ModifiedIndex},
+	}
+
+	key := h.serviceKey(app, "/http_rules")
+	set := TxnOp{Action: TxnSet, Key: key, IfNotExist: true}
+	rules := []HTTPRule{}
+	node, err := h.Get(key, nil)
+	switch {
+	case err == nil:
+		set = TxnOp{Action: TxnSet, Key: key, PrevIndex: node.ModifiedIndex}
+		rules, err = parseHTTPRules(node.Value)
+		if err != nil {
+			return err
+		}
+	case !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return err
+	}
+
+	rules, err = change(rules)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	set.Value, err = encodeHTTPRules(rules)
+	if err != nil {
+		return err
+	}
+
+	return h.Txn(append(ops, set))
+}
+
+// findHTTPRules reads the HTTP rules of an HTTP service.
+func (h *LiveHaproxy) findHTTPRules(name string) ([]HTTPRule, error) {
+	node, err := h.Get(h.serviceKey(name, "/http_rules"), nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return []HTTPRule{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHTTPRules(node.Value)
+}
+
+// httpRuleNotFound is the error returned for a missing HTTP rule. It is a key
+// not found error so callers can tell it apart from invalid rules.
+func httpRuleNotFound(app, id string) error {
+	key := fmt.Sprintf("%s/http_rules/%s", app, id)
+	return &KVError{Key: key, Err: newEtcdError(etcdclient.ErrorCodeKeyNotFound, key, 0)}
+}
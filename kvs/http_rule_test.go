@@ -0,0 +1,123 @@
+package kvs_test
+
+import (
+	"golang.org/x/net/context"
+
+	. "github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/pkg/app"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPRule", func() {
+
+	It("fills in defaults", func() {
+		header := &HTTPRule{Action: "set-header", Header: "X-Forwarded-Proto", Value: "https"}
+		header.SetDefaults()
+		Ω(header.Direction).To(Equal("request"))
+		Ω(header.Validate()).To(Succeed())
+
+		redirect := &HTTPRule{Action: "redirect-https"}
+		redirect.SetDefaults()
+		Ω(redirect.Code).To(Equal(301))
+		Ω(redirect.Validate()).To(Succeed())
+
+		hsts := &HTTPRule{Action: "hsts"}
+		hsts.SetDefaults()
+		Ω(hsts.MaxAge).To(Equal(31536000))
+		Ω(hsts.Validate()).To(Succeed())
+	})
+
+	It("rejects invalid rules", func() {
+		invalid := []HTTPRule{
+			{Action: "rewrite"},
+			{Action: "set-header", Direction: "both", Header: "X-A", Value: "a"},
+			{Action: "set-header", Direction: "request", Header: "X A", Value: "a"},
+			{Action: "del-header", Direction: "request", Header: "X#A"},
+			{Action: "set-header", Direction: "request", Header: "X-A"},
+			{Action: "add-header", Direction: "request", Header: "X-A", Value: "a\r\nX-B: b"},
+			{Action: "del-header", Direction: "response", Header: "Server", Value: "a"},
+			{Action: "redirect-https", Code: 200},
+			{Action: "redirect-host", Host: "*.example.com", Code: 301},
+			{Action: "redirect-host", Host: "example.com/path", Code: 301},
+			{Action: "hsts", MaxAge: -1},
+		}
+
+		for _, r := range invalid {
+			Ω(r.Validate()).ToNot(Succeed(), "%#v", r)
+		}
+	})
+
+	Describe("storing rules", func() {
+
+		var (
+			cancel  context.CancelFunc
+			haproxy *LiveHaproxy
+			ids     int
+		)
+
+		BeforeEach(func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			ids = 0
+			idGen := func() string {
+				ids++
+				return string('a' + rune(ids-1))
+			}
+			haproxy = NewLiveHaproxy(NewMemory(ctx), idGen, app.DefaultLogger())
+			Ω(haproxy.Init()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("keeps rules in order", func() {
+			Ω(haproxy.Domain("service-a", "example.com", 80)).To(Succeed())
+
+			redirect := HTTPRule{Action: "redirect-https", Code: 301}
+			id, err := haproxy.AddHTTPRule("service-a", redirect)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(id).To(Equal("a"))
+
+			header := HTTPRule{Action: "del-header", Direction: "response", Header: "Server"}
+			id, err = haproxy.AddHTTPRule("service-a", header)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(id).To(Equal("b"))
+
+			Ω(haproxy.UpdateHTTPRule("service-a", "a", HTTPRule{Action: "redirect-https", Code: 308})).To(Succeed())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HTTPRules()).To(Equal([]HTTPRule{
+				{ID: "a", Action: "redirect-https", Code: 308},
+				{ID: "b", Action: "del-header", Direction: "response", Header: "Server"},
+			}))
+
+			Ω(haproxy.DeleteHTTPRule("service-a", "a")).To(Succeed())
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.HTTPRules()).To(Equal([]HTTPRule{
+				{ID: "b", Action: "del-header", Direction: "response", Header: "Server"},
+			}))
+		})
+
+		It("reports missing rules as not found", func() {
+			Ω(haproxy.Domain("service-a", "example.com", 80)).To(Succeed())
+
+			err := haproxy.DeleteHTTPRule("service-a", "missing")
+			Ω(IsKeyNotFound(err)).To(BeTrue())
+
+			err = haproxy.UpdateHTTPRule("service-a", "missing", HTTPRule{Action: "hsts", MaxAge: 60})
+			Ω(IsKeyNotFound(err)).To(BeTrue())
+		})
+
+		It("rejects rules for tcp services", func() {
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+
+			_, err := haproxy.AddHTTPRule("service-b", HTTPRule{Action: "redirect-https", Code: 301})
+			Ω(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,221 @@
+package kvs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+const (
+	// keyringKeySize is the size of keyring keys. They are AES-256 keys.
+	keyringKeySize = 32
+)
+
+// Keyring is a set of key encryption keys. New values are encrypted with the
+// primary key. The other keys are kept so values encrypted before a rotation
+// can still be decrypted.
+type Keyring struct {
+	Primary string            `json:"primary"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// NewKeyring builds a Keyring with a new random primary key.
+func NewKeyring() (*Keyring, error) {
+	kr := &Keyring{Keys: map[string][]byte{}}
+	if _, err := kr.Rotate(); err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring reads a JSON encoded keyring from a file.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kr Keyring
+	if err := json.Unmarshal(b, &kr); err != nil {
+		return nil, fmt.Errorf("could not decode keyring: %v", err)
+	}
+
+	if err := kr.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &kr, nil
+}
+
+// Rotate adds a new random key to the keyring and makes it the primary key.
+// It returns the id of the new key.
+func (kr *Keyring) Rotate() (string, error) {
+	key := make([]byte, keyringKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	if kr.Keys == nil {
+		kr.Keys = map[string][]byte{}
+	}
+
+	kr.Primary = hex.EncodeToString(id)
+	kr.Keys[kr.Primary] = key
+
+	return kr.Primary, nil
+}
+
+// Validate makes sure a keyring can be used for encryption.
+func (kr *Keyring) Validate() error {
+	if _, ok := kr.Keys[kr.Primary]; !ok {
+		return fmt.Errorf("keyring primary key %q does not exist", kr.Primary)
+	}
+
+	for id, key := range kr.Keys {
+		if len(key) != keyringKeySize {
+			return fmt.Errorf("keyring key %q is %d bytes; expected %d", id, len(key), keyringKeySize)
+		}
+	}
+
+	return nil
+}
+
+// Sign signs msg with the primary key. The server signs its requests to a
+// cluster's agents with the cluster keyring. The signature names the key,
+// so it can still be verified after the keyring was rotated.
+func (kr *Keyring) Sign(msg []byte) string {
+	return kr.Primary + ":" + hex.EncodeToString(kr.mac(kr.Primary, msg))
+}
+
+// Verify returns true if sig is a signature of msg made by Sign with one of
+// the keyring's keys.
+func (kr *Keyring) Verify(msg []byte, sig string) bool {
+	parts := strings.SplitN(sig, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	if _, ok := kr.Keys[parts[0]]; !ok {
+		return false
+	}
+
+	mac, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac, kr.mac(parts[0], msg))
+}
+
+// mac computes the HMAC of msg with a signing key derived from key id, so
+// the key encryption keys aren't used for signing themselves.
+func (kr *Keyring) mac(id string, msg []byte) []byte {
+	derive := hmac.New(sha256.New, kr.Keys[id])
+	derive.Write([]byte("dolb request signing"))
+
+	m := hmac.New(sha256.New, derive.Sum(nil))
+	m.Write(msg)
+	return m.Sum(nil)
+}
+
+// ClusterKeyring returns the keyring stored in key, creating it if it
+// doesn't exist.
+func ClusterKeyring(backend KVS, key string) (*Keyring, error) {
+	node, err := backend.Get(key, nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		kr, err := NewKeyring()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(kr)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = backend.Set(key, string(b), &SetOptions{IfNotExist: true})
+		if _, ok := err.(*NodeExistError); ok {
+			// created concurrently.
+			return ClusterKeyring(backend, key)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return kr, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var kr Keyring
+	if err := json.Unmarshal([]byte(node.Value), &kr); err != nil {
+		return nil, fmt.Errorf("could not decode keyring: %v", err)
+	}
+
+	if err := kr.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &kr, nil
+}
+
+// RotateClusterKeyring adds a new primary key to the keyring stored in key.
+// Values encrypted with the old primary key can still be decrypted. It
+// returns the rotated keyring.
+func RotateClusterKeyring(backend KVS, key string) (*Keyring, error) {
+	for {
+		node, err := backend.Get(key, nil)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			if _, err := ClusterKeyring(backend, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var kr Keyring
+		if err := json.Unmarshal([]byte(node.Value), &kr); err != nil {
+			return nil, fmt.Errorf("could not decode keyring: %v", err)
+		}
+
+		if _, err := kr.Rotate(); err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(&kr)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = backend.Set(key, string(b), &SetOptions{PrevIndex: node.ModifiedIndex})
+		if isKVError(err, etcdclient.ErrorCodeTestFailed) {
+			// rotated concurrently.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &kr, nil
+	}
+}
@@ -0,0 +1,187 @@
+package kvs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// etcdErrorMessages are the messages etcd uses for its error codes.
+var etcdErrorMessages = map[int]string{
+	etcdclient.ErrorCodeKeyNotFound: "Key not found",
+	etcdclient.ErrorCodeTestFailed:  "Compare failed",
+	etcdclient.ErrorCodeNotFile:     "Not a file",
+	etcdclient.ErrorCodeNotDir:      "Not a directory",
+	etcdclient.ErrorCodeNodeExist:   "Key already exists",
+	etcdclient.ErrorCodeRootROnly:   "Root is read only",
+}
+
+// newEtcdError creates an etcd error. Backends that aren't etcd v2 use it so
+// callers can inspect errors the same way regardless of backend.
+func newEtcdError(code int, key string, index uint64) error {
+	return etcdclient.Error{
+		Code:    code,
+		Message: etcdErrorMessages[code],
+		Cause:   key,
+		Index:   index,
+	}
+}
+
+// isEtcdError returns true if err is an etcd error with one of the given
+// codes.
+func isEtcdError(err error, codes ...int) bool {
+	eerr, ok := err.(etcdclient.Error)
+	if !ok {
+		return false
+	}
+
+	for _, code := range codes {
+		if eerr.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsKeyNotFound returns true if err was caused by a key which doesn't exist.
+func IsKeyNotFound(err error) bool {
+	return isKVError(err, etcdclient.ErrorCodeKeyNotFound)
+}
+
+// isKVError returns true if err is a kvs error caused by an etcd error with
+// one of the given codes.
+func isKVError(err error, codes ...int) bool {
+	return isEtcdError(txnCause(err, "", 0), codes...)
+}
+
+// txnCause extracts the underlying error from an error returned by a kvs
+// operation so it can be reported in a TxnError.
+func txnCause(err error, key string, index uint64) error {
+	switch e := err.(type) {
+	case *KVError:
+		return e.Err
+	case *KVDeleteError:
+		return e.Err
+	case *MkdirError:
+		return e.Err
+	case *NodeExistError:
+		return newEtcdError(etcdclient.ErrorCodeNodeExist, key, index)
+	default:
+		return err
+	}
+}
+
+// validateTxn makes sure every operation in a transaction has a known
+// action.
+func validateTxn(ops []TxnOp) error {
+	for i, op := range ops {
+		switch op.Action {
+		case TxnCheck, TxnSet, TxnDelete, TxnRmdir:
+		default:
+			return &TxnError{Op: i, Key: op.Key, Err: fmt.Errorf("unknown transaction action %q", op.Action)}
+		}
+	}
+
+	return nil
+}
+
+// normalizeKey normalizes a key so it always starts with a slash and never
+// ends with one.
+func normalizeKey(key string) string {
+	return path.Clean("/" + key)
+}
+
+// keyParts splits a key into its path components.
+func keyParts(key string) []string {
+	trimmed := strings.Trim(key, "/")
+	if trimmed == "" {
+		return []string{}
+	}
+
+	return strings.Split(trimmed, "/")
+}
+
+// isBelowKey returns true if key is below dir.
+func isBelowKey(key, dir string) bool {
+	if dir == "/" {
+		return key != "/"
+	}
+
+	return strings.HasPrefix(key, dir+"/")
+}
+
+// childPrefix is the prefix of all keys below key.
+func childPrefix(key string) string {
+	if key == "/" {
+		return key
+	}
+
+	return key + "/"
+}
+
+// buildDir builds a directory tree from a flat list of the nodes below dir.
+// Directories without a node of their own are created as needed.
+func buildDir(dir string, flat Nodes) *Node {
+	root := &Node{Key: dir, Dir: true, Nodes: Nodes{}}
+	dirs := map[string]*Node{dir: root}
+	marked := map[string]bool{}
+
+	var ensureDir func(string) *Node
+	ensureDir = func(key string) *Node {
+		if n, ok := dirs[key]; ok {
+			return n
+		}
+
+		n := &Node{Key: key, Dir: true, Nodes: Nodes{}}
+		dirs[key] = n
+
+		parent := ensureDir(path.Dir(key))
+		parent.Nodes = append(parent.Nodes, n)
+
+		return n
+	}
+
+	for _, n := range flat {
+		if n.Dir {
+			d := ensureDir(n.Key)
+			d.CreatedIndex = n.CreatedIndex
+			d.ModifiedIndex = n.ModifiedIndex
+			marked[d.Key] = true
+			continue
+		}
+
+		parent := ensureDir(path.Dir(n.Key))
+		parent.Nodes = append(parent.Nodes, n)
+	}
+
+	finishDir(root, marked)
+
+	return root
+}
+
+// finishDir sorts a directory tree and gives directories without a marker
+// the index of their oldest child.
+func finishDir(n *Node, marked map[string]bool) {
+	sort.Sort(nodesByKey(n.Nodes))
+
+	for _, child := range n.Nodes {
+		if child.Dir {
+			finishDir(child, marked)
+		}
+
+		if !marked[n.Key] && (n.CreatedIndex == 0 || child.CreatedIndex < n.CreatedIndex) {
+			n.CreatedIndex = child.CreatedIndex
+			n.ModifiedIndex = child.CreatedIndex
+		}
+	}
+}
+
+type nodesByKey Nodes
+
+func (s nodesByKey) Len() int           { return len(s) }
+func (s nodesByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s nodesByKey) Less(i, j int) bool { return s[i].Key < s[j].Key }
@@ -23,6 +23,16 @@ type KVS interface {
 	Mkdir(dir string) error
 	Rmdir(dir string) error
 	Set(key, value string, options *SetOptions) (*Node, error)
+	Txn(ops []TxnOp) error
+	Watch(key string, recursive bool) (<-chan Event, error)
+}
+
+// Event is a change to a key in the kvs. Watchers should treat events as
+// notifications and re-read the state they care about.
+type Event struct {
+	Action   string
+	Node     *Node
+	PrevNode *Node
 }
 
 // GetOptions are options for get operations.
@@ -36,3 +46,28 @@ type SetOptions struct {
 	PrevIndex  uint64
 	IfNotExist bool
 }
+
+// Transaction operation actions.
+const (
+	// TxnCheck compares a key without changing it.
+	TxnCheck = "check"
+	// TxnSet creates or updates a key.
+	TxnSet = "set"
+	// TxnDelete deletes a key.
+	TxnDelete = "delete"
+	// TxnRmdir removes a directory recursively.
+	TxnRmdir = "rmdir"
+)
+
+// TxnOp is an operation in a transaction. If IfNotExist is set, the key must
+// not exist. Otherwise check, delete and rmdir operations require the key to
+// exist, and if PrevIndex is set, the key must have been last modified at
+// PrevIndex. A key should appear in at most one write operation per
+// transaction.
+type TxnOp struct {
+	Action     string
+	Key        string
+	Value      string
+	PrevIndex  uint64
+	IfNotExist bool
+}
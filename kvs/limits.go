@@ -0,0 +1,164 @@
+package kvs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// Limit actions. They say what happens to clients over a limit.
+const (
+	// LimitDeny rejects requests and connections over a limit.
+	LimitDeny = "deny"
+	// LimitTarpit holds requests over a limit open before rejecting them,
+	// which slows down abusive clients. Only HTTP services can tarpit.
+	LimitTarpit = "tarpit"
+)
+
+// Limits protect the upstreams of a service from abusive clients.
+// RequestRate is the number of requests per second a source IP can make,
+// and ConnectionsPerSource is the number of concurrent connections a source
+// IP can have open. UpstreamMaxConn is the number of concurrent connections
+// each upstream is sent; the others are queued. Limits which are 0 aren't
+// enforced.
+type Limits struct {
+	RequestRate          int
+	ConnectionsPerSource int
+	UpstreamMaxConn      int
+	Action               string
+}
+
+// SetDefaults fills in the settings which weren't supplied.
+func (l *Limits) SetDefaults() {
+	if l.Action == "" {
+		l.Action = LimitDeny
+	}
+}
+
+// Validate makes sure limits can be used by a service of type serviceType.
+func (l *Limits) Validate(serviceType string) error {
+	if l.RequestRate < 0 || l.ConnectionsPerSource < 0 || l.UpstreamMaxConn < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+
+	if l.RequestRate == 0 && l.ConnectionsPerSource == 0 && l.UpstreamMaxConn == 0 {
+		return fmt.Errorf("supply at least one limit")
+	}
+
+	switch l.Action {
+	case LimitDeny:
+	case LimitTarpit:
+		if serviceType == "tcp" {
+			return fmt.Errorf("tcp services can't tarpit")
+		}
+	default:
+		return fmt.Errorf("unknown limit action %q", l.Action)
+	}
+
+	if serviceType == "tcp" && l.RequestRate != 0 {
+		return fmt.Errorf("tcp services can't limit the request rate")
+	}
+
+	return nil
+}
+
+// SetLimits replaces the limits of a service. If l is nil, the service isn't
+// limited.
+func (h *LiveHaproxy) SetLimits(app string, l *Limits) error {
+	isTCP, err := h.isTCPService(app)
+	if err != nil {
+		return err
+	}
+
+	serviceType := "http"
+	dir := h.serviceKey(app, "")
+	if isTCP {
+		serviceType = "tcp"
+		dir = h.tcpServiceKey(app, "")
+	}
+
+	portNode, err := h.Get(dir+"/port", nil)
+	if err != nil {
+		return err
+	}
+
+	limitsOps, err := h.limitsOps(dir, serviceType, l)
+	if err != nil {
+		return err
+	}
+
+	ops := []TxnOp{
+		// the service must not be deleted while the limits are changed.
+		{Action: TxnCheck, Key: dir + "/port", PrevIndex: portNode.ModifiedIndex},
+	}
+
+	return h.Txn(append(ops, limitsOps...))
+}
+
+// limitsOps builds the operations which replace the limits in the service
+// directory dir. If l is nil, the existing limits are removed.
+func (h *LiveHaproxy) limitsOps(dir, serviceType string, l *Limits) ([]TxnOp, error) {
+	ops := []TxnOp{}
+
+	key := dir + "/limits"
+	_, err := h.Get(key, nil)
+	switch {
+	case err == nil:
+		ops = append(ops, TxnOp{Action: TxnRmdir, Key: key})
+	case !isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return nil, err
+	}
+
+	if l == nil {
+		return ops, nil
+	}
+
+	if err := l.Validate(serviceType); err != nil {
+		return nil, err
+	}
+
+	return append(ops,
+		TxnOp{Action: TxnSet, Key: key + "/request_rate", Value: strconv.Itoa(l.RequestRate)},
+		TxnOp{Action: TxnSet, Key: key + "/connections_per_source", Value: strconv.Itoa(l.ConnectionsPerSource)},
+		TxnOp{Action: TxnSet, Key: key + "/upstream_maxconn", Value: strconv.Itoa(l.UpstreamMaxConn)},
+		TxnOp{Action: TxnSet, Key: key + "/action", Value: l.Action},
+	), nil
+}
+
+// findLimits reads the limits in the service directory dir. It returns nil
+// if the service isn't limited.
+func (h *LiveHaproxy) findLimits(dir string) (*Limits, error) {
+	key := dir + "/limits"
+	node, err := h.Get(key, &GetOptions{Recursive: true})
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Limits{}
+	for _, n := range node.Nodes {
+		field := strings.TrimPrefix(n.Key, key+"/")
+
+		switch field {
+		case "request_rate":
+			l.RequestRate, err = strconv.Atoi(n.Value)
+		case "connections_per_source":
+			l.ConnectionsPerSource, err = strconv.Atoi(n.Value)
+		case "upstream_maxconn":
+			l.UpstreamMaxConn, err = strconv.Atoi(n.Value)
+		case "action":
+			l.Action = n.Value
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %s: %v", field, err)
+		}
+	}
+
+	return l, nil
+}
@@ -0,0 +1,85 @@
+package kvs_test
+
+import (
+	"golang.org/x/net/context"
+
+	. "github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/pkg/app"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+
+	It("defaults to denying", func() {
+		l := &Limits{RequestRate: 10}
+		l.SetDefaults()
+
+		Ω(l).To(Equal(&Limits{RequestRate: 10, Action: "deny"}))
+		Ω(l.Validate("http")).To(Succeed())
+	})
+
+	It("rejects invalid limits", func() {
+		invalid := []struct {
+			serviceType string
+			limits      Limits
+		}{
+			{"http", Limits{Action: "deny"}},
+			{"http", Limits{RequestRate: -1, Action: "deny"}},
+			{"http", Limits{RequestRate: 10, Action: "drop"}},
+			{"tcp", Limits{RequestRate: 10, Action: "deny"}},
+			{"tcp", Limits{ConnectionsPerSource: 10, Action: "tarpit"}},
+		}
+
+		for _, i := range invalid {
+			Ω(i.limits.Validate(i.serviceType)).ToNot(Succeed(), "%s %#v", i.serviceType, i.limits)
+		}
+	})
+
+	Describe("storing limits", func() {
+
+		var (
+			cancel  context.CancelFunc
+			haproxy *LiveHaproxy
+		)
+
+		BeforeEach(func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			haproxy = NewLiveHaproxy(NewMemory(ctx), func() string { return "1" }, app.DefaultLogger())
+			Ω(haproxy.Init()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("stores limits alongside the matcher", func() {
+			Ω(haproxy.Domain("service-a", "example.com", 80)).To(Succeed())
+
+			l := &Limits{RequestRate: 20, ConnectionsPerSource: 10, UpstreamMaxConn: 100, Action: "tarpit"}
+			Ω(haproxy.SetLimits("service-a", l)).To(Succeed())
+
+			svc, err := haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Limits()).To(Equal(l))
+			Ω(svc.ServiceConfig()["domain"]).To(Equal("example.com"))
+
+			Ω(haproxy.SetLimits("service-a", nil)).To(Succeed())
+			svc, err = haproxy.Service("service-a")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Limits()).To(BeNil())
+		})
+
+		It("validates limits by service type", func() {
+			Ω(haproxy.TCP("service-b", 5432)).To(Succeed())
+			Ω(haproxy.SetLimits("service-b", &Limits{RequestRate: 20, Action: "deny"})).ToNot(Succeed())
+			Ω(haproxy.SetLimits("service-b", &Limits{ConnectionsPerSource: 5, Action: "deny"})).To(Succeed())
+
+			svc, err := haproxy.Service("service-b")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(svc.Limits()).To(Equal(&Limits{ConnectionsPerSource: 5, Action: "deny"}))
+		})
+	})
+})
@@ -1,29 +1,68 @@
 package kvs
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
 
 const (
 	// baseLockDir is the base directory for locks.
 	baseLockDir = "/dolb/locks"
+
+	// baseFenceDir is the base directory for the largest fencing tokens
+	// written under locks.
+	baseFenceDir = "/dolb/fences"
 )
 
 var (
 	// lockRetryTimeout is the timeout for when a lock is active. It
 	// will wait for lockRetryTimeout and try again.
 	lockRetryTimeout = time.Millisecond * 100
+
+	// ErrLockHeld is returned when locking a lock which is already held.
+	ErrLockHeld = errors.New("lock is already held")
+
+	// ErrLockNotHeld is returned when unlocking a lock which isn't held.
+	ErrLockNotHeld = errors.New("lock is not held")
+
+	// ErrLockLost is returned when unlocking a lock which expired or was
+	// taken by someone else while it was held.
+	ErrLockLost = errors.New("lock was lost while it was held")
+
+	// ErrStaleToken is returned when fencing a write with a token older
+	// than the token of a later holder of the lock.
+	ErrStaleToken = errors.New("fencing token is stale")
 )
 
 // KLock is an interface for proving locks.
 type KLock interface {
-	Lock(time.Duration) error
+	Lock(ctx context.Context, ttl time.Duration) (uint64, error)
+	Lost() <-chan struct{}
+	FenceOp(token uint64) (TxnOp, error)
 	Unlock() error
 }
 
-// KLock provides a distributed lock service based on a key value store.
+// Lock provides a distributed lock service based on a key value store.
+// While the lock is held, its TTL is renewed in the background.
 type Lock struct {
 	KVS
 	BaseDir string
 	Item    string
+	Owner   string
+
+	mu    sync.Mutex
+	held  bool
+	index uint64
+	token uint64
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
 }
 
 var _ KLock = &Lock{}
@@ -37,24 +76,88 @@ func NewLock(item string, kvs KVS) *Lock {
 	}
 }
 
-// Lock locks the lock by setting a key. If the key exists, it'll retry
-// 100 milliseconds later (by default)
-func (el *Lock) Lock(d time.Duration) error {
+// Lock acquires the lock. If someone else holds the lock, it'll retry 100
+// milliseconds later (by default) until ctx is done. It returns a fencing
+// token which is larger than the token of any previous holder of the lock.
+func (el *Lock) Lock(ctx context.Context, ttl time.Duration) (uint64, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	if el.held {
+		return 0, ErrLockHeld
+	}
+
 	for {
-		opts := &SetOptions{TTL: d, IfNotExist: true}
-		_, err := el.Set(el.key(), el.Item, opts)
-		if eerr, ok := err.(*NodeExistError); !ok {
-			return eerr
+		opts := &SetOptions{TTL: ttl, IfNotExist: true}
+		node, err := el.Set(el.key(), el.owner(), opts)
+		if err == nil {
+			el.held = true
+			el.index = node.ModifiedIndex
+			el.token = node.ModifiedIndex
+			el.stop = make(chan struct{})
+			el.done = make(chan struct{})
+			el.lost = make(chan struct{})
+
+			go el.renew(ttl, el.index, el.stop, el.done, el.lost)
+
+			return el.token, nil
 		}
 
-		if err == nil {
-			break
+		if _, ok := err.(*NodeExistError); !ok {
+			return 0, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(lockRetryTimeout):
 		}
+	}
+}
+
+// Token returns the fencing token of the held lock.
+func (el *Lock) Token() uint64 {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	return el.token
+}
+
+// Lost returns a channel which is closed if the lock expires or is taken
+// by someone else while it is held.
+func (el *Lock) Lost() <-chan struct{} {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	return el.lost
+}
+
+// FenceOp builds the operation which fences a write with token, the fencing
+// token returned by Lock. Applied in the same transaction as the write, it
+// makes the write fail if a holder with a larger token has written since,
+// and stores token so writes of earlier holders fail.
+func (el *Lock) FenceOp(token uint64) (TxnOp, error) {
+	key := baseFenceDir + "/" + el.Item
+	value := strconv.FormatUint(token, 10)
 
-		time.Sleep(lockRetryTimeout)
+	node, err := el.Get(key, nil)
+	switch {
+	case isKVError(err, etcdclient.ErrorCodeKeyNotFound):
+		return TxnOp{Action: TxnSet, Key: key, Value: value, IfNotExist: true}, nil
+	case err != nil:
+		return TxnOp{}, err
 	}
 
-	return nil
+	latest, err := strconv.ParseUint(node.Value, 10, 64)
+	if err != nil {
+		return TxnOp{}, fmt.Errorf("invalid fencing token %q: %v", node.Value, err)
+	}
+
+	if latest > token {
+		return TxnOp{}, ErrStaleToken
+	}
+
+	return TxnOp{Action: TxnSet, Key: key, Value: value, PrevIndex: node.ModifiedIndex}, nil
 }
 
 func (el *Lock) IsLocked() bool {
@@ -62,11 +165,79 @@ func (el *Lock) IsLocked() bool {
 	return err == nil
 }
 
-// Unlock the lock by deleting the key in kvs.
+// Unlock stops renewing the lock and deletes its key, unless the key now
+// belongs to someone else.
 func (el *Lock) Unlock() error {
-	return el.Delete(el.key())
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	if !el.held {
+		return ErrLockNotHeld
+	}
+
+	close(el.stop)
+	<-el.done
+
+	el.held = false
+	el.token = 0
+
+	select {
+	case <-el.lost:
+		return ErrLockLost
+	default:
+	}
+
+	err := el.Txn([]TxnOp{{Action: TxnDelete, Key: el.key(), PrevIndex: el.index}})
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound, etcdclient.ErrorCodeTestFailed) {
+		return ErrLockLost
+	}
+
+	return err
+}
+
+// renew refreshes the lock's TTL until stop is closed. If the lock key was
+// changed by someone else, lost is closed and renewal stops. The index of
+// the lock key is stored in el.index when renewal stops.
+func (el *Lock) renew(ttl time.Duration, index uint64, stop, done, lost chan struct{}) {
+	defer close(done)
+	defer func() { el.index = index }()
+
+	// keys without a TTL don't need renewing.
+	if ttl <= 0 {
+		<-stop
+		return
+	}
+
+	t := time.NewTicker(ttl / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			opts := &SetOptions{TTL: ttl, PrevIndex: index}
+			node, err := el.Set(el.key(), el.owner(), opts)
+			switch {
+			case err == nil:
+				index = node.ModifiedIndex
+			case isKVError(err, etcdclient.ErrorCodeKeyNotFound, etcdclient.ErrorCodeTestFailed):
+				close(lost)
+				<-stop
+				return
+			}
+		}
+	}
 }
 
 func (el *Lock) key() string {
 	return el.BaseDir + "/" + el.Item
 }
+
+func (el *Lock) owner() string {
+	if el.Owner == "" {
+		return el.Item
+	}
+
+	return el.Owner
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	. "github.com/bryanl/dolb/kvs"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -15,7 +17,7 @@ var _ = Describe("Lock", func() {
 	var (
 		err          error
 		lock         *Lock
-		lockDuration = 100 * time.Millisecond
+		lockDuration = time.Minute
 		kvs          *MockKVS
 		item         string
 	)
@@ -32,37 +34,84 @@ var _ = Describe("Lock", func() {
 
 	Describe("Lock", func() {
 
+		var (
+			token  uint64
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+		})
+
 		JustBeforeEach(func() {
-			err = lock.Lock(lockDuration)
+			token, err = lock.Lock(ctx, lockDuration)
+		})
+
+		AfterEach(func() {
+			if err == nil {
+				kvs.On("Txn", mock.Anything).Return(nil)
+				lock.Unlock()
+			}
 		})
 
 		Context("locking without error", func() {
 
 			BeforeEach(func() {
 				opts := &SetOptions{TTL: lockDuration, IfNotExist: true}
-				node := &Node{}
+				node := &Node{ModifiedIndex: 7}
 				kvs.On("Set", "/dolb/locks/foo", item, opts).Return(node, nil)
 			})
 
-			It("doesn't return an error", func() {
+			It("returns the fencing token", func() {
 				Ω(err).ToNot(HaveOccurred())
+				Ω(token).To(Equal(uint64(7)))
 			})
 		})
 
 		Context("locking with a previously held lock", func() {
 
 			BeforeEach(func() {
-				node := &Node{}
+				node := &Node{ModifiedIndex: 9}
 				opts := &SetOptions{TTL: lockDuration, IfNotExist: true}
 
-				errs := []error{&NodeExistError{}, &NodeExistError{}, nil}
-				for _, e := range errs {
-					kvs.On("Set", "/dolb/locks/foo", item, opts).Return(node, e).Once()
-				}
+				kvs.On("Set", "/dolb/locks/foo", item, opts).Return(nil, &NodeExistError{}).Twice()
+				kvs.On("Set", "/dolb/locks/foo", item, opts).Return(node, nil).Once()
 			})
 
-			It("doesn't return an error", func() {
+			It("waits for the lock", func() {
 				Ω(err).ToNot(HaveOccurred())
+				Ω(token).To(Equal(uint64(9)))
+			})
+		})
+
+		Context("with a kvs error", func() {
+
+			BeforeEach(func() {
+				opts := &SetOptions{TTL: lockDuration, IfNotExist: true}
+				kvs.On("Set", "/dolb/locks/foo", item, opts).Return(nil, errors.New("timeout")).Once()
+			})
+
+			It("returns the error", func() {
+				Ω(err).To(MatchError("timeout"))
+			})
+		})
+
+		Context("when the context is done", func() {
+
+			BeforeEach(func() {
+				ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+
+				opts := &SetOptions{TTL: lockDuration, IfNotExist: true}
+				kvs.On("Set", "/dolb/locks/foo", item, opts).Return(nil, &NodeExistError{})
+			})
+
+			AfterEach(func() {
+				cancel()
+			})
+
+			It("gives up", func() {
+				Ω(err).To(Equal(context.DeadlineExceeded))
 			})
 		})
 
@@ -102,4 +151,104 @@ var _ = Describe("Lock", func() {
 		})
 
 	})
+
+	Context("with a memory kvs", func() {
+
+		var (
+			mem    *Memory
+			other  *Lock
+			ttl    = 100 * time.Millisecond
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			mem = NewMemory(ctx)
+			lock = NewLock(item, mem)
+			lock.Owner = "agent-1"
+			other = NewLock(item, mem)
+			other.Owner = "agent-2"
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("hands out increasing fencing tokens", func() {
+			first, err := lock.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(lock.Unlock()).To(Succeed())
+
+			second, err := other.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(second).To(BeNumerically(">", first))
+			Ω(other.Unlock()).To(Succeed())
+		})
+
+		It("excludes other holders", func() {
+			_, err = lock.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+
+			ctx, cancelLock := context.WithTimeout(context.Background(), 150*time.Millisecond)
+			defer cancelLock()
+			_, err = other.Lock(ctx, time.Second)
+			Ω(err).To(Equal(context.DeadlineExceeded))
+
+			_, err = lock.Lock(context.Background(), time.Second)
+			Ω(err).To(Equal(ErrLockHeld))
+
+			Ω(lock.Unlock()).To(Succeed())
+			Ω(lock.Unlock()).To(Equal(ErrLockNotHeld))
+		})
+
+		It("renews the lock while it is held", func() {
+			_, err = lock.Lock(context.Background(), ttl)
+			Ω(err).ToNot(HaveOccurred())
+
+			Consistently(lock.IsLocked, 3*ttl).Should(BeTrue())
+			Ω(lock.Unlock()).To(Succeed())
+			Ω(lock.IsLocked()).To(BeFalse())
+		})
+
+		It("reports a lost lock", func() {
+			_, err = lock.Lock(context.Background(), ttl)
+			Ω(err).ToNot(HaveOccurred())
+
+			Ω(mem.Delete("/dolb/locks/foo")).To(Succeed())
+			_, err = other.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+
+			Eventually(lock.Lost()).Should(BeClosed())
+			Ω(lock.Unlock()).To(Equal(ErrLockLost))
+
+			node, err := mem.Get("/dolb/locks/foo", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("agent-2"))
+			Ω(other.Unlock()).To(Succeed())
+		})
+
+		It("fences writes of earlier holders", func() {
+			first, err := lock.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+			stale, err := lock.FenceOp(first)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(lock.Unlock()).To(Succeed())
+
+			second, err := other.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+			fence, err := other.FenceOp(second)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(mem.Txn([]TxnOp{fence, {Action: TxnSet, Key: "/data", Value: "second"}})).To(Succeed())
+			Ω(other.Unlock()).To(Succeed())
+
+			Ω(mem.Txn([]TxnOp{stale, {Action: TxnSet, Key: "/data", Value: "first"}})).ToNot(Succeed())
+			_, err = lock.FenceOp(first)
+			Ω(err).To(Equal(ErrStaleToken))
+
+			node, err := mem.Get("/data", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("second"))
+		})
+	})
 })
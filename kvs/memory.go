@@ -0,0 +1,615 @@
+package kvs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+var (
+	// memExpireInterval is how often Memory checks for expired keys.
+	memExpireInterval = 500 * time.Millisecond
+)
+
+// memNode is an entry in the in-memory keyspace.
+type memNode struct {
+	key           string
+	value         string
+	dir           bool
+	children      map[string]*memNode
+	createdIndex  uint64
+	modifiedIndex uint64
+	expiration    *time.Time
+}
+
+// memWatcher is a watch on the in-memory keyspace. Events are queued so
+// writers never block on slow readers.
+type memWatcher struct {
+	key       string
+	recursive bool
+
+	mu      sync.Mutex
+	pending []Event
+	ready   chan struct{}
+}
+
+// matches returns true if a change to key should be reported to the watcher.
+func (w *memWatcher) matches(action, key string) bool {
+	switch {
+	case key == w.key:
+		return true
+	case w.recursive && isBelowKey(key, w.key):
+		return true
+	case (action == "delete" || action == "expire") && isBelowKey(w.key, key):
+		// removing a directory removes everything below it.
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *memWatcher) push(e Event) {
+	w.mu.Lock()
+	w.pending = append(w.pending, e)
+	w.mu.Unlock()
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatcher) drain() []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := w.pending
+	w.pending = nil
+	return events
+}
+
+// Memory is an in-memory kvs. It models the etcd v2 keyspace (directories,
+// indexes, TTLs, compare-and-swap and watches) so it can stand in for etcd
+// in tests and when running locally.
+type Memory struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	index    uint64
+	root     *memNode
+	watchers []*memWatcher
+
+	// now returns the current time. It is used to expire keys.
+	now func() time.Time
+}
+
+var _ KVS = &Memory{}
+
+// NewMemory builds a Memory instance. Expired keys are removed in the
+// background until ctx is done.
+func NewMemory(ctx context.Context) *Memory {
+	m := &Memory{
+		ctx:  ctx,
+		root: &memNode{key: "/", dir: true, children: map[string]*memNode{}},
+		now:  time.Now,
+	}
+
+	go m.expireLoop()
+
+	return m
+}
+
+// Mkdir makes a directory in the kvs.
+func (m *Memory) Mkdir(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	dir = normalizeKey(dir)
+
+	existing, err := m.find(dir)
+	if err == nil && existing.dir {
+		return &MkdirError{Dir: dir, Err: m.error(etcdclient.ErrorCodeNotFile, dir)}
+	}
+
+	var prev *Node
+	if err == nil {
+		prev = m.convertNode(existing, false)
+	}
+
+	m.index++
+	n, err := m.create(dir, "", true, 0)
+	if err != nil {
+		m.index--
+		return &MkdirError{Dir: dir, Err: err}
+	}
+
+	m.notify("set", m.convertNode(n, false), prev)
+
+	return nil
+}
+
+// Set creates or updates a key in the kvs.
+func (m *Memory) Set(key, value string, options *SetOptions) (*Node, error) {
+	if options == nil {
+		options = &SetOptions{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	node, prev, action, err := m.set(normalizeKey(key), value, options)
+	if err != nil {
+		return nil, err
+	}
+
+	m.notify(action, node, prev)
+
+	return node, nil
+}
+
+// set creates or updates a key without notifying watchers. It returns the
+// new node, the node it replaced and the watch action.
+func (m *Memory) set(key, value string, options *SetOptions) (*Node, *Node, string, error) {
+	existing, findErr := m.find(key)
+
+	if options.IfNotExist && findErr == nil {
+		return nil, nil, "", &NodeExistError{Key: key}
+	}
+
+	if options.PrevIndex > 0 {
+		switch {
+		case findErr != nil:
+			return nil, nil, "", &KVError{Key: key, Err: findErr}
+		case existing.dir:
+			return nil, nil, "", &KVError{Key: key, Err: m.error(etcdclient.ErrorCodeNotFile, key)}
+		case existing.modifiedIndex != options.PrevIndex:
+			return nil, nil, "", &KVError{Key: key, Err: m.error(etcdclient.ErrorCodeTestFailed, key)}
+		}
+	}
+
+	if findErr == nil && existing.dir {
+		return nil, nil, "", &KVError{Key: key, Err: m.error(etcdclient.ErrorCodeNotFile, key)}
+	}
+
+	var prev *Node
+	if findErr == nil {
+		prev = m.convertNode(existing, false)
+	}
+
+	m.index++
+	n, err := m.create(key, value, false, options.TTL)
+	if err != nil {
+		m.index--
+		return nil, nil, "", &KVError{Key: key, Err: err}
+	}
+
+	action := "set"
+	switch {
+	case options.IfNotExist:
+		action = "create"
+	case options.PrevIndex > 0:
+		action = "compareAndSwap"
+	}
+
+	return m.convertNode(n, false), prev, action, nil
+}
+
+// Get retrieves a key from the kvs.
+func (m *Memory) Get(key string, options *GetOptions) (*Node, error) {
+	if options == nil {
+		options = &GetOptions{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	key = normalizeKey(key)
+	n, err := m.find(key)
+	if err != nil {
+		return nil, &KVError{Key: key, Err: err}
+	}
+
+	node := m.convertNode(n, options.Recursive)
+	if n.dir && !options.Recursive {
+		for _, child := range sortedChildren(n) {
+			node.Nodes = append(node.Nodes, m.convertNode(child, false))
+		}
+	}
+
+	return node, nil
+}
+
+// Rmdir removes a directory from the kvs.
+func (m *Memory) Rmdir(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	dir = normalizeKey(dir)
+	n, err := m.remove(dir, true)
+	if err != nil {
+		return &KVDeleteError{Key: dir, Err: err}
+	}
+
+	m.notifyRemoved("delete", n)
+
+	return nil
+}
+
+// Delete deletes a key from the kvs.
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	key = normalizeKey(key)
+	n, err := m.remove(key, false)
+	if err != nil {
+		return &KVDeleteError{Key: key, Err: err}
+	}
+
+	m.notifyRemoved("delete", n)
+
+	return nil
+}
+
+// Txn applies ops atomically. If an operation fails, the keyspace is
+// restored and watchers see none of the changes.
+func (m *Memory) Txn(ops []TxnOp) error {
+	if err := validateTxn(ops); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	root, index := copyMemNode(m.root), m.index
+	events := []Event{}
+
+	for i, op := range ops {
+		key := normalizeKey(op.Key)
+
+		event, err := m.applyTxnOp(key, op)
+		if err != nil {
+			cause := txnCause(err, key, m.index)
+			m.root, m.index = root, index
+			return &TxnError{Op: i, Key: key, Err: cause}
+		}
+
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	for _, e := range events {
+		m.notify(e.Action, e.Node, e.PrevNode)
+	}
+
+	return nil
+}
+
+// applyTxnOp applies a transaction operation. It returns the event for the
+// change, if there was one.
+func (m *Memory) applyTxnOp(key string, op TxnOp) (*Event, error) {
+	if op.Action == TxnSet {
+		node, prev, action, err := m.set(key, op.Value, &SetOptions{PrevIndex: op.PrevIndex, IfNotExist: op.IfNotExist})
+		if err != nil {
+			return nil, err
+		}
+
+		return &Event{Action: action, Node: node, PrevNode: prev}, nil
+	}
+
+	n, err := m.find(key)
+	switch {
+	case op.IfNotExist && err == nil:
+		return nil, m.error(etcdclient.ErrorCodeNodeExist, key)
+	case op.IfNotExist:
+		// the key doesn't exist, so there is nothing to remove.
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case op.PrevIndex > 0 && n.modifiedIndex != op.PrevIndex:
+		return nil, m.error(etcdclient.ErrorCodeTestFailed, key)
+	}
+
+	if op.Action == TxnCheck {
+		return nil, nil
+	}
+
+	n, err = m.remove(key, op.Action == TxnRmdir)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.removedEvent("delete", n), nil
+}
+
+// Watch watches a key for changes. If recursive is true, changes to keys
+// below key are reported as well. The returned channel is closed when the
+// kvs context is done.
+func (m *Memory) Watch(key string, recursive bool) (<-chan Event, error) {
+	w := &memWatcher{
+		key:       normalizeKey(key),
+		recursive: recursive,
+		ready:     make(chan struct{}, 1),
+	}
+
+	m.mu.Lock()
+	m.watchers = append(m.watchers, w)
+	m.mu.Unlock()
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+		defer m.unwatch(w)
+
+		for {
+			select {
+			case <-w.ready:
+			case <-m.ctx.Done():
+				return
+			}
+
+			for _, e := range w.drain() {
+				select {
+				case out <- e:
+				case <-m.ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (m *Memory) unwatch(w *memWatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.watchers {
+		if m.watchers[i] == w {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify sends an event to every interested watcher.
+func (m *Memory) notify(action string, node, prev *Node) {
+	for _, w := range m.watchers {
+		if w.matches(action, node.Key) {
+			w.push(Event{Action: action, Node: node, PrevNode: prev})
+		}
+	}
+}
+
+// notifyRemoved sends an event for a node that was removed at the current
+// index.
+func (m *Memory) notifyRemoved(action string, n *memNode) {
+	e := m.removedEvent(action, n)
+	m.notify(e.Action, e.Node, e.PrevNode)
+}
+
+// removedEvent builds the event for a node that was removed at the current
+// index.
+func (m *Memory) removedEvent(action string, n *memNode) *Event {
+	prev := m.convertNode(n, false)
+
+	node := m.convertNode(n, false)
+	node.ModifiedIndex = m.index
+	node.Value = ""
+	node.Expiration = nil
+
+	return &Event{Action: action, Node: node, PrevNode: prev}
+}
+
+// find locates a node in the keyspace.
+func (m *Memory) find(key string) (*memNode, error) {
+	n := m.root
+	for _, part := range keyParts(key) {
+		if !n.dir {
+			return nil, m.error(etcdclient.ErrorCodeKeyNotFound, key)
+		}
+
+		child, ok := n.children[part]
+		if !ok {
+			return nil, m.error(etcdclient.ErrorCodeKeyNotFound, key)
+		}
+		n = child
+	}
+
+	return n, nil
+}
+
+// create creates or replaces a node at the current index. Missing parent
+// directories are created along the way.
+func (m *Memory) create(key, value string, dir bool, ttl time.Duration) (*memNode, error) {
+	if key == "/" {
+		return nil, m.error(etcdclient.ErrorCodeRootROnly, key)
+	}
+
+	parts := keyParts(key)
+	parent := m.root
+	for i, part := range parts[:len(parts)-1] {
+		child, ok := parent.children[part]
+		if !ok {
+			child = &memNode{
+				key:           "/" + strings.Join(parts[:i+1], "/"),
+				dir:           true,
+				children:      map[string]*memNode{},
+				createdIndex:  m.index,
+				modifiedIndex: m.index,
+			}
+			parent.children[part] = child
+		}
+
+		if !child.dir {
+			return nil, m.error(etcdclient.ErrorCodeNotDir, child.key)
+		}
+
+		parent = child
+	}
+
+	name := parts[len(parts)-1]
+	n, ok := parent.children[name]
+	if !ok || n.dir != dir {
+		n = &memNode{
+			key:          key,
+			dir:          dir,
+			createdIndex: m.index,
+		}
+		if dir {
+			n.children = map[string]*memNode{}
+		}
+		parent.children[name] = n
+	}
+
+	n.value = value
+	n.modifiedIndex = m.index
+	n.expiration = nil
+	if ttl > 0 {
+		exp := m.now().Add(ttl)
+		n.expiration = &exp
+	}
+
+	return n, nil
+}
+
+// remove removes a node from the keyspace. Directories can only be removed
+// if dir is true.
+func (m *Memory) remove(key string, dir bool) (*memNode, error) {
+	if key == "/" {
+		return nil, m.error(etcdclient.ErrorCodeRootROnly, key)
+	}
+
+	n, err := m.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.dir && !dir {
+		return nil, m.error(etcdclient.ErrorCodeNotFile, key)
+	}
+
+	parent, _ := m.find(path.Dir(key))
+	delete(parent.children, path.Base(key))
+	m.index++
+
+	return n, nil
+}
+
+func (m *Memory) expireLoop() {
+	t := time.NewTicker(memExpireInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.mu.Lock()
+			m.expire()
+			m.mu.Unlock()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// expire removes nodes whose TTL has passed. Like etcd, every expiration
+// advances the index.
+func (m *Memory) expire() {
+	now := m.now()
+
+	var walk func(*memNode)
+	walk = func(n *memNode) {
+		for _, child := range sortedChildren(n) {
+			if child.expiration != nil && !child.expiration.After(now) {
+				delete(n.children, path.Base(child.key))
+				m.index++
+				m.notifyRemoved("expire", child)
+				continue
+			}
+
+			if child.dir {
+				walk(child)
+			}
+		}
+	}
+
+	walk(m.root)
+}
+
+// convertNode converts a memNode to a Node. If recursive is true, the
+// children of directories are converted as well.
+func (m *Memory) convertNode(in *memNode, recursive bool) *Node {
+	n := &Node{
+		CreatedIndex:  in.createdIndex,
+		Dir:           in.dir,
+		Key:           in.key,
+		ModifiedIndex: in.modifiedIndex,
+		Nodes:         Nodes{},
+		Value:         in.value,
+	}
+
+	if in.expiration != nil {
+		exp := *in.expiration
+		n.Expiration = &exp
+	}
+
+	if recursive {
+		for _, child := range sortedChildren(in) {
+			n.Nodes = append(n.Nodes, m.convertNode(child, true))
+		}
+	}
+
+	return n
+}
+
+func (m *Memory) error(code int, key string) error {
+	return newEtcdError(code, key, m.index)
+}
+
+// copyMemNode makes a deep copy of a node and its children.
+func copyMemNode(in *memNode) *memNode {
+	n := *in
+	if in.dir {
+		n.children = map[string]*memNode{}
+		for name, child := range in.children {
+			n.children[name] = copyMemNode(child)
+		}
+	}
+
+	return &n
+}
+
+func sortedChildren(n *memNode) []*memNode {
+	children := []*memNode{}
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+
+	sort.Sort(memNodesByKey(children))
+	return children
+}
+
+type memNodesByKey []*memNode
+
+func (s memNodesByKey) Len() int           { return len(s) }
+func (s memNodesByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s memNodesByKey) Less(i, j int) bool { return s[i].key < s[j].key }
@@ -0,0 +1,320 @@
+package kvs_test
+
+import (
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func etcdErrorCode(err error) int {
+	var inner error
+	switch e := err.(type) {
+	case *KVError:
+		inner = e.Err
+	case *KVDeleteError:
+		inner = e.Err
+	case *MkdirError:
+		inner = e.Err
+	case *TxnError:
+		inner = e.Err
+	}
+
+	if eerr, ok := inner.(etcdclient.Error); ok {
+		return eerr.Code
+	}
+
+	return 0
+}
+
+var _ = Describe("Memory", func() {
+
+	var (
+		mem    *Memory
+		node   *Node
+		err    error
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("Set", func() {
+
+		It("creates a key with new indexes", func() {
+			node, err = mem.Set("/foo/bar", "baz", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Key).To(Equal("/foo/bar"))
+			Ω(node.Value).To(Equal("baz"))
+			Ω(node.CreatedIndex).To(Equal(uint64(1)))
+			Ω(node.ModifiedIndex).To(Equal(uint64(1)))
+		})
+
+		It("keeps the created index when updating a key", func() {
+			_, err = mem.Set("/foo", "bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			node, err = mem.Set("/foo", "baz", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.CreatedIndex).To(Equal(uint64(1)))
+			Ω(node.ModifiedIndex).To(Equal(uint64(2)))
+		})
+
+		It("returns a NodeExistError when IfNotExist is set and the key exists", func() {
+			_, err = mem.Set("/foo", "bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = mem.Set("/foo", "baz", &SetOptions{IfNotExist: true})
+			Ω(err).To(BeAssignableToTypeOf(&NodeExistError{}))
+		})
+
+		It("compares and swaps with PrevIndex", func() {
+			node, err = mem.Set("/foo", "bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = mem.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex + 1})
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeTestFailed))
+
+			node, err = mem.Set("/foo", "baz", &SetOptions{PrevIndex: node.ModifiedIndex})
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("baz"))
+		})
+
+		It("can't compare and swap a missing key", func() {
+			_, err = mem.Set("/foo", "bar", &SetOptions{PrevIndex: 1})
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+
+		It("can't overwrite a directory", func() {
+			Ω(mem.Mkdir("/foo")).To(Succeed())
+
+			_, err = mem.Set("/foo", "bar", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+		})
+
+		It("can't create a key below a file", func() {
+			_, err = mem.Set("/foo", "bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = mem.Set("/foo/bar", "baz", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotDir))
+		})
+
+		It("expires keys with a TTL", func() {
+			node, err = mem.Set("/foo", "bar", &SetOptions{TTL: 10 * time.Millisecond})
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Expiration).ToNot(BeNil())
+
+			time.Sleep(20 * time.Millisecond)
+
+			_, err = mem.Get("/foo", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+	})
+
+	Describe("Get", func() {
+
+		BeforeEach(func() {
+			_, err = mem.Set("/a/b", "1", nil)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = mem.Set("/a/c/d", "2", nil)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		It("returns a missing key error", func() {
+			_, err = mem.Get("/missing", nil)
+			Ω(err).To(BeAssignableToTypeOf(&KVError{}))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+
+		It("lists the immediate children of a directory", func() {
+			node, err = mem.Get("/a", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Dir).To(BeTrue())
+			Ω(node.Nodes).To(HaveLen(2))
+			Ω(node.Nodes[0].Key).To(Equal("/a/b"))
+			Ω(node.Nodes[1].Key).To(Equal("/a/c"))
+			Ω(node.Nodes[1].Nodes).To(BeEmpty())
+		})
+
+		It("lists the whole tree when recursive", func() {
+			node, err = mem.Get("/a", &GetOptions{Recursive: true})
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Nodes[1].Nodes).To(HaveLen(1))
+			Ω(node.Nodes[1].Nodes[0].Value).To(Equal("2"))
+		})
+	})
+
+	Describe("Mkdir", func() {
+
+		It("creates a directory", func() {
+			Ω(mem.Mkdir("/foo/bar")).To(Succeed())
+
+			node, err = mem.Get("/foo/bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Dir).To(BeTrue())
+		})
+
+		It("fails when the directory exists", func() {
+			Ω(mem.Mkdir("/foo")).To(Succeed())
+
+			err = mem.Mkdir("/foo")
+			Ω(err).To(BeAssignableToTypeOf(&MkdirError{}))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+		})
+	})
+
+	Describe("Delete and Rmdir", func() {
+
+		BeforeEach(func() {
+			_, err = mem.Set("/foo/bar", "baz", nil)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes a key", func() {
+			Ω(mem.Delete("/foo/bar")).To(Succeed())
+
+			_, err = mem.Get("/foo/bar", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("won't delete a directory", func() {
+			err = mem.Delete("/foo")
+			Ω(err).To(BeAssignableToTypeOf(&KVDeleteError{}))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+		})
+
+		It("removes a directory recursively", func() {
+			Ω(mem.Rmdir("/foo")).To(Succeed())
+
+			_, err = mem.Get("/foo/bar", nil)
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("returns an error for a missing key", func() {
+			err = mem.Delete("/missing")
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+	})
+
+	Describe("Watch", func() {
+
+		var (
+			events <-chan Event
+		)
+
+		It("reports changes to a key", func() {
+			events, err = mem.Watch("/foo", false)
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = mem.Set("/foo", "bar", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			var e Event
+			Eventually(events).Should(Receive(&e))
+			Ω(e.Action).To(Equal("set"))
+			Ω(e.Node.Value).To(Equal("bar"))
+			Ω(e.PrevNode).To(BeNil())
+		})
+
+		It("reports changes below a key when recursive", func() {
+			events, err = mem.Watch("/foo", true)
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = mem.Set("/foo/bar", "1", nil)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = mem.Set("/other", "1", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(mem.Rmdir("/foo")).To(Succeed())
+
+			var e Event
+			Eventually(events).Should(Receive(&e))
+			Ω(e.Node.Key).To(Equal("/foo/bar"))
+			Eventually(events).Should(Receive(&e))
+			Ω(e.Action).To(Equal("delete"))
+			Ω(e.Node.Key).To(Equal("/foo"))
+		})
+
+		It("reports expired keys", func() {
+			_, err = mem.Set("/foo", "bar", &SetOptions{TTL: 10 * time.Millisecond})
+			Ω(err).ToNot(HaveOccurred())
+
+			events, err = mem.Watch("/foo", false)
+			Ω(err).ToNot(HaveOccurred())
+
+			var e Event
+			Eventually(events, time.Second).Should(Receive(&e))
+			Ω(e.Action).To(Equal("expire"))
+		})
+
+		It("closes the channel when the context is done", func() {
+			events, err = mem.Watch("/foo", false)
+			Ω(err).ToNot(HaveOccurred())
+
+			cancel()
+			Eventually(events).Should(BeClosed())
+		})
+	})
+
+	txnSpecs(func() KVS { return mem })
+
+	It("only notifies watchers of applied transactions", func() {
+		events, err := mem.Watch("/foo", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		err = mem.Txn([]TxnOp{
+			{Action: TxnSet, Key: "/foo/bar", Value: "1"},
+			{Action: TxnCheck, Key: "/missing"},
+		})
+		Ω(err).To(HaveOccurred())
+
+		err = mem.Txn([]TxnOp{{Action: TxnSet, Key: "/foo/baz", Value: "2"}})
+		Ω(err).ToNot(HaveOccurred())
+
+		var e Event
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Node.Key).To(Equal("/foo/baz"))
+		Consistently(events).ShouldNot(Receive())
+	})
+
+	Describe("as a backend", func() {
+
+		It("elects the oldest cluster member as leader", func() {
+			cluster := NewCluster(mem, time.Second)
+
+			_, err = cluster.RegisterAgent("agent-1")
+			Ω(err).ToNot(HaveOccurred())
+			index, err := cluster.RegisterAgent("agent-2")
+			Ω(err).ToNot(HaveOccurred())
+
+			_, err = cluster.Refresh("agent-2", index)
+			Ω(err).ToNot(HaveOccurred())
+
+			leader, err := cluster.Leader()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(leader.Name).To(Equal("agent-1"))
+			Ω(leader.NodeCount).To(Equal(2))
+		})
+
+		It("locks", func() {
+			lock := NewLock("item", mem)
+
+			_, err = lock.Lock(context.Background(), time.Second)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(lock.IsLocked()).To(BeTrue())
+			Ω(lock.Unlock()).To(Succeed())
+			Ω(lock.IsLocked()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,102 @@
+package kvs
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics is a KVS which counts the calls, errors and latency of every
+// operation in an expvar map. For each operation, the map has
+// "<op>.calls", "<op>.errors" and "<op>.latency_ns" entries. Latency is
+// the total time spent in the operation, in nanoseconds.
+type Metrics struct {
+	backend KVS
+	vars    *expvar.Map
+}
+
+var _ KVS = &Metrics{}
+
+// NewMetrics builds a Metrics instance.
+func NewMetrics(backend KVS, vars *expvar.Map) *Metrics {
+	return &Metrics{
+		backend: backend,
+		vars:    vars,
+	}
+}
+
+// Delete deletes a key.
+func (m *Metrics) Delete(key string) error {
+	defer m.observe("delete", time.Now())()
+
+	err := m.backend.Delete(key)
+	m.countError("delete", err)
+	return err
+}
+
+// Get retrieves a key.
+func (m *Metrics) Get(key string, options *GetOptions) (*Node, error) {
+	defer m.observe("get", time.Now())()
+
+	node, err := m.backend.Get(key, options)
+	m.countError("get", err)
+	return node, err
+}
+
+// Mkdir creates a directory.
+func (m *Metrics) Mkdir(dir string) error {
+	defer m.observe("mkdir", time.Now())()
+
+	err := m.backend.Mkdir(dir)
+	m.countError("mkdir", err)
+	return err
+}
+
+// Rmdir removes a directory.
+func (m *Metrics) Rmdir(dir string) error {
+	defer m.observe("rmdir", time.Now())()
+
+	err := m.backend.Rmdir(dir)
+	m.countError("rmdir", err)
+	return err
+}
+
+// Set sets a key's value.
+func (m *Metrics) Set(key, value string, options *SetOptions) (*Node, error) {
+	defer m.observe("set", time.Now())()
+
+	node, err := m.backend.Set(key, value, options)
+	m.countError("set", err)
+	return node, err
+}
+
+// Txn applies a transaction.
+func (m *Metrics) Txn(ops []TxnOp) error {
+	defer m.observe("txn", time.Now())()
+
+	err := m.backend.Txn(ops)
+	m.countError("txn", err)
+	return err
+}
+
+// Watch watches a key for changes.
+func (m *Metrics) Watch(key string, recursive bool) (<-chan Event, error) {
+	defer m.observe("watch", time.Now())()
+
+	events, err := m.backend.Watch(key, recursive)
+	m.countError("watch", err)
+	return events, err
+}
+
+// observe returns a func which records a call to op and its latency.
+func (m *Metrics) observe(op string, start time.Time) func() {
+	return func() {
+		m.vars.Add(op+".calls", 1)
+		m.vars.Add(op+".latency_ns", int64(time.Since(start)))
+	}
+}
+
+func (m *Metrics) countError(op string, err error) {
+	if err != nil {
+		m.vars.Add(op+".errors", 1)
+	}
+}
@@ -0,0 +1,46 @@
+package kvs_test
+
+import (
+	"errors"
+	"expvar"
+
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+
+	var (
+		kvs     *MockKVS
+		vars    *expvar.Map
+		metrics *Metrics
+	)
+
+	BeforeEach(func() {
+		kvs = &MockKVS{}
+		vars = new(expvar.Map).Init()
+		metrics = NewMetrics(kvs, vars)
+	})
+
+	AfterEach(func() {
+		kvs.AssertExpectations(GinkgoT())
+	})
+
+	It("counts calls and errors per operation", func() {
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(&Node{}, nil).Once()
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, errors.New("timeout")).Once()
+		kvs.On("Delete", "/foo").Return(nil).Once()
+
+		metrics.Get("/foo", nil)
+		metrics.Get("/foo", nil)
+		metrics.Delete("/foo")
+
+		Ω(vars.Get("get.calls").String()).To(Equal("2"))
+		Ω(vars.Get("get.errors").String()).To(Equal("1"))
+		Ω(vars.Get("get.latency_ns")).ToNot(BeNil())
+		Ω(vars.Get("delete.calls").String()).To(Equal("1"))
+		Ω(vars.Get("delete.errors")).To(BeNil())
+	})
+})
@@ -0,0 +1,126 @@
+package kvs
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// Migration changes the layout of keys in a kvs from Version-1 to Version.
+// Plan reads the kvs and returns the operations which perform the
+// migration. They are applied in the same transaction as the schema version
+// update, so a migration is either applied completely or not at all.
+type Migration struct {
+	Version     int
+	Description string
+	Plan        func() ([]TxnOp, error)
+}
+
+// Migrator applies migrations to a kvs. The current schema version is
+// stored in VersionKey. A missing version key is version 0.
+type Migrator struct {
+	KVS
+	VersionKey string
+	Migrations []Migration
+
+	log *logrus.Entry
+}
+
+// NewMigrator builds a Migrator instance. Migrations must be ordered by
+// version, starting with version 1.
+func NewMigrator(backend KVS, versionKey string, migrations []Migration, log *logrus.Entry) *Migrator {
+	return &Migrator{
+		KVS:        backend,
+		VersionKey: versionKey,
+		Migrations: migrations,
+		log:        log,
+	}
+}
+
+// LatestVersion returns the version the migrations lead to.
+func (m *Migrator) LatestVersion() int {
+	return len(m.Migrations)
+}
+
+// Version returns the current schema version.
+func (m *Migrator) Version() (int, error) {
+	version, _, err := m.version()
+	return version, err
+}
+
+// Migrate applies pending migrations in order. If another process applies a
+// migration concurrently, Migrate continues from the version it left.
+func (m *Migrator) Migrate() error {
+	for i, mig := range m.Migrations {
+		if mig.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d; expected %d", mig.Description, mig.Version, i+1)
+		}
+	}
+
+	for {
+		version, index, err := m.version()
+		if err != nil {
+			return err
+		}
+
+		if version > m.LatestVersion() {
+			return fmt.Errorf("schema version %d is newer than the latest known version %d", version, m.LatestVersion())
+		}
+
+		if version == m.LatestVersion() {
+			return nil
+		}
+
+		mig := m.Migrations[version]
+		log := m.log.WithFields(logrus.Fields{
+			"schema-version": mig.Version,
+			"migration":      mig.Description,
+		})
+		log.Info("running migration")
+
+		ops, err := mig.Plan()
+		if err != nil {
+			return fmt.Errorf("could not plan migration %d (%s): %v", mig.Version, mig.Description, err)
+		}
+
+		guard := TxnOp{Action: TxnCheck, Key: m.VersionKey, PrevIndex: index}
+		if index == 0 {
+			guard.IfNotExist = true
+		}
+
+		txn := append([]TxnOp{guard}, ops...)
+		txn = append(txn, TxnOp{Action: TxnSet, Key: m.VersionKey, Value: strconv.Itoa(mig.Version)})
+
+		err = m.Txn(txn)
+		if terr, ok := err.(*TxnError); ok && terr.Op == 0 {
+			log.Info("schema version changed while migrating; retrying")
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not apply migration %d (%s): %v", mig.Version, mig.Description, err)
+		}
+	}
+}
+
+// version returns the current schema version and the modified index of the
+// version key.
+func (m *Migrator) version() (int, uint64, error) {
+	node, err := m.Get(m.VersionKey, nil)
+	if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+		return 0, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version, err := strconv.Atoi(node.Value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid schema version %q: %v", node.Value, err)
+	}
+
+	return version, node.ModifiedIndex, nil
+}
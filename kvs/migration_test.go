@@ -0,0 +1,265 @@
+package kvs_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrator", func() {
+
+	var (
+		mem      *Memory
+		migrator *Migrator
+		applied  []int
+		cancel   context.CancelFunc
+		log      *logrus.Entry
+	)
+
+	step := func(version int, ops ...TxnOp) Migration {
+		return Migration{
+			Version:     version,
+			Description: "testing",
+			Plan: func() ([]TxnOp, error) {
+				applied = append(applied, version)
+				return ops, nil
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+		applied = []int{}
+
+		logrus.SetOutput(ioutil.Discard)
+		log = logrus.WithField("testing", true)
+
+		migrator = NewMigrator(mem, "/schema", []Migration{
+			step(1, TxnOp{Action: TxnSet, Key: "/a", Value: "1"}),
+			step(2, TxnOp{Action: TxnSet, Key: "/b", Value: "2"}),
+		}, log)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("starts at version 0", func() {
+		Ω(migrator.Version()).To(Equal(0))
+		Ω(migrator.LatestVersion()).To(Equal(2))
+	})
+
+	It("applies pending migrations in order", func() {
+		Ω(migrator.Migrate()).To(Succeed())
+		Ω(applied).To(Equal([]int{1, 2}))
+		Ω(migrator.Version()).To(Equal(2))
+
+		node, err := mem.Get("/b", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+	})
+
+	It("skips applied migrations", func() {
+		_, err := mem.Set("/schema", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(migrator.Migrate()).To(Succeed())
+		Ω(applied).To(Equal([]int{2}))
+
+		_, err = mem.Get("/a", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("doesn't change the version if a migration fails", func() {
+		migrator.Migrations[1].Plan = func() ([]TxnOp, error) {
+			return []TxnOp{{Action: TxnCheck, Key: "/missing"}}, nil
+		}
+
+		Ω(migrator.Migrate()).ToNot(Succeed())
+		Ω(migrator.Version()).To(Equal(1))
+	})
+
+	It("returns errors from planning", func() {
+		migrator.Migrations[0].Plan = func() ([]TxnOp, error) {
+			return nil, errors.New("boom")
+		}
+
+		Ω(migrator.Migrate()).To(MatchError(ContainSubstring("boom")))
+		Ω(migrator.Version()).To(Equal(0))
+	})
+
+	It("continues after a concurrent migration", func() {
+		migrator.Migrations[0].Plan = func() ([]TxnOp, error) {
+			applied = append(applied, 1)
+			// another leader finishes the migration first.
+			if _, err := mem.Set("/schema", "1", nil); err != nil {
+				return nil, err
+			}
+			return []TxnOp{{Action: TxnSet, Key: "/a", Value: "1"}}, nil
+		}
+
+		Ω(migrator.Migrate()).To(Succeed())
+		Ω(applied).To(Equal([]int{1, 2}))
+
+		_, err := mem.Get("/a", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("refuses versions it doesn't know", func() {
+		_, err := mem.Set("/schema", "3", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(migrator.Migrate()).ToNot(Succeed())
+		Ω(applied).To(BeEmpty())
+	})
+
+	It("requires migrations to be in order", func() {
+		migrator.Migrations = []Migration{step(2)}
+
+		Ω(migrator.Migrate()).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Haproxy migrations", func() {
+
+	var (
+		mem     *Memory
+		haproxy *LiveHaproxy
+		cancel  context.CancelFunc
+	)
+
+	set := func(key, value string) {
+		_, err := mem.Set(key, value, nil)
+		Ω(err).ToNot(HaveOccurred())
+	}
+
+	value := func(key string) string {
+		node, err := mem.Get(key, nil)
+		if err != nil {
+			return ""
+		}
+		return node.Value
+	}
+
+	migrateTo := func(version int) {
+		m := haproxy.Migrator()
+		m.Migrations = m.Migrations[:version]
+		Ω(m.Migrate()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+
+		logrus.SetOutput(ioutil.Discard)
+		haproxy = NewLiveHaproxy(mem, func() string { return "1" }, logrus.WithField("testing", true))
+		Ω(haproxy.Init()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("migrates an empty kvs to the latest version", func() {
+		Ω(haproxy.Migrate()).To(Succeed())
+		Ω(value("/haproxy-discover/schema_version")).To(Equal("4"))
+
+		Ω(haproxy.Migrate()).To(Succeed())
+	})
+
+	Describe("1: reserve the ports of existing services", func() {
+
+		It("reserves unreserved ports", func() {
+			set("/haproxy-discover/services/service-a/port", "80")
+			set("/haproxy-discover/services/service-b/port", "81")
+			set("/haproxy-discover/ports/81", "service-b")
+			set("/haproxy-discover/services/service-c/port", "80")
+			set("/haproxy-discover/services/service-d/port", "invalid")
+
+			migrateTo(1)
+
+			Ω(value("/haproxy-discover/ports/80")).To(Equal("service-a"))
+			Ω(value("/haproxy-discover/ports/81")).To(Equal("service-b"))
+			Ω(value("/haproxy-discover/schema_version")).To(Equal("1"))
+		})
+	})
+
+	Describe("2: remove matchers of the wrong service type", func() {
+
+		It("keeps only the matcher of the service type", func() {
+			set("/haproxy-discover/services/service-a/type", "url_reg")
+			set("/haproxy-discover/services/service-a/url_reg", ".*")
+			set("/haproxy-discover/services/service-a/domain", "a.example.com")
+			set("/haproxy-discover/services/service-b/type", "domain")
+			set("/haproxy-discover/services/service-b/domain", "b.example.com")
+			set("/haproxy-discover/services/service-c/port", "82")
+
+			migrateTo(1)
+			Ω(value("/haproxy-discover/services/service-a/domain")).To(Equal("a.example.com"))
+
+			migrateTo(2)
+
+			Ω(value("/haproxy-discover/services/service-a/url_reg")).To(Equal(".*"))
+			Ω(value("/haproxy-discover/services/service-a/domain")).To(BeEmpty())
+			Ω(value("/haproxy-discover/services/service-b/domain")).To(Equal("b.example.com"))
+			Ω(value("/haproxy-discover/schema_version")).To(Equal("2"))
+		})
+	})
+
+	Describe("3: encode upstream attributes in upstream values", func() {
+
+		It("rewrites upstreams in the current encoding", func() {
+			set("/haproxy-discover/services/service-a/port", "80")
+			set("/haproxy-discover/services/service-a/upstreams/a", "10.0.0.1:80")
+			set("/haproxy-discover/services/service-a/upstreams/b", "10.0.0.2:80  weight 1 backup")
+			set("/haproxy-discover/services/service-a/upstreams/c", "invalid")
+			set("/haproxy-discover/tcp-services/service-b/port", "5432")
+			set("/haproxy-discover/tcp-services/service-b/upstreams/a", "10.0.0.3:5432 drain weight 20")
+
+			migrateTo(3)
+
+			Ω(value("/haproxy-discover/services/service-a/upstreams/a")).To(Equal("10.0.0.1:80"))
+			Ω(value("/haproxy-discover/services/service-a/upstreams/b")).To(Equal("10.0.0.2:80 backup"))
+			Ω(value("/haproxy-discover/services/service-a/upstreams/c")).To(Equal("invalid"))
+			Ω(value("/haproxy-discover/tcp-services/service-b/upstreams/a")).To(Equal("10.0.0.3:5432 weight 20 drain"))
+			Ω(value("/haproxy-discover/schema_version")).To(Equal("3"))
+		})
+	})
+
+	Describe("4: share the ports of http services", func() {
+
+		It("adds the http services using a port to its owners", func() {
+			certPEM, _ := testCertificate("c.example.com", time.Now().Add(time.Hour))
+
+			set("/haproxy-discover/services/service-a/port", "80")
+			set("/haproxy-discover/services/service-b/port", "80")
+			set("/haproxy-discover/services/service-c/port", "81")
+			set("/haproxy-discover/services/service-c/certificates/1/certificate", certPEM)
+			set("/haproxy-discover/services/service-d/port", "5432")
+			set("/haproxy-discover/tcp-services/service-e/port", "5432")
+			set("/haproxy-discover/ports/5432", "service-e")
+
+			migrateTo(3)
+			Ω(value("/haproxy-discover/ports/80")).To(Equal("service-a"))
+
+			migrateTo(4)
+
+			Ω(value("/haproxy-discover/ports/80")).To(Equal("service-a service-b"))
+			Ω(value("/haproxy-discover/ports/81")).To(Equal("service-c"))
+			Ω(value("/haproxy-discover/ports/443")).To(Equal("service-c"))
+			Ω(value("/haproxy-discover/ports/5432")).To(Equal("service-e"))
+			Ω(value("/haproxy-discover/schema_version")).To(Equal("4"))
+		})
+	})
+})
@@ -63,3 +63,15 @@ func (_m *MockFirewall) DisablePort(port int) error {
 
 	return r0
 }
+func (_m *MockFirewall) MovePort(from int, to int) error {
+	ret := _m.Called(from, to)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, int) error); ok {
+		r0 = rf(from, to)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
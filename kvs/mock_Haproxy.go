@@ -6,6 +6,87 @@ type MockHaproxy struct {
 	mock.Mock
 }
 
+func (_m *MockHaproxy) AddCertificate(svcName string, cert string, key string) (string, error) {
+	ret := _m.Called(svcName, cert, key)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, string) string); ok {
+		r0 = rf(svcName, cert, key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string) error); ok {
+		r1 = rf(svcName, cert, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockHaproxy) AddCertificateWith(svcName string, cert string, key string, ops []TxnOp) (string, error) {
+	ret := _m.Called(svcName, cert, key, ops)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, string, []TxnOp) string); ok {
+		r0 = rf(svcName, cert, key, ops)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, []TxnOp) error); ok {
+		r1 = rf(svcName, cert, key, ops)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockHaproxy) AddHTTPRule(svcName string, r HTTPRule) (string, error) {
+	ret := _m.Called(svcName, r)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, HTTPRule) string); ok {
+		r0 = rf(svcName, r)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, HTTPRule) error); ok {
+		r1 = rf(svcName, r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockHaproxy) DeleteCertificate(svcName string, id string) error {
+	ret := _m.Called(svcName, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(svcName, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) DeleteHTTPRule(svcName string, id string) error {
+	ret := _m.Called(svcName, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(svcName, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockHaproxy) DeleteService(name string) error {
 	ret := _m.Called(name)
 
@@ -54,6 +135,30 @@ func (_m *MockHaproxy) Init() error {
 
 	return r0
 }
+func (_m *MockHaproxy) Rules(svcName string, rules []Rule, port int) error {
+	ret := _m.Called(svcName, rules, port)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, []Rule, int) error); ok {
+		r0 = rf(svcName, rules, port)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) SaveService(spec ServiceSpec) error {
+	ret := _m.Called(spec)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(ServiceSpec) error); ok {
+		r0 = rf(spec)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockHaproxy) Service(name string) (Service, error) {
 	ret := _m.Called(name)
 
@@ -94,6 +199,54 @@ func (_m *MockHaproxy) Services() ([]Service, error) {
 
 	return r0, r1
 }
+func (_m *MockHaproxy) SetBalance(svcName string, b Balance) error {
+	ret := _m.Called(svcName, b)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, Balance) error); ok {
+		r0 = rf(svcName, b)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) SetHealthCheck(svcName string, hc *HealthCheck) error {
+	ret := _m.Called(svcName, hc)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *HealthCheck) error); ok {
+		r0 = rf(svcName, hc)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) SetLimits(svcName string, l *Limits) error {
+	ret := _m.Called(svcName, l)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *Limits) error); ok {
+		r0 = rf(svcName, l)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) TCP(svcName string, port int) error {
+	ret := _m.Called(svcName, port)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(svcName, port)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
 func (_m *MockHaproxy) URLReg(svcName string, regex string, port int) error {
 	ret := _m.Called(svcName, regex, port)
 
@@ -106,15 +259,67 @@ func (_m *MockHaproxy) URLReg(svcName string, regex string, port int) error {
 
 	return r0
 }
-func (_m *MockHaproxy) Upstream(svcName string, address string) error {
-	ret := _m.Called(svcName, address)
+func (_m *MockHaproxy) Upstream(svcName string, u Upstream) (string, error) {
+	ret := _m.Called(svcName, u)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, Upstream) string); ok {
+		r0 = rf(svcName, u)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, Upstream) error); ok {
+		r1 = rf(svcName, u)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockHaproxy) UpdateHTTPRule(svcName string, id string, r HTTPRule) error {
+	ret := _m.Called(svcName, id, r)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(svcName, address)
+	if rf, ok := ret.Get(0).(func(string, string, HTTPRule) error); ok {
+		r0 = rf(svcName, id, r)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockHaproxy) UpdateUpstream(svcName string, id string, uu UpstreamUpdate) error {
+	ret := _m.Called(svcName, id, uu)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, UpstreamUpdate) error); ok {
+		r0 = rf(svcName, id, uu)
 	} else {
 		r0 = ret.Error(0)
 	}
 
 	return r0
 }
+func (_m *MockHaproxy) SyncUpstreams(svcName string, upstreams []Upstream) (*UpstreamSync, error) {
+	ret := _m.Called(svcName, upstreams)
+
+	var r0 *UpstreamSync
+	if rf, ok := ret.Get(0).(func(string, []Upstream) *UpstreamSync); ok {
+		r0 = rf(svcName, upstreams)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*UpstreamSync)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, []Upstream) error); ok {
+		r1 = rf(svcName, upstreams)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
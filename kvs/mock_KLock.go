@@ -3,23 +3,64 @@ package kvs
 import "github.com/stretchr/testify/mock"
 
 import "time"
+import "golang.org/x/net/context"
 
 type MockKLock struct {
 	mock.Mock
 }
 
-func (_m *MockKLock) Lock(_a0 time.Duration) error {
-	ret := _m.Called(_a0)
+func (_m *MockKLock) Lock(ctx context.Context, ttl time.Duration) (uint64, error) {
+	ret := _m.Called(ctx, ttl)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(time.Duration) error); ok {
-		r0 = rf(_a0)
+	var r0 uint64
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) uint64); ok {
+		r0 = rf(ctx, ttl)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(uint64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, ttl)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *MockKLock) Lost() <-chan struct{} {
+	ret := _m.Called()
+
+	var r0 <-chan struct{}
+	if rf, ok := ret.Get(0).(func() <-chan struct{}); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan struct{})
+		}
 	}
 
 	return r0
 }
+func (_m *MockKLock) FenceOp(token uint64) (TxnOp, error) {
+	ret := _m.Called(token)
+
+	var r0 TxnOp
+	if rf, ok := ret.Get(0).(func(uint64) TxnOp); ok {
+		r0 = rf(token)
+	} else {
+		r0 = ret.Get(0).(TxnOp)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint64) error); ok {
+		r1 = rf(token)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
 func (_m *MockKLock) Unlock() error {
 	ret := _m.Called()
 
@@ -84,3 +84,36 @@ func (_m *MockKVS) Set(key string, value string, options *SetOptions) (*Node, er
 
 	return r0, r1
 }
+func (_m *MockKVS) Txn(ops []TxnOp) error {
+	ret := _m.Called(ops)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]TxnOp) error); ok {
+		r0 = rf(ops)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *MockKVS) Watch(key string, recursive bool) (<-chan Event, error) {
+	ret := _m.Called(key, recursive)
+
+	var r0 <-chan Event
+	if rf, ok := ret.Get(0).(func(string, bool) <-chan Event); ok {
+		r0 = rf(key, recursive)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan Event)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, bool) error); ok {
+		r1 = rf(key, recursive)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -68,3 +68,71 @@ func (_m *MockService) ServiceConfig() ServiceConfig {
 
 	return r0
 }
+func (_m *MockService) Certificates() []Certificate {
+	ret := _m.Called()
+
+	var r0 []Certificate
+	if rf, ok := ret.Get(0).(func() []Certificate); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Certificate)
+		}
+	}
+
+	return r0
+}
+func (_m *MockService) HealthCheck() *HealthCheck {
+	ret := _m.Called()
+
+	var r0 *HealthCheck
+	if rf, ok := ret.Get(0).(func() *HealthCheck); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*HealthCheck)
+		}
+	}
+
+	return r0
+}
+func (_m *MockService) Balance() Balance {
+	ret := _m.Called()
+
+	var r0 Balance
+	if rf, ok := ret.Get(0).(func() Balance); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(Balance)
+	}
+
+	return r0
+}
+func (_m *MockService) HTTPRules() []HTTPRule {
+	ret := _m.Called()
+
+	var r0 []HTTPRule
+	if rf, ok := ret.Get(0).(func() []HTTPRule); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]HTTPRule)
+		}
+	}
+
+	return r0
+}
+func (_m *MockService) Limits() *Limits {
+	ret := _m.Called()
+
+	var r0 *Limits
+	if rf, ok := ret.Get(0).(func() *Limits); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*Limits)
+		}
+	}
+
+	return r0
+}
@@ -0,0 +1,173 @@
+package kvs
+
+import (
+	"strings"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+// Namespace is a KVS which stores its keys below a prefix in another KVS.
+// Several clusters can share a backend by using different prefixes.
+type Namespace struct {
+	backend KVS
+	prefix  string
+}
+
+var _ KVS = &Namespace{}
+
+// NewNamespace builds a Namespace instance. An empty or root prefix returns
+// keys as they are.
+func NewNamespace(backend KVS, prefix string) *Namespace {
+	prefix = normalizeKey(prefix)
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	return &Namespace{
+		backend: backend,
+		prefix:  prefix,
+	}
+}
+
+// Prefix returns the prefix of the namespace.
+func (ns *Namespace) Prefix() string {
+	return ns.prefix
+}
+
+// Delete deletes a key.
+func (ns *Namespace) Delete(key string) error {
+	return ns.stripError(ns.backend.Delete(ns.key(key)))
+}
+
+// Get retrieves a key.
+func (ns *Namespace) Get(key string, options *GetOptions) (*Node, error) {
+	node, err := ns.backend.Get(ns.key(key), options)
+	if err != nil {
+		return nil, ns.stripError(err)
+	}
+
+	return ns.stripNode(node), nil
+}
+
+// Mkdir creates a directory.
+func (ns *Namespace) Mkdir(dir string) error {
+	return ns.stripError(ns.backend.Mkdir(ns.key(dir)))
+}
+
+// Rmdir removes a directory.
+func (ns *Namespace) Rmdir(dir string) error {
+	return ns.stripError(ns.backend.Rmdir(ns.key(dir)))
+}
+
+// Set sets a key's value.
+func (ns *Namespace) Set(key, value string, options *SetOptions) (*Node, error) {
+	node, err := ns.backend.Set(ns.key(key), value, options)
+	if err != nil {
+		return nil, ns.stripError(err)
+	}
+
+	return ns.stripNode(node), nil
+}
+
+// Txn applies a transaction.
+func (ns *Namespace) Txn(ops []TxnOp) error {
+	prefixed := make([]TxnOp, len(ops))
+	for i, op := range ops {
+		op.Key = ns.key(op.Key)
+		prefixed[i] = op
+	}
+
+	return ns.stripError(ns.backend.Txn(prefixed))
+}
+
+// Watch watches a key for changes.
+func (ns *Namespace) Watch(key string, recursive bool) (<-chan Event, error) {
+	in, err := ns.backend.Watch(ns.key(key), recursive)
+	if err != nil {
+		return nil, ns.stripError(err)
+	}
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+
+		for e := range in {
+			out <- Event{
+				Action:   e.Action,
+				Node:     ns.stripNode(e.Node),
+				PrevNode: ns.stripNode(e.PrevNode),
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// key converts a key in the namespace to a key in the backend.
+func (ns *Namespace) key(key string) string {
+	key = normalizeKey(key)
+	if ns.prefix == "" {
+		return key
+	}
+
+	if key == "/" {
+		return ns.prefix
+	}
+
+	return ns.prefix + key
+}
+
+// strip converts a key in the backend to a key in the namespace.
+func (ns *Namespace) strip(key string) string {
+	if ns.prefix == "" {
+		return key
+	}
+
+	key = strings.TrimPrefix(key, ns.prefix)
+	if key == "" {
+		return "/"
+	}
+
+	return key
+}
+
+// stripNode copies a node tree with the prefix removed from its keys.
+func (ns *Namespace) stripNode(n *Node) *Node {
+	if n == nil {
+		return nil
+	}
+
+	c := *n
+	c.Key = ns.strip(n.Key)
+
+	if n.Nodes != nil {
+		c.Nodes = make(Nodes, len(n.Nodes))
+		for i, child := range n.Nodes {
+			c.Nodes[i] = ns.stripNode(child)
+		}
+	}
+
+	return &c
+}
+
+// stripError removes the prefix from the keys reported in an error.
+func (ns *Namespace) stripError(err error) error {
+	switch e := err.(type) {
+	case *KVError:
+		return &KVError{Key: ns.strip(e.Key), Err: ns.stripError(e.Err)}
+	case *KVDeleteError:
+		return &KVDeleteError{Key: ns.strip(e.Key), Err: ns.stripError(e.Err)}
+	case *MkdirError:
+		return &MkdirError{Dir: ns.strip(e.Dir), Err: ns.stripError(e.Err)}
+	case *NodeExistError:
+		return &NodeExistError{Key: ns.strip(e.Key)}
+	case *TxnError:
+		return &TxnError{Op: e.Op, Key: ns.strip(e.Key), Err: ns.stripError(e.Err)}
+	case etcdclient.Error:
+		e.Cause = ns.strip(e.Cause)
+		return e
+	default:
+		return err
+	}
+}
@@ -0,0 +1,206 @@
+package kvs_test
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace", func() {
+
+	var (
+		mem    *Memory
+		ns     *Namespace
+		other  *Namespace
+		node   *Node
+		err    error
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mem = NewMemory(ctx)
+		ns = NewNamespace(mem, "/lb/a/")
+		other = NewNamespace(mem, "lb/b")
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("normalizes the prefix", func() {
+		Ω(ns.Prefix()).To(Equal("/lb/a"))
+		Ω(NewNamespace(mem, "/").Prefix()).To(Equal(""))
+	})
+
+	It("stores keys below the prefix", func() {
+		node, err = ns.Set("/foo/bar", "baz", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Key).To(Equal("/foo/bar"))
+
+		node, err = mem.Get("/lb/a/foo/bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("baz"))
+
+		_, err = other.Get("/foo/bar", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	It("strips the prefix from directory listings", func() {
+		_, err = ns.Set("/foo/bar/baz", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		node, err = ns.Get("/foo", &GetOptions{Recursive: true})
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Key).To(Equal("/foo"))
+		Ω(node.Nodes).To(HaveLen(1))
+		Ω(node.Nodes[0].Key).To(Equal("/foo/bar"))
+		Ω(node.Nodes[0].Nodes[0].Key).To(Equal("/foo/bar/baz"))
+
+		node, err = ns.Get("/", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Key).To(Equal("/"))
+	})
+
+	It("strips the prefix from errors", func() {
+		_, err = ns.Set("/foo", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = ns.Set("/foo", "2", &SetOptions{IfNotExist: true})
+		Ω(err).To(Equal(&NodeExistError{Key: "/foo"}))
+
+		err = ns.Delete("/missing")
+		kderr, ok := err.(*KVDeleteError)
+		Ω(ok).To(BeTrue())
+		Ω(kderr.Key).To(Equal("/missing"))
+	})
+
+	It("removes directories below the prefix", func() {
+		_, err = ns.Set("/foo/bar", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = other.Set("/foo/bar", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(ns.Rmdir("/foo")).To(Succeed())
+
+		_, err = mem.Get("/lb/a/foo", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		node, err = other.Get("/foo/bar", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+	})
+
+	It("watches keys below the prefix", func() {
+		events, err := ns.Watch("/foo", true)
+		Ω(err).ToNot(HaveOccurred())
+
+		_, err = other.Set("/foo/bar", "1", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = ns.Set("/foo/bar", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		var e Event
+		Eventually(events).Should(Receive(&e))
+		Ω(e.Action).To(Equal("set"))
+		Ω(e.Node.Key).To(Equal("/foo/bar"))
+		Ω(e.Node.Value).To(Equal("2"))
+		Consistently(events).ShouldNot(Receive())
+	})
+
+	It("reports the failed operation of a transaction", func() {
+		err = ns.Txn([]TxnOp{
+			{Action: TxnSet, Key: "/foo", Value: "1"},
+			{Action: TxnCheck, Key: "/missing"},
+		})
+		terr, ok := err.(*TxnError)
+		Ω(ok).To(BeTrue())
+		Ω(terr.Op).To(Equal(1))
+		Ω(terr.Key).To(Equal("/missing"))
+	})
+
+	txnSpecs(func() KVS { return ns })
+
+	Context("shared by several load balancers", func() {
+
+		var (
+			log *logrus.Entry
+		)
+
+		BeforeEach(func() {
+			logrus.SetOutput(ioutil.Discard)
+			log = logrus.WithField("testing", true)
+		})
+
+		It("keeps cluster leaders apart", func() {
+			_, err = NewCluster(ns, time.Minute).RegisterAgent("agent-a")
+			Ω(err).ToNot(HaveOccurred())
+			_, err = NewCluster(other, time.Minute).RegisterAgent("agent-b")
+			Ω(err).ToNot(HaveOccurred())
+
+			leader, err := NewCluster(ns, time.Minute).Leader()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(leader).To(Equal(&Leader{Name: "agent-a", NodeCount: 1}))
+		})
+
+		It("keeps floating ips apart", func() {
+			Ω(NewFipKVS(ns).SetFloatingIP("10.0.0.1")).To(Succeed())
+			Ω(NewFipKVS(other).SetFloatingIP("10.0.0.2")).To(Succeed())
+
+			ip, err := NewFipKVS(ns).FloatingIP()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(ip).To(Equal("10.0.0.1"))
+
+			node, err = mem.Get("/lb/b/agent/floating_ip", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("10.0.0.2"))
+		})
+
+		It("keeps firewall ports apart", func() {
+			fw := NewLiveFirewall(ns)
+			Ω(fw.Init()).To(Succeed())
+			Ω(fw.EnablePort(80)).To(Succeed())
+			Ω(NewLiveFirewall(other).Init()).To(Succeed())
+
+			ports, err := NewLiveFirewall(other).Ports()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(ports).To(Equal([]FirewallPort{{Port: 8889, Enabled: true}}))
+		})
+
+		It("keeps haproxy services apart", func() {
+			idGen := func() string { return "1" }
+			a := NewLiveHaproxy(ns, idGen, log)
+			b := NewLiveHaproxy(other, idGen, log)
+			Ω(a.Init()).To(Succeed())
+			Ω(b.Init()).To(Succeed())
+
+			Ω(a.Domain("app", "a.example.com", 8000)).To(Succeed())
+			Ω(b.Domain("app", "b.example.com", 8000)).To(Succeed())
+
+			services, err := b.Services()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(services).To(HaveLen(1))
+			Ω(services[0].ServiceConfig()["domain"]).To(Equal("b.example.com"))
+		})
+
+		It("keeps locks apart", func() {
+			a := NewLock("fip", ns)
+			b := NewLock("fip", other)
+
+			_, err = a.Lock(context.Background(), time.Minute)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(a.IsLocked()).To(BeTrue())
+			Ω(b.IsLocked()).To(BeFalse())
+
+			_, err = mem.Get("/lb/a/dolb/locks/fip", nil)
+			Ω(err).ToNot(HaveOccurred())
+		})
+	})
+})
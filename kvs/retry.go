@@ -0,0 +1,156 @@
+package kvs
+
+import (
+	"math/rand"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultMaxBackoff is the longest a Retry will wait between attempts.
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Retry is a KVS which retries idempotent operations that fail with a
+// transient error. Gets, watches and unconditional sets are retried. Other
+// operations might have been applied before they failed, so they are
+// attempted once.
+type Retry struct {
+	backend KVS
+
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var _ KVS = &Retry{}
+
+// NewRetry builds a Retry instance. Operations are attempted up to attempts
+// times, waiting a jittered, exponentially growing multiple of backoff
+// between attempts.
+func NewRetry(backend KVS, attempts int, backoff time.Duration) *Retry {
+	return &Retry{
+		backend:    backend,
+		Attempts:   attempts,
+		Backoff:    backoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+// Delete deletes a key.
+func (r *Retry) Delete(key string) error {
+	return r.backend.Delete(key)
+}
+
+// Get retrieves a key.
+func (r *Retry) Get(key string, options *GetOptions) (*Node, error) {
+	var node *Node
+	err := r.retry(func() error {
+		var err error
+		node, err = r.backend.Get(key, options)
+		return err
+	})
+
+	return node, err
+}
+
+// Mkdir creates a directory.
+func (r *Retry) Mkdir(dir string) error {
+	return r.backend.Mkdir(dir)
+}
+
+// Rmdir removes a directory.
+func (r *Retry) Rmdir(dir string) error {
+	return r.backend.Rmdir(dir)
+}
+
+// Set sets a key's value. Sets with a PrevIndex or IfNotExist are not
+// retried.
+func (r *Retry) Set(key, value string, options *SetOptions) (*Node, error) {
+	if options != nil && (options.PrevIndex > 0 || options.IfNotExist) {
+		return r.backend.Set(key, value, options)
+	}
+
+	var node *Node
+	err := r.retry(func() error {
+		var err error
+		node, err = r.backend.Set(key, value, options)
+		return err
+	})
+
+	return node, err
+}
+
+// Txn applies a transaction.
+func (r *Retry) Txn(ops []TxnOp) error {
+	return r.backend.Txn(ops)
+}
+
+// Watch watches a key for changes.
+func (r *Retry) Watch(key string, recursive bool) (<-chan Event, error) {
+	var events <-chan Event
+	err := r.retry(func() error {
+		var err error
+		events, err = r.backend.Watch(key, recursive)
+		return err
+	})
+
+	return events, err
+}
+
+func (r *Retry) retry(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < r.Attempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(r.backoff(attempt))
+		}
+
+		err = fn()
+		if !isTransient(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// backoff is the time to wait before an attempt. It doubles with every
+// attempt and is jittered so clients don't retry in lockstep.
+func (r *Retry) backoff(attempt int) time.Duration {
+	d := r.Backoff << uint(attempt-1)
+	if d <= 0 || d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// isTransient returns true if err might not happen if the operation is
+// tried again. Errors reported by the kvs itself, like a missing key or a
+// failed comparison, are not transient.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *NodeExistError:
+		return false
+	case *TxnError:
+		if e.Op >= 0 {
+			return false
+		}
+		return isTransient(e.Err)
+	}
+
+	cause := txnCause(err, "", 0)
+	if _, ok := cause.(etcdclient.Error); ok {
+		return false
+	}
+
+	return cause != context.Canceled && err != ErrCircuitOpen
+}
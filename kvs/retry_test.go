@@ -0,0 +1,86 @@
+package kvs_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+
+	var (
+		kvs     *MockKVS
+		retry   *Retry
+		node    *Node
+		err     error
+		timeout = errors.New("timeout")
+	)
+
+	BeforeEach(func() {
+		kvs = &MockKVS{}
+		retry = NewRetry(kvs, 3, time.Millisecond)
+	})
+
+	AfterEach(func() {
+		kvs.AssertExpectations(GinkgoT())
+	})
+
+	It("retries gets which fail with a transient error", func() {
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, timeout).Twice()
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(&Node{Value: "bar"}, nil).Once()
+
+		node, err = retry.Get("/foo", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("bar"))
+	})
+
+	It("gives up after the last attempt", func() {
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, timeout).Times(3)
+
+		_, err = retry.Get("/foo", nil)
+		Ω(err).To(Equal(timeout))
+	})
+
+	It("doesn't retry errors reported by the kvs", func() {
+		notFound := &KVError{Key: "/foo", Err: etcdclient.Error{Code: etcdclient.ErrorCodeKeyNotFound}}
+		kvs.On("Get", "/foo", (*GetOptions)(nil)).Return(nil, notFound).Once()
+
+		_, err = retry.Get("/foo", nil)
+		Ω(err).To(Equal(notFound))
+	})
+
+	It("retries unconditional sets", func() {
+		opts := &SetOptions{TTL: time.Second}
+		kvs.On("Set", "/foo", "bar", opts).Return(nil, timeout).Once()
+		kvs.On("Set", "/foo", "bar", opts).Return(&Node{}, nil).Once()
+
+		_, err = retry.Set("/foo", "bar", opts)
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	It("doesn't retry conditional sets", func() {
+		opts := &SetOptions{PrevIndex: 5}
+		kvs.On("Set", "/foo", "bar", opts).Return(nil, timeout).Once()
+
+		_, err = retry.Set("/foo", "bar", opts)
+		Ω(err).To(Equal(timeout))
+	})
+
+	It("doesn't retry deletes", func() {
+		kvs.On("Delete", "/foo").Return(timeout).Once()
+
+		Ω(retry.Delete("/foo")).To(Equal(timeout))
+	})
+
+	It("doesn't retry transactions", func() {
+		ops := []TxnOp{{Action: TxnSet, Key: "/foo", Value: "bar"}}
+		kvs.On("Txn", ops).Return(timeout).Once()
+
+		Ω(retry.Txn(ops)).To(Equal(timeout))
+	})
+})
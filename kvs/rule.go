@@ -0,0 +1,133 @@
+package kvs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Rule routes HTTP requests to a service. A request matches a rule if it
+// matches all the conditions the rule sets. Host is a host name, or a
+// wildcard like "*.example.com" which matches any subdomain. If Header is
+// set, the header must equal HeaderValue or match HeaderRegex.
+type Rule struct {
+	Host        string `json:"host,omitempty"`
+	PathPrefix  string `json:"path_prefix,omitempty"`
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"header_value,omitempty"`
+	HeaderRegex string `json:"header_regex,omitempty"`
+	Method      string `json:"method,omitempty"`
+}
+
+var (
+	hostPattern = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	// headerPattern only allows header names haproxy reads without quoting
+	// or escaping; e.g. "#" starts a comment in haproxy.cfg.
+	headerPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+// Validate makes sure a rule can be used.
+func (r Rule) Validate() error {
+	if r.Host == "" && r.PathPrefix == "" && r.Header == "" && r.Method == "" {
+		return fmt.Errorf("rules need at least one condition")
+	}
+
+	if r.Host != "" && !hostPattern.MatchString(r.Host) {
+		return fmt.Errorf("invalid rule host %q", r.Host)
+	}
+
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("invalid rule path prefix %q", r.PathPrefix)
+	}
+
+	switch {
+	case r.Header == "" && (r.HeaderValue != "" || r.HeaderRegex != ""):
+		return fmt.Errorf("rule header value or regex supplied without a header")
+	case r.Header == "":
+	case !headerPattern.MatchString(r.Header):
+		return fmt.Errorf("invalid rule header %q", r.Header)
+	case r.HeaderValue != "" && r.HeaderRegex != "":
+		return fmt.Errorf("only supply a rule header value or a header regex, not both")
+	case r.HeaderRegex != "":
+		if _, err := regexp.Compile(r.HeaderRegex); err != nil {
+			return fmt.Errorf("invalid rule header regex %q: %v", r.HeaderRegex, err)
+		}
+	}
+
+	if r.Method != "" && !methodPattern.MatchString(r.Method) {
+		return fmt.Errorf("invalid rule method %q", r.Method)
+	}
+
+	return nil
+}
+
+// Matches returns true if req matches the rule.
+func (r Rule) Matches(req *http.Request) bool {
+	if r.Host != "" && !matchHost(r.Host, req.Host) {
+		return false
+	}
+
+	if !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+
+	if r.Method != "" && r.Method != req.Method {
+		return false
+	}
+
+	if r.Header != "" {
+		value := req.Header.Get(r.Header)
+		switch {
+		case r.HeaderValue != "":
+			return value == r.HeaderValue
+		case r.HeaderRegex != "":
+			matched, err := regexp.MatchString(r.HeaderRegex, value)
+			return err == nil && matched
+		default:
+			return value != ""
+		}
+	}
+
+	return true
+}
+
+// matchHost returns true if host, which may include a port, matches
+// pattern.
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+// encodeRules encodes an ordered list of rules.
+func encodeRules(rules []Rule) (string, error) {
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// parseRules decodes rules encoded by encodeRules.
+func parseRules(value string) ([]Rule, error) {
+	rules := []Rule{}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	return rules, nil
+}
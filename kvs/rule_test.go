@@ -0,0 +1,64 @@
+package kvs_test
+
+import (
+	"net/http"
+
+	. "github.com/bryanl/dolb/kvs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rule", func() {
+
+	newRequest := func(method, url string, header http.Header) *http.Request {
+		req, err := http.NewRequest(method, url, nil)
+		Ω(err).ToNot(HaveOccurred())
+		if header != nil {
+			req.Header = header
+		}
+		return req
+	}
+
+	It("matches requests meeting all its conditions", func() {
+		r := Rule{Host: "*.example.com", PathPrefix: "/api", Header: "X-Version", HeaderRegex: "^v2", Method: "POST"}
+		Ω(r.Validate()).To(Succeed())
+
+		header := http.Header{"X-Version": {"v2.1"}}
+		Ω(r.Matches(newRequest("POST", "http://www.example.com:8080/api/users", header))).To(BeTrue())
+		Ω(r.Matches(newRequest("POST", "http://a.b.EXAMPLE.com/api", header))).To(BeTrue())
+
+		Ω(r.Matches(newRequest("POST", "http://example.com/api", header))).To(BeFalse())
+		Ω(r.Matches(newRequest("GET", "http://www.example.com/api", header))).To(BeFalse())
+		Ω(r.Matches(newRequest("POST", "http://www.example.com/web", header))).To(BeFalse())
+		Ω(r.Matches(newRequest("POST", "http://www.example.com/api", http.Header{"X-Version": {"v1"}}))).To(BeFalse())
+	})
+
+	It("matches header values exactly", func() {
+		r := Rule{Header: "X-Tenant", HeaderValue: "acme"}
+		Ω(r.Validate()).To(Succeed())
+
+		Ω(r.Matches(newRequest("GET", "http://example.com/", http.Header{"X-Tenant": {"acme"}}))).To(BeTrue())
+		Ω(r.Matches(newRequest("GET", "http://example.com/", http.Header{"X-Tenant": {"acme-2"}}))).To(BeFalse())
+	})
+
+	It("rejects invalid rules", func() {
+		invalid := []Rule{
+			{},
+			{Host: "exa mple.com"},
+			{Host: "www.*.com"},
+			{PathPrefix: "api"},
+			{HeaderValue: "acme"},
+			{Header: "X Tenant"},
+			{Header: "X#Tenant"},
+			{Header: "X'Tenant"},
+			{Header: "X-Tenant", HeaderValue: "acme", HeaderRegex: "acme"},
+			{Header: "X-Tenant", HeaderRegex: "("},
+			{Method: "get"},
+		}
+
+		for _, r := range invalid {
+			Ω(r.Validate()).ToNot(Succeed(), "%#v", r)
+		}
+	})
+})
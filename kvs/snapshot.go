@@ -0,0 +1,181 @@
+package kvs
+
+import (
+	"fmt"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot format.
+	SnapshotVersion = 1
+)
+
+var (
+	// snapshotRoots are the trees included in a snapshot.
+	snapshotRoots = []string{
+		"/haproxy-discover/services",
+		"/haproxy-discover/tcp-services",
+		"/haproxy-discover/ports",
+		firewallPortsKey,
+	}
+
+	// snapshotKeys are the single keys included in a snapshot.
+	snapshotKeys = []string{
+		"/haproxy-discover/schema_version",
+	}
+)
+
+// Snapshot is a versioned export of the kvs trees an agent cluster uses.
+type Snapshot struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Entries   []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is a key and its value.
+type SnapshotEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// TakeSnapshot exports the haproxy and firewall trees and the haproxy
+// schema version from a kvs.
+func TakeSnapshot(backend KVS) (*Snapshot, error) {
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Entries:   []SnapshotEntry{},
+	}
+
+	for _, root := range snapshotRoots {
+		node, err := backend.Get(root, &GetOptions{Recursive: true})
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.Entries = append(s.Entries, snapshotEntries(node)...)
+	}
+
+	for _, key := range snapshotKeys {
+		node, err := backend.Get(key, nil)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.Entries = append(s.Entries, SnapshotEntry{Key: node.Key, Value: node.Value})
+	}
+
+	return s, nil
+}
+
+// RestoreSnapshot replaces the haproxy and firewall trees in a kvs with the
+// contents of a snapshot. The trees are replaced in a single transaction.
+func RestoreSnapshot(backend KVS, s *Snapshot) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	ops := []TxnOp{}
+
+	for _, root := range snapshotRoots {
+		_, err := backend.Get(root, nil)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ops = append(ops, TxnOp{Action: TxnRmdir, Key: root})
+	}
+
+	// keys missing from the snapshot are removed too, so a snapshot taken
+	// before the schema was versioned is migrated from the start.
+	for _, key := range snapshotKeys {
+		_, err := backend.Get(key, nil)
+		if isKVError(err, etcdclient.ErrorCodeKeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ops = append(ops, TxnOp{Action: TxnDelete, Key: key})
+	}
+
+	for _, e := range s.Entries {
+		ops = append(ops, TxnOp{Action: TxnSet, Key: e.Key, Value: e.Value})
+	}
+
+	if err := backend.Txn(ops); err != nil {
+		return err
+	}
+
+	// empty trees aren't in the snapshot, so make sure they exist.
+	for _, root := range snapshotRoots {
+		err := backend.Mkdir(root)
+		if err != nil && !isKVError(err, etcdclient.ErrorCodeNotFile, etcdclient.ErrorCodeNodeExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate makes sure a snapshot can be restored.
+func (s *Snapshot) Validate() error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	for _, e := range s.Entries {
+		if !inSnapshot(normalizeKey(e.Key)) {
+			return fmt.Errorf("snapshot key %q is outside of the snapshot trees", e.Key)
+		}
+	}
+
+	return nil
+}
+
+// inSnapshot returns true if key belongs in a snapshot.
+func inSnapshot(key string) bool {
+	for _, k := range snapshotKeys {
+		if key == k {
+			return true
+		}
+	}
+
+	for _, root := range snapshotRoots {
+		if isBelowKey(key, root) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapshotEntries flattens the keys below a directory.
+func snapshotEntries(n *Node) []SnapshotEntry {
+	if !n.Dir {
+		return []SnapshotEntry{{Key: n.Key, Value: n.Value}}
+	}
+
+	entries := []SnapshotEntry{}
+	for _, child := range n.Nodes {
+		entries = append(entries, snapshotEntries(child)...)
+	}
+
+	return entries
+}
@@ -0,0 +1,197 @@
+package kvs_test
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+
+	var (
+		source *Memory
+		dest   *Memory
+		cancel context.CancelFunc
+		err    error
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		source = NewMemory(ctx)
+		dest = NewMemory(ctx)
+
+		_, err = source.Set("/haproxy-discover/services/app/domain", "example.com", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = source.Set("/haproxy-discover/services/app/upstreams/a", "10.0.0.1:80", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = source.Set("/firewall/ports/80", "enabled", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = source.Set("/agent/leader", "agent-1", &SetOptions{TTL: time.Minute})
+		Ω(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("exports the haproxy and firewall trees", func() {
+		s, err := TakeSnapshot(source)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(s.Version).To(Equal(SnapshotVersion))
+		Ω(s.Entries).To(Equal([]SnapshotEntry{
+			{Key: "/haproxy-discover/services/app/domain", Value: "example.com"},
+			{Key: "/haproxy-discover/services/app/upstreams/a", Value: "10.0.0.1:80"},
+			{Key: "/firewall/ports/80", Value: "enabled"},
+		}))
+	})
+
+	It("restores into another kvs", func() {
+		_, err = dest.Set("/haproxy-discover/services/old/port", "81", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		s, err := TakeSnapshot(source)
+		Ω(err).ToNot(HaveOccurred())
+
+		Ω(RestoreSnapshot(dest, s)).To(Succeed())
+
+		restored, err := TakeSnapshot(dest)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(restored.Entries).To(Equal(s.Entries))
+
+		node, err := dest.Get("/haproxy-discover/tcp-services", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Dir).To(BeTrue())
+	})
+
+	It("includes the schema version", func() {
+		_, err = source.Set("/haproxy-discover/schema_version", "2", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = dest.Set("/haproxy-discover/schema_version", "4", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		s, err := TakeSnapshot(source)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(s.Entries).To(ContainElement(SnapshotEntry{Key: "/haproxy-discover/schema_version", Value: "2"}))
+
+		Ω(RestoreSnapshot(dest, s)).To(Succeed())
+
+		node, err := dest.Get("/haproxy-discover/schema_version", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("2"))
+	})
+
+	It("removes the schema version if the snapshot has none", func() {
+		_, err = dest.Set("/haproxy-discover/schema_version", "4", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		s, err := TakeSnapshot(source)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(RestoreSnapshot(dest, s)).To(Succeed())
+
+		_, err = dest.Get("/haproxy-discover/schema_version", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+
+	Context("restored by haproxy", func() {
+
+		var haproxy *LiveHaproxy
+
+		BeforeEach(func() {
+			haproxy = NewLiveHaproxy(dest, func() string { return "1" }, logrus.WithField("testing", true))
+		})
+
+		It("migrates the restored keys", func() {
+			_, err = source.Set("/haproxy-discover/services/app/port", "80", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			s, err := TakeSnapshot(source)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(haproxy.RestoreSnapshot(s)).To(Succeed())
+
+			Ω(haproxy.Migrator().Version()).To(Equal(haproxy.Migrator().LatestVersion()))
+
+			node, err := dest.Get("/haproxy-discover/ports/80", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(node.Value).To(Equal("app"))
+		})
+
+		It("refuses snapshots of newer schema versions", func() {
+			_, err = source.Set("/haproxy-discover/schema_version", "1000", nil)
+			Ω(err).ToNot(HaveOccurred())
+
+			s, err := TakeSnapshot(source)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(haproxy.RestoreSnapshot(s)).To(MatchError(ContainSubstring("newer")))
+
+			_, err = dest.Get("/haproxy-discover/services/app/domain", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+	})
+
+	It("rejects unknown versions", func() {
+		s := &Snapshot{Version: SnapshotVersion + 1}
+		Ω(RestoreSnapshot(dest, s)).ToNot(Succeed())
+	})
+
+	It("rejects keys outside of the snapshot trees", func() {
+		s := &Snapshot{
+			Version: SnapshotVersion,
+			Entries: []SnapshotEntry{{Key: "/agent/leader", Value: "agent-2"}},
+		}
+		Ω(RestoreSnapshot(dest, s)).ToNot(Succeed())
+
+		_, err = dest.Get("/agent/leader", nil)
+		Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+	})
+})
+
+var _ = Describe("Snapshot with etcd", func() {
+
+	var (
+		source *Etcd
+		dest   *Etcd
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		source = NewEtcd(ctx, &memoryKeysAPI{mem: NewMemory(ctx)})
+		dest = NewEtcd(ctx, &memoryKeysAPI{mem: NewMemory(ctx)})
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("keeps keys below service directories", func() {
+		_, err := source.Set("/haproxy-discover/services/app/port", "80", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = source.Set("/haproxy-discover/services/app/upstreams/a", "10.0.0.1:80", nil)
+		Ω(err).ToNot(HaveOccurred())
+		_, err = source.Set("/haproxy-discover/services/app/health_check/path", "/health", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		s, err := TakeSnapshot(source)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(s.Entries).To(ContainElement(SnapshotEntry{Key: "/haproxy-discover/services/app/upstreams/a", Value: "10.0.0.1:80"}))
+		Ω(s.Entries).To(ContainElement(SnapshotEntry{Key: "/haproxy-discover/services/app/health_check/path", Value: "/health"}))
+
+		Ω(RestoreSnapshot(dest, s)).To(Succeed())
+
+		node, err := dest.Get("/haproxy-discover/services/app/upstreams/a", nil)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(node.Value).To(Equal("10.0.0.1:80"))
+
+		restored, err := TakeSnapshot(dest)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(restored.Entries).To(Equal(s.Entries))
+	})
+})
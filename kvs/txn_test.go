@@ -0,0 +1,113 @@
+package kvs_test
+
+import (
+	. "github.com/bryanl/dolb/kvs"
+	etcdclient "github.com/coreos/etcd/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// txnSpecs describes the transaction behavior every backend shares.
+// backend is called after the BeforeEach blocks of the enclosing container
+// have run.
+func txnSpecs(backend func() KVS) {
+
+	Describe("Txn", func() {
+
+		var (
+			kv   KVS
+			node *Node
+			err  error
+		)
+
+		BeforeEach(func() {
+			kv = backend()
+
+			node, err = kv.Set("/a/b", "1", nil)
+			Ω(err).ToNot(HaveOccurred())
+			_, err = kv.Set("/c", "2", nil)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		It("applies every operation", func() {
+			err = kv.Txn([]TxnOp{
+				{Action: TxnCheck, Key: "/a/b", PrevIndex: node.ModifiedIndex},
+				{Action: TxnSet, Key: "/a/b", Value: "3"},
+				{Action: TxnDelete, Key: "/c"},
+				{Action: TxnSet, Key: "/d/e", Value: "4", IfNotExist: true},
+			})
+			Ω(err).ToNot(HaveOccurred())
+
+			n, err := kv.Get("/a/b", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(n.Value).To(Equal("3"))
+
+			_, err = kv.Get("/c", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+
+			n, err = kv.Get("/d/e", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(n.Value).To(Equal("4"))
+		})
+
+		It("removes directories", func() {
+			Ω(kv.Txn([]TxnOp{{Action: TxnRmdir, Key: "/a"}})).To(Succeed())
+
+			_, err = kv.Get("/a/b", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+
+		It("applies nothing when a key is missing", func() {
+			err = kv.Txn([]TxnOp{
+				{Action: TxnSet, Key: "/x", Value: "1"},
+				{Action: TxnDelete, Key: "/missing"},
+			})
+			Ω(err).To(BeAssignableToTypeOf(&TxnError{}))
+			Ω(err.(*TxnError).Op).To(Equal(1))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+
+			_, err = kv.Get("/x", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+
+		It("applies nothing when a key exists", func() {
+			err = kv.Txn([]TxnOp{
+				{Action: TxnSet, Key: "/x", Value: "1"},
+				{Action: TxnSet, Key: "/c", Value: "3", IfNotExist: true},
+			})
+			Ω(err.(*TxnError).Op).To(Equal(1))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNodeExist))
+
+			_, err = kv.Get("/x", nil)
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeKeyNotFound))
+		})
+
+		It("applies nothing when an index doesn't match", func() {
+			err = kv.Txn([]TxnOp{
+				{Action: TxnDelete, Key: "/c"},
+				{Action: TxnSet, Key: "/a/b", Value: "3", PrevIndex: node.ModifiedIndex + 100},
+			})
+			Ω(err.(*TxnError).Op).To(Equal(1))
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeTestFailed))
+
+			n, err := kv.Get("/c", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(n.Value).To(Equal("2"))
+		})
+
+		It("won't replace a directory with a file", func() {
+			err = kv.Txn([]TxnOp{{Action: TxnSet, Key: "/a", Value: "1"}})
+			Ω(etcdErrorCode(err)).To(Equal(etcdclient.ErrorCodeNotFile))
+		})
+
+		It("rejects unknown actions", func() {
+			err = kv.Txn([]TxnOp{{Action: "bogus", Key: "/c"}})
+			Ω(err).To(BeAssignableToTypeOf(&TxnError{}))
+
+			n, err := kv.Get("/c", nil)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(n.Value).To(Equal("2"))
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package kvs
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Upstream admin states.
+const (
+	// UpstreamActive upstreams receive traffic.
+	UpstreamActive = "active"
+	// UpstreamDrain upstreams finish their current sessions but don't
+	// receive new ones.
+	UpstreamDrain = "drain"
+	// UpstreamMaint upstreams don't receive any traffic.
+	UpstreamMaint = "maint"
+)
+
+const (
+	// DefaultUpstreamWeight is haproxy's default server weight.
+	DefaultUpstreamWeight = 1
+	maxUpstreamWeight     = 256
+)
+
+// UpstreamSync is the result of syncing the upstreams of a service.
+type UpstreamSync struct {
+	Added   []Upstream
+	Updated []Upstream
+	Removed []Upstream
+}
+
+// NewUpstream creates an active upstream with the default weight.
+func NewUpstream(host string, port int) Upstream {
+	return Upstream{
+		Host:   host,
+		Port:   port,
+		Weight: DefaultUpstreamWeight,
+		State:  UpstreamActive,
+	}
+}
+
+// Address is the host:port of the upstream.
+func (u Upstream) Address() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// Validate makes sure an upstream can be used.
+func (u Upstream) Validate() error {
+	if u.Host == "" {
+		return fmt.Errorf("upstream host is required")
+	}
+
+	if u.Port < 1 || u.Port > 65535 {
+		return fmt.Errorf("invalid upstream port %d", u.Port)
+	}
+
+	if u.Weight < 0 || u.Weight > maxUpstreamWeight {
+		return fmt.Errorf("upstream weight must be between 0 and %d", maxUpstreamWeight)
+	}
+
+	switch u.State {
+	case UpstreamActive, UpstreamDrain, UpstreamMaint:
+	default:
+		return fmt.Errorf("invalid upstream state %q", u.State)
+	}
+
+	return nil
+}
+
+// UpstreamUpdate changes the attributes of an existing upstream. Fields
+// which are nil are left alone.
+type UpstreamUpdate struct {
+	Weight *int
+	Backup *bool
+	State  *string
+}
+
+func (uu UpstreamUpdate) apply(u *Upstream) {
+	if uu.Weight != nil {
+		u.Weight = *uu.Weight
+	}
+
+	if uu.Backup != nil {
+		u.Backup = *uu.Backup
+	}
+
+	if uu.State != nil {
+		u.State = *uu.State
+	}
+}
+
+// encodeUpstream encodes an upstream as its address followed by the
+// attributes which aren't defaults, e.g. "10.0.0.1:80 weight 50 backup
+// drain". An upstream with default attributes is a bare address, so the
+// values written before upstreams had attributes are still valid. Readers
+// must understand the attributes from schema version 3 on.
+func encodeUpstream(u Upstream) string {
+	fields := []string{u.Address()}
+
+	if u.Weight != DefaultUpstreamWeight {
+		fields = append(fields, "weight", strconv.Itoa(u.Weight))
+	}
+
+	if u.Backup {
+		fields = append(fields, "backup")
+	}
+
+	if u.State != UpstreamActive {
+		fields = append(fields, u.State)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// parseUpstream decodes an upstream encoded by encodeUpstream.
+func parseUpstream(id, value string) (Upstream, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return Upstream{}, fmt.Errorf("upstream %s is empty", id)
+	}
+
+	host, port, err := net.SplitHostPort(fields[0])
+	if err != nil {
+		return Upstream{}, err
+	}
+
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return Upstream{}, err
+	}
+
+	u := NewUpstream(host, portInt)
+	u.ID = id
+
+	for i := 1; i < len(fields); i++ {
+		switch f := fields[i]; f {
+		case "weight":
+			if i+1 == len(fields) {
+				return Upstream{}, fmt.Errorf("upstream %s is missing its weight", id)
+			}
+			i++
+			u.Weight, err = strconv.Atoi(fields[i])
+			if err != nil {
+				return Upstream{}, fmt.Errorf("upstream %s has an invalid weight %q", id, fields[i])
+			}
+		case "backup":
+			u.Backup = true
+		case UpstreamDrain, UpstreamMaint:
+			u.State = f
+		default:
+			return Upstream{}, fmt.Errorf("upstream %s has an unknown attribute %q", id, f)
+		}
+	}
+
+	return u, nil
+}
@@ -1,10 +1,10 @@
 package mocks
 
+import "context"
+
 import "github.com/coreos/etcd/client"
 import "github.com/stretchr/testify/mock"
 
-import "github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
-
 type KeysAPI struct {
 	mock.Mock
 }
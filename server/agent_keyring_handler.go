@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// KeyringRequest is a request from an agent for its cluster's keyring.
+type KeyringRequest struct {
+	AgentID   string `json:"agent_id"`
+	ClusterID string `json:"cluster_id"`
+}
+
+// AgentKeyringHandler returns the keyring an agent's cluster uses to encrypt
+// kvs values. Agents fetch it at boot, so it never appears in their user
+// data. The request has to come from the agent's droplet.
+func AgentKeyringHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	var kr KeyringRequest
+	err := json.NewDecoder(r.Body).Decode(&kr)
+	if err != nil {
+		return service.Response{Body: fmt.Errorf("could not decode json: %v", err), Status: 422}
+	}
+
+	logger := config.logger.WithFields(logrus.Fields{
+		"agent-id":   kr.AgentID,
+		"cluster-id": kr.ClusterID,
+	})
+
+	agent, err := config.DBSession.LoadAgent(kr.AgentID)
+	if err != nil || agent.IsDeleted || agent.ClusterID != kr.ClusterID {
+		logger.Warn("keyring requested by unknown agent")
+		return service.Response{Body: errors.New("unknown agent"), Status: 403}
+	}
+
+	lb, err := config.DBSession.LoadLoadBalancer(kr.ClusterID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	droplet, err := config.DigitalOcean(lb.DigitaloceanAccessToken).GetAgent(agent.DropletID)
+	if err != nil {
+		logger.WithError(err).Error("could not retrieve agent droplet")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !hasIPAddress(droplet.IPAddresses, host) {
+		logger.WithField("remote-addr", r.RemoteAddr).Warn("keyring requested from outside of agent droplet")
+		return service.Response{Body: errors.New("unknown agent"), Status: 403}
+	}
+
+	keyring, err := kvs.ClusterKeyring(config.KVS, clusterKeyringKey(lb.ID))
+	if err != nil {
+		logger.WithError(err).Error("could not load cluster keyring")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	return service.Response{Body: keyring, Status: http.StatusOK}
+}
+
+// clusterKeyringKey is the kvs key containing a cluster's keyring.
+func clusterKeyringKey(clusterID string) string {
+	return "/dolb/keyrings/" + clusterID
+}
+
+func hasIPAddress(addresses map[string]string, ip string) bool {
+	for _, a := range addresses {
+		if a == ip {
+			return true
+		}
+	}
+
+	return false
+}
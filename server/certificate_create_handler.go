@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// CertificateCreateHandler adds a TLS certificate to a service of a load
+// balancer.
+func CertificateCreateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", lb.FloatingIp, 8889),
+		Path:   fmt.Sprintf("/services/%s/certificates", svcName),
+	}
+
+	defer r.Body.Close()
+
+	resp, err := http.Post(u.String(), "application/json", r.Body)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		var agentErr map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&agentErr)
+		if err != nil {
+			return service.Response{Body: "cannot read agent response", Status: 500}
+		}
+
+		return service.Response{Body: agentErr["error"], Status: resp.StatusCode}
+	}
+
+	var cr service.CertificateResponse
+	err = json.NewDecoder(resp.Body).Decode(&cr)
+	if err != nil {
+		return service.Response{Body: "cannot read agent response", Status: 500}
+	}
+
+	return service.Response{Body: cr, Status: resp.StatusCode}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// CertificateListHandler lists the TLS certificates of a service of a load
+// balancer.
+func CertificateListHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", lb.FloatingIp, 8889),
+		Path:   fmt.Sprintf("/services/%s/certificates", svcName),
+	}
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return service.Response{Body: "not found", Status: resp.StatusCode}
+	}
+
+	var cr service.CertificatesResponse
+	err = json.NewDecoder(resp.Body).Decode(&cr)
+	if err != nil {
+		return service.Response{Body: "cannot read agent response", Status: 500}
+	}
+
+	return service.Response{Body: cr, Status: resp.StatusCode}
+}
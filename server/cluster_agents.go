@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bryanl/dolb/dao"
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+)
+
+// agentPort is the port agents serve their api on.
+const agentPort = 8889
+
+// clusterAgent is an agent of a load balancer. Host is the public address of
+// its droplet. Err is set if the droplet couldn't be looked up.
+type clusterAgent struct {
+	ID   string
+	Host string
+	Err  error
+}
+
+// clusterAgents returns the agents of a load balancer which aren't deleted.
+func clusterAgents(config *Config, lb *dao.LoadBalancer) ([]clusterAgent, error) {
+	agents, err := config.DBSession.LoadBalancerAgents(lb.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := config.DigitalOcean(lb.DigitaloceanAccessToken)
+
+	cas := []clusterAgent{}
+	for _, a := range agents {
+		if a.IsDeleted {
+			continue
+		}
+
+		ca := clusterAgent{ID: a.ID}
+		droplet, err := doc.GetAgent(a.DropletID)
+		switch {
+		case err != nil:
+			ca.Err = err
+		case droplet.IPAddresses["public"] == "":
+			ca.Err = fmt.Errorf("droplet %d has no public address", a.DropletID)
+		default:
+			ca.Host = droplet.IPAddresses["public"]
+		}
+
+		cas = append(cas, ca)
+	}
+
+	return cas, nil
+}
+
+// agentURL is the url of path on the agent api of host.
+func agentURL(host, path string) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", host, agentPort),
+		Path:   path,
+	}
+
+	return u.String()
+}
+
+// callAgent sends a request without a body to the agent api of host and
+// decodes the response into out if it isn't nil.
+func callAgent(method, host, path string, out interface{}) error {
+	req, err := http.NewRequest(method, agentURL(host, path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent %s returned status %d", host, resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// signedAgentRequest creates a request to the agent api of host which is
+// signed with the cluster keyring. Agents reject unsigned requests to their
+// snapshot and restore endpoints.
+func signedAgentRequest(keyring *kvs.Keyring, method, host, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, agentURL(host, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	signedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	msg := service.SignatureMessage(method, path, signedAt, body)
+
+	req.Header.Set(service.SignatureTimeHeader, signedAt)
+	req.Header.Set(service.SignatureHeader, keyring.Sign(msg))
+
+	return req, nil
+}
@@ -94,8 +94,20 @@ func New(config *Config) (*API, error) {
 	mux.Handle("/api/lb/{lb_id}", service.Handler{Config: config, F: LBDeleteHandler}).Methods("DELETE")
 	mux.Handle("/api/user", service.Handler{Config: config, F: UserRetrieveHandler}).Methods("GET")
 	mux.Handle(service.PingPath, service.Handler{Config: config, F: PingHandler}).Methods("POST")
+	mux.Handle(service.KeyringPath, service.Handler{Config: config, F: AgentKeyringHandler}).Methods("POST")
 	mux.Handle("/api/lb/{lb_id}/services", service.Handler{Config: config, F: ServiceCreateHandler}).Methods("POST")
 	mux.Handle("/api/lb/{lb_id}/services", service.Handler{Config: config, F: ServiceListHandler}).Methods("GET")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/stats", service.Handler{Config: config, F: ServiceStatsHandler}).Methods("GET")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/certificates", service.Handler{Config: config, F: CertificateCreateHandler}).Methods("POST")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/certificates", service.Handler{Config: config, F: CertificateListHandler}).Methods("GET")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/certificates/{certificate}", service.Handler{Config: config, F: CertificateDeleteHandler}).Methods("DELETE")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/http-rules", service.Handler{Config: config, F: HTTPRuleCreateHandler}).Methods("POST")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/http-rules", service.Handler{Config: config, F: HTTPRuleListHandler}).Methods("GET")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/http-rules/{rule}", service.Handler{Config: config, F: HTTPRuleUpdateHandler}).Methods("PUT")
+	mux.Handle("/api/lb/{lb_id}/services/{service}/http-rules/{rule}", service.Handler{Config: config, F: HTTPRuleDeleteHandler}).Methods("DELETE")
+	mux.Handle("/api/lb/{lb_id}/snapshot", service.Handler{Config: config, F: LBSnapshotHandler}).Methods("GET")
+	mux.Handle("/api/lb/{lb_id}/restore", service.Handler{Config: config, F: LBRestoreHandler}).Methods("POST")
+	mux.Handle("/api/lb/{lb_id}/keyring/rotate", service.Handler{Config: config, F: KeyringRotateHandler}).Methods("POST")
 
 	return a, nil
 }
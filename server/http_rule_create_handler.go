@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleCreateHandler adds an http rule to a service of a load balancer.
+func HTTPRuleCreateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", lb.FloatingIp, 8889),
+		Path:   fmt.Sprintf("/services/%s/http-rules", svcName),
+	}
+
+	defer r.Body.Close()
+
+	resp, err := http.Post(u.String(), "application/json", r.Body)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	return httpRuleAgentResponse(resp, http.StatusCreated)
+}
+
+// httpRuleAgentResponse relays the agent's response to an http rule change.
+func httpRuleAgentResponse(resp *http.Response, status int) service.Response {
+	if resp.StatusCode != status {
+		var agentErr map[string]interface{}
+		err := json.NewDecoder(resp.Body).Decode(&agentErr)
+		if err != nil {
+			return service.Response{Body: "cannot read agent response", Status: 500}
+		}
+
+		return service.Response{Body: agentErr["error"], Status: resp.StatusCode}
+	}
+
+	var hr service.HTTPRule
+	err := json.NewDecoder(resp.Body).Decode(&hr)
+	if err != nil {
+		return service.Response{Body: "cannot read agent response", Status: 500}
+	}
+
+	return service.Response{Body: hr, Status: resp.StatusCode}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleDeleteHandler removes an http rule from a service of a load
+// balancer.
+func HTTPRuleDeleteHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+	ruleID := vars["rule"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", lb.FloatingIp, 8889),
+		Path:   fmt.Sprintf("/services/%s/http-rules/%s", svcName, ruleID),
+	}
+
+	req, err := http.NewRequest("DELETE", u.String(), nil)
+	if err != nil {
+		return service.Response{Body: err, Status: 500}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return service.Response{Body: "not found", Status: resp.StatusCode}
+	}
+
+	return service.Response{Status: http.StatusNoContent}
+}
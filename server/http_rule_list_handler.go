@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// HTTPRuleListHandler lists the http rules of a service of a load balancer.
+func HTTPRuleListHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", lb.FloatingIp, 8889),
+		Path:   fmt.Sprintf("/services/%s/http-rules", svcName),
+	}
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return service.Response{Body: "not found", Status: resp.StatusCode}
+	}
+
+	var hrr service.HTTPRulesResponse
+	err = json.NewDecoder(resp.Body).Decode(&hrr)
+	if err != nil {
+		return service.Response{Body: "cannot read agent response", Status: 500}
+	}
+
+	return service.Response{Body: hrr, Status: resp.StatusCode}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// KeyringRotateHandler rotates the keyring a load balancer's agent cluster
+// encrypts kvs values with. Every agent is told to refresh its keyring, then
+// the agent holding the floating ip encrypts the existing values with the
+// new primary key. If an agent can't be reached, values aren't reencrypted,
+// because that agent couldn't decrypt them until it refreshes on its own.
+func KeyringRotateHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	lbID := mux.Vars(r)["lb_id"]
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	logger := config.logger.WithField("cluster-id", lb.ID)
+
+	keyring, err := kvs.RotateClusterKeyring(config.KVS, clusterKeyringKey(lb.ID))
+	if err != nil {
+		logger.WithError(err).Error("could not rotate cluster keyring")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	agents, err := clusterAgents(config, lb)
+	if err != nil {
+		logger.WithError(err).Error("could not load cluster agents")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	krr := service.KeyringRotateResponse{
+		Primary:         keyring.Primary,
+		RefreshedAgents: []string{},
+		FailedAgents:    []string{},
+	}
+
+	for _, a := range agents {
+		err := a.Err
+		if err == nil {
+			err = callAgent("POST", a.Host, "/keyring/refresh", nil)
+		}
+
+		if err != nil {
+			logger.WithError(err).WithField("agent-id", a.ID).Warn("could not refresh agent keyring")
+			krr.FailedAgents = append(krr.FailedAgents, a.ID)
+			continue
+		}
+
+		krr.RefreshedAgents = append(krr.RefreshedAgents, a.ID)
+	}
+
+	if len(krr.FailedAgents) > 0 {
+		logger.WithField("failed-agents", krr.FailedAgents).Warn("not reencrypting kvs values until every agent has the new keyring")
+		return service.Response{Body: krr, Status: http.StatusServiceUnavailable}
+	}
+
+	var rr service.KeyringReencryptResponse
+	if err := callAgent("POST", lb.FloatingIp, "/keyring/reencrypt", &rr); err != nil {
+		logger.WithError(err).Error("could not reencrypt kvs values")
+		return service.Response{Body: "keyring rotated, but the agent could not reencrypt values", Status: 500}
+	}
+	krr.Reencrypted = rr.Reencrypted
+
+	return service.Response{Body: krr, Status: http.StatusOK}
+}
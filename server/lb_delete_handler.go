@@ -57,6 +57,14 @@ func LBDeleteHandler(c interface{}, r *http.Request) service.Response {
 			Error("could not delete kvs entry for cluster")
 	}
 
+	err = config.KVS.Delete(clusterKeyringKey(lb.ID))
+	if err != nil {
+		config.logger.
+			WithError(err).
+			WithField("cluster-id", lb.ID).
+			Error("could not delete keyring for cluster")
+	}
+
 	return service.Response{Body: nil, Status: 204}
 }
 
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// LBRestoreHandler restores a snapshot into a load balancer's agent
+// cluster.
+func LBRestoreHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+	defer r.Body.Close()
+
+	if _, err := sessionUser(config, r); err != nil {
+		return service.Response{Body: "unknown user", Status: 401}
+	}
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return service.Response{Body: "cannot read snapshot", Status: 400}
+	}
+
+	keyring, err := kvs.ClusterKeyring(config.KVS, clusterKeyringKey(lb.ID))
+	if err != nil {
+		config.logger.WithError(err).Error("could not load cluster keyring")
+		return service.Response{Body: "could not load cluster keyring", Status: 500}
+	}
+
+	req, err := signedAgentRequest(keyring, "POST", lb.FloatingIp, "/restore", body)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		var agentErr map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&agentErr)
+		if err != nil {
+			return service.Response{Body: "cannot read agent response", Status: 500}
+		}
+
+		return service.Response{Body: agentErr["error"], Status: resp.StatusCode}
+	}
+
+	return service.Response{Status: http.StatusNoContent}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bryanl/dolb/kvs"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// LBSnapshotHandler exports the services and firewall ports of a load
+// balancer's agent cluster. Certificate keys stay encrypted with the
+// cluster keyring.
+func LBSnapshotHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	if _, err := sessionUser(config, r); err != nil {
+		return service.Response{Body: "unknown user", Status: 401}
+	}
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	keyring, err := kvs.ClusterKeyring(config.KVS, clusterKeyringKey(lb.ID))
+	if err != nil {
+		config.logger.WithError(err).Error("could not load cluster keyring")
+		return service.Response{Body: "could not load cluster keyring", Status: 500}
+	}
+
+	req, err := signedAgentRequest(keyring, "GET", lb.FloatingIp, "/snapshot", nil)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return service.Response{Body: "cannot contact agent", Status: 500}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return service.Response{Body: "agent could not take snapshot", Status: resp.StatusCode}
+	}
+
+	var s kvs.Snapshot
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return service.Response{Body: "cannot read agent response", Status: 500}
+	}
+
+	return service.Response{Body: s, Status: resp.StatusCode}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/service"
+	"github.com/gorilla/mux"
+)
+
+// ServiceStatsHandler returns the traffic and health statistics of a service
+// of a load balancer and its upstreams. Every agent keeps its own counters,
+// e.g. from before the floating ip moved, so the counters of all agents
+// which can be reached are summed.
+func ServiceStatsHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	vars := mux.Vars(r)
+	lbID := vars["lb_id"]
+	svcName := vars["service"]
+
+	lb, err := config.DBSession.LoadLoadBalancer(lbID)
+	if err != nil {
+		return service.Response{Body: "not found", Status: 404}
+	}
+
+	logger := config.logger.WithFields(logrus.Fields{
+		"cluster-id":   lb.ID,
+		"service-name": svcName,
+	})
+
+	agents, err := clusterAgents(config, lb)
+	if err != nil {
+		logger.WithError(err).Error("could not load cluster agents")
+		return service.Response{Body: err, Status: 500}
+	}
+
+	total := service.ServiceStatsResponse{
+		Name:      svcName,
+		Upstreams: []service.UpstreamStatsResponse{},
+	}
+
+	collected := 0
+	for _, a := range agents {
+		err := a.Err
+
+		var ssr service.ServiceStatsResponse
+		if err == nil {
+			err = callAgent("GET", a.Host, fmt.Sprintf("/services/%s/stats", svcName), &ssr)
+		}
+
+		if err != nil {
+			logger.WithError(err).WithField("agent-id", a.ID).Warn("could not retrieve agent stats")
+			continue
+		}
+
+		mergeServiceStats(&total, ssr)
+		collected++
+	}
+
+	if collected == 0 {
+		return service.Response{Body: "agents could not retrieve stats", Status: 500}
+	}
+
+	return service.Response{Body: total, Status: http.StatusOK}
+}
+
+// mergeServiceStats adds the stats of a service reported by one agent to
+// total. Upstreams are matched by id.
+func mergeServiceStats(total *service.ServiceStatsResponse, ssr service.ServiceStatsResponse) {
+	addStats(&total.Stats, ssr.Stats)
+
+	for _, u := range ssr.Upstreams {
+		found := false
+		for i := range total.Upstreams {
+			if total.Upstreams[i].ID == u.ID {
+				addStats(&total.Upstreams[i].Stats, u.Stats)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			total.Upstreams = append(total.Upstreams, u)
+		}
+	}
+}
+
+// addStats adds the counters of s to total. If agents disagree about a
+// status, the unhealthy one is kept, so problems seen by any agent are
+// reported.
+func addStats(total *service.Stats, s service.Stats) {
+	total.Status = mergeStatus(total.Status, s.Status)
+	total.CheckStatus = mergeStatus(total.CheckStatus, s.CheckStatus)
+	total.Queue += s.Queue
+	total.Sessions += s.Sessions
+	total.TotalSessions += s.TotalSessions
+	total.BytesIn += s.BytesIn
+	total.BytesOut += s.BytesOut
+	total.Responses2xx += s.Responses2xx
+	total.Responses4xx += s.Responses4xx
+	total.Responses5xx += s.Responses5xx
+}
+
+func mergeStatus(total, s string) string {
+	if s == "" {
+		return total
+	}
+
+	if total == "" || isHealthyStatus(total) {
+		return s
+	}
+
+	return total
+}
+
+// isHealthyStatus returns true for haproxy's healthy statuses, e.g. "UP" or
+// the check status "L7OK".
+func isHealthyStatus(s string) bool {
+	return s == "UP" || strings.HasSuffix(s, "OK")
+}
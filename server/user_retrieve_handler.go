@@ -1,9 +1,11 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/bryanl/dolb/dao"
 	"github.com/bryanl/dolb/service"
 	"github.com/gorilla/sessions"
 )
@@ -12,21 +14,26 @@ var (
 	sessionStore = sessions.NewCookieStore([]byte("secret"))
 )
 
-func UserRetrieveHandler(c interface{}, r *http.Request) service.Response {
-	config := c.(*Config)
-
+// sessionUser returns the user logged in with the request's session.
+func sessionUser(config *Config, r *http.Request) (*dao.User, error) {
 	session, err := sessionStore.Get(r, "_dolb_session")
 	if err != nil {
 		logrus.WithError(err).Error("unable to load session")
-		return service.Response{Body: "unknown user", Status: 401}
+		return nil, err
 	}
 
-	userID, ok := session.Values["user_id"]
+	userID, ok := session.Values["user_id"].(string)
 	if !ok {
-		return service.Response{Body: "unknown user", Status: 401}
+		return nil, errors.New("session has no user")
 	}
 
-	u, err := config.DBSession.FindUser(userID.(string))
+	return config.DBSession.FindUser(userID)
+}
+
+func UserRetrieveHandler(c interface{}, r *http.Request) service.Response {
+	config := c.(*Config)
+
+	u, err := sessionUser(config, r)
 	if err != nil {
 		return service.Response{Body: "unknown user", Status: 401}
 	}
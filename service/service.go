@@ -1,9 +1,12 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -13,9 +16,25 @@ import (
 )
 
 var (
-	PingPath = "/api/ping"
+	PingPath    = "/api/ping"
+	KeyringPath = "/api/agent/keyring"
 )
 
+const (
+	// SignatureHeader carries the signature of a request the server sends
+	// to an agent. It is made with the cluster keyring.
+	SignatureHeader = "X-Dolb-Signature"
+	// SignatureTimeHeader carries the unix time a request was signed at.
+	SignatureTimeHeader = "X-Dolb-Signature-Time"
+)
+
+// SignatureMessage is the part of a request which is signed: the method,
+// the path, the time it was signed at and the body.
+func SignatureMessage(method, path, signedAt string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(strings.Join([]string{method, path, signedAt, hex.EncodeToString(sum[:])}, "\n"))
+}
+
 // HandlerFunc is a handler function that returns a Response.
 type HandlerFunc func(config interface{}, r *http.Request) Response
 
@@ -1,19 +1,98 @@
 package service
 
+import "time"
+
 // ServiceCreateRequest is a request to create a service.
 type ServiceCreateRequest struct {
-	Name   string `json:"service_name"`
-	Port   int    `json:"port"`
-	Domain string `json:"domain"`
-	Regex  string `json:"url_regex"`
+	Name        string       `json:"service_name"`
+	Port        int          `json:"port"`
+	Protocol    string       `json:"protocol"`
+	Domain      string       `json:"domain"`
+	Regex       string       `json:"url_regex"`
+	Rules       []Rule       `json:"rules,omitempty"`
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	Balance     string       `json:"balance,omitempty"`
+	Sticky      *Sticky      `json:"sticky,omitempty"`
+	Limits      *Limits      `json:"limits,omitempty"`
+}
+
+// Rule routes HTTP requests to a service. A request matches a rule if it
+// matches all the conditions the rule sets. Host may be a wildcard like
+// "*.example.com".
+type Rule struct {
+	Host        string `json:"host,omitempty"`
+	PathPrefix  string `json:"path_prefix,omitempty"`
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"header_value,omitempty"`
+	HeaderRegex string `json:"header_regex,omitempty"`
+	Method      string `json:"method,omitempty"`
+}
+
+// HealthCheck configures how the upstreams of a service are checked. Type is
+// "tcp" or "http". Interval is a duration like "5s". Settings which aren't
+// supplied get defaults.
+type HealthCheck struct {
+	Type           string `json:"type"`
+	Path           string `json:"path,omitempty"`
+	ExpectedStatus int    `json:"expected_status,omitempty"`
+	Interval       string `json:"interval,omitempty"`
+	Rise           int    `json:"rise,omitempty"`
+	Fall           int    `json:"fall,omitempty"`
+}
+
+// Sticky configures cookie based session persistence. Mode is "insert",
+// "rewrite" or "prefix".
+type Sticky struct {
+	Cookie string `json:"cookie,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// Limits protect the upstreams of a service from abusive clients.
+// RequestRate is requests per second per source IP and only applies to http
+// services. ConnectionsPerSource is concurrent connections per source IP.
+// UpstreamMaxConn is concurrent connections per upstream. Action is "deny"
+// (the default) or "tarpit", which only http services support.
+type Limits struct {
+	RequestRate          int    `json:"request_rate,omitempty"`
+	ConnectionsPerSource int    `json:"connections_per_source,omitempty"`
+	UpstreamMaxConn      int    `json:"upstream_maxconn,omitempty"`
+	Action               string `json:"action,omitempty"`
+}
+
+// HTTPRule rewrites the requests or responses of an http service. Action is
+// "set-header", "add-header" or "del-header", which change Header in
+// Direction ("request", the default, or "response"); "redirect-https",
+// which redirects plain HTTP requests to HTTPS; "redirect-host", which
+// redirects requests for any other host to Host; or "hsts", which sets the
+// Strict-Transport-Security header of HTTPS responses. Redirects use Code,
+// 301 by default. Header values may use haproxy sample fetches like
+// "%[uuid]".
+type HTTPRule struct {
+	ID                string `json:"id,omitempty"`
+	Action            string `json:"action"`
+	Direction         string `json:"direction,omitempty"`
+	Header            string `json:"header,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Host              string `json:"host,omitempty"`
+	Code              int    `json:"code,omitempty"`
+	MaxAge            int    `json:"max_age,omitempty"`
+	IncludeSubdomains bool   `json:"include_subdomains,omitempty"`
+}
+
+// HTTPRulesResponse is an http rules response sent to a client. Rules are
+// applied in order.
+type HTTPRulesResponse struct {
+	Rules []HTTPRule `json:"http_rules"`
 }
 
 // ServiceCreateResponse is a response to create a service.
 type ServiceCreateResponse struct {
-	Name   string `json:"service_name"`
-	Port   int    `json:"port"`
-	Domain string `json:"domain"`
-	Regex  string `json:"url_regex"`
+	Name     string `json:"service_name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Domain   string `json:"domain"`
+	Regex    string `json:"url_regex"`
+	Rules    []Rule `json:"rules,omitempty"`
 }
 
 // ServicesResponse is a services response sent to a client.
@@ -23,18 +102,109 @@ type ServicesResponse struct {
 
 // ServiceResponse is a service response sent to a client.
 type ServiceResponse struct {
-	Name      string                 `json:"name"`
-	Port      int                    `json:"port"`
-	Type      string                 `json:"type"`
-	Config    map[string]interface{} `json:"config"`
-	Upstreams []UpstreamResponse     `json:"upstreams"`
+	Name         string                 `json:"name"`
+	Port         int                    `json:"port"`
+	Type         string                 `json:"type"`
+	Balance      string                 `json:"balance"`
+	Sticky       *Sticky                `json:"sticky,omitempty"`
+	Config       map[string]interface{} `json:"config"`
+	Upstreams    []UpstreamResponse     `json:"upstreams"`
+	Certificates []CertificateResponse  `json:"certificates"`
 }
 
 // UpstreamResponse is an upstream response sent to a client.
 type UpstreamResponse struct {
+	ID     string `json:"id"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+	Backup bool   `json:"backup"`
+	State  string `json:"state"`
+}
+
+// UpstreamSyncResponse reports the upstreams a sync added, updated and
+// removed.
+type UpstreamSyncResponse struct {
+	Added   []UpstreamResponse `json:"added"`
+	Updated []UpstreamResponse `json:"updated"`
+	Removed []UpstreamResponse `json:"removed"`
+}
+
+// Stats are haproxy's traffic and health statistics of a service or
+// upstream. Response counts are only collected for http services.
+type Stats struct {
+	Status        string `json:"status"`
+	CheckStatus   string `json:"check_status,omitempty"`
+	Queue         int64  `json:"queue"`
+	Sessions      int64  `json:"sessions"`
+	TotalSessions int64  `json:"total_sessions"`
+	BytesIn       int64  `json:"bytes_in"`
+	BytesOut      int64  `json:"bytes_out"`
+	Responses2xx  int64  `json:"responses_2xx"`
+	Responses4xx  int64  `json:"responses_4xx"`
+	Responses5xx  int64  `json:"responses_5xx"`
+}
+
+// ServiceStatsResponse is a service stats response sent to a client. Stats
+// are the totals of the service's upstreams.
+type ServiceStatsResponse struct {
+	Name string `json:"name"`
+	Stats
+	Upstreams []UpstreamStatsResponse `json:"upstreams"`
+}
+
+// UpstreamStatsResponse is an upstream stats response sent to a client.
+type UpstreamStatsResponse struct {
 	ID   string `json:"id"`
 	Host string `json:"host"`
 	Port int    `json:"port"`
+	Stats
+}
+
+// CertificateCreateRequest is a request to add a TLS certificate to a
+// service. Certificate is the PEM encoded certificate chain, leaf first, and
+// Key is the PEM encoded private key.
+type CertificateCreateRequest struct {
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+}
+
+// CertificateResponse is a certificate response sent to a client. It never
+// includes the private key.
+type CertificateResponse struct {
+	ID        string    `json:"id"`
+	Names     []string  `json:"names"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Expired   bool      `json:"expired"`
+}
+
+// CertificatesResponse is a certificates response sent to a client.
+type CertificatesResponse struct {
+	Certificates []CertificateResponse `json:"certificates"`
+}
+
+// KeyringRefreshResponse reports the primary key of the keyring an agent
+// encrypts with after refreshing it.
+type KeyringRefreshResponse struct {
+	Primary string `json:"primary"`
+	Changed bool   `json:"changed"`
+}
+
+// KeyringReencryptResponse reports how many values were encrypted with the
+// primary key.
+type KeyringReencryptResponse struct {
+	Reencrypted int `json:"reencrypted"`
+}
+
+// KeyringRotateResponse reports a keyring rotation. Agents which couldn't be
+// refreshed pick up the new keyring on their next refresh; existing values
+// are only reencrypted once every agent was refreshed.
+type KeyringRotateResponse struct {
+	Primary         string   `json:"primary"`
+	RefreshedAgents []string `json:"refreshed_agents"`
+	FailedAgents    []string `json:"failed_agents"`
+	Reencrypted     int      `json:"reencrypted"`
 }
 
 // UserInfoResponse is a user info response.